@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+)
+
+// runCollect collects every feed, or the one given by -feed, once. It is
+// intended to be run from cron when scheduled collection is disabled.
+func runCollect(args []string) error {
+	var feedID string
+	cfg, fs, err := parseCommand("collect", "", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&feedID, "feed", "", "ID of the only feed to collect")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	policy, err := newFetchPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	icons, err := newIconFetcher(cfg, policy)
+	if err != nil {
+		return err
+	}
+	box, err := newSecretsBox(cfg)
+	if err != nil {
+		return err
+	}
+	feedCollector := newCollector(cfg, store, icons, nil, box, policy)
+	if len(feedID) == 0 {
+		return feedCollector.CollectAll(ctx)
+	}
+
+	source, err := store.FetchSource(ctx, feedID)
+	if err != nil {
+		return err
+	}
+	return feedCollector.CollectSource(ctx, source.FeedSourcePartial)
+}
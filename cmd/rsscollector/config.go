@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+)
+
+// runConfig runs the config subcommands.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return unknownSubcommand("config", args, "print")
+	}
+
+	cfg, fs, err := parseCommand("config print", "", args[1:], nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+	return cfg.Print(os.Stdout)
+}
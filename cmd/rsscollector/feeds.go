@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runFeeds runs the feeds subcommands.
+func runFeeds(args []string) error {
+	if len(args) == 0 {
+		return unknownSubcommand("feeds", args, "add", "list", "remove")
+	}
+
+	switch args[0] {
+	case "add":
+		return feedsAdd(args[1:])
+	case "list":
+		return feedsList(args[1:])
+	case "remove":
+		return feedsRemove(args[1:])
+	default:
+		return unknownSubcommand("feeds", args, "add", "list", "remove")
+	}
+}
+
+func feedsAdd(args []string) error {
+	cfg, fs, err := parseCommand("feeds add", "<feed URL>...", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "no feed URLs given")
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	policy, err := newFetchPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	icons, err := newIconFetcher(cfg, policy)
+	if err != nil {
+		return err
+	}
+	box, err := newSecretsBox(cfg)
+	if err != nil {
+		return err
+	}
+	feedCollector := newCollector(cfg, store, icons, nil, box, policy)
+	for _, feedURL := range fs.Args() {
+		source, err := feedCollector.AddSource(ctx, feedURL)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", feedURL, err)
+		}
+		fmt.Printf("added %s %s (%d items)\n", source.ID, source.Title, len(source.FeedItems))
+	}
+	return nil
+}
+
+func feedsList(args []string) error {
+	cfg, fs, err := parseCommand("feeds list", "", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	sources, err := store.FetchAllSources(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tLAST COLLECTED\tFEED URL")
+	for _, source := range sources {
+		lastCollected := "never"
+		if !source.LastCollected.IsZero() {
+			lastCollected = source.LastCollected.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", source.ID, source.Title, lastCollected, source.FeedURL)
+	}
+	return w.Flush()
+}
+
+func feedsRemove(args []string) error {
+	cfg, fs, err := parseCommand("feeds remove", "<feed ID>...", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "no feed IDs given")
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	for _, feedID := range fs.Args() {
+		if _, err := store.FetchSource(ctx, feedID); err != nil {
+			return fmt.Errorf("failed to find feed %s: %w", feedID, err)
+		}
+		if err := store.DeleteSourceByID(ctx, feedID); err != nil {
+			return fmt.Errorf("failed to remove feed %s: %w", feedID, err)
+		}
+		fmt.Printf("moved %s to the trash\n", feedID)
+	}
+	return nil
+}
@@ -1,58 +1,435 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/config"
+	"github.com/JonPulfer/rss_collector/pkg/extract"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/icon"
 	"github.com/JonPulfer/rss_collector/pkg/repository"
-	"github.com/JonPulfer/rss_collector/pkg/server"
+	"github.com/JonPulfer/rss_collector/pkg/retention"
+	"github.com/JonPulfer/rss_collector/pkg/sanitize"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+const usage = `Usage: rsscollector <command> [flags] [arguments]
+
+Commands:
+  serve                       run the HTTP API and scheduled collection (default)
+  migrate up                  apply the database migrations built in
+  migrate down [steps]        revert the most recent migrations, 1 unless steps is given
+  migrate status              show the version of the database schema
+  collect [-feed ID]          collect every feed, or only the given feed, once
+  prune [-dry-run]            remove the items the retention policies don't keep
+  feeds add <feed URL>...     add and collect feeds
+  feeds list                  list the feeds
+  feeds remove <feed ID>...   move feeds and their items to the trash
+  trash list                  list the deleted feeds, items and categories
+  trash restore <type> <ID>...
+                              restore feeds, items or categories from the trash
+  trash purge [-all]          remove what has been in the trash longer than the trash period
+  import-opml <file>          add the feeds in an OPML file, - reads stdin
+  export [file]               write the feeds as OPML, to stdout unless file is given
+  backup [file]               write every feed, item and category as an archive, to stdout
+                              unless file is given
+  restore <file>              load an archive written by backup, - reads stdin
+  config print                show the effective configuration
+
+Every command accepts the configuration flags, see "rsscollector <command> -h".
+`
+
+// errUsage is returned by a command when it has been given invalid
+// arguments and has already explained why.
+var errUsage = errors.New("invalid usage")
+
+// commands by name. Commands with subcommands dispatch on their first
+// argument themselves.
+var commands = map[string]func(args []string) error{
+	"serve":       runServe,
+	"migrate":     runMigrate,
+	"collect":     runCollect,
+	"prune":       runPrune,
+	"feeds":       runFeeds,
+	"trash":       runTrash,
+	"import-opml": runImportOPML,
+	"export":      runExport,
+	"backup":      runBackup,
+	"restore":     runRestore,
+	"config":      runConfig,
+}
+
 func main() {
+	args := os.Args[1:]
 
-	var itemRepos repository.FeedItemStore
-	var categoryRepos repository.FeedCategoryStore
-	var feedRepos repository.FeedSourceStore
+	// Without a command, or when only given flags, the server is started as
+	// it was before there were commands.
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return
+	}
 
-	// This memory based repository satisfies all of the object store interfaces
-	itemRepos = repository.NewMemoryStore()
-	categoryRepos = repository.NewMemoryStore()
-	feedRepos = repository.NewMemoryStore()
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
 
-	if len(os.Getenv("DATABASE_URL")) > 0 {
-		dbRepos, err := repository.NewPostgresDB(os.Getenv("DATABASE_URL"))
-		if err != nil {
-			log.Error().Err(err).Msg("failed to connect to db")
-			panic(err)
-		}
-		log.Debug().Msg("connected to database")
-		if len(os.Getenv("MIGRATIONS_DIR")) > 0 {
-			if err := dbRepos.Migrate(os.Getenv("MIGRATIONS_DIR")); err != nil {
-				log.Error().Err(err).Msg("failed to migrate database")
-				panic(err)
-			}
+	err := run(args)
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "rsscollector %s: %s\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// parseCommand parses the flags given to a command, defined by define, and
+// loads the configuration. synopsis describes the arguments the command
+// takes, which are left in the returned flag set.
+func parseCommand(name, synopsis string, args []string, define func(fs *flag.FlagSet)) (*config.Config, *flag.FlagSet, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	// The flag package shows the usage when asked for help or when the flags
+	// are invalid, after reporting the problem.
+	usageShown := false
+	fs.Usage = func() {
+		usageShown = true
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\nFlags:\n",
+			strings.TrimSpace(fmt.Sprintf("rsscollector %s [flags] %s", name, synopsis)))
+		fs.PrintDefaults()
+	}
+	if define != nil {
+		define(fs)
+	}
+
+	cfg, err := config.LoadFlags(fs, args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return nil, nil, err
+	case err != nil && usageShown:
+		return nil, nil, errUsage
+	case err != nil:
+		return nil, nil, err
+	}
+
+	configureLogging(cfg.Log)
+	// Every command storing feeds strips the same tracking parameters.
+	urlnorm.SetTrackingParams(cfg.Links.TrackingParams)
+	return cfg, fs, nil
+}
+
+// usageError reports a problem with the arguments given to a command.
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(fs.Output(), format+"\n", args...)
+	fs.Usage()
+	return errUsage
+}
+
+// commandContext is cancelled when the command is interrupted.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// openStores opens the object stores from the configuration, the PostgreSQL
+// database when one is configured, or otherwise the memory store. The
+// database is migrated when AutoMigrate is set and is refused if its schema
+// is still behind. The URLs of the feeds are normalised, flagging those that
+// turn out to be duplicates, in case any were stored before they were. They
+// should be closed with closeRepos.
+func openStores(cfg *config.Config) (*repository.InstrumentedStore, error) {
+	store, err := openStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	normalized, err := repository.NormalizeSourceURLs(ctx, store)
+	if err != nil {
+		closeRepos(store)
+		return nil, fmt.Errorf("failed to normalise feed URLs: %w", err)
+	}
+	if normalized > 0 {
+		log.Info().Int("feeds", normalized).Msg("normalised stored feed URLs")
+	}
+	return store, nil
+}
+
+// openStore opens the object stores for openStores.
+func openStore(cfg *config.Config) (*repository.InstrumentedStore, error) {
+	if len(cfg.Database.URL) == 0 {
+		return openMemoryStore(cfg)
+	}
+
+	dbRepos, err := openPostgres(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Database.AutoMigrate {
+		if err := dbRepos.Migrate(cfg.Database.MigrationsDir); err != nil {
+			closeRepos(dbRepos)
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
 		}
-		itemRepos = dbRepos
-		categoryRepos = dbRepos
-		feedRepos = dbRepos
 	}
 
-	port := uint(8080)
-	if len(os.Getenv("PORT")) > 0 {
-		envPort, err := strconv.Atoi(os.Getenv("PORT"))
+	ctx, cancel := commandContext()
+	defer cancel()
+	if err := dbRepos.CheckSchema(ctx, cfg.Database.MigrationsDir); err != nil {
+		closeRepos(dbRepos)
+		return nil, err
+	}
+	return repository.NewInstrumentedStore(dbRepos, "postgres"), nil
+}
+
+// openMemoryStore opens the memory store, kept on disk when MemoryDir is set,
+// and loads the seed file into it if it is empty.
+func openMemoryStore(cfg *config.Config) (*repository.InstrumentedStore, error) {
+	// This memory based repository satisfies all of the object store interfaces
+	memory := repository.NewMemoryStore()
+	if len(cfg.Database.MemoryDir) > 0 {
+		var err error
+		memory, err = repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{
+			Dir:              cfg.Database.MemoryDir,
+			SnapshotInterval: cfg.Database.SnapshotInterval.Duration(),
+			SyncWrites:       cfg.Database.SyncWrites,
+		})
 		if err != nil {
-			log.Error().Err(err).Msg("failed to parse supplied PORT envvar")
-			panic(err)
+			return nil, fmt.Errorf("failed to open memory store: %w", err)
 		}
-		port = uint(envPort)
-		log.Info().Msgf("port configured as %d", port)
 	}
-	s := server.NewHTTPFeedServer(feedRepos, itemRepos, categoryRepos, &server.Config{Port: port})
-	err := s.Start()
+	store := repository.NewInstrumentedStore(memory, "memory")
+	if len(cfg.Database.SeedFile) == 0 {
+		return store, nil
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	totals, err := memory.Count(ctx)
+	if err != nil {
+		closeRepos(store)
+		return nil, err
+	}
+	if totals.Feeds > 0 || totals.Items > 0 || totals.Categories > 0 {
+		log.Info().Msg("memory store already holds data, not loading the seed file")
+		return store, nil
+	}
+	if err := seedStore(ctx, store, cfg.Database.SeedFile); err != nil {
+		closeRepos(store)
+		return nil, err
+	}
+	return store, nil
+}
+
+// openStoresForCommand opens the object stores for a command that changes
+// or reports on them, warning that nothing is kept without a database.
+func openStoresForCommand(cfg *config.Config) (*repository.InstrumentedStore, error) {
+	if len(cfg.Database.URL) == 0 && len(cfg.Database.MemoryDir) == 0 {
+		log.Warn().Msg("no database configured, using a memory store that is discarded on exit")
+	}
+	return openStores(cfg)
+}
+
+func openPostgres(cfg *config.Config) (*repository.PostgresDB, error) {
+	if len(cfg.Database.URL) == 0 {
+		return nil, errors.New("no database configured, set -database-url or DATABASE_URL")
+	}
+	dbRepos, err := repository.NewPostgresDB(cfg.Database.URL, cfg.Database.MigrationsDir)
 	if err != nil {
-		log.Error().Err(err).Msg("server detected an error")
-		panic(err)
+		return nil, fmt.Errorf("failed to connect to db: %w", err)
+	}
+	log.Debug().Msg("connected to database")
+	return dbRepos, nil
+}
+
+// newCollector collecting into store as configured, refreshing feed icons
+// with icons and extracting full content with extractor unless they are nil.
+// Feeds, and the item links resolved when that is enabled, are fetched with
+// the credentials box decrypts, as policy allows.
+func newCollector(
+	cfg *config.Config,
+	store repository.Store,
+	icons *icon.Fetcher,
+	extractor *extract.Extractor,
+	box *secrets.Box,
+	policy *fetchpolicy.Policy) *collector.Collector {
+	collectorConfig := &collector.Config{
+		RefreshInterval: cfg.Collector.RefreshInterval.Duration(),
+		FetchTimeout:    cfg.Collector.FetchTimeout.Duration(),
+		StoreTimeout:    cfg.Collector.StoreTimeout.Duration(),
+		Concurrency:     cfg.Collector.Concurrency,
+		UserAgent:       cfg.Collector.UserAgent,
+		HostConcurrency: cfg.Collector.HostConcurrency,
+		HostInterval:    cfg.Collector.HostInterval.Duration(),
+		RateLimit:       cfg.Collector.RateLimit,
+		CrawlDelay:      cfg.Collector.CrawlDelay,
+		Sanitizer:       newSanitizer(cfg),
+		Secrets:         box,
+		Policy:          policy,
+	}
+	// A nil *icon.Fetcher must not become a non-nil IconRefresher.
+	if icons != nil {
+		collectorConfig.Icons = icons
+	}
+	if extractor != nil {
+		collectorConfig.Extractor = extractor
+	}
+	if cfg.Links.ResolveRedirects {
+		collectorConfig.Resolver = urlnorm.NewResolver(
+			policy.Client(cfg.Collector.FetchTimeout.Duration()), cfg.Links.Redirectors)
+	}
+	return collector.NewCollector(store, store, collectorConfig)
+}
+
+// newSecretsBox encrypting the credentials of feeds with the configured key,
+// nil when there is none.
+func newSecretsBox(cfg *config.Config) (*secrets.Box, error) {
+	if len(cfg.Secrets.Key) == 0 {
+		return nil, nil
+	}
+	key, err := secrets.ParseKey(cfg.Secrets.Key)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.NewBox(key)
+}
+
+// newFetchPolicy guarding everything fetched for feeds as configured.
+func newFetchPolicy(cfg *config.Config) (*fetchpolicy.Policy, error) {
+	return fetchpolicy.New(fetchpolicy.Config{
+		Allow:        cfg.Fetch.Allow,
+		MaxRedirects: cfg.Fetch.MaxRedirects,
+		MaxBytes:     int64(cfg.Fetch.MaxBytes),
+		ContentTypes: cfg.Fetch.ContentTypes,
+	})
+}
+
+// newSanitizer applying the configured policy, nil when items are stored as
+// feeds give them.
+func newSanitizer(cfg *config.Config) *sanitize.Sanitizer {
+	if !cfg.Sanitize.Enabled {
+		return nil
+	}
+	policy := sanitize.DefaultPolicy()
+	if len(cfg.Sanitize.Elements) > 0 {
+		policy.Elements = cfg.Sanitize.Elements
+	}
+	if cfg.Sanitize.Attributes != nil {
+		policy.Attributes = cfg.Sanitize.Attributes
+	}
+	policy.URLSchemes = cfg.Sanitize.URLSchemes
+	policy.KeepTrackingPixels = cfg.Sanitize.KeepTrackingPixels
+
+	sanitizer := sanitize.New(policy)
+	sanitizer.KeepOriginal = cfg.Sanitize.KeepOriginal
+	sanitizer.SummaryLength = cfg.Sanitize.SummaryLength
+	return sanitizer
+}
+
+// newIconFetcher keeping feed icons in the configured directory, nil when
+// icons are disabled. Icons are fetched as policy allows.
+func newIconFetcher(cfg *config.Config, policy *fetchpolicy.Policy) (*icon.Fetcher, error) {
+	if len(cfg.Icons.Dir) == 0 {
+		return nil, nil
+	}
+	store, err := icon.NewFileStore(cfg.Icons.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return icon.NewFetcher(store, policy.Client(cfg.Collector.FetchTimeout.Duration()), icon.Config{
+		MaxBytes:        int64(cfg.Icons.MaxBytes),
+		RefreshInterval: cfg.Icons.RefreshInterval.Duration(),
+		Timeout:         cfg.Collector.FetchTimeout.Duration(),
+		UserAgent:       cfg.Collector.UserAgent,
+	}), nil
+}
+
+// newExtractor of the full content of items in store, nil when extraction is
+// disabled. Articles are fetched as policy allows.
+func newExtractor(cfg *config.Config, store repository.Store, policy *fetchpolicy.Policy) *extract.Extractor {
+	if !cfg.Extract.Enabled {
+		return nil
+	}
+	return extract.NewExtractor(store, newSanitizer(cfg), policy.Client(cfg.Collector.FetchTimeout.Duration()), extract.Config{
+		Workers:      cfg.Extract.Workers,
+		HostInterval: cfg.Extract.HostInterval.Duration(),
+		Timeout:      cfg.Collector.FetchTimeout.Duration(),
+		MaxBytes:     int64(cfg.Extract.MaxBytes),
+		QueueSize:    cfg.Extract.QueueSize,
+		UserAgent:    cfg.Collector.UserAgent,
+	})
+}
+
+// newPruner applying the configured retention policies and trash period to
+// store.
+func newPruner(cfg *config.Config, store repository.Store) *retention.Pruner {
+	policies := retention.Policies{
+		Default: retention.Policy{
+			MaxAge:   cfg.Retention.MaxAge.Duration(),
+			MaxItems: cfg.Retention.MaxItemsPerFeed,
+		},
+		Feeds:      retentionPolicies(cfg.Retention.Feeds),
+		Categories: retentionPolicies(cfg.Retention.Categories),
+	}
+	return retention.NewPruner(store, store, store, store, policies, cfg.Retention.TrashPeriod.Duration())
+}
+
+func retentionPolicies(configured map[string]config.RetentionPolicy) map[string]retention.Policy {
+	policies := make(map[string]retention.Policy, len(configured))
+	for name, policy := range configured {
+		policies[name] = retention.Policy{
+			MaxAge:   policy.MaxAge.Duration(),
+			MaxItems: policy.MaxItems,
+		}
+	}
+	return policies
+}
+
+// configureLogging sets the level and format of the global logger. The
+// configuration has been validated so the level is known to parse.
+func configureLogging(cfg config.LogConfig) {
+	level, _ := zerolog.ParseLevel(cfg.Level)
+	zerolog.SetGlobalLevel(level)
+	if cfg.Format == "console" {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+}
+
+// closeRepos closes the connections held by a store if it has any.
+func closeRepos(repos interface{}) {
+	if closer, ok := repos.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close repository")
+		}
+	}
+}
+
+// unknownSubcommand reports that args doesn't start with one of the
+// subcommands of command.
+func unknownSubcommand(command string, args []string, subcommands ...string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "rsscollector %s needs one of: %s\n", command, strings.Join(subcommands, ", "))
+	} else {
+		fmt.Fprintf(os.Stderr, "unknown subcommand %q, rsscollector %s needs one of: %s\n",
+			args[0], command, strings.Join(subcommands, ", "))
 	}
+	return errUsage
 }
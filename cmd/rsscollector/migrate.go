@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+// runMigrate runs the migrate subcommands against the configured database.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return unknownSubcommand("migrate", args, "up", "down", "status")
+	}
+
+	switch args[0] {
+	case "up":
+		return migrateUp(args[1:])
+	case "down":
+		return migrateDown(args[1:])
+	case "status":
+		return migrateStatus(args[1:])
+	default:
+		return unknownSubcommand("migrate", args, "up", "down", "status")
+	}
+}
+
+func migrateUp(args []string) error {
+	cfg, fs, err := parseCommand("migrate up", "", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	dbRepos, err := openPostgres(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(dbRepos)
+
+	if err := dbRepos.Migrate(cfg.Database.MigrationsDir); err != nil {
+		return err
+	}
+	return printSchemaVersion(dbRepos)
+}
+
+func migrateDown(args []string) error {
+	cfg, fs, err := parseCommand("migrate down", "[steps]", args, nil)
+	if err != nil {
+		return err
+	}
+	steps := 1
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		steps, err = strconv.Atoi(fs.Arg(0))
+		if err != nil || steps < 1 {
+			return usageError(fs, "steps must be a positive number, got %q", fs.Arg(0))
+		}
+	default:
+		return usageError(fs, "unexpected arguments: %v", fs.Args()[1:])
+	}
+
+	dbRepos, err := openPostgres(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(dbRepos)
+
+	if err := dbRepos.MigrateDown(cfg.Database.MigrationsDir, steps); err != nil {
+		return err
+	}
+	return printSchemaVersion(dbRepos)
+}
+
+func migrateStatus(args []string) error {
+	cfg, fs, err := parseCommand("migrate status", "", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	dbRepos, err := openPostgres(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(dbRepos)
+	if err := printSchemaVersion(dbRepos); err != nil {
+		return err
+	}
+
+	latest, err := repository.LatestMigration(cfg.Database.MigrationsDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("latest migration %d\n", latest)
+	return nil
+}
+
+// schemaVersioner reports the version of a database schema.
+type schemaVersioner interface {
+	SchemaVersion(ctx context.Context) (uint, bool, error)
+}
+
+func printSchemaVersion(db schemaVersioner) error {
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	version, dirty, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version == 0:
+		fmt.Println("no migrations applied")
+	case dirty:
+		fmt.Printf("version %d, dirty: the migration failed and must be fixed by hand\n", version)
+	default:
+		fmt.Printf("version %d\n", version)
+	}
+	return nil
+}
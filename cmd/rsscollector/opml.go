@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/feed"
+	"github.com/JonPulfer/rss_collector/pkg/opml"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
+)
+
+// runImportOPML adds the feeds in an OPML file, placing them in categories
+// named after the folders they are in.
+func runImportOPML(args []string) error {
+	var collect bool
+	cfg, fs, err := parseCommand("import-opml", "<file>", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&collect, "collect", true, "collect each feed once it has been added")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "expected one OPML file, or - for stdin")
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	doc, err := opml.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	var feedCollector *collector.Collector
+	if collect {
+		policy, err := newFetchPolicy(cfg)
+		if err != nil {
+			return err
+		}
+		icons, err := newIconFetcher(cfg, policy)
+		if err != nil {
+			return err
+		}
+		box, err := newSecretsBox(cfg)
+		if err != nil {
+			return err
+		}
+		feedCollector = newCollector(cfg, store, icons, nil, box, policy)
+	}
+	added, skipped, err := importSubscriptions(ctx, store, feedCollector, doc.Subscriptions())
+	fmt.Printf("added %d feeds, skipped %d already added\n", added, skipped)
+	return err
+}
+
+// importSubscriptions stores a source for each subscription that isn't
+// already stored and collects it when feedCollector isn't nil. Failing to
+// collect a feed is logged, the feed is still added and collected later.
+func importSubscriptions(
+	ctx context.Context,
+	store repository.Store,
+	feedCollector *collector.Collector,
+	subscriptions []opml.Subscription) (added, skipped int, err error) {
+
+	sources, err := store.FetchAllSources(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	existing := make(map[string]struct{}, len(sources))
+	for _, source := range sources {
+		existing[urlnorm.Key(source.FeedURL)] = struct{}{}
+	}
+
+	categories, err := store.FetchAllCategories(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	categoryIDs := make(map[string]string, len(categories))
+	for _, category := range categories {
+		categoryIDs[category.Name] = category.ID
+	}
+
+	for _, subscription := range subscriptions {
+		// Parsing the URL as the collector would normalises it before
+		// checking whether it has already been added, however it is
+		// written.
+		parsed, err := feed.NewSource(subscription.FeedURL, nil)
+		if err != nil {
+			log.Warn().Err(err).Str("feedUrl", subscription.FeedURL).Msg("skipping invalid feed")
+			continue
+		}
+		if _, ok := existing[urlnorm.Key(parsed.FeedURL)]; ok {
+			skipped++
+			continue
+		}
+
+		source := rsscollector.FeedSource{
+			FeedSourcePartial: rsscollector.FeedSourcePartial{
+				FeedURL: parsed.FeedURL,
+				Title:   subscription.Title,
+			},
+		}
+		for _, name := range subscription.Categories {
+			id, ok := categoryIDs[name]
+			if !ok {
+				category := rsscollector.FeedCategory{Name: name}
+				if err := store.StoreCategory(ctx, &category); err != nil {
+					return added, skipped, err
+				}
+				id = category.ID
+				categoryIDs[name] = id
+			}
+			source.CategoryIDs = append(source.CategoryIDs, id)
+		}
+		if err := store.StoreSource(ctx, &source); err != nil {
+			return added, skipped, err
+		}
+		existing[urlnorm.Key(source.FeedURL)] = struct{}{}
+		added++
+		log.Info().Str("feedId", source.ID).Str("feedUrl", source.FeedURL).Msg("added feed")
+
+		if feedCollector != nil {
+			if err := feedCollector.CollectSource(ctx, source.FeedSourcePartial); err != nil {
+				log.Error().Err(err).Str("feedId", source.ID).Str("feedUrl", source.FeedURL).
+					Msg("failed to collect feed, it will be collected later")
+			}
+		}
+	}
+	return added, skipped, nil
+}
+
+// runExport writes the feeds as OPML, in folders named after their
+// categories.
+func runExport(args []string) error {
+	cfg, fs, err := parseCommand("export", "[file]", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args()[1:])
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	doc, err := exportSubscriptions(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return doc.Write(os.Stdout)
+	}
+	f, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := doc.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func exportSubscriptions(ctx context.Context, store repository.Store) (*opml.Document, error) {
+	sources, err := store.FetchAllSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := store.FetchAllCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	categoryNames := make(map[string]string, len(categories))
+	for _, category := range categories {
+		categoryNames[category.ID] = category.Name
+	}
+
+	subscriptions := make([]opml.Subscription, 0, len(sources))
+	for _, source := range sources {
+		// A scraped page is no feed for another reader to subscribe to.
+		if source.Scrape != nil {
+			continue
+		}
+		subscription := opml.Subscription{FeedURL: source.FeedURL, Title: source.Title}
+		for _, id := range source.CategoryIDs {
+			if name, ok := categoryNames[id]; ok {
+				subscription.Categories = append(subscription.Categories, name)
+			}
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return opml.New("rss_collector feeds", time.Now(), subscriptions), nil
+}
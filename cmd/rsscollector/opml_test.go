@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/opml"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestImportAndExportSubscriptions(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<rss version="2.0"><channel><title>Collected %s</title>
+<item><title>Item</title><guid>%s/item</guid></item></channel></rss>`, r.URL.Path, r.URL.Path)
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	feedCollector := collector.NewCollector(store, store, &collector.Config{})
+
+	subscriptions := []opml.Subscription{
+		{FeedURL: feedServer.URL + "/a", Title: "A"},
+		{FeedURL: feedServer.URL + "/b", Title: "B", Categories: []string{"News", "Tech"}},
+		{FeedURL: "not a url", Title: "Invalid"},
+	}
+	added, skipped, err := importSubscriptions(ctx, store, feedCollector, subscriptions)
+	require.Nil(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, 0, skipped)
+
+	// Feeds already added are skipped however their URL is written.
+	subscriptions = append(subscriptions, opml.Subscription{FeedURL: feedServer.URL + "/a/?utm_source=opml", Title: "A again"})
+	added, skipped, err = importSubscriptions(ctx, store, feedCollector, subscriptions)
+	require.Nil(t, err)
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 3, skipped)
+
+	categories, err := store.FetchAllCategories(ctx)
+	require.Nil(t, err)
+	assert.Len(t, categories, 2)
+
+	doc, err := exportSubscriptions(ctx, store)
+	require.Nil(t, err)
+	exported := doc.Subscriptions()
+	require.Len(t, exported, 2)
+	for _, subscription := range exported {
+		switch subscription.FeedURL {
+		case feedServer.URL + "/a":
+			assert.Equal(t, "Collected /a", subscription.Title)
+			assert.Empty(t, subscription.Categories)
+		case feedServer.URL + "/b":
+			assert.Equal(t, "Collected /b", subscription.Title)
+			assert.ElementsMatch(t, []string{"News", "Tech"}, subscription.Categories)
+		default:
+			t.Errorf("unexpected subscription %s", subscription.FeedURL)
+		}
+	}
+}
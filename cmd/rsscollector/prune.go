@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runPrune removes the items the retention policies don't keep, or with
+// -dry-run only reports them. It is intended to be run from cron when
+// scheduled pruning is disabled.
+func runPrune(args []string) error {
+	var dryRun bool
+	cfg, fs, err := parseCommand("prune", "", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "report the items that would be removed without removing them")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	report, err := newPruner(cfg, store).Prune(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKEPT\tREMOVED\tFEED URL")
+	for _, feed := range report.Feeds {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", feed.FeedID, feed.Kept, feed.Removed, feed.FeedURL)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("would remove %d items\n", report.Removed)
+	} else {
+		fmt.Printf("removed %d items\n", report.Removed)
+	}
+	return nil
+}
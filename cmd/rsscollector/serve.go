@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/server"
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+
+	"github.com/rs/zerolog/log"
+)
+
+// runServe runs the HTTP API and scheduled collection until interrupted.
+func runServe(args []string) error {
+	cfg, fs, err := parseCommand("serve", "", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	metrics.SetPerFeedLabels(cfg.Metrics.PerFeedLabels)
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	store, err := openStores(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	policy, err := newFetchPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	icons, err := newIconFetcher(cfg, policy)
+	if err != nil {
+		return err
+	}
+	box, err := newSecretsBox(cfg)
+	if err != nil {
+		return err
+	}
+	extractor := newExtractor(cfg, store, policy)
+	feedCollector := newCollector(cfg, store, icons, extractor, box, policy)
+	collectorCtx, stopCollector := context.WithCancel(context.Background())
+	defer stopCollector()
+	go feedCollector.Run(collectorCtx)
+	if extractor != nil {
+		extractor.Run(collectorCtx)
+		defer extractor.Wait()
+	}
+	go metrics.UpdateTotals(collectorCtx, store.Count, cfg.Metrics.TotalsInterval.Duration())
+	pruner := newPruner(cfg, store)
+	go pruner.Run(collectorCtx, cfg.Retention.Interval.Duration())
+
+	s := server.NewHTTPFeedServer(store, store, store, store, feedCollector, pruner, icons,
+		&server.Config{
+			Address:        cfg.Server.ListenAddress,
+			TLSCertFile:    cfg.Server.TLSCertFile,
+			TLSKeyFile:     cfg.Server.TLSKeyFile,
+			CacheTTL:       cfg.Server.CacheTTL.Duration(),
+			APIKeys:        cfg.Auth.APIKeys,
+			PublicMetrics:  cfg.Metrics.Public,
+			RequestTimeout: cfg.Server.RequestTimeout.Duration(),
+			ReadTimeout:    cfg.Server.ReadTimeout.Duration(),
+			BodyLimit:      cfg.Server.BodyLimit,
+			Secrets:        box,
+			FetchPolicy:    policy,
+		})
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info().Msgf("listening on %s", cfg.Server.ListenAddress)
+		serverErr <- s.Start()
+	}()
+
+	signals, stopSignals := commandContext()
+	defer stopSignals()
+
+	select {
+	case err := <-serverErr:
+		log.Error().Err(err).Msg("server detected an error")
+		stopCollector()
+		feedCollector.Stop()
+		pruner.Stop()
+		feedCollector.Wait()
+		pruner.Wait()
+		return err
+	case <-signals.Done():
+		log.Info().Msg("shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		cfg.Server.ShutdownTimeout.Duration())
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to drain requests")
+	}
+
+	// No more collections and prunes are scheduled and those in progress are
+	// given until the shutdown deadline to finish. Any still running then are
+	// cancelled, the stores only ever commit complete collections and each
+	// feed's items are removed in a single call.
+	feedCollector.Stop()
+	pruner.Stop()
+	finished := make(chan struct{})
+	go func() {
+		feedCollector.Wait()
+		pruner.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-shutdownCtx.Done():
+		log.Warn().Msg("cancelling collections and prunes still running at the shutdown deadline")
+		stopCollector()
+		<-finished
+	}
+	stopCollector()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to flush traces")
+	}
+	log.Info().Msg("shutdown complete")
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runTrash runs the trash subcommands.
+func runTrash(args []string) error {
+	if len(args) == 0 {
+		return unknownSubcommand("trash", args, "list", "restore", "purge")
+	}
+
+	switch args[0] {
+	case "list":
+		return trashList(args[1:])
+	case "restore":
+		return trashRestore(args[1:])
+	case "purge":
+		return trashPurge(args[1:])
+	default:
+		return unknownSubcommand("trash", args, "list", "restore", "purge")
+	}
+}
+
+func trashList(args []string) error {
+	cfg, fs, err := parseCommand("trash list", "", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	trash, err := store.FetchTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tID\tDELETED AT\tNAME")
+	for _, feed := range trash.Feeds {
+		fmt.Fprintf(w, "feeds\t%s\t%s\t%s\n", feed.ID, deletedAt(feed.DeletedAt), feed.FeedURL)
+	}
+	for _, item := range trash.Items {
+		fmt.Fprintf(w, "items\t%s\t%s\t%s\n", item.ID, deletedAt(item.DeletedAt), item.Title)
+	}
+	for _, category := range trash.Categories {
+		fmt.Fprintf(w, "categories\t%s\t%s\t%s\n", category.ID, deletedAt(category.DeletedAt), category.Name)
+	}
+	return w.Flush()
+}
+
+func deletedAt(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func trashRestore(args []string) error {
+	cfg, fs, err := parseCommand("trash restore", "<feeds|items|categories> <ID>...", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return usageError(fs, "a type and at least one ID are needed")
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	var restore func(ctx context.Context, id string) error
+	switch fs.Arg(0) {
+	case "feeds":
+		restore = store.RestoreSource
+	case "items":
+		restore = store.RestoreItem
+	case "categories":
+		restore = store.RestoreCategory
+	default:
+		return usageError(fs, "unknown type %q, expected feeds, items or categories", fs.Arg(0))
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	for _, id := range fs.Args()[1:] {
+		if err := restore(ctx, id); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", id, err)
+		}
+		fmt.Printf("restored %s\n", id)
+	}
+	return nil
+}
+
+// trashPurge permanently removes the objects in the trash for longer than the
+// trash period, or with -all everything in the trash.
+func trashPurge(args []string) error {
+	var all bool
+	cfg, fs, err := parseCommand("trash purge", "", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&all, "all", false, "remove everything in the trash regardless of the trash period")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments: %v", fs.Args())
+	}
+
+	store, err := openStoresForCommand(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeRepos(store)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	var purged int
+	if all {
+		purged, err = store.PurgeTrash(ctx, time.Now())
+	} else {
+		purged, err = newPruner(cfg, store).PurgeTrash(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("purged %d objects\n", purged)
+	return nil
+}
@@ -3,19 +3,21 @@ module github.com/JonPulfer/rss_collector
 go 1.16
 
 require (
-	github.com/andybalholm/brotli v1.0.1 // indirect
+	github.com/PuerkitoBio/goquery v1.5.1
+	github.com/andybalholm/cascadia v1.1.0
 	github.com/gofiber/fiber/v2 v2.5.0
-	github.com/golang-migrate/migrate/v4 v4.14.1
-	github.com/google/uuid v1.2.0
-	github.com/klauspost/compress v1.11.3 // indirect
-	github.com/kr/text v0.2.0 // indirect
+	github.com/golang-migrate/migrate/v4 v4.15.2
+	github.com/google/uuid v1.3.0
 	github.com/lib/pq v1.10.0
 	github.com/mmcdole/gofeed v1.1.0
+	github.com/prometheus/client_golang v1.11.0
 	github.com/rs/zerolog v1.20.0
-	github.com/stretchr/testify v1.6.1
-	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
-	golang.org/x/sys v0.0.0-20210309074719-68d13333faf2 // indirect
-	golang.org/x/text v0.3.5 // indirect
-	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
+	github.com/stretchr/testify v1.7.0
+	github.com/valyala/fasthttp v1.34.0
+	go.opentelemetry.io/otel v1.3.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.3.0
+	go.opentelemetry.io/otel/sdk v1.3.0
+	go.opentelemetry.io/otel/trace v1.3.0
+	golang.org/x/net v0.0.0-20220225172249-27dd8689420f
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
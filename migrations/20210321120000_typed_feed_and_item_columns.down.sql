@@ -0,0 +1,51 @@
+drop index item_categories_category_id_idx;
+drop index items_source_id_published_idx;
+drop index items_published_idx;
+
+alter table items add column item_data text;
+
+update items set item_data = jsonb_strip_nulls(jsonb_build_object(
+    'id', id,
+    'sourceId', source_id,
+    'title', nullif(title, ''),
+    'description', nullif(description, ''),
+    'content', nullif(content, ''),
+    'link', nullif(link, ''),
+    'updated', updated,
+    'published', published,
+    'author', nullif(author, ''),
+    'guid', nullif(guid, ''),
+    'image', case when image_url is null then null
+        else jsonb_build_object('url', image_url, 'title', coalesce(image_title, '')) end,
+    'categories', case when cardinality(categories) = 0 then null else to_jsonb(categories) end,
+    'custom', custom
+))::text;
+
+alter table items
+    drop column title,
+    drop column description,
+    drop column content,
+    drop column link,
+    drop column guid,
+    drop column published,
+    drop column updated,
+    drop column author,
+    drop column image_url,
+    drop column image_title,
+    drop column categories,
+    drop column custom;
+
+alter table feeds add column feed_data text;
+
+update feeds set feed_data = jsonb_build_object(
+    'id', id,
+    'link', '/feeds/' || id,
+    'feedUrl', feed_url,
+    'title', title,
+    'lastCollected', coalesce(last_collected, '0001-01-01T00:00:00Z'::timestamptz),
+    'feedItems', null
+)::text;
+
+alter table feeds
+    drop column title,
+    drop column last_collected;
@@ -0,0 +1,45 @@
+alter table feeds
+    add column title text not null default '',
+    add column last_collected timestamptz;
+
+update feeds set
+    title = coalesce(feed_data::jsonb ->> 'title', ''),
+    last_collected = nullif(feed_data::jsonb ->> 'lastCollected', '0001-01-01T00:00:00Z')::timestamptz
+where coalesce(feed_data, '') <> '';
+
+alter table feeds drop column feed_data;
+
+alter table items
+    add column title text not null default '',
+    add column description text not null default '',
+    add column content text not null default '',
+    add column link text not null default '',
+    add column guid text not null default '',
+    add column published timestamptz,
+    add column updated timestamptz,
+    add column author text not null default '',
+    add column image_url text,
+    add column image_title text,
+    add column categories text[] not null default '{}',
+    add column custom jsonb;
+
+update items set
+    title = coalesce(item_data::jsonb ->> 'title', ''),
+    description = coalesce(item_data::jsonb ->> 'description', ''),
+    content = coalesce(item_data::jsonb ->> 'content', ''),
+    link = coalesce(item_data::jsonb ->> 'link', ''),
+    guid = coalesce(item_data::jsonb ->> 'guid', ''),
+    published = (item_data::jsonb ->> 'published')::timestamptz,
+    updated = (item_data::jsonb ->> 'updated')::timestamptz,
+    author = coalesce(item_data::jsonb ->> 'author', ''),
+    image_url = item_data::jsonb -> 'image' ->> 'url',
+    image_title = item_data::jsonb -> 'image' ->> 'title',
+    categories = array(select jsonb_array_elements_text(item_data::jsonb -> 'categories')),
+    custom = item_data::jsonb -> 'custom'
+where coalesce(item_data, '') <> '';
+
+alter table items drop column item_data;
+
+create index items_published_idx on items(published nulls first);
+create index items_source_id_published_idx on items(source_id, published nulls first);
+create index item_categories_category_id_idx on item_categories(category_id);
@@ -0,0 +1 @@
+drop index items_source_id_guid_idx;
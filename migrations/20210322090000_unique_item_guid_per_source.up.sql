@@ -0,0 +1,10 @@
+-- Keep a single stored copy of any item that was collected more than once.
+delete from item_categories where item_id in (
+    select a.id from items a join items b
+        on a.source_id = b.source_id and a.guid = b.guid and a.id > b.id
+    where a.guid <> ''
+);
+delete from items a using items b
+where a.source_id = b.source_id and a.guid = b.guid and a.id > b.id and a.guid <> '';
+
+create unique index items_source_id_guid_idx on items(source_id, guid) where guid <> '';
@@ -0,0 +1 @@
+alter table items add column starred boolean not null default false;
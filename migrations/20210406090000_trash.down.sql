@@ -0,0 +1,24 @@
+drop index categories_deleted_at_idx;
+drop index items_deleted_at_idx;
+drop index feeds_deleted_at_idx;
+
+-- Objects in the trash are removed, as the schema can't hide them any more.
+delete from item_categories where item_id in (
+    select id from items where deleted_at is not null
+    or source_id in (select id from feeds where deleted_at is not null))
+or category_id in (select id from categories where deleted_at is not null);
+delete from feed_categories where feed_id in (select id from feeds where deleted_at is not null)
+or category_id in (select id from categories where deleted_at is not null);
+delete from items where deleted_at is not null
+or source_id in (select id from feeds where deleted_at is not null);
+delete from feeds where deleted_at is not null;
+delete from categories where deleted_at is not null;
+
+drop index categories_category_name_idx;
+create unique index categories_category_name_idx on categories(category_name);
+drop index feeds_feeds_url_idx;
+create unique index feeds_feeds_url_idx on feeds(feed_url);
+
+alter table categories drop column deleted_at;
+alter table items drop column deleted_at;
+alter table feeds drop column deleted_at;
@@ -0,0 +1,13 @@
+alter table feeds add column deleted_at timestamptz;
+alter table items add column deleted_at timestamptz;
+alter table categories add column deleted_at timestamptz;
+
+-- A feed URL or category name may be reused while the old one is in the trash.
+drop index feeds_feeds_url_idx;
+create unique index feeds_feeds_url_idx on feeds(feed_url) where deleted_at is null;
+drop index categories_category_name_idx;
+create unique index categories_category_name_idx on categories(category_name) where deleted_at is null;
+
+create index feeds_deleted_at_idx on feeds(deleted_at) where deleted_at is not null;
+create index items_deleted_at_idx on items(deleted_at) where deleted_at is not null;
+create index categories_deleted_at_idx on categories(deleted_at) where deleted_at is not null;
@@ -0,0 +1,2 @@
+alter table items drop column media;
+alter table items drop column enclosures;
@@ -0,0 +1,4 @@
+-- Enclosures are a list of objects and media a nested object, both only
+-- read back whole, so they are kept as JSON like custom.
+alter table items add column enclosures jsonb;
+alter table items add column media jsonb;
@@ -0,0 +1,10 @@
+alter table feeds drop column feed_version;
+alter table feeds drop column feed_type;
+alter table feeds drop column generator;
+alter table feeds drop column copyright;
+alter table feeds drop column authors;
+alter table feeds drop column image_title;
+alter table feeds drop column image_url;
+alter table feeds drop column language;
+alter table feeds drop column site_link;
+alter table feeds drop column description;
@@ -0,0 +1,13 @@
+-- The text columns default to empty so feeds stored before they existed
+-- scan like feeds without the metadata. Authors are kept as JSON like item
+-- enclosures.
+alter table feeds add column description text not null default '';
+alter table feeds add column site_link text not null default '';
+alter table feeds add column language text not null default '';
+alter table feeds add column image_url text;
+alter table feeds add column image_title text;
+alter table feeds add column authors jsonb;
+alter table feeds add column copyright text not null default '';
+alter table feeds add column generator text not null default '';
+alter table feeds add column feed_type text not null default '';
+alter table feeds add column feed_version text not null default '';
@@ -0,0 +1,3 @@
+alter table items drop column raw_content;
+alter table items drop column raw_description;
+alter table items drop column summary;
@@ -0,0 +1,4 @@
+-- Items stored before they were sanitized have no summary or raw copy.
+alter table items add column summary text not null default '';
+alter table items add column raw_description text not null default '';
+alter table items add column raw_content text not null default '';
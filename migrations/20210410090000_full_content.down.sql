@@ -0,0 +1,2 @@
+alter table items drop column extracted_at;
+alter table feeds drop column fetch_full_content;
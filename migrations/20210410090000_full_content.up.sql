@@ -0,0 +1,2 @@
+alter table feeds add column fetch_full_content boolean not null default false;
+alter table items add column extracted_at timestamptz;
@@ -0,0 +1 @@
+alter table feeds add column scrape jsonb;
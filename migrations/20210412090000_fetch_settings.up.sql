@@ -0,0 +1 @@
+alter table feeds add column fetch_settings jsonb;
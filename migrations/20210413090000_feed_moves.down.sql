@@ -0,0 +1,3 @@
+alter table feeds drop column dead_at;
+alter table feeds drop column duplicate_of;
+alter table feeds drop column url_history;
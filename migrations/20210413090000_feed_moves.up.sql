@@ -0,0 +1,3 @@
+alter table feeds add column url_history jsonb;
+alter table feeds add column duplicate_of varchar(40);
+alter table feeds add column dead_at timestamptz;
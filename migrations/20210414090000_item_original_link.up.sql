@@ -0,0 +1 @@
+alter table items add column original_link text not null default '';
@@ -0,0 +1,14 @@
+-- Duplicates sharing the URL of another live feed are moved to the trash
+-- along with their items, as the schema can't hold both any more.
+update items set deleted_at = now() where deleted_at is null and source_id in (
+    select id from feeds where deleted_at is null and duplicate_of is not null
+    and exists (select 1 from feeds other where other.deleted_at is null
+        and other.feed_url = feeds.feed_url and other.id <> feeds.id
+        and (other.duplicate_of is null or other.id < feeds.id)));
+update feeds set deleted_at = now() where deleted_at is null and duplicate_of is not null
+and exists (select 1 from feeds other where other.deleted_at is null
+    and other.feed_url = feeds.feed_url and other.id <> feeds.id
+    and (other.duplicate_of is null or other.id < feeds.id));
+
+drop index feeds_feeds_url_idx;
+create unique index feeds_feeds_url_idx on feeds(feed_url) where deleted_at is null;
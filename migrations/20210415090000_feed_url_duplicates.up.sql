@@ -0,0 +1,4 @@
+-- Feeds flagged as duplicates share the URL of the feed they duplicate once
+-- their URLs are normalised.
+drop index feeds_feeds_url_idx;
+create unique index feeds_feeds_url_idx on feeds(feed_url) where deleted_at is null and duplicate_of is null;
@@ -0,0 +1 @@
+alter table feeds drop column pruned_guids;
@@ -0,0 +1 @@
+alter table feeds add column pruned_guids jsonb;
@@ -0,0 +1,11 @@
+// Package migrations embeds the database schema migrations so they are
+// applied from the binary rather than from files deployed beside it.
+package migrations
+
+import "embed"
+
+// FS holds the up and down migrations, named <version>_<title>.<up|down>.sql
+// as golang-migrate expects.
+//
+//go:embed *.sql
+var FS embed.FS
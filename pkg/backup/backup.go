@@ -0,0 +1,216 @@
+// Package backup writes the whole dataset held by the object stores to a
+// portable archive and loads it back into any backend, so data can be moved
+// between the memory store and PostgreSQL or between environments.
+//
+// An archive is newline delimited JSON. The first line is a header giving
+// the format version, followed by a line per category, then each feed
+// followed by its items. Feeds and items refer to their categories by ID and
+// every object keeps its ID when loaded, so the links between them survive.
+// The trash is not archived.
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+// Version of the archive format written by Export. Import refuses archives
+// written with a newer version.
+const Version = 1
+
+// ContentType of an archive.
+const ContentType = "application/x-ndjson"
+
+// itemBatchSize bounds the items stored in a single call when importing.
+const itemBatchSize = 500
+
+// Record types, one per line of an archive.
+const (
+	recordHeader   = "header"
+	recordCategory = "category"
+	recordFeed     = "feed"
+	recordItem     = "item"
+)
+
+// record is a single line of an archive, holding the object named by Type.
+type record struct {
+	Type      string                          `json:"type"`
+	Version   int                             `json:"version,omitempty"`
+	CreatedAt *time.Time                      `json:"createdAt,omitempty"`
+	Category  *rsscollector.FeedCategory      `json:"category,omitempty"`
+	Feed      *rsscollector.FeedSourcePartial `json:"feed,omitempty"`
+	Item      *rsscollector.FeedItem          `json:"item,omitempty"`
+}
+
+// Summary counts the objects exported or imported.
+type Summary struct {
+	Categories int `json:"categories"`
+	Feeds      int `json:"feeds"`
+	Items      int `json:"items"`
+}
+
+// Export writes an archive of every category, feed and item to w. Items are
+// fetched a feed at a time so the dataset is never held in memory at once.
+func Export(
+	ctx context.Context,
+	w io.Writer,
+	feedRepos repository.FeedSourceStore,
+	itemRepos repository.FeedItemStore,
+	categoryRepos repository.FeedCategoryStore) (Summary, error) {
+
+	var summary Summary
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	now := time.Now().UTC()
+	if err := encoder.Encode(record{Type: recordHeader, Version: Version, CreatedAt: &now}); err != nil {
+		return summary, err
+	}
+
+	categories, err := categoryRepos.FetchAllCategories(ctx)
+	if err != nil {
+		return summary, err
+	}
+	for i := range categories {
+		if err := encoder.Encode(record{Type: recordCategory, Category: &categories[i]}); err != nil {
+			return summary, err
+		}
+		summary.Categories++
+	}
+
+	sources, err := feedRepos.FetchAllSources(ctx)
+	if err != nil {
+		return summary, err
+	}
+	for i := range sources {
+		if err := encoder.Encode(record{Type: recordFeed, Feed: &sources[i]}); err != nil {
+			return summary, err
+		}
+		summary.Feeds++
+
+		items, err := itemRepos.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: sources[i].ID})
+		if err != nil {
+			return summary, err
+		}
+		for _, item := range items {
+			if err := encoder.Encode(record{Type: recordItem, Item: item}); err != nil {
+				return summary, err
+			}
+			summary.Items++
+		}
+	}
+	return summary, buffered.Flush()
+}
+
+// Import loads an archive written by Export into the stores, keeping the IDs
+// of every object. Objects already stored with the same IDs are overwritten.
+// Loading into a store that holds other feeds or categories with the same
+// feed URLs or names may fail, depending on the backend.
+//
+// The archive is read a line at a time, storing each feed's items in
+// batches, so it is not held in memory at once. Should it fail part way
+// through, the objects stored so far are left in place.
+func Import(
+	ctx context.Context,
+	r io.Reader,
+	feedRepos repository.FeedSourceStore,
+	itemRepos repository.FeedItemStore,
+	categoryRepos repository.FeedCategoryStore) (Summary, error) {
+
+	var summary Summary
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	var header record
+	if err := decoder.Decode(&header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return summary, errors.New("empty archive")
+		}
+		return summary, fmt.Errorf("invalid archive header: %w", err)
+	}
+	if header.Type != recordHeader {
+		return summary, errors.New("not an archive, the header is missing")
+	}
+	if header.Version < 1 || header.Version > Version {
+		return summary, fmt.Errorf("unsupported archive version %d, expected at most %d", header.Version, Version)
+	}
+
+	var sourceID string
+	batch := make([]*rsscollector.FeedItem, 0, itemBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := itemRepos.StoreItems(ctx, sourceID, batch); err != nil {
+			return fmt.Errorf("failed to store items of feed %s: %w", sourceID, err)
+		}
+		summary.Items += len(batch)
+		batch = make([]*rsscollector.FeedItem, 0, itemBatchSize)
+		return nil
+	}
+
+	for line := 2; ; line++ {
+		var rec record
+		err := decoder.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return summary, fmt.Errorf("invalid record %d: %w", line, err)
+		}
+
+		switch {
+		case rec.Type == recordCategory && rec.Category != nil:
+			if len(rec.Category.ID) == 0 {
+				return summary, fmt.Errorf("category on record %d has no ID", line)
+			}
+			if err := categoryRepos.StoreCategory(ctx, rec.Category); err != nil {
+				return summary, fmt.Errorf("failed to store category %s: %w", rec.Category.ID, err)
+			}
+			summary.Categories++
+
+		case rec.Type == recordFeed && rec.Feed != nil:
+			if err := flush(); err != nil {
+				return summary, err
+			}
+			if len(rec.Feed.ID) == 0 {
+				return summary, fmt.Errorf("feed on record %d has no ID", line)
+			}
+			rec.Feed.DeletedAt = nil
+			source := rsscollector.FeedSource{FeedSourcePartial: *rec.Feed}
+			if err := feedRepos.StoreSource(ctx, &source); err != nil {
+				return summary, fmt.Errorf("failed to store feed %s: %w", source.ID, err)
+			}
+			sourceID = source.ID
+			summary.Feeds++
+
+		case rec.Type == recordItem && rec.Item != nil:
+			if len(rec.Item.ID) == 0 {
+				return summary, fmt.Errorf("item on record %d has no ID", line)
+			}
+			// Items follow the feed they belong to.
+			if rec.Item.SourceID != sourceID {
+				return summary, fmt.Errorf("item %s on record %d does not follow its feed %s",
+					rec.Item.ID, line, rec.Item.SourceID)
+			}
+			rec.Item.DeletedAt = nil
+			batch = append(batch, rec.Item)
+			if len(batch) == itemBatchSize {
+				if err := flush(); err != nil {
+					return summary, err
+				}
+			}
+
+		default:
+			return summary, fmt.Errorf("unknown record %d of type %q", line, rec.Type)
+		}
+	}
+	return summary, flush()
+}
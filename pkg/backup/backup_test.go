@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	original := repository.NewMemoryStore()
+
+	news := rsscollector.FeedCategory{Name: "News"}
+	require.Nil(t, original.StoreCategory(ctx, &news))
+	lastCollected := time.Date(2021, 4, 7, 12, 0, 0, 0, time.UTC)
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{
+			FeedURL:       "http://example.com/feed.xml",
+			Title:         "Example",
+			CategoryIDs:   []string{news.ID},
+			LastCollected: lastCollected,
+		},
+	}
+	require.Nil(t, original.StoreSource(ctx, &source))
+	// More items than fit in a batch are stored in several calls.
+	items := make([]*rsscollector.FeedItem, 0, itemBatchSize+1)
+	for i := 0; i <= itemBatchSize; i++ {
+		published := lastCollected.Add(-time.Duration(i) * time.Minute)
+		items = append(items, &rsscollector.FeedItem{GUID: fmt.Sprint(i), Published: &published})
+	}
+	items[0].CategoryIDs = []string{news.ID}
+	items[0].Starred = true
+	require.Nil(t, original.StoreItems(ctx, source.ID, items))
+	// Deleted objects are not archived.
+	require.Nil(t, original.DeleteItemByID(ctx, items[1].ID))
+	deleted := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/deleted.xml"},
+	}
+	require.Nil(t, original.StoreSource(ctx, &deleted))
+	require.Nil(t, original.DeleteSourceByID(ctx, deleted.ID))
+
+	var archive bytes.Buffer
+	exported, err := Export(ctx, &archive, original, original, original)
+	require.Nil(t, err)
+	assert.Equal(t, Summary{Categories: 1, Feeds: 1, Items: itemBatchSize}, exported)
+
+	restored := repository.NewMemoryStore()
+	imported, err := Import(ctx, &archive, restored, restored, restored)
+	require.Nil(t, err)
+	assert.Equal(t, exported, imported)
+
+	category, err := restored.FetchCategoryByID(ctx, news.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "News", category.Name)
+
+	fetched, err := restored.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, source.FeedURL, fetched.FeedURL)
+	assert.Equal(t, source.Title, fetched.Title)
+	assert.Equal(t, []string{news.ID}, fetched.CategoryIDs)
+	assert.True(t, lastCollected.Equal(fetched.LastCollected))
+
+	item, err := restored.FetchItemByID(ctx, items[0].ID)
+	require.Nil(t, err)
+	assert.Equal(t, source.ID, item.SourceID)
+	assert.Equal(t, []string{news.ID}, item.CategoryIDs)
+	assert.True(t, item.Starred)
+	_, err = restored.FetchItemByID(ctx, items[1].ID)
+	assert.NotNil(t, err)
+
+	// Importing again overwrites rather than duplicates.
+	archive.Reset()
+	_, err = Export(ctx, &archive, original, original, original)
+	require.Nil(t, err)
+	_, err = Import(ctx, &archive, restored, restored, restored)
+	require.Nil(t, err)
+	all, err := restored.FetchAllItems(ctx, rsscollector.ItemOptions{})
+	require.Nil(t, err)
+	assert.Len(t, all, itemBatchSize)
+}
+
+func TestImportInvalid(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Archive string
+	}{
+		{"Empty", ""},
+		{"Not JSON", "feeds\n"},
+		{"No header", `{"type":"category","category":{"id":"1","name":"News"}}` + "\n"},
+		{"Newer version", `{"type":"header","version":2}` + "\n"},
+		{"Unknown record", `{"type":"header","version":1}` + "\n" + `{"type":"user"}` + "\n"},
+		{"Item without its feed", `{"type":"header","version":1}` + "\n" +
+			`{"type":"item","item":{"id":"1","sourceId":"2"}}` + "\n"},
+		{"Feed without an ID", `{"type":"header","version":1}` + "\n" +
+			`{"type":"feed","feed":{"feedUrl":"http://example.com/feed.xml"}}` + "\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			store := repository.NewMemoryStore()
+			_, err := Import(context.Background(), strings.NewReader(tc.Archive), store, store, store)
+			assert.NotNil(t, err)
+		})
+	}
+}
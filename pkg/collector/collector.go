@@ -0,0 +1,852 @@
+// Package collector fetches feed sources and stores the items they contain,
+// either on demand or on a schedule.
+package collector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/feed"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/sanitize"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
+)
+
+// Config for a Collector. Zero durations disable the corresponding timeout
+// or, for RefreshInterval, scheduled collection.
+type Config struct {
+	// RefreshInterval between scheduled collections of every feed source.
+	RefreshInterval time.Duration
+	// FetchTimeout bounds fetching and parsing a single feed.
+	FetchTimeout time.Duration
+	// StoreTimeout bounds storing the results of a single feed collection.
+	StoreTimeout time.Duration
+	// Concurrency is the number of feed sources CollectAll collects at once,
+	// one if it isn't set.
+	Concurrency int
+	// UserAgent sent when fetching feeds, feed.DefaultUserAgent if it isn't
+	// set.
+	UserAgent string
+	// HostConcurrency is the number of feeds fetched from the same host at
+	// once, unlimited when it isn't set.
+	HostConcurrency int
+	// HostInterval between starting requests to the same host.
+	HostInterval time.Duration
+	// RateLimit is the number of requests started each second across every
+	// host, unlimited when it isn't set.
+	RateLimit int
+	// CrawlDelay spaces the requests to each host by the Crawl-delay of its
+	// robots.txt, when that is longer than HostInterval.
+	CrawlDelay bool
+	// Icons, when set, refreshes the icon of each feed after it is collected.
+	Icons IconRefresher
+	// Sanitizer, when set, cleans the HTML of each item before it is stored.
+	// Items are stored as the feed gives them otherwise.
+	Sanitizer *sanitize.Sanitizer
+	// Extractor, when set, fetches the full article for the new items of
+	// feeds that ask for it and for items extracted on demand.
+	Extractor ContentExtractor
+	// Secrets decrypts the credentials of feeds with fetch settings. Feeds
+	// whose settings hold secrets can't be collected without it.
+	Secrets *secrets.Box
+	// Policy, when set, guards every feed fetched against reaching private
+	// networks and limits the responses read. Any feed can be fetched
+	// otherwise.
+	Policy *fetchpolicy.Policy
+	// Resolver, when set, replaces the links of new items on redirector
+	// hosts with the pages they lead to.
+	Resolver *urlnorm.Resolver
+}
+
+// IconRefresher keeps the icon of a feed up to date, e.g. an icon.Fetcher.
+type IconRefresher interface {
+	Refresh(ctx context.Context, source rsscollector.FeedSourcePartial) error
+}
+
+// ContentExtractor replaces the content of an item with the article on the
+// page it links to, e.g. an extract.Extractor.
+type ContentExtractor interface {
+	// Enqueue the item to be extracted in the background, reporting false
+	// when it couldn't be.
+	Enqueue(itemID string) bool
+	Extract(ctx context.Context, itemID string) (rsscollector.FeedItem, error)
+}
+
+// ErrStopped is returned for a feed added or collected once the collector
+// has been stopped.
+var ErrStopped = errors.New("the collector has been stopped")
+
+// ErrExtractionDisabled is returned by ExtractContent when the collector has
+// no ContentExtractor.
+var ErrExtractionDisabled = errors.New("full content extraction is disabled")
+
+// Collector fetches feed sources and stores their items.
+type Collector struct {
+	feedRepos  repository.FeedSourceStore
+	itemRepos  repository.FeedItemStore
+	httpClient *http.Client
+	politeness *politeness
+	config     *Config
+	running    sync.WaitGroup
+	// stopMu orders counting work in progress with Stop, so that none is
+	// counted once Wait may have started waiting.
+	stopMu sync.Mutex
+	stop   chan struct{}
+}
+
+func NewCollector(
+	feedRepos repository.FeedSourceStore,
+	itemRepos repository.FeedItemStore,
+	config *Config) *Collector {
+	httpClient := newHTTPClient(config)
+	return &Collector{
+		feedRepos:  feedRepos,
+		itemRepos:  itemRepos,
+		httpClient: httpClient,
+		politeness: newPoliteness(config, httpClient),
+		config:     config,
+		stop:       make(chan struct{}),
+	}
+}
+
+// newHTTPClient shared by every feed, whose connections to a host are kept
+// open between feeds and collections.
+func newHTTPClient(config *Config) *http.Client {
+	httpClient := &http.Client{
+		Timeout:   config.FetchTimeout,
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	if config.Policy != nil {
+		httpClient = config.Policy.Client(config.FetchTimeout)
+	}
+	transport := httpClient.Transport.(*http.Transport)
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 2
+	if config.HostConcurrency > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = config.HostConcurrency
+	}
+	transport.MaxConnsPerHost = config.HostConcurrency
+	return httpClient
+}
+
+// AddSource fetches the feed at feedURL and stores it as a new source along
+// with its items.
+func (c *Collector) AddSource(ctx context.Context, feedURL string) (rsscollector.FeedSource, error) {
+	return c.AddSourceFrom(ctx, rsscollector.FeedSourcePartial{FeedURL: feedURL})
+}
+
+// AddScrapedSource scrapes the web page at pageURL for the items the
+// selectors find and stores it as a new source along with its items. The
+// page is scraped again each time it is collected.
+func (c *Collector) AddScrapedSource(
+	ctx context.Context,
+	pageURL string,
+	selectors rsscollector.ScrapeSelectors) (rsscollector.FeedSource, error) {
+	return c.AddSourceFrom(ctx, rsscollector.FeedSourcePartial{FeedURL: pageURL, Scrape: &selectors})
+}
+
+// AddSourceFrom fetches the feed at the FeedURL of source, with its Scrape
+// selectors and Fetch settings when it has them, and stores it as a new
+// source along with its items. The secrets of the settings must already be
+// encrypted.
+func (c *Collector) AddSourceFrom(ctx context.Context, from rsscollector.FeedSourcePartial) (_ rsscollector.FeedSource, err error) {
+	if !c.begin() {
+		return rsscollector.FeedSource{}, ErrStopped
+	}
+	defer c.running.Done()
+
+	ctx, span := tracing.Tracer().Start(ctx, "collector.AddSource",
+		trace.WithAttributes(attribute.String("feed.url", from.FeedURL)))
+	defer tracing.EndSpan(span, &err)
+
+	// A feed already added is turned away before it is fetched again, though
+	// the store has the last word on it.
+	if err := repository.CheckNotAdded(ctx, c.feedRepos, from.FeedURL, ""); err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	source, err := c.newSource(from)
+	if err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	if err := c.fetch(ctx, source); err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	movedTo, reason := c.checkMove(ctx, source, from)
+	feedSource := source.FeedSource()
+	// A new source has no items stored yet.
+	c.resolveLinks(ctx, feedSource.FeedItems, nil)
+
+	storeCtx, cancel := withTimeout(ctx, c.config.StoreTimeout)
+	defer cancel()
+
+	feedSource.Fetch = from.Fetch
+	c.sanitize(feedSource)
+	if err := c.move(storeCtx, &feedSource.FeedSourcePartial, movedTo, reason); err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	if err := c.feedRepos.StoreSource(storeCtx, &feedSource); err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	newItems, err := c.storeItems(storeCtx, feedSource.ID, feedSource.FeedItems, nil)
+	if err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	c.refreshIcon(ctx, feedSource.FeedSourcePartial)
+	c.extract(feedSource.FeedSourcePartial, newItems)
+	return feedSource, nil
+}
+
+// PreviewScrape scrapes the web page at pageURL as AddScrapedSource would,
+// returning the source and its items without storing them, so selectors can
+// be tried out.
+func (c *Collector) PreviewScrape(
+	ctx context.Context,
+	pageURL string,
+	selectors rsscollector.ScrapeSelectors) (rsscollector.FeedSource, error) {
+	return c.PreviewSource(ctx, rsscollector.FeedSourcePartial{FeedURL: pageURL, Scrape: &selectors})
+}
+
+// PreviewSource fetches the source as AddSourceFrom would, returning it with
+// its items without storing them.
+func (c *Collector) PreviewSource(ctx context.Context, from rsscollector.FeedSourcePartial) (_ rsscollector.FeedSource, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "collector.PreviewSource",
+		trace.WithAttributes(attribute.String("feed.url", from.FeedURL)))
+	defer tracing.EndSpan(span, &err)
+
+	source, err := c.newSource(from)
+	if err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	if err := c.fetch(ctx, source); err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	feedSource := source.FeedSource()
+	feedSource.Fetch = from.Fetch
+	c.sanitize(feedSource)
+	return feedSource, nil
+}
+
+// CollectSource fetches the feed for an existing source, refreshes the stored
+// source details and stores any new or updated items. A feed that has moved
+// is given its new URL, and one served as 410 Gone is marked dead.
+func (c *Collector) CollectSource(ctx context.Context, feedSource rsscollector.FeedSourcePartial) error {
+	if !c.begin() {
+		return ErrStopped
+	}
+	defer c.running.Done()
+	return c.collectSource(ctx, feedSource)
+}
+
+// collectSource for CollectSource, or for a collection already counted in
+// progress which carries on once the collector is stopped.
+func (c *Collector) collectSource(ctx context.Context, feedSource rsscollector.FeedSourcePartial) (err error) {
+
+	ctx, span := tracing.Tracer().Start(ctx, "collector.CollectSource",
+		trace.WithAttributes(
+			attribute.String("feed.id", feedSource.ID),
+			attribute.String("feed.url", feedSource.FeedURL)))
+	defer tracing.EndSpan(span, &err)
+
+	source, err := c.newSource(feedSource)
+	if err != nil {
+		return err
+	}
+	source.ID = feedSource.ID
+	if err := c.fetch(ctx, source); err != nil {
+		var httpErr feed.HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusGone {
+			c.markDead(ctx, feedSource.ID)
+		}
+		return err
+	}
+	movedTo, reason := c.checkMove(ctx, source, feedSource)
+	collected := source.FeedSource()
+	if err := c.resolveNewLinks(ctx, feedSource.ID, collected.FeedItems, feedSource.PrunedGUIDs); err != nil {
+		return err
+	}
+
+	storeCtx, cancel := withTimeout(ctx, c.config.StoreTimeout)
+	defer cancel()
+
+	stored, err := c.feedRepos.FetchSource(storeCtx, feedSource.ID)
+	if err != nil {
+		return err
+	}
+	c.sanitize(collected)
+	stored.Title = collected.Title
+	stored.LastCollected = collected.LastCollected
+	stored.FeedMetadata = collected.FeedMetadata
+	stored.DeadAt = nil
+	stored.PrunedGUIDs = listedGUIDs(stored.PrunedGUIDs, collected.FeedItems)
+	if err := c.move(storeCtx, &stored.FeedSourcePartial, movedTo, reason); err != nil {
+		return err
+	}
+	if err := c.feedRepos.StoreSource(storeCtx, &stored); err != nil {
+		return err
+	}
+	newItems, err := c.storeItems(storeCtx, stored.ID, collected.FeedItems, stored.PrunedGUIDs)
+	if err != nil {
+		return err
+	}
+	c.refreshIcon(ctx, stored.FeedSourcePartial)
+	c.extract(stored.FeedSourcePartial, newItems)
+	return nil
+}
+
+// checkMove returns the URL the collected feed has moved to, and why, if it
+// has. A permanent redirect is followed as it is, a URL the document declares
+// is only once it has been fetched and parsed as a feed, and never back to a
+// URL the source had before.
+func (c *Collector) checkMove(ctx context.Context, source *feed.Source, from rsscollector.FeedSourcePartial) (string, string) {
+	if len(source.MovedTo) > 0 {
+		return source.MovedTo, rsscollector.URLChangeRedirect
+	}
+	declared, reason := source.DeclaredURL()
+	if len(declared) == 0 {
+		return "", ""
+	}
+	for _, change := range from.URLHistory {
+		if change.URL == declared {
+			return "", ""
+		}
+	}
+
+	moved := from
+	moved.FeedURL = declared
+	probe, err := c.newSource(moved)
+	if err != nil {
+		return "", ""
+	}
+	probe.ID = from.ID
+	if err := c.fetch(ctx, probe); err != nil {
+		log.Warn().Err(err).Str("feedId", from.ID).Str("feedUrl", from.FeedURL).Str("declaredUrl", declared).
+			Msg("feed declares a URL that can't be collected")
+		return "", ""
+	}
+	if len(probe.MovedTo) > 0 {
+		declared = probe.MovedTo
+	}
+	return declared, reason
+}
+
+// move the source to the URL it has moved to, recording the URL it had. A
+// source moving to the URL of another, however it is written, is flagged as
+// its duplicate instead, as two sources can't share a URL. A duplicate that
+// moves to a URL of its own is one no longer, and one that hasn't moved stays
+// flagged.
+func (c *Collector) move(ctx context.Context, source *rsscollector.FeedSourcePartial, movedTo, reason string) error {
+	if normalized, err := urlnorm.Normalize(movedTo); err == nil {
+		movedTo = normalized
+	}
+	if len(movedTo) == 0 || movedTo == source.FeedURL {
+		return nil
+	}
+	sources, err := c.feedRepos.FetchAllSources(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range sources {
+		if other.ID != source.ID && urlnorm.Key(other.FeedURL) == urlnorm.Key(movedTo) {
+			source.DuplicateOf = other.ID
+			log.Warn().Str("feedId", source.ID).Str("feedUrl", source.FeedURL).Str("duplicateOf", other.ID).
+				Msg("feed has moved to the URL of another feed")
+			return nil
+		}
+	}
+	log.Info().Str("feedId", source.ID).Str("feedUrl", source.FeedURL).Str("movedTo", movedTo).Str("reason", reason).
+		Msg("feed has moved")
+	source.URLHistory = append(source.URLHistory, rsscollector.FeedURLChange{
+		URL:       source.FeedURL,
+		Reason:    reason,
+		ChangedAt: time.Now(),
+	})
+	source.FeedURL = movedTo
+	source.DuplicateOf = ""
+	metrics.FeedMoves.WithLabelValues(reason).Inc()
+	return nil
+}
+
+// resolveNewLinks of the items not yet stored for the source, nor pruned
+// from it, as resolveLinks does. The stored items are only fetched when an
+// item links to a redirector.
+func (c *Collector) resolveNewLinks(ctx context.Context, sourceID string, items rsscollector.FeedItems, pruned []string) error {
+	if c.config.Resolver == nil {
+		return nil
+	}
+	redirected := false
+	for _, item := range items {
+		redirected = redirected || c.config.Resolver.Redirects(item.Link)
+	}
+	if !redirected {
+		return nil
+	}
+
+	storeCtx, cancel := withTimeout(ctx, c.config.StoreTimeout)
+	defer cancel()
+	existing, left, err := c.storedItems(storeCtx, sourceID, pruned)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]struct{}, len(existing)+len(left))
+	for guid := range existing {
+		stored[guid] = struct{}{}
+	}
+	for guid := range left {
+		stored[guid] = struct{}{}
+	}
+	c.resolveLinks(ctx, items, stored)
+	return nil
+}
+
+// resolveLinks of the items on redirector hosts, other than those whose GUID
+// is stored, to the pages they lead to when the collector has a Resolver.
+// Links that can't be resolved are left as they are.
+func (c *Collector) resolveLinks(ctx context.Context, items rsscollector.FeedItems, stored map[string]struct{}) {
+	if c.config.Resolver == nil {
+		return
+	}
+	for _, item := range items {
+		if _, ok := stored[item.GUID]; ok && len(item.GUID) > 0 {
+			continue
+		}
+		if !c.config.Resolver.Redirects(item.Link) {
+			continue
+		}
+		resolved, err := c.config.Resolver.Resolve(ctx, item.Link)
+		if err != nil {
+			log.Debug().Err(err).Str("link", item.Link).Msg("failed to resolve item link")
+			continue
+		}
+		if len(item.OriginalLink) == 0 {
+			item.OriginalLink = item.Link
+		}
+		item.Link = resolved
+	}
+}
+
+// markDead records that the feed was served as 410 Gone, so that scheduled
+// collections stop fetching it. Collecting it successfully revives it.
+func (c *Collector) markDead(ctx context.Context, feedID string) {
+	storeCtx, cancel := withTimeout(ctx, c.config.StoreTimeout)
+	defer cancel()
+
+	stored, err := c.feedRepos.FetchSource(storeCtx, feedID)
+	if err == nil {
+		now := time.Now()
+		stored.DeadAt = &now
+		err = c.feedRepos.StoreSource(storeCtx, &stored)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("feedId", feedID).Msg("failed to mark feed dead")
+		return
+	}
+	log.Warn().Str("feedId", feedID).Str("feedUrl", stored.FeedURL).Msg("feed is gone, it won't be collected again")
+}
+
+// sanitize the items of the collected source, resolving their relative URLs
+// against the feed's site or, when it has none, the feed itself.
+func (c *Collector) sanitize(source rsscollector.FeedSource) {
+	if c.config.Sanitizer == nil {
+		return
+	}
+	base, err := url.Parse(source.SiteLink)
+	if err != nil || !base.IsAbs() {
+		base, _ = url.Parse(source.FeedURL)
+	}
+	for _, item := range source.FeedItems {
+		c.config.Sanitizer.Item(item, base)
+	}
+}
+
+// refreshIcon of the source when icons are enabled. The collection has
+// succeeded by now so failing to find an icon is only logged, the feed is
+// served with a placeholder instead.
+func (c *Collector) refreshIcon(ctx context.Context, source rsscollector.FeedSourcePartial) {
+	if c.config.Icons == nil {
+		return
+	}
+	if err := c.config.Icons.Refresh(ctx, source); err != nil {
+		log.Warn().Err(err).Str("feedId", source.ID).Str("feedUrl", source.FeedURL).
+			Msg("failed to fetch feed icon")
+	}
+}
+
+// extract the full content of the new items in the background when the
+// source asks for it and extraction is enabled.
+func (c *Collector) extract(source rsscollector.FeedSourcePartial, items rsscollector.FeedItems) {
+	if c.config.Extractor == nil || !source.FetchFullContent {
+		return
+	}
+	// Items repeated in a feed share the ID of the one stored.
+	queued := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		if _, ok := queued[item.ID]; ok {
+			continue
+		}
+		queued[item.ID] = struct{}{}
+		if !c.config.Extractor.Enqueue(item.ID) {
+			log.Warn().Str("feedId", source.ID).Str("itemId", item.ID).
+				Msg("extraction queue is full, item keeps its feed content")
+		}
+	}
+}
+
+// ExtractContent of the item from the page it links to now, whether or not
+// its feed asks for full content, returning the updated item.
+func (c *Collector) ExtractContent(ctx context.Context, itemID string) (rsscollector.FeedItem, error) {
+	if c.config.Extractor == nil {
+		return rsscollector.FeedItem{}, ErrExtractionDisabled
+	}
+	return c.config.Extractor.Extract(ctx, itemID)
+}
+
+// storeItems stores the collected items for the source, counting those that
+// are new and those that update an item already stored with the same GUID,
+// and returns the new ones.
+//
+// Items whose full content was extracted keep it, the feed only has the
+// teaser it gave the first time, and items keep the link theirs was
+// resolved to. Items in the trash are left as they were deleted, so that
+// restoring one gives it back whole, and the pruned GUIDs of the source
+// aren't stored again once their items are purged from it.
+func (c *Collector) storeItems(ctx context.Context, sourceID string, items rsscollector.FeedItems, pruned []string) (rsscollector.FeedItems, error) {
+	byGUID, left, err := c.storedItems(ctx, sourceID, pruned)
+	if err != nil {
+		return nil, err
+	}
+	toStore := make(rsscollector.FeedItems, 0, len(items))
+	for _, item := range items {
+		if _, ok := left[item.GUID]; ok && len(item.GUID) > 0 {
+			continue
+		}
+		toStore = append(toStore, item)
+		previous, ok := byGUID[item.GUID]
+		if !ok || len(item.GUID) == 0 {
+			continue
+		}
+		if previous.ExtractedAt != nil {
+			item.Content = previous.Content
+			item.ExtractedAt = previous.ExtractedAt
+		}
+		// A link resolved when the item was new stays resolved while the
+		// feed gives the same link.
+		if len(previous.OriginalLink) > 0 && previous.OriginalLink == originalLink(item) {
+			item.Link = previous.Link
+			item.OriginalLink = previous.OriginalLink
+		}
+	}
+
+	if err := c.itemRepos.StoreItems(ctx, sourceID, toStore); err != nil {
+		return nil, err
+	}
+
+	var newItems rsscollector.FeedItems
+	deduplicated := len(items) - len(toStore)
+	for _, item := range toStore {
+		if _, ok := byGUID[item.GUID]; ok && len(item.GUID) > 0 {
+			deduplicated++
+			continue
+		}
+		newItems = append(newItems, item)
+	}
+	label := metrics.FeedLabel(sourceID)
+	metrics.ItemsStored.WithLabelValues(label).Add(float64(len(newItems)))
+	metrics.ItemsDeduplicated.WithLabelValues(label).Add(float64(deduplicated))
+	return newItems, nil
+}
+
+// storedItems of the source by GUID, along with the GUIDs of those left out
+// of collections, the items in the trash and the pruned GUIDs no item is
+// stored with.
+func (c *Collector) storedItems(ctx context.Context, sourceID string, pruned []string) (map[string]*rsscollector.FeedItem, map[string]struct{}, error) {
+	existing, err := c.itemRepos.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: sourceID})
+	if err != nil {
+		return nil, nil, err
+	}
+	deleted, err := c.itemRepos.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: sourceID, Deleted: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	byGUID := make(map[string]*rsscollector.FeedItem, len(existing))
+	for _, item := range existing {
+		if len(item.GUID) > 0 {
+			byGUID[item.GUID] = item
+		}
+	}
+	left := make(map[string]struct{}, len(deleted)+len(pruned))
+	for _, item := range deleted {
+		if len(item.GUID) > 0 {
+			left[item.GUID] = struct{}{}
+		}
+	}
+	for _, guid := range pruned {
+		if _, ok := byGUID[guid]; !ok {
+			left[guid] = struct{}{}
+		}
+	}
+	return byGUID, left, nil
+}
+
+// listedGUIDs of the pruned GUIDs that are still among the items, the
+// others are dropped as the feed won't give them again.
+func listedGUIDs(pruned []string, items rsscollector.FeedItems) []string {
+	if len(pruned) == 0 {
+		return nil
+	}
+	listed := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		listed[item.GUID] = struct{}{}
+	}
+	var kept []string
+	for _, guid := range pruned {
+		if _, ok := listed[guid]; ok {
+			kept = append(kept, guid)
+		}
+	}
+	return kept
+}
+
+// originalLink of the item as its feed gave it.
+func originalLink(item *rsscollector.FeedItem) string {
+	if len(item.OriginalLink) > 0 {
+		return item.OriginalLink
+	}
+	return item.Link
+}
+
+// CollectAll collects every stored feed source, Concurrency at a time, with
+// the feeds of each host spread through the collection. Dead feeds and those
+// duplicating another are left out. A failure to collect one source is
+// logged and does not prevent the others being collected.
+func (c *Collector) CollectAll(ctx context.Context) error {
+	if !c.begin() {
+		return ErrStopped
+	}
+	defer c.running.Done()
+	return c.collectAll(ctx)
+}
+
+// collectAll for CollectAll, or for Run which has already counted it in
+// progress.
+func (c *Collector) collectAll(ctx context.Context) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "collector.CollectAll")
+	defer tracing.EndSpan(span, &err)
+
+	sources, err := c.feedRepos.FetchAllSources(ctx)
+	if err != nil {
+		return err
+	}
+	sources = interleaveHosts(scheduled(sources))
+	// Sources are queued until a worker takes them, then until their host
+	// is free.
+	metrics.FetchQueueDepth.Add(float64(len(sources)))
+	queued := len(sources)
+	defer func() {
+		metrics.FetchQueueDepth.Sub(float64(queued))
+	}()
+
+	workers := c.config.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	pending := make(chan rsscollector.FeedSourcePartial)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for source := range pending {
+				if err := c.collectSource(ctx, source); err != nil {
+					log.Error().Err(err).Str("feedId", source.ID).Str("feedUrl", source.FeedURL).
+						Msg("failed to collect feed")
+				}
+			}
+		}()
+	}
+
+	defer wg.Wait()
+	defer close(pending)
+	for _, source := range sources {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case pending <- source:
+			metrics.FetchQueueDepth.Dec()
+			queued--
+		}
+	}
+	return nil
+}
+
+// scheduled sources, those that are neither dead nor a duplicate.
+func scheduled(sources []rsscollector.FeedSourcePartial) []rsscollector.FeedSourcePartial {
+	live := make([]rsscollector.FeedSourcePartial, 0, len(sources))
+	for _, source := range sources {
+		if source.DeadAt == nil && len(source.DuplicateOf) == 0 {
+			live = append(live, source)
+		}
+	}
+	return live
+}
+
+// Run collects every feed source each RefreshInterval until ctx is done.
+// Cancelling ctx also abandons any collection in progress, use Wait to know
+// when it has stopped.
+func (c *Collector) Run(ctx context.Context) {
+	if !c.begin() {
+		return
+	}
+	defer c.running.Done()
+
+	if c.config.RefreshInterval <= 0 {
+		log.Info().Msg("scheduled feed collection disabled")
+		return
+	}
+
+	ticker := time.NewTicker(c.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			// A tick that came due while stopping is dropped.
+			if c.stopped() {
+				return
+			}
+			if err := c.collectAll(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to collect feeds")
+			}
+		}
+	}
+}
+
+// Stop scheduling collections, leaving those in progress to finish. Run
+// returns once it is between collections.
+func (c *Collector) Stop() {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	if !c.stopped() {
+		close(c.stop)
+	}
+}
+
+// begin counts work in progress for Wait, unless the collector has been
+// stopped.
+func (c *Collector) begin() bool {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+	if c.stopped() {
+		return false
+	}
+	c.running.Add(1)
+	return true
+}
+
+func (c *Collector) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until Run has returned and there are no collections in
+// progress. It must only be called once the collector has been stopped, as
+// nothing more is started from then on.
+func (c *Collector) Wait() {
+	c.running.Wait()
+}
+
+// newSource for the feed at the FeedURL of from or, when it has selectors,
+// the web page to scrape, made with its fetch settings.
+func (c *Collector) newSource(from rsscollector.FeedSourcePartial) (*feed.Source, error) {
+	source, err := feed.NewSource(from.FeedURL, c.httpClient)
+	if err != nil {
+		return nil, err
+	}
+	source.Selectors = from.Scrape
+	source.Policy = c.config.Policy
+	if len(c.config.UserAgent) > 0 {
+		source.UserAgent = c.config.UserAgent
+	}
+	if from.Fetch != nil {
+		settings, err := c.config.Secrets.Open(from.Fetch)
+		if err != nil {
+			return nil, err
+		}
+		if err := source.Configure(*settings); err != nil {
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+func (c *Collector) fetch(ctx context.Context, source *feed.Source) error {
+	// Waiting for the host doesn't count towards the fetch timeout.
+	done, err := c.politeness.wait(ctx, source.FeedURL)
+	if err != nil {
+		return err
+	}
+	defer done()
+	fetchCtx, cancel := withTimeout(ctx, c.config.FetchTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err = source.Collect(fetchCtx)
+
+	label := metrics.FeedLabel(source.ID)
+	metrics.FeedFetchDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	metrics.FeedFetchBytes.WithLabelValues(label).Add(float64(source.Bytes))
+	metrics.FeedFetches.WithLabelValues(label, fetchOutcome(err)).Inc()
+	return err
+}
+
+// fetchOutcome classifies the result of collecting a feed for the
+// FeedFetches metric.
+func fetchOutcome(err error) string {
+	var httpErr feed.HTTPError
+	var parseErr feed.ParseError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.Is(err, context.DeadlineExceeded):
+		return metrics.OutcomeTimeout
+	case errors.As(err, &httpErr):
+		return metrics.OutcomeHTTPError
+	case errors.As(err, &parseErr):
+		return metrics.OutcomeParseError
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return metrics.OutcomeTimeout
+	default:
+		return metrics.OutcomeNetworkError
+	}
+}
+
+// withTimeout derives a context from ctx that is cancelled after timeout, or
+// only when ctx is if timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,783 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/retention"
+	"github.com/JonPulfer/rss_collector/pkg/sanitize"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>%s</title>
+<link>http://example.com/</link>
+<item>
+<title>First</title>
+<link>http://example.com/first</link>
+<guid>http://example.com/first</guid>
+<pubDate>Mon, 15 Mar 2021 08:00:00 GMT</pubDate>
+</item>
+<item>
+<title>Second</title>
+<link>http://example.com/second</link>
+<guid>http://example.com/second</guid>
+<pubDate>Mon, 15 Mar 2021 09:00:00 GMT</pubDate>
+</item>
+</channel>
+</rss>`
+
+func TestAddAndCollectSource(t *testing.T) {
+	title := "Example"
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testFeed, title)
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second})
+
+	source, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+	assert.NotEmpty(t, source.ID)
+	assert.Equal(t, "Example", source.Title)
+
+	items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+	firstIDs := []string{items[0].ID, items[1].ID}
+
+	title = "Renamed"
+	require.Nil(t, c.CollectAll(ctx))
+
+	stored, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "Renamed", stored.Title)
+
+	items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	assert.Equal(t, firstIDs, []string{items[0].ID, items[1].ID})
+}
+
+func TestAddSourceAlreadyAdded(t *testing.T) {
+	var requests int
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, testFeed, "Example")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second})
+
+	source, err := c.AddSource(ctx, feedServer.URL+"/feed.xml")
+	require.Nil(t, err)
+
+	// Written differently, it is turned away without fetching it again.
+	_, err = c.AddSource(ctx, strings.ToUpper(feedServer.URL)+"/feed.xml#latest")
+	var duplicate repository.DuplicateSourceError
+	require.True(t, errors.As(err, &duplicate), "got %v", err)
+	assert.Equal(t, source.ID, duplicate.ID)
+	assert.Equal(t, 1, requests)
+
+	sources, err := store.FetchAllSources(ctx)
+	require.Nil(t, err)
+	assert.Len(t, sources, 1)
+}
+
+// iconRecorder records the feeds whose icons are refreshed, failing each.
+type iconRecorder struct {
+	refreshed []string
+}
+
+func (i *iconRecorder) Refresh(_ context.Context, source rsscollector.FeedSourcePartial) error {
+	i.refreshed = append(i.refreshed, source.SiteLink)
+	return errors.New("no icon")
+}
+
+func TestRefreshIcons(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testFeed, "Example")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	icons := &iconRecorder{}
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second, Icons: icons})
+
+	// Failing to find an icon doesn't fail the collection.
+	source, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+	require.Nil(t, c.CollectSource(ctx, source.FeedSourcePartial))
+	assert.Equal(t, []string{"http://example.com/", "http://example.com/"}, icons.refreshed)
+}
+
+func TestSanitizeItems(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Example</title>
+<link>http://example.com/blog/</link>
+<item>
+<guid>first</guid>
+<description><![CDATA[<p>Read <a href="more.html">more</a></p><script>track()</script>]]></description>
+</item>
+</channel>
+</rss>`)
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second, Sanitizer: sanitize.New(sanitize.DefaultPolicy())})
+
+	source, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+	items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, items, 1)
+	// Without a link of its own the item's links are relative to the site.
+	assert.Equal(t,
+		`<p>Read <a href="http://example.com/blog/more.html" rel="nofollow noopener noreferrer">more</a></p>`,
+		items[0].Description)
+	assert.Equal(t, "Read more", items[0].Summary)
+}
+
+// extractRecorder records the items queued for extraction and extracts them
+// by replacing their content in the store.
+type extractRecorder struct {
+	store  repository.FeedItemStore
+	queued []string
+}
+
+func (e *extractRecorder) Enqueue(itemID string) bool {
+	e.queued = append(e.queued, itemID)
+	return true
+}
+
+func (e *extractRecorder) Extract(ctx context.Context, itemID string) (rsscollector.FeedItem, error) {
+	item, err := e.store.FetchItemByID(ctx, itemID)
+	if err != nil {
+		return rsscollector.FeedItem{}, err
+	}
+	extractedAt := time.Now().UTC()
+	item.Content = "<p>The whole story</p>"
+	item.ExtractedAt = &extractedAt
+	return item, e.store.StoreItem(ctx, item.SourceID, &item)
+}
+
+func TestExtractContent(t *testing.T) {
+	third := ""
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Example</title>
+<item><guid>first</guid><link>http://example.com/first</link><description>A teaser</description></item>
+<item><guid>second</guid><link>http://example.com/second</link><description>A teaser</description></item>
+%s
+</channel>
+</rss>`, third)
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	extractor := &extractRecorder{store: store}
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second})
+
+	_, err := c.ExtractContent(ctx, "ca7cf0c5-1a7b-4cb4-a2a2-6a1f5f2c3c47")
+	assert.Equal(t, ErrExtractionDisabled, err)
+
+	c = NewCollector(store, store, &Config{FetchTimeout: time.Second, Extractor: extractor})
+	// Feeds don't ask for their full content unless told to.
+	source, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+	assert.Empty(t, extractor.queued)
+
+	source.FetchFullContent = true
+	require.Nil(t, store.StoreSource(ctx, &source))
+	items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+	first := items[0]
+	if first.GUID != "first" {
+		first = items[1]
+	}
+	extracted, err := c.ExtractContent(ctx, first.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "<p>The whole story</p>", extracted.Content)
+
+	// Only the new item is queued and the extracted content isn't replaced
+	// by the teaser when the feed is collected again.
+	third = `<item><guid>third</guid><link>http://example.com/third</link></item>`
+	require.Nil(t, c.CollectSource(ctx, source.FeedSourcePartial))
+	items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, items, 3)
+	for _, item := range items {
+		switch item.GUID {
+		case "first":
+			assert.Equal(t, "<p>The whole story</p>", item.Content)
+			assert.NotNil(t, item.ExtractedAt)
+		case "second":
+			assert.Nil(t, item.ExtractedAt)
+		case "third":
+			assert.Equal(t, []string{item.ID}, extractor.queued)
+		}
+	}
+}
+
+func TestScrapedSource(t *testing.T) {
+	stories := `<li><a href="/first">First</a></li>`
+	pageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Example</title></head><body><ul>%s</ul></body></html>`, stories)
+	}))
+	defer pageServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second})
+	selectors := rsscollector.ScrapeSelectors{Item: "li"}
+
+	preview, err := c.PreviewScrape(ctx, pageServer.URL, selectors)
+	require.Nil(t, err)
+	require.Len(t, preview.FeedItems, 1)
+	sources, err := store.FetchAllSources(ctx)
+	require.Nil(t, err)
+	assert.Empty(t, sources)
+
+	source, err := c.AddScrapedSource(ctx, pageServer.URL, selectors)
+	require.Nil(t, err)
+	assert.Equal(t, "Example", source.Title)
+
+	// Collecting again scrapes the page with the stored selectors, the items
+	// deduplicated by their links.
+	stories += `<li><a href="/second">Second</a></li>`
+	stored, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	require.Nil(t, c.CollectSource(ctx, stored.FeedSourcePartial))
+	items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+	stored, err = store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, &selectors, stored.Scrape)
+}
+
+func TestFetchSettings(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, testFeed, "Private")
+	}))
+	defer feedServer.Close()
+
+	box, err := secrets.NewBox(make([]byte, secrets.KeySize))
+	require.Nil(t, err)
+	sealed, err := box.Seal(&rsscollector.FetchSettings{Username: "reader", Password: "secret"})
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second, Secrets: box})
+	source, err := c.AddSourceFrom(ctx, rsscollector.FeedSourcePartial{FeedURL: feedServer.URL, Fetch: sealed})
+	require.Nil(t, err)
+	assert.Equal(t, "Private", source.Title)
+
+	// The password is only stored encrypted, and decrypted for each
+	// collection.
+	stored, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.True(t, secrets.IsEncrypted(stored.Fetch.Password))
+	require.Nil(t, c.CollectSource(ctx, stored.FeedSourcePartial))
+
+	// Without the key the feed can't be collected.
+	keyless := NewCollector(store, store, &Config{FetchTimeout: time.Second})
+	assert.Equal(t, secrets.ErrNoKey, keyless.CollectSource(ctx, stored.FeedSourcePartial))
+}
+
+func TestFetchPolicy(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testFeed, "Guarded")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	blocking, err := fetchpolicy.New(fetchpolicy.Config{})
+	require.Nil(t, err)
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second, Policy: blocking})
+	_, err = c.AddSource(ctx, feedServer.URL)
+	var blocked fetchpolicy.BlockedError
+	assert.True(t, errors.As(err, &blocked), err)
+
+	allowing, err := fetchpolicy.New(fetchpolicy.Config{Allow: []string{"127.0.0.1"}, MaxBytes: 1 << 10})
+	require.Nil(t, err)
+	c = NewCollector(store, store, &Config{FetchTimeout: time.Second, Policy: allowing})
+	// Feeds with settings of their own are fetched with the policy too.
+	source, err := c.AddSourceFrom(ctx, rsscollector.FeedSourcePartial{
+		FeedURL: feedServer.URL,
+		Fetch:   &rsscollector.FetchSettings{TLS: &rsscollector.FetchTLSSettings{ServerName: "feeds.internal"}},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "Guarded", source.Title)
+
+	tooSmall, err := fetchpolicy.New(fetchpolicy.Config{Allow: []string{"127.0.0.1"}, MaxBytes: 100})
+	require.Nil(t, err)
+	c = NewCollector(store, store, &Config{FetchTimeout: time.Second, Policy: tooSmall})
+	err = c.CollectSource(ctx, source.FeedSourcePartial)
+	assert.True(t, errors.Is(err, fetchpolicy.ErrTooLarge), err)
+}
+
+func TestFeedMoves(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	var feedServer *httptest.Server
+	feedServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/old.xml", "/also-old.xml":
+			http.Redirect(w, r, "/feed.xml", http.StatusMovedPermanently)
+		case "/podcast.xml":
+			fmt.Fprintf(w, `<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<title>Podcast</title><itunes:new-feed-url>%s/new.xml</itunes:new-feed-url></channel></rss>`, feedServer.URL)
+		case "/declares-missing.xml":
+			fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom"><title>Atom</title><link rel="self" href="/missing.xml"/></feed>`)
+		case "/gone.xml":
+			w.WriteHeader(http.StatusGone)
+		case "/feed.xml", "/new.xml":
+			fmt.Fprintf(w, testFeed, "Example")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: time.Second})
+	sources := make(map[string]string)
+	for _, path := range []string{"/old.xml", "/podcast.xml", "/declares-missing.xml", "/gone.xml"} {
+		source := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: feedServer.URL + path}}
+		require.Nil(t, store.StoreSource(ctx, &source))
+		sources[path] = source.ID
+	}
+	require.Nil(t, c.CollectAll(ctx))
+
+	testCases := []struct {
+		Name    string
+		Path    string
+		FeedURL string
+		Reason  string
+	}{
+		{"Permanent redirect", "/old.xml", "/feed.xml", rsscollector.URLChangeRedirect},
+		{"New feed URL", "/podcast.xml", "/new.xml", rsscollector.URLChangeNewFeedURL},
+		{"Declared URL missing", "/declares-missing.xml", "/declares-missing.xml", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			stored, err := store.FetchSource(ctx, sources[tc.Path])
+			require.Nil(t, err)
+			assert.Equal(t, feedServer.URL+tc.FeedURL, stored.FeedURL)
+			if len(tc.Reason) == 0 {
+				assert.Empty(t, stored.URLHistory)
+				return
+			}
+			require.Len(t, stored.URLHistory, 1)
+			assert.Equal(t, feedServer.URL+tc.Path, stored.URLHistory[0].URL)
+			assert.Equal(t, tc.Reason, stored.URLHistory[0].Reason)
+		})
+	}
+
+	t.Run("Converged", func(t *testing.T) {
+		duplicate, err := c.AddSource(ctx, feedServer.URL+"/also-old.xml")
+		require.Nil(t, err)
+		assert.Equal(t, sources["/old.xml"], duplicate.DuplicateOf)
+		assert.Equal(t, feedServer.URL+"/also-old.xml", duplicate.FeedURL)
+		assert.Empty(t, duplicate.URLHistory)
+	})
+
+	t.Run("Duplicate collected", func(t *testing.T) {
+		// A duplicate with the URL of the feed it duplicates, as
+		// NormalizeSourceURLs leaves them, is still one once collected.
+		duplicate := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{
+			FeedURL:     feedServer.URL + "/feed.xml",
+			DuplicateOf: sources["/old.xml"],
+		}}
+		require.Nil(t, store.StoreSource(ctx, &duplicate))
+		require.Nil(t, c.CollectSource(ctx, duplicate.FeedSourcePartial))
+		stored, err := store.FetchSource(ctx, duplicate.ID)
+		require.Nil(t, err)
+		assert.Equal(t, sources["/old.xml"], stored.DuplicateOf)
+	})
+
+	t.Run("Gone", func(t *testing.T) {
+		stored, err := store.FetchSource(ctx, sources["/gone.xml"])
+		require.Nil(t, err)
+		assert.NotNil(t, stored.DeadAt)
+	})
+
+	// Dead and duplicate feeds aren't collected again.
+	require.Nil(t, c.CollectAll(ctx))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, requests["/gone.xml"])
+	assert.Equal(t, 1, requests["/also-old.xml"])
+	assert.Equal(t, 1, requests["/old.xml"])
+}
+
+func TestResolveItemLinks(t *testing.T) {
+	var mu sync.Mutex
+	resolved := make(map[string]int)
+	items := 1
+	var feedServer *httptest.Server
+	feedServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/feed.xml":
+			fmt.Fprint(w, `<rss version="2.0"><channel><title>Redirected</title>`)
+			for i := 1; i <= items; i++ {
+				fmt.Fprintf(w, `<item><title>%[2]d</title><link>%[1]s/r/%[2]d?utm_source=rss</link><guid>%[2]d</guid></item>`,
+					feedServer.URL, i)
+			}
+			fmt.Fprint(w, `</channel></rss>`)
+		case strings.HasPrefix(r.URL.Path, "/r/"):
+			resolved[r.URL.Path]++
+			http.Redirect(w, r, "/story/"+strings.TrimPrefix(r.URL.Path, "/r/")+"?utm_medium=feed", http.StatusFound)
+		default:
+			fmt.Fprint(w, "story")
+		}
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{
+		FetchTimeout: time.Second,
+		Resolver:     urlnorm.NewResolver(feedServer.Client(), []string{"127.0.0.1"}),
+	})
+	source, err := c.AddSource(ctx, feedServer.URL+"/feed.xml")
+	require.Nil(t, err)
+
+	mu.Lock()
+	items = 2
+	mu.Unlock()
+	require.Nil(t, c.CollectSource(ctx, source.FeedSourcePartial))
+
+	stored, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, stored, 2)
+	for _, item := range stored {
+		assert.Equal(t, feedServer.URL+"/story/"+item.GUID, item.Link)
+		assert.Equal(t, feedServer.URL+"/r/"+item.GUID+"?utm_source=rss", item.OriginalLink)
+	}
+
+	// Links are only resolved for new items.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{"/r/1": 1, "/r/2": 1}, resolved)
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer feedServer.Close()
+	defer close(release)
+
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{FetchTimeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := c.AddSource(context.Background(), feedServer.URL)
+	assert.NotNil(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+
+	sources, err := store.FetchAllSources(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, sources)
+}
+
+func TestFetchOutcome(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Handler         http.HandlerFunc
+		ExpectedOutcome string
+	}{
+		{
+			"Success",
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, testFeed, "Example")
+			},
+			metrics.OutcomeSuccess,
+		},
+		{
+			"HTTP error",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			metrics.OutcomeHTTPError,
+		},
+		{
+			"Parse error",
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "not a feed")
+			},
+			metrics.OutcomeParseError,
+		},
+		{
+			"Timeout",
+			func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			metrics.OutcomeTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			feedServer := httptest.NewServer(tc.Handler)
+			defer feedServer.Close()
+
+			store := repository.NewMemoryStore()
+			c := NewCollector(store, store, &Config{FetchTimeout: 50 * time.Millisecond})
+			fetches := metrics.FeedFetches.WithLabelValues(metrics.AllFeeds, tc.ExpectedOutcome)
+			before := testutil.ToFloat64(fetches)
+
+			_, _ = c.AddSource(context.Background(), feedServer.URL)
+			assert.Equal(t, before+1, testutil.ToFloat64(fetches))
+		})
+	}
+}
+
+func TestItemsStoredAndDeduplicated(t *testing.T) {
+	metrics.SetPerFeedLabels(false)
+	defer metrics.SetPerFeedLabels(true)
+
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testFeed, "Example")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{})
+	stored := testutil.ToFloat64(metrics.ItemsStored.WithLabelValues(metrics.AllFeeds))
+	deduplicated := testutil.ToFloat64(metrics.ItemsDeduplicated.WithLabelValues(metrics.AllFeeds))
+
+	_, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+	require.Nil(t, c.CollectAll(ctx))
+
+	assert.Equal(t, stored+2, testutil.ToFloat64(metrics.ItemsStored.WithLabelValues(metrics.AllFeeds)))
+	assert.Equal(t, deduplicated+2, testutil.ToFloat64(metrics.ItemsDeduplicated.WithLabelValues(metrics.AllFeeds)))
+}
+
+func TestCollectAllConcurrently(t *testing.T) {
+	var userAgents = make(chan string, 10)
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.UserAgent()
+		fmt.Fprintf(w, testFeed, r.URL.Path)
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{Concurrency: 3, UserAgent: "test-agent"})
+	for i := 0; i < 5; i++ {
+		_, err := c.AddSource(ctx, fmt.Sprintf("%s/%d", feedServer.URL, i))
+		require.Nil(t, err)
+	}
+	require.Nil(t, c.CollectAll(ctx))
+
+	close(userAgents)
+	var requests int
+	for userAgent := range userAgents {
+		assert.Equal(t, "test-agent", userAgent)
+		requests++
+	}
+	assert.Equal(t, 10, requests)
+}
+
+func TestStopLeavesCollectionsToFinish(t *testing.T) {
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var requests int
+	var mu sync.Mutex
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		select {
+		case fetching <- struct{}{}:
+		default:
+		}
+		<-release
+		fmt.Fprintf(w, testFeed, "Finished")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: feedServer.URL}}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	c := NewCollector(store, store, &Config{RefreshInterval: 10 * time.Millisecond})
+	go c.Run(ctx)
+	<-fetching
+	c.Stop()
+
+	waited := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while a collection was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-waited
+
+	stored, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "Finished", stored.Title)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, requests)
+}
+
+func TestPrunedItemsStayPruned(t *testing.T) {
+	metrics.SetPerFeedLabels(false)
+	defer metrics.SetPerFeedLabels(true)
+
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testFeed, "Example")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{})
+	pruner := retention.NewPruner(store, store, store, store,
+		retention.Policies{Default: retention.Policy{MaxItems: 1}}, time.Nanosecond)
+
+	source, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := pruner.Prune(ctx, false)
+		require.Nil(t, err)
+		time.Sleep(time.Millisecond)
+		_, err = pruner.PurgeTrash(ctx)
+		require.Nil(t, err)
+		require.Nil(t, c.CollectAll(ctx))
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		require.Len(t, items, 1)
+		assert.Equal(t, "http://example.com/second", items[0].GUID)
+	}
+
+	// The pruned GUID is kept until the feed no longer lists it.
+	stored, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"http://example.com/first"}, stored.PrunedGUIDs)
+	assert.Empty(t, listedGUIDs(stored.PrunedGUIDs, rsscollector.FeedItems{{GUID: "http://example.com/second"}}))
+}
+
+func TestStoppedCollectorStartsNothing(t *testing.T) {
+	var requests int
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, testFeed, "Example")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: feedServer.URL}}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	c := NewCollector(store, store, &Config{RefreshInterval: time.Millisecond})
+	c.Stop()
+	c.Run(ctx)
+	_, err := c.AddSource(ctx, feedServer.URL+"/other.xml")
+	assert.Equal(t, ErrStopped, err)
+	assert.Equal(t, ErrStopped, c.CollectAll(ctx))
+	assert.Equal(t, ErrStopped, c.CollectSource(ctx, source.FeedSourcePartial))
+	c.Wait()
+	assert.Zero(t, requests)
+}
+
+func TestTrashedItemsLeftAlone(t *testing.T) {
+	metrics.SetPerFeedLabels(false)
+	defer metrics.SetPerFeedLabels(true)
+
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, testFeed, "Example")
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	c := NewCollector(store, store, &Config{})
+	source, err := c.AddSource(ctx, feedServer.URL)
+	require.Nil(t, err)
+
+	items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+	trashed := items[0]
+	extractedAt := time.Now().UTC().Truncate(time.Second)
+	trashed.Content = "<p>The whole article</p>"
+	trashed.ExtractedAt = &extractedAt
+	require.Nil(t, store.StoreItem(ctx, source.ID, trashed))
+	require.Nil(t, store.DeleteItemByID(ctx, trashed.ID))
+
+	stored := testutil.ToFloat64(metrics.ItemsStored.WithLabelValues(metrics.AllFeeds))
+	deduplicated := testutil.ToFloat64(metrics.ItemsDeduplicated.WithLabelValues(metrics.AllFeeds))
+	require.Nil(t, c.CollectSource(ctx, source.FeedSourcePartial))
+	assert.Equal(t, stored, testutil.ToFloat64(metrics.ItemsStored.WithLabelValues(metrics.AllFeeds)))
+	assert.Equal(t, deduplicated+2, testutil.ToFloat64(metrics.ItemsDeduplicated.WithLabelValues(metrics.AllFeeds)))
+
+	require.Nil(t, store.RestoreItem(ctx, trashed.ID))
+	restored, err := store.FetchItemByID(ctx, trashed.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "<p>The whole article</p>", restored.Content)
+	require.NotNil(t, restored.ExtractedAt)
+	assert.True(t, extractedAt.Equal(*restored.ExtractedAt))
+}
@@ -0,0 +1,276 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/feed"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+)
+
+const (
+	// robotsTTL is how long a host's robots.txt is relied on before it is
+	// fetched again.
+	robotsTTL = 24 * time.Hour
+	// robotsTimeout bounds fetching a robots.txt.
+	robotsTimeout = 10 * time.Second
+	// robotsMaxBytes of a robots.txt read, the rest is ignored.
+	robotsMaxBytes = 512 << 10
+	// maxCrawlDelay honoured, so a site can't hold a worker for longer.
+	maxCrawlDelay = time.Minute
+)
+
+// politeness spaces the requests made for feeds so that no host is sent
+// more than HostConcurrency at once or one more often than HostInterval, or
+// its robots.txt Crawl-delay when that is longer, and no more than
+// RateLimit are made each second overall.
+type politeness struct {
+	config     *Config
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	hosts       map[string]*hostState
+	nextRequest time.Time
+}
+
+// hostState of the requests made to one host.
+type hostState struct {
+	// slots holds a token for each request in progress.
+	slots       chan struct{}
+	nextRequest time.Time
+
+	robotsMu      sync.Mutex
+	robotsFetched time.Time
+	crawlDelay    time.Duration
+}
+
+func newPoliteness(config *Config, httpClient *http.Client) *politeness {
+	return &politeness{
+		config:     config,
+		httpClient: httpClient,
+		hosts:      make(map[string]*hostState),
+	}
+}
+
+// wait blocks until a request can be made for the feed, returning a func to
+// call once it has been.
+func (p *politeness) wait(ctx context.Context, feedURL string) (func(), error) {
+	address, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	metrics.FetchQueueDepth.Inc()
+	defer metrics.FetchQueueDepth.Dec()
+	start := time.Now()
+	defer func() {
+		metrics.FetchWaitDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	host := p.host(address.Host)
+	if p.config.CrawlDelay {
+		p.checkRobots(ctx, address, host)
+	}
+
+	release := func() {}
+	if host.slots != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case host.slots <- struct{}{}:
+		}
+		release = func() { <-host.slots }
+	}
+
+	if err := sleepUntil(ctx, p.reserve(host)); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// host state for the host, created on first use.
+func (p *politeness) host(name string) *hostState {
+	name = strings.ToLower(name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	host, ok := p.hosts[name]
+	if !ok {
+		host = &hostState{}
+		if p.config.HostConcurrency > 0 {
+			host.slots = make(chan struct{}, p.config.HostConcurrency)
+		}
+		p.hosts[name] = host
+	}
+	return host
+}
+
+// reserve the next time a request can be made to the host, and overall,
+// returning it.
+func (p *politeness) reserve(host *hostState) time.Time {
+	host.robotsMu.Lock()
+	interval := host.crawlDelay
+	host.robotsMu.Unlock()
+	if p.config.HostInterval > interval {
+		interval = p.config.HostInterval
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := time.Now()
+	if host.nextRequest.After(next) {
+		next = host.nextRequest
+	}
+	if p.config.RateLimit > 0 {
+		if p.nextRequest.After(next) {
+			next = p.nextRequest
+		}
+		p.nextRequest = next.Add(time.Second / time.Duration(p.config.RateLimit))
+	}
+	host.nextRequest = next.Add(interval)
+	return next
+}
+
+// checkRobots fetches the host's robots.txt for its Crawl-delay when it
+// hasn't been for robotsTTL. A host without one, or that can't be reached,
+// has no delay until it is next checked.
+func (p *politeness) checkRobots(ctx context.Context, address *url.URL, host *hostState) {
+	host.robotsMu.Lock()
+	defer host.robotsMu.Unlock()
+	if time.Since(host.robotsFetched) < robotsTTL {
+		return
+	}
+	host.robotsFetched = time.Now()
+	host.crawlDelay = 0
+
+	ctx, cancel := context.WithTimeout(ctx, robotsTimeout)
+	defer cancel()
+	robots := url.URL{Scheme: address.Scheme, Host: address.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robots.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", p.userAgent())
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		log.Debug().Err(err).Str("host", address.Host).Msg("failed to fetch robots.txt")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	host.crawlDelay = crawlDelay(io.LimitReader(resp.Body, robotsMaxBytes), p.userAgent())
+}
+
+func (p *politeness) userAgent() string {
+	if len(p.config.UserAgent) > 0 {
+		return p.config.UserAgent
+	}
+	return feed.DefaultUserAgent
+}
+
+// crawlDelay given by the robots.txt for the user agent, taken from the
+// group naming its product token or, failing that, the group for every
+// agent. It is capped at maxCrawlDelay.
+func crawlDelay(robots io.Reader, userAgent string) time.Duration {
+	token := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+	var agents []string
+	inRules := false
+	delays := make(map[string]time.Duration)
+
+	scanner := bufio.NewScanner(robots)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		switch field {
+		case "user-agent":
+			// Agents listed together share the rules that follow them.
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "crawl-delay":
+			inRules = true
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds <= 0 {
+				continue
+			}
+			for _, agent := range agents {
+				delays[agent] = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inRules = true
+		}
+	}
+
+	delay, ok := delays[token]
+	if !ok {
+		delay = delays["*"]
+	}
+	if delay > maxCrawlDelay {
+		delay = maxCrawlDelay
+	}
+	return delay
+}
+
+// interleaveHosts orders the sources so that each host's feeds are spread
+// through the collection, rather than workers all waiting on one host.
+func interleaveHosts(sources []rsscollector.FeedSourcePartial) []rsscollector.FeedSourcePartial {
+	var hosts []string
+	byHost := make(map[string][]rsscollector.FeedSourcePartial)
+	for _, source := range sources {
+		host := ""
+		if address, err := url.Parse(source.FeedURL); err == nil {
+			host = strings.ToLower(address.Host)
+		}
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], source)
+	}
+
+	interleaved := make([]rsscollector.FeedSourcePartial, 0, len(sources))
+	for len(interleaved) < len(sources) {
+		for _, host := range hosts {
+			if queued := byHost[host]; len(queued) > 0 {
+				interleaved = append(interleaved, queued[0])
+				byHost[host] = queued[1:]
+			}
+		}
+	}
+	return interleaved
+}
+
+// sleepUntil the time, or until ctx is done.
+func sleepUntil(ctx context.Context, until time.Time) error {
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+// feedRecorder serves testFeed, recording when each feed request arrived
+// and the most served at once.
+type feedRecorder struct {
+	mu          sync.Mutex
+	requests    []time.Time
+	inFlight    int
+	maxInFlight int
+	robots      string
+}
+
+func (f *feedRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/robots.txt" {
+		_, _ = w.Write([]byte(f.robots))
+		return
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, time.Now())
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	fmt.Fprintf(w, testFeed, r.URL.Path)
+
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+}
+
+// assertSpaced checks each request arrived at least interval after the last,
+// allowing for the clock's resolution.
+func (f *feedRecorder) assertSpaced(t *testing.T, count int, interval time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.Len(t, f.requests, count)
+	for i := 1; i < len(f.requests); i++ {
+		assert.GreaterOrEqual(t, f.requests[i].Sub(f.requests[i-1]), interval-5*time.Millisecond)
+	}
+}
+
+func TestPoliteness(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Config   Config
+		Robots   string
+		Interval time.Duration
+	}{
+		{"Host interval", Config{HostConcurrency: 1, HostInterval: 50 * time.Millisecond}, "", 50 * time.Millisecond},
+		{"Rate limit", Config{RateLimit: 20}, "", 50 * time.Millisecond},
+		{
+			"Crawl delay",
+			Config{CrawlDelay: true, UserAgent: "rss_collector/1.0", HostInterval: time.Millisecond},
+			"User-agent: other\nCrawl-delay: 5\n\nUser-agent: rss_collector\nCrawl-delay: 0.06\n",
+			60 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			recorder := &feedRecorder{robots: tc.Robots}
+			feedServer := httptest.NewServer(recorder)
+			defer feedServer.Close()
+
+			ctx := context.Background()
+			store := repository.NewMemoryStore()
+			for _, name := range []string{"a", "b", "c"} {
+				source := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: feedServer.URL + "/" + name}}
+				require.Nil(t, store.StoreSource(ctx, &source))
+			}
+
+			config := tc.Config
+			config.Concurrency = 3
+			c := NewCollector(store, store, &config)
+			require.Nil(t, c.CollectAll(ctx))
+
+			recorder.assertSpaced(t, 3, tc.Interval)
+			if config.HostConcurrency > 0 {
+				assert.LessOrEqual(t, recorder.maxInFlight, config.HostConcurrency)
+			}
+			assert.Equal(t, 0.0, testutil.ToFloat64(metrics.FetchQueueDepth))
+			assert.Equal(t, 1, testutil.CollectAndCount(metrics.FetchWaitDuration))
+		})
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Robots   string
+		Expected time.Duration
+	}{
+		{"None", "User-agent: *\nDisallow: /private\n", 0},
+		{"Every agent", "User-agent: *\nCrawl-delay: 2\n", 2 * time.Second},
+		{"Own group first", "User-agent: *\nCrawl-delay: 2\n\nUser-agent: RSS_Collector\nCrawl-delay: 0.5\n", 500 * time.Millisecond},
+		{"Shared group", "User-agent: other\nUser-agent: rss_collector # us\nDisallow: /\nCrawl-delay: 3\n", 3 * time.Second},
+		{"Other agent", "User-agent: other\nCrawl-delay: 3\n\nUser-agent: another\nCrawl-delay: 4\n", 0},
+		{"Capped", "User-agent: *\nCrawl-delay: 3600\n", maxCrawlDelay},
+		{"Invalid", "User-agent: *\nCrawl-delay: soon\n", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			delay := crawlDelay(strings.NewReader(tc.Robots), "rss_collector/1.0 (+https://example.com)")
+			assert.Equal(t, tc.Expected, delay)
+		})
+	}
+}
+
+func TestInterleaveHosts(t *testing.T) {
+	sources := []rsscollector.FeedSourcePartial{
+		{FeedURL: "http://a.example.com/1"},
+		{FeedURL: "http://a.example.com/2"},
+		{FeedURL: "http://A.example.com/3"},
+		{FeedURL: "http://b.example.com/1"},
+		{FeedURL: "http://c.example.com/1"},
+		{FeedURL: "http://c.example.com/2"},
+	}
+	var urls []string
+	for _, source := range interleaveHosts(sources) {
+		urls = append(urls, source.FeedURL)
+	}
+	assert.Equal(t, []string{
+		"http://a.example.com/1",
+		"http://b.example.com/1",
+		"http://c.example.com/1",
+		"http://a.example.com/2",
+		"http://c.example.com/2",
+		"http://A.example.com/3",
+	}, urls)
+}
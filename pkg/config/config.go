@@ -0,0 +1,603 @@
+// Package config loads the configuration of the service.
+//
+// Each setting is taken from the first of the following that provides it,
+// from highest to lowest precedence: -
+//
+//  1. command line flags
+//  2. envvars
+//  3. a YAML configuration file, given by the -config flag or CONFIG_FILE
+//  4. the defaults provided by Default
+//
+// The configuration is validated once it has been loaded so that mistakes are
+// reported at startup rather than when a setting is first used.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
+)
+
+// Redacted replaces secrets in a printed configuration.
+const Redacted = "REDACTED"
+
+// Config of the service.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Collector CollectorConfig `yaml:"collector"`
+	Retention RetentionConfig `yaml:"retention"`
+	Icons     IconsConfig     `yaml:"icons"`
+	Sanitize  SanitizeConfig  `yaml:"sanitize"`
+	Extract   ExtractConfig   `yaml:"extract"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Secrets   SecretsConfig   `yaml:"secrets"`
+	Fetch     FetchConfig     `yaml:"fetch"`
+	Links     LinksConfig     `yaml:"links"`
+	Log       LogConfig       `yaml:"log"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+}
+
+type ServerConfig struct {
+	// ListenAddress for the HTTP API as host:port.
+	ListenAddress string `yaml:"listenAddress"`
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
+	TLSCertFile string `yaml:"tlsCertFile"`
+	TLSKeyFile  string `yaml:"tlsKeyFile"`
+	// CacheTTL of cached GET responses, zero disables the cache.
+	CacheTTL        Duration `yaml:"cacheTTL"`
+	RequestTimeout  Duration `yaml:"requestTimeout"`
+	ReadTimeout     Duration `yaml:"readTimeout"`
+	ShutdownTimeout Duration `yaml:"shutdownTimeout"`
+	// BodyLimit in bytes of API requests, other than imports which are read
+	// as they arrive.
+	BodyLimit int `yaml:"bodyLimit"`
+}
+
+type DatabaseConfig struct {
+	// URL of the PostgreSQL database, the memory store is used when empty.
+	URL string `yaml:"url"`
+	// AutoMigrate applies any migrations not yet applied at startup.
+	// Otherwise they must be applied with the migrate command first.
+	AutoMigrate bool `yaml:"autoMigrate"`
+	// MigrationsDir overrides the migrations embedded in the binary, for
+	// developing new migrations.
+	MigrationsDir string `yaml:"migrationsDir"`
+	// SeedFile is a backup archive loaded into the memory store at startup,
+	// when it is empty. It can't be used with a database.
+	SeedFile string `yaml:"seedFile"`
+	// MemoryDir keeps the memory store on disk so it survives restarts, it
+	// is discarded on exit when empty. It can't be used with a database.
+	MemoryDir string `yaml:"memoryDir"`
+	// SnapshotInterval between snapshots of the memory store, which replace
+	// its write log. Zero only writes a snapshot on exit.
+	SnapshotInterval Duration `yaml:"snapshotInterval"`
+	// SyncWrites flushes every change to the memory store to disk before
+	// it is acknowledged, so none are lost if the machine fails.
+	SyncWrites bool `yaml:"syncWrites"`
+}
+
+type CollectorConfig struct {
+	// RefreshInterval between scheduled collections, zero disables them.
+	RefreshInterval Duration `yaml:"refreshInterval"`
+	FetchTimeout    Duration `yaml:"fetchTimeout"`
+	StoreTimeout    Duration `yaml:"storeTimeout"`
+	// Concurrency of scheduled collections, the number of feeds collected
+	// at once.
+	Concurrency int `yaml:"concurrency"`
+	// UserAgent sent when fetching feeds.
+	UserAgent string `yaml:"userAgent"`
+	// HostConcurrency is the number of feeds fetched from one host at once.
+	HostConcurrency int `yaml:"hostConcurrency"`
+	// HostInterval between requests for feeds on the same host.
+	HostInterval Duration `yaml:"hostInterval"`
+	// RateLimit of requests each second across every host, zero is
+	// unlimited.
+	RateLimit int `yaml:"rateLimit"`
+	// CrawlDelay spaces requests to each host by its robots.txt Crawl-delay
+	// when that is longer than HostInterval.
+	CrawlDelay bool `yaml:"crawlDelay"`
+}
+
+type RetentionConfig struct {
+	// MaxAge of items kept, zero keeps items regardless of age.
+	MaxAge Duration `yaml:"maxAge"`
+	// MaxItemsPerFeed kept, zero keeps every item.
+	MaxItemsPerFeed int `yaml:"maxItemsPerFeed"`
+	// Interval between scheduled prunes, zero disables them.
+	Interval Duration `yaml:"interval"`
+	// TrashPeriod deleted objects are kept in the trash before they are
+	// purged, zero keeps them forever.
+	TrashPeriod Duration `yaml:"trashPeriod"`
+	// Feeds overrides the policy for individual feeds by feed URL.
+	Feeds map[string]RetentionPolicy `yaml:"feeds,omitempty"`
+	// Categories overrides the policy for the feeds in a category by
+	// category name.
+	Categories map[string]RetentionPolicy `yaml:"categories,omitempty"`
+}
+
+// RetentionPolicy for a feed or category, zero values keep every item.
+type RetentionPolicy struct {
+	MaxAge   Duration `yaml:"maxAge"`
+	MaxItems int      `yaml:"maxItems"`
+}
+
+type IconsConfig struct {
+	// Dir feed icons are kept in, icons aren't fetched when it is empty and
+	// every feed is served a placeholder.
+	Dir string `yaml:"dir"`
+	// MaxBytes of an icon, larger images are not downloaded.
+	MaxBytes int `yaml:"maxBytes"`
+	// RefreshInterval between fetching a feed's icon again.
+	RefreshInterval Duration `yaml:"refreshInterval"`
+}
+
+type SanitizeConfig struct {
+	// Enabled cleans the HTML of item descriptions and content before they
+	// are stored, otherwise it is stored as the feed gives it.
+	Enabled bool `yaml:"enabled"`
+	// KeepOriginal keeps the HTML as the feed gave it alongside the cleaned
+	// HTML.
+	KeepOriginal bool `yaml:"keepOriginal"`
+	// SummaryLength in characters of the plain text summary of each item.
+	SummaryLength int `yaml:"summaryLength"`
+	// Elements allowed, with the attributes allowed on each, replacing the
+	// built in allowlist when set.
+	Elements map[string][]string `yaml:"elements,omitempty"`
+	// Attributes allowed on every element, replacing lang and dir when set.
+	Attributes []string `yaml:"attributes,omitempty"`
+	// URLSchemes allowed in links and image sources.
+	URLSchemes []string `yaml:"urlSchemes"`
+	// KeepTrackingPixels keeps images of a pixel or less.
+	KeepTrackingPixels bool `yaml:"keepTrackingPixels"`
+}
+
+type ExtractConfig struct {
+	// Enabled fetches the full article for the items of feeds that ask for
+	// it and for items extracted on demand.
+	Enabled bool `yaml:"enabled"`
+	// Workers extracting articles at once.
+	Workers int `yaml:"workers"`
+	// HostInterval between requests for articles to the same host.
+	HostInterval Duration `yaml:"hostInterval"`
+	// MaxBytes of an article's page, larger pages are abandoned.
+	MaxBytes int `yaml:"maxBytes"`
+	// QueueSize is the number of items that can wait for their article,
+	// those beyond it keep the content their feed gave them.
+	QueueSize int `yaml:"queueSize"`
+}
+
+type AuthConfig struct {
+	// APIKeys accepted by the HTTP API, which is open to anyone when empty.
+	APIKeys []string `yaml:"apiKeys"`
+}
+
+type SecretsConfig struct {
+	// Key encrypting the credentials feeds are fetched with, 32 base64
+	// encoded bytes. Feeds can't be given credentials when it is empty.
+	Key string `yaml:"key"`
+}
+
+type FetchConfig struct {
+	// Allow lists the networks, as CIDRs or IPs, and host names that feeds,
+	// icons and articles may be fetched from though they are loopback,
+	// private, link-local or otherwise internal. All of those are blocked
+	// otherwise.
+	Allow []string `yaml:"allow"`
+	// MaxRedirects followed fetching a feed, none when zero.
+	MaxRedirects int `yaml:"maxRedirects"`
+	// MaxBytes of a feed document, larger feeds fail to be collected.
+	MaxBytes int `yaml:"maxBytes"`
+	// ContentTypes feeds may be served as, type/* allows any subtype.
+	ContentTypes []string `yaml:"contentTypes"`
+}
+
+type LinksConfig struct {
+	// TrackingParams stripped from feed and item URLs, a name ending in *
+	// matching every name it begins. None are stripped when it is empty.
+	TrackingParams []string `yaml:"trackingParams"`
+	// ResolveRedirects replaces the links of new items on the Redirectors
+	// with the pages they lead to.
+	ResolveRedirects bool `yaml:"resolveRedirects"`
+	// Redirectors are the hosts whose links only redirect to another.
+	Redirectors []string `yaml:"redirectors"`
+}
+
+type LogConfig struct {
+	// Level is one of the zerolog levels, e.g. debug, info or error.
+	Level string `yaml:"level"`
+	// Format is either json or console.
+	Format string `yaml:"format"`
+}
+
+type MetricsConfig struct {
+	// PerFeedLabels labels the feed metrics with the feed ID rather than
+	// recording them all under one label.
+	PerFeedLabels bool `yaml:"perFeedLabels"`
+	// TotalsInterval between counting the objects stored.
+	TotalsInterval Duration `yaml:"totalsInterval"`
+	// Public serves the metrics without an API key when API keys are
+	// configured.
+	Public bool `yaml:"public"`
+}
+
+// Default configuration. Everything that changes what is collected or
+// stored, or fetches more than the feeds themselves, is off until it is
+// configured.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress:   "0.0.0.0:8080",
+			CacheTTL:        Duration(time.Minute),
+			RequestTimeout:  Duration(time.Minute),
+			ReadTimeout:     Duration(10 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+			BodyLimit:       4 << 20,
+		},
+		Database: DatabaseConfig{
+			AutoMigrate:      true,
+			SnapshotInterval: Duration(5 * time.Minute),
+		},
+		Collector: CollectorConfig{
+			FetchTimeout:    Duration(30 * time.Second),
+			StoreTimeout:    Duration(30 * time.Second),
+			Concurrency:     4,
+			UserAgent:       "rss_collector/1.0 (+https://github.com/JonPulfer/rss_collector)",
+			HostConcurrency: 2,
+			HostInterval:    Duration(time.Second),
+			RateLimit:       20,
+		},
+		Retention: RetentionConfig{
+			Interval:    Duration(time.Hour),
+			TrashPeriod: Duration(30 * 24 * time.Hour),
+		},
+		Sanitize: SanitizeConfig{
+			SummaryLength: 300,
+			URLSchemes:    []string{"http", "https", "mailto"},
+		},
+		Extract: ExtractConfig{
+			Workers:      2,
+			HostInterval: Duration(2 * time.Second),
+			MaxBytes:     2 << 20,
+			QueueSize:    1000,
+		},
+		Fetch: FetchConfig{
+			MaxRedirects: 5,
+			MaxBytes:     10 << 20,
+			ContentTypes: append([]string(nil), fetchpolicy.DefaultContentTypes...),
+		},
+		Links: LinksConfig{
+			Redirectors: append([]string(nil), urlnorm.DefaultRedirectors...),
+		},
+		Icons: IconsConfig{
+			MaxBytes:        256 << 10,
+			RefreshInterval: Duration(24 * time.Hour),
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Metrics: MetricsConfig{
+			PerFeedLabels:  true,
+			TotalsInterval: Duration(time.Minute),
+		},
+	}
+}
+
+// setting that can be provided by a flag, an envvar or both.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+// settings that can be provided by flags and envvars. Envvars are applied in
+// this order so PORT, which is kept for compatibility, is overridden by
+// LISTEN_ADDRESS.
+func (c *Config) settings() []setting {
+	return []setting{
+		{"", "PORT", "", portValue{&c.Server.ListenAddress}},
+		{"listen-address", "LISTEN_ADDRESS", "address to listen on as host:port", (*stringValue)(&c.Server.ListenAddress)},
+		{"tls-cert-file", "TLS_CERT_FILE", "TLS certificate file, enables HTTPS with -tls-key-file", (*stringValue)(&c.Server.TLSCertFile)},
+		{"tls-key-file", "TLS_KEY_FILE", "TLS private key file, enables HTTPS with -tls-cert-file", (*stringValue)(&c.Server.TLSKeyFile)},
+		{"cache-ttl", "CACHE_TTL", "time to cache GET responses, 0 disables the cache", &c.Server.CacheTTL},
+		{"request-timeout", "REQUEST_TIMEOUT", "time allowed to handle an API request", &c.Server.RequestTimeout},
+		{"read-timeout", "READ_TIMEOUT", "time allowed to read a request", &c.Server.ReadTimeout},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time allowed to drain requests when shutting down", &c.Server.ShutdownTimeout},
+		{"body-limit", "BODY_LIMIT", "largest API request body in bytes, other than imports", (*intValue)(&c.Server.BodyLimit)},
+		{"database-url", "DATABASE_URL", "PostgreSQL database URL, the memory store is used when empty", (*stringValue)(&c.Database.URL)},
+		{"auto-migrate", "AUTO_MIGRATE", "apply the database migrations at startup", (*boolValue)(&c.Database.AutoMigrate)},
+		{"migrations-dir", "MIGRATIONS_DIR", "directory of migrations to use instead of those built in", (*stringValue)(&c.Database.MigrationsDir)},
+		{"seed-file", "SEED_FILE", "backup archive loaded into the memory store at startup when it is empty", (*stringValue)(&c.Database.SeedFile)},
+		{"memory-dir", "MEMORY_DIR", "directory keeping the memory store across restarts, discarded on exit when empty", (*stringValue)(&c.Database.MemoryDir)},
+		{"snapshot-interval", "SNAPSHOT_INTERVAL", "time between snapshots of the memory store, 0 only snapshots on exit", &c.Database.SnapshotInterval},
+		{"sync-writes", "SYNC_WRITES", "flush every change to the memory store to disk before acknowledging it", (*boolValue)(&c.Database.SyncWrites)},
+		{"refresh-interval", "REFRESH_INTERVAL", "time between scheduled collections, 0 disables them", &c.Collector.RefreshInterval},
+		{"fetch-timeout", "FETCH_TIMEOUT", "time allowed to fetch and parse a feed", &c.Collector.FetchTimeout},
+		{"store-timeout", "STORE_TIMEOUT", "time allowed to store a collected feed", &c.Collector.StoreTimeout},
+		{"collector-concurrency", "COLLECTOR_CONCURRENCY", "number of feeds collected at once", (*intValue)(&c.Collector.Concurrency)},
+		{"user-agent", "USER_AGENT", "user agent sent when fetching feeds", (*stringValue)(&c.Collector.UserAgent)},
+		{"host-concurrency", "HOST_CONCURRENCY", "number of feeds fetched from one host at once", (*intValue)(&c.Collector.HostConcurrency)},
+		{"host-interval", "HOST_INTERVAL", "time between requests for feeds on the same host", &c.Collector.HostInterval},
+		{"rate-limit", "RATE_LIMIT", "requests each second across every host, 0 is unlimited", (*intValue)(&c.Collector.RateLimit)},
+		{"crawl-delay", "CRAWL_DELAY", "space requests to each host by its robots.txt Crawl-delay", (*boolValue)(&c.Collector.CrawlDelay)},
+		{"retention-max-age", "RETENTION_MAX_AGE", "age of items to keep, 0 keeps every item", &c.Retention.MaxAge},
+		{"retention-max-items-per-feed", "RETENTION_MAX_ITEMS_PER_FEED", "items to keep per feed, 0 keeps every item", (*intValue)(&c.Retention.MaxItemsPerFeed)},
+		{"retention-interval", "RETENTION_INTERVAL", "time between pruning items, 0 disables it", &c.Retention.Interval},
+		{"trash-period", "TRASH_PERIOD", "time deleted objects are kept in the trash, 0 keeps them forever", &c.Retention.TrashPeriod},
+		{"icon-dir", "ICON_DIR", "directory feed icons are kept in, empty disables fetching them", (*stringValue)(&c.Icons.Dir)},
+		{"icon-max-bytes", "ICON_MAX_BYTES", "largest feed icon downloaded in bytes", (*intValue)(&c.Icons.MaxBytes)},
+		{"icon-refresh-interval", "ICON_REFRESH_INTERVAL", "time between fetching a feed's icon again", &c.Icons.RefreshInterval},
+		{"sanitize-html", "SANITIZE_HTML", "clean the HTML of items before storing them", (*boolValue)(&c.Sanitize.Enabled)},
+		{"keep-original-html", "KEEP_ORIGINAL_HTML", "keep the HTML of items as the feed gave it alongside the cleaned HTML", (*boolValue)(&c.Sanitize.KeepOriginal)},
+		{"summary-length", "SUMMARY_LENGTH", "characters in the plain text summary of each item", (*intValue)(&c.Sanitize.SummaryLength)},
+		{"url-schemes", "URL_SCHEMES", "comma separated URL schemes allowed in the HTML of items", (*stringsValue)(&c.Sanitize.URLSchemes)},
+		{"extract-content", "EXTRACT_CONTENT", "fetch the full article for the items of feeds that ask for it", (*boolValue)(&c.Extract.Enabled)},
+		{"extract-workers", "EXTRACT_WORKERS", "number of articles extracted at once", (*intValue)(&c.Extract.Workers)},
+		{"extract-host-interval", "EXTRACT_HOST_INTERVAL", "time between requests for articles to the same host", &c.Extract.HostInterval},
+		{"extract-max-bytes", "EXTRACT_MAX_BYTES", "largest page an article is extracted from in bytes", (*intValue)(&c.Extract.MaxBytes)},
+		{"extract-queue-size", "EXTRACT_QUEUE_SIZE", "number of items that can wait for their article", (*intValue)(&c.Extract.QueueSize)},
+		{"api-keys", "API_KEYS", "comma separated API keys required by the HTTP API", (*stringsValue)(&c.Auth.APIKeys)},
+		{"secrets-key", "SECRETS_KEY", "base64 encoded 32 byte key encrypting the credentials of feeds", (*stringValue)(&c.Secrets.Key)},
+		{"fetch-allow", "FETCH_ALLOW", "comma separated networks and hosts that may be fetched from though they are internal", (*stringsValue)(&c.Fetch.Allow)},
+		{"fetch-max-redirects", "FETCH_MAX_REDIRECTS", "redirects followed fetching a feed", (*intValue)(&c.Fetch.MaxRedirects)},
+		{"fetch-max-bytes", "FETCH_MAX_BYTES", "largest feed fetched in bytes", (*intValue)(&c.Fetch.MaxBytes)},
+		{"fetch-content-types", "FETCH_CONTENT_TYPES", "comma separated content types feeds may be served as", (*stringsValue)(&c.Fetch.ContentTypes)},
+		{"links-tracking-params", "LINKS_TRACKING_PARAMS", "comma separated query parameters stripped from feed and item URLs", (*stringsValue)(&c.Links.TrackingParams)},
+		{"links-resolve-redirects", "LINKS_RESOLVE_REDIRECTS", "replace the links of new items on redirector hosts with the pages they lead to", (*boolValue)(&c.Links.ResolveRedirects)},
+		{"links-redirectors", "LINKS_REDIRECTORS", "comma separated hosts whose links only redirect to another", (*stringsValue)(&c.Links.Redirectors)},
+		{"log-level", "LOG_LEVEL", "log level, e.g. debug, info or error", (*stringValue)(&c.Log.Level)},
+		{"log-format", "LOG_FORMAT", "log format, json or console", (*stringValue)(&c.Log.Format)},
+		{"metrics-per-feed-labels", "METRICS_PER_FEED_LABELS", "label feed metrics with the feed ID", (*boolValue)(&c.Metrics.PerFeedLabels)},
+		{"metrics-totals-interval", "METRICS_TOTALS_INTERVAL", "time between counting the objects stored", &c.Metrics.TotalsInterval},
+		{"metrics-public", "METRICS_PUBLIC", "serve the metrics without an API key", (*boolValue)(&c.Metrics.Public)},
+	}
+}
+
+// Load the configuration from the defaults, the configuration file, envvars
+// and the command line flags in args, in increasing order of precedence.
+func Load(name string, args []string) (*Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c, err := LoadFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return c, nil
+}
+
+// LoadFlags loads the configuration like Load, defining the configuration
+// flags on fs alongside any flags already defined. The arguments remaining
+// after the flags are left in fs.Args().
+func LoadFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+
+	// Flags are parsed first to find the configuration file but applied last
+	// so that they take precedence.
+	var flagValues []*deferredValue
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file (env CONFIG_FILE)")
+	for _, s := range c.settings() {
+		if len(s.flag) == 0 {
+			continue
+		}
+		value := &deferredValue{value: s.value}
+		flagValues = append(flagValues, value)
+		fs.Var(value, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if len(*configFile) > 0 {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range c.settings() {
+		raw := os.Getenv(s.env)
+		if len(raw) == 0 {
+			continue
+		}
+		if err := s.value.Set(raw); err != nil {
+			return nil, fmt.Errorf("invalid %s envvar: %w", s.env, err)
+		}
+	}
+
+	for _, f := range flagValues {
+		if err := f.apply(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate the configuration, reporting every problem found.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.ListenAddress)
+	check(err == nil, "server.listenAddress %q is not a host:port", c.Server.ListenAddress)
+	check((len(c.Server.TLSCertFile) == 0) == (len(c.Server.TLSKeyFile) == 0),
+		"server.tlsCertFile and server.tlsKeyFile must be set together")
+	check(c.Server.CacheTTL >= 0, "server.cacheTTL must not be negative")
+	check(c.Server.RequestTimeout >= 0, "server.requestTimeout must not be negative")
+	check(c.Server.ReadTimeout >= 0, "server.readTimeout must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(c.Server.BodyLimit > 0, "server.bodyLimit must be positive")
+
+	if len(c.Database.URL) > 0 {
+		_, err := url.Parse(c.Database.URL)
+		check(err == nil, "database.url is not a URL")
+		check(len(c.Database.SeedFile) == 0, "database.seedFile is only loaded into the memory store, not with database.url")
+		check(len(c.Database.MemoryDir) == 0, "database.memoryDir is only used by the memory store, not with database.url")
+	}
+	check(c.Database.SnapshotInterval >= 0, "database.snapshotInterval must not be negative")
+
+	check(c.Collector.RefreshInterval >= 0, "collector.refreshInterval must not be negative")
+	check(c.Collector.FetchTimeout >= 0, "collector.fetchTimeout must not be negative")
+	check(c.Collector.StoreTimeout >= 0, "collector.storeTimeout must not be negative")
+	check(c.Collector.Concurrency > 0, "collector.concurrency must be at least 1")
+	check(len(strings.TrimSpace(c.Collector.UserAgent)) > 0, "collector.userAgent must be set")
+	check(c.Collector.HostConcurrency > 0, "collector.hostConcurrency must be at least 1")
+	check(c.Collector.HostInterval >= 0, "collector.hostInterval must not be negative")
+	check(c.Collector.RateLimit >= 0, "collector.rateLimit must not be negative")
+
+	check(c.Retention.MaxAge >= 0, "retention.maxAge must not be negative")
+	check(c.Retention.MaxItemsPerFeed >= 0, "retention.maxItemsPerFeed must not be negative")
+	check(c.Retention.Interval >= 0, "retention.interval must not be negative")
+	check(c.Retention.TrashPeriod >= 0, "retention.trashPeriod must not be negative")
+	for _, name := range sortedKeys(c.Retention.Feeds) {
+		policy := c.Retention.Feeds[name]
+		check(policy.MaxAge >= 0 && policy.MaxItems >= 0, "retention.feeds %q must not be negative", name)
+	}
+	for _, name := range sortedKeys(c.Retention.Categories) {
+		policy := c.Retention.Categories[name]
+		check(policy.MaxAge >= 0 && policy.MaxItems >= 0, "retention.categories %q must not be negative", name)
+	}
+
+	check(c.Icons.MaxBytes > 0, "icons.maxBytes must be positive")
+	check(c.Icons.RefreshInterval > 0, "icons.refreshInterval must be positive")
+
+	check(c.Sanitize.SummaryLength > 0, "sanitize.summaryLength must be positive")
+	for _, scheme := range c.Sanitize.URLSchemes {
+		check(len(strings.TrimSpace(scheme)) > 0, "sanitize.urlSchemes must not contain empty schemes")
+	}
+
+	check(c.Extract.Workers > 0, "extract.workers must be positive")
+	check(c.Extract.HostInterval > 0, "extract.hostInterval must be positive")
+	check(c.Extract.MaxBytes > 0, "extract.maxBytes must be positive")
+	check(c.Extract.QueueSize > 0, "extract.queueSize must be positive")
+
+	for _, key := range c.Auth.APIKeys {
+		check(len(strings.TrimSpace(key)) > 0, "auth.apiKeys must not contain empty keys")
+	}
+
+	if len(c.Secrets.Key) > 0 {
+		_, err = secrets.ParseKey(c.Secrets.Key)
+		check(err == nil, "secrets.key must be %d base64 encoded bytes", secrets.KeySize)
+	}
+
+	_, err = fetchpolicy.New(fetchpolicy.Config{Allow: c.Fetch.Allow})
+	check(err == nil, "fetch.allow entries must be networks or host names: %v", err)
+	check(c.Fetch.MaxRedirects >= 0, "fetch.maxRedirects must not be negative")
+	check(c.Fetch.MaxBytes > 0, "fetch.maxBytes must be positive")
+	check(len(c.Fetch.ContentTypes) > 0, "fetch.contentTypes must not be empty")
+
+	for _, param := range c.Links.TrackingParams {
+		check(len(strings.TrimSpace(param)) > 0, "links.trackingParams must not contain empty names")
+	}
+	for _, host := range c.Links.Redirectors {
+		check(len(strings.TrimSpace(host)) > 0, "links.redirectors must not contain empty hosts")
+	}
+	check(!c.Links.ResolveRedirects || len(c.Links.Redirectors) > 0,
+		"links.redirectors must not be empty with links.resolveRedirects")
+
+	_, err = zerolog.ParseLevel(c.Log.Level)
+	check(err == nil && len(c.Log.Level) > 0, "log.level %q is not a log level", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "console",
+		"log.format %q must be json or console", c.Log.Format)
+
+	check(c.Metrics.TotalsInterval > 0, "metrics.totalsInterval must be positive")
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Print the configuration as YAML with its secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	if len(c.Database.URL) > 0 {
+		c.Database.URL = redactDatabaseURL(c.Database.URL)
+	}
+	apiKeys := make([]string, len(c.Auth.APIKeys))
+	for i := range apiKeys {
+		apiKeys[i] = Redacted
+	}
+	c.Auth.APIKeys = apiKeys
+	if len(c.Secrets.Key) > 0 {
+		c.Secrets.Key = Redacted
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dsnPassword matches the password of a key=value connection string, quoted
+// or not.
+var dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s]*)`)
+
+// redactDatabaseURL redacts the password of a postgres:// URL, given in its
+// user info or its query, or of a key=value connection string.
+func redactDatabaseURL(databaseURL string) string {
+	u, err := url.Parse(databaseURL)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return dsnPassword.ReplaceAllString(databaseURL, "${1}"+Redacted)
+	}
+	query := u.Query()
+	if _, ok := query["password"]; ok {
+		query.Set("password", Redacted)
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+func sortedKeys(policies map[string]RetentionPolicy) []string {
+	keys := make([]string, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written as a Go duration string such as "30s"
+// in configuration files, envvars and flags.
+type Duration time.Duration
+
+// Duration converts d to a time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if err := d.Set(node.Value); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+type stringValue string
+
+func (s *stringValue) String() string {
+	return string(*s)
+}
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+type intValue int
+
+func (i *intValue) String() string {
+	return strconv.Itoa(int(*i))
+}
+
+func (i *intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*i = intValue(parsed)
+	return nil
+}
+
+type boolValue bool
+
+func (b *boolValue) String() string {
+	return strconv.FormatBool(bool(*b))
+}
+
+func (b *boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*b = boolValue(parsed)
+	return nil
+}
+
+// IsBoolFlag allows the flag to be given without a value.
+func (b *boolValue) IsBoolFlag() bool {
+	return true
+}
+
+// stringsValue is a comma separated list.
+type stringsValue []string
+
+func (s *stringsValue) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsValue) Set(value string) error {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		values = append(values, strings.TrimSpace(v))
+	}
+	*s = values
+	return nil
+}
+
+// portValue sets the port of a listen address, keeping its host.
+type portValue struct {
+	address *string
+}
+
+func (p portValue) String() string {
+	if p.address == nil {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(*p.address)
+	return port
+}
+
+func (p portValue) Set(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", value)
+	}
+	host, _, err := net.SplitHostPort(*p.address)
+	if err != nil {
+		host = "0.0.0.0"
+	}
+	*p.address = net.JoinHostPort(host, value)
+	return nil
+}
+
+// deferredValue holds the values given for a flag until they are applied.
+type deferredValue struct {
+	value flag.Value
+	raw   []string
+}
+
+func (d *deferredValue) String() string {
+	if d.value == nil {
+		return ""
+	}
+	return d.value.String()
+}
+
+func (d *deferredValue) Set(value string) error {
+	d.raw = append(d.raw, value)
+	return nil
+}
+
+func (d *deferredValue) IsBoolFlag() bool {
+	b, ok := d.value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func (d *deferredValue) apply() error {
+	for _, raw := range d.raw {
+		if err := d.value.Set(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
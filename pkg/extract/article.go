@@ -0,0 +1,256 @@
+package extract
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ErrNoArticle is returned by Article when a page has nothing that looks
+// like the body of an article.
+var ErrNoArticle = errors.New("no article found on the page")
+
+// minParagraphLength of the text of an element for it to count towards the
+// score of the elements around it.
+const minParagraphLength = 25
+
+// minArticleLength of the text of the element chosen as the article.
+const minArticleLength = 140
+
+var (
+	// positiveNames in the class or id of an element suggest it holds the
+	// article.
+	positiveNames = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|story|text`)
+	// negativeNames suggest it holds anything but.
+	negativeNames = regexp.MustCompile(`(?i)advert|banner|combx|comment|cookie|footer|footnote|masthead|menu|meta|nav|popup|promo|related|share|shopping|sidebar|social|sponsor|subscribe|tags|widget`)
+)
+
+// skipped elements are never part of an article.
+var skipped = map[atom.Atom]struct{}{
+	atom.Aside:    {},
+	atom.Button:   {},
+	atom.Footer:   {},
+	atom.Form:     {},
+	atom.Head:     {},
+	atom.Iframe:   {},
+	atom.Input:    {},
+	atom.Nav:      {},
+	atom.Noscript: {},
+	atom.Script:   {},
+	atom.Select:   {},
+	atom.Style:    {},
+	atom.Svg:      {},
+	atom.Textarea: {},
+}
+
+// paragraphs are the elements whose text is scored.
+var paragraphs = map[atom.Atom]struct{}{
+	atom.Blockquote: {},
+	atom.P:          {},
+	atom.Pre:        {},
+	atom.Td:         {},
+}
+
+// Article finds the main article on an HTML page and returns its HTML, which
+// still needs sanitizing before it is shown to anyone.
+//
+// It works in the manner of readability: each paragraph of reasonable length
+// adds to the score of its parent and, by half as much, its grandparent,
+// according to how long it is and how many commas it has. Elements whose
+// class or id suggest they hold the article start with a higher score and
+// those that suggest comments, navigation or adverts a lower one. The
+// element with the highest score, once reduced by the proportion of its text
+// that is links, is taken to be the article.
+func Article(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if _, ok := skipped[n.DataAtom]; ok {
+				return
+			}
+			if _, ok := paragraphs[n.DataAtom]; ok {
+				text := textOf(n)
+				if len(text) >= minParagraphLength {
+					score := 1 + float64(strings.Count(text, ",")) + minFloat(float64(len(text))/100, 3)
+					addScore(n.Parent, score)
+					if n.Parent != nil {
+						addScore(n.Parent.Parent, score/2)
+					}
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	var best *html.Node
+	var bestScore float64
+	for _, candidate := range candidates {
+		score := scores[candidate] * (1 - linkDensity(candidate))
+		if best == nil || score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best == nil || len(textOf(best)) < minArticleLength {
+		return "", ErrNoArticle
+	}
+
+	var b strings.Builder
+	for child := best.FirstChild; child != nil; child = child.NextSibling {
+		if err := renderArticle(&b, child); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// renderArticle writes the node unless it is one of the skipped elements or
+// its class or id suggests it isn't part of the article.
+func renderArticle(w io.Writer, n *html.Node) error {
+	if n.Type == html.ElementNode {
+		if _, ok := skipped[n.DataAtom]; ok {
+			return nil
+		}
+		if classWeight(n) < 0 {
+			return nil
+		}
+	}
+	if n.Type != html.ElementNode || n.FirstChild == nil {
+		return html.Render(w, n)
+	}
+
+	// Copy the element without its children so they can be filtered too.
+	shallow := &html.Node{Type: n.Type, Data: n.Data, DataAtom: n.DataAtom, Namespace: n.Namespace, Attr: n.Attr}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if err := renderArticle(&b, child); err != nil {
+			return err
+		}
+	}
+	// The filtered children are text to the renderer, so the element is
+	// written around them by hand.
+	var open strings.Builder
+	if err := html.Render(&open, shallow); err != nil {
+		return err
+	}
+	rendered := open.String()
+	closing := "</" + n.Data + ">"
+	if !strings.HasSuffix(rendered, closing) {
+		// Void elements have no children to write.
+		_, err := io.WriteString(w, rendered)
+		return err
+	}
+	_, err := io.WriteString(w, strings.TrimSuffix(rendered, closing)+b.String()+closing)
+	return err
+}
+
+// initialScore of an element by its kind and by its class and id.
+func initialScore(n *html.Node) float64 {
+	score := float64(classWeight(n))
+	switch n.DataAtom {
+	case atom.Article, atom.Main:
+		score += 10
+	case atom.Div:
+		score += 5
+	case atom.Blockquote, atom.Pre, atom.Td:
+		score += 3
+	case atom.Dl, atom.Ol, atom.Ul, atom.Li:
+		score -= 3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		score -= 5
+	}
+	return score
+}
+
+// classWeight of an element is positive when its class or id suggests it
+// holds the article and negative when they suggest otherwise.
+func classWeight(n *html.Node) int {
+	weight := 0
+	for _, attr := range n.Attr {
+		if attr.Key != "class" && attr.Key != "id" {
+			continue
+		}
+		if negativeNames.MatchString(attr.Val) {
+			weight -= 25
+		}
+		if positiveNames.MatchString(attr.Val) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// linkDensity is the proportion of the text of the element within links.
+func linkDensity(n *html.Node) float64 {
+	total := len(textOf(n))
+	if total == 0 {
+		return 0
+	}
+	linked := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			linked += len(textOf(n))
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return float64(linked) / float64(total)
+}
+
+// textOf the element with runs of whitespace collapsed, leaving out the
+// skipped elements.
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		case html.ElementNode, html.DocumentNode:
+			if _, ok := skipped[n.DataAtom]; ok {
+				return
+			}
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,265 @@
+// Package extract downloads the pages that feed items link to and keeps the
+// article they hold as the item's content, for feeds that only give a teaser.
+package extract
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html/charset"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/sanitize"
+)
+
+// Defaults used for zero Config values.
+const (
+	DefaultWorkers      = 2
+	DefaultHostInterval = 2 * time.Second
+	DefaultMaxBytes     = 2 << 20
+	DefaultQueueSize    = 1000
+)
+
+// contentTypes of the pages articles are extracted from. A page served
+// without a type is tried too.
+var contentTypes = map[string]struct{}{
+	"text/html":             {},
+	"application/xhtml+xml": {},
+}
+
+// Config for an Extractor.
+type Config struct {
+	// Workers extracting queued items at once.
+	Workers int
+	// HostInterval between requests to the same host, however many workers
+	// there are.
+	HostInterval time.Duration
+	// Timeout bounds downloading a single page, zero leaves it to the
+	// context.
+	Timeout time.Duration
+	// MaxBytes of a page, larger pages are abandoned.
+	MaxBytes int64
+	// QueueSize is the number of items that can wait to be extracted, those
+	// queued beyond it are dropped.
+	QueueSize int
+	// UserAgent sent when downloading pages.
+	UserAgent string
+}
+
+// Extractor replaces the content of items with the article found on the
+// page they link to, either on demand or from a queue worked through in the
+// background.
+type Extractor struct {
+	itemRepos  repository.FeedItemStore
+	sanitizer  *sanitize.Sanitizer
+	httpClient *http.Client
+	config     Config
+	queue      chan string
+	running    sync.WaitGroup
+
+	mu sync.Mutex
+	// nextRequest to each host may be made no earlier than this.
+	nextRequest map[string]time.Time
+}
+
+// NewExtractor of the items in itemRepos. The articles are cleaned with the
+// sanitizer, or the default policy when it is nil, as the HTML of a page is
+// no more trustworthy than that of a feed.
+func NewExtractor(
+	itemRepos repository.FeedItemStore,
+	sanitizer *sanitize.Sanitizer,
+	httpClient *http.Client,
+	config Config) *Extractor {
+	if sanitizer == nil {
+		sanitizer = sanitize.New(sanitize.DefaultPolicy())
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if config.Workers <= 0 {
+		config.Workers = DefaultWorkers
+	}
+	if config.HostInterval <= 0 {
+		config.HostInterval = DefaultHostInterval
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	return &Extractor{
+		itemRepos:   itemRepos,
+		sanitizer:   sanitizer,
+		httpClient:  httpClient,
+		config:      config,
+		queue:       make(chan string, config.QueueSize),
+		nextRequest: make(map[string]time.Time),
+	}
+}
+
+// Enqueue the item to be extracted by Run, reporting false when the queue
+// is full and the item was dropped.
+func (e *Extractor) Enqueue(itemID string) bool {
+	select {
+	case e.queue <- itemID:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run extracts queued items, Workers at a time, until ctx is done. Failures
+// are logged, the item keeps the content its feed gave it. Use Wait to know
+// when the workers have stopped.
+func (e *Extractor) Run(ctx context.Context) {
+	e.running.Add(e.config.Workers)
+	for i := 0; i < e.config.Workers; i++ {
+		go func() {
+			defer e.running.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case itemID := <-e.queue:
+					if _, err := e.Extract(ctx, itemID); err != nil && ctx.Err() == nil {
+						log.Warn().Err(err).Str("itemId", itemID).Msg("failed to extract article")
+					}
+				}
+			}
+		}()
+	}
+}
+
+// Wait blocks until the workers started by Run have stopped.
+func (e *Extractor) Wait() {
+	e.running.Wait()
+}
+
+// Extract the article from the page the item links to and store it as the
+// item's content, returning the updated item.
+func (e *Extractor) Extract(ctx context.Context, itemID string) (rsscollector.FeedItem, error) {
+	item, err := e.itemRepos.FetchItemByID(ctx, itemID)
+	if err != nil {
+		return rsscollector.FeedItem{}, err
+	}
+	page, err := url.Parse(item.Link)
+	if err != nil {
+		return rsscollector.FeedItem{}, err
+	}
+	if page.Scheme != "http" && page.Scheme != "https" {
+		return rsscollector.FeedItem{}, fmt.Errorf("item link %q is not http or https", item.Link)
+	}
+
+	article, base, err := e.download(ctx, page)
+	if err != nil {
+		return rsscollector.FeedItem{}, err
+	}
+	content := e.sanitizer.HTML(article, base)
+	if len(sanitize.Text(content)) == 0 {
+		return rsscollector.FeedItem{}, ErrNoArticle
+	}
+
+	extractedAt := time.Now().UTC()
+	item.Content = content
+	item.ExtractedAt = &extractedAt
+	if err := e.itemRepos.StoreItem(ctx, item.SourceID, &item); err != nil {
+		return rsscollector.FeedItem{}, err
+	}
+	return item, nil
+}
+
+// download the page once its host is free and find its article, returning
+// it with the URL of the page after any redirects.
+func (e *Extractor) download(ctx context.Context, page *url.URL) (string, *url.URL, error) {
+	if err := e.waitForHost(ctx, page.Host); err != nil {
+		return "", nil, err
+	}
+	if e.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, page.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(e.config.UserAgent) > 0 {
+		req.Header.Set("User-Agent", e.config.UserAgent)
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	header := resp.Header.Get("Content-Type")
+	if len(header) > 0 {
+		contentType, _, _ := mime.ParseMediaType(header)
+		if _, ok := contentTypes[contentType]; !ok {
+			return "", nil, fmt.Errorf("unsupported page type %s", contentType)
+		}
+	}
+	if resp.ContentLength > e.config.MaxBytes {
+		return "", nil, fmt.Errorf("page of %d bytes is larger than %d", resp.ContentLength, e.config.MaxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, e.config.MaxBytes+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if int64(len(data)) > e.config.MaxBytes {
+		return "", nil, fmt.Errorf("page is larger than %d bytes", e.config.MaxBytes)
+	}
+
+	// Pages not in UTF-8 say so in their header or a meta element.
+	body, err := charset.NewReader(bytes.NewReader(data), header)
+	if err != nil {
+		return "", nil, err
+	}
+	article, err := Article(body)
+	if err != nil {
+		return "", nil, err
+	}
+	return article, resp.Request.URL, nil
+}
+
+// waitForHost blocks until a request can be made to host, reserving the
+// next slot so requests from several workers are spread HostInterval apart.
+func (e *Extractor) waitForHost(ctx context.Context, host string) error {
+	host = strings.ToLower(host)
+	e.mu.Lock()
+	now := time.Now()
+	next := e.nextRequest[host]
+	if next.Before(now) {
+		next = now
+	}
+	e.nextRequest[host] = next.Add(e.config.HostInterval)
+	e.mu.Unlock()
+
+	wait := time.Until(next)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+package extract
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head><title>A story</title><script>var tracking = true;</script></head>
+<body>
+<nav><a href="/">Home</a> <a href="/news/">News</a> <a href="/sport/">Sport</a></nav>
+<div class="sidebar">
+  <p>Popular today: the ten most read stories, chosen by our readers, updated hourly.</p>
+</div>
+<div class="story-body">
+  <h1>A story</h1>
+  <p>The first paragraph of the story, which runs on for a while, with a comma or two, to look like writing.</p>
+  <p>The second paragraph has <a href="/more.html">a link</a> and goes on about the subject, at some length, as they do.</p>
+  <div class="share-buttons"><p>Share this story on every social network you can think of, please.</p></div>
+  <p onclick="steal()">The third paragraph ends the story, after which there is nothing else of interest to read.</p>
+</div>
+<div id="comments">
+  <p>A comment about the story, from a reader, who has opinions, many of them, all strong.</p>
+</div>
+<footer><p>Copyright of the publisher, all rights reserved, for ever and ever, amen.</p></footer>
+</body></html>`
+
+func TestArticle(t *testing.T) {
+	article, err := Article(strings.NewReader(testPage))
+	require.Nil(t, err)
+
+	assert.True(t, strings.Contains(article, "<h1>A story</h1>"))
+	assert.True(t, strings.Contains(article, "The first paragraph"))
+	assert.True(t, strings.Contains(article, `<a href="/more.html">a link</a>`))
+	assert.True(t, strings.Contains(article, "The third paragraph"))
+	for _, left := range []string{"Home", "Popular today", "Share this story", "A comment", "Copyright", "tracking"} {
+		assert.False(t, strings.Contains(article, left), left)
+	}
+
+	_, err = Article(strings.NewReader(`<html><body><p>Too short.</p></body></html>`))
+	assert.Equal(t, ErrNoArticle, err)
+}
+
+func TestExtract(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/story.html", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-agent", r.UserAgent())
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/latin1.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		_, _ = w.Write([]byte(strings.Replace(testPage, "The first paragraph", "The caf\xe9 paragraph", 1)))
+	})
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		_, _ = w.Write([]byte(testPage))
+	})
+	mux.HandleFunc("/large.html", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testPage + strings.Repeat(" ", 1000)))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	store := repository.NewMemoryStore()
+	ctx := context.Background()
+	source := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml"}}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	extractor := NewExtractor(store, nil, server.Client(), Config{
+		HostInterval: time.Millisecond,
+		MaxBytes:     int64(len(testPage) + 100),
+		UserAgent:    "test-agent",
+	})
+
+	testCases := []struct {
+		Name     string
+		Link     string
+		Expected string
+		Error    bool
+	}{
+		{"Article", server.URL + "/story.html", "The first paragraph", false},
+		{"Other character set", server.URL + "/latin1.html", "The café paragraph", false},
+		{"Not a page", server.URL + "/feed.xml", "", true},
+		{"Too large", server.URL + "/large.html", "", true},
+		{"Missing", server.URL + "/missing.html", "", true},
+		{"Not http", "ftp://example.com/story.html", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			item := rsscollector.FeedItem{GUID: tc.Name, Link: tc.Link, Content: "<p>A teaser</p>"}
+			require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+			extracted, err := extractor.Extract(ctx, item.ID)
+			stored, fetchErr := store.FetchItemByID(ctx, item.ID)
+			require.Nil(t, fetchErr)
+			if tc.Error {
+				assert.NotNil(t, err)
+				assert.Equal(t, "<p>A teaser</p>", stored.Content)
+				assert.Nil(t, stored.ExtractedAt)
+				return
+			}
+			require.Nil(t, err)
+			assert.True(t, strings.Contains(extracted.Content, tc.Expected))
+			// The article is sanitized, its links resolved against the page.
+			assert.True(t, strings.Contains(extracted.Content,
+				`<a href="`+server.URL+`/more.html" rel="nofollow noopener noreferrer">a link</a>`))
+			assert.False(t, strings.Contains(extracted.Content, "onclick"))
+			assert.NotNil(t, extracted.ExtractedAt)
+			assert.Equal(t, extracted.Content, stored.Content)
+			assert.NotNil(t, stored.ExtractedAt)
+		})
+	}
+}
+
+func TestHostInterval(t *testing.T) {
+	var mu sync.Mutex
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, time.Now())
+		mu.Unlock()
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer server.Close()
+
+	store := repository.NewMemoryStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := rsscollector.FeedSource{FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml"}}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	interval := 50 * time.Millisecond
+	extractor := NewExtractor(store, nil, server.Client(), Config{Workers: 3, HostInterval: interval, QueueSize: 3})
+	var ids []string
+	for i := 0; i < 4; i++ {
+		item := rsscollector.FeedItem{GUID: string(rune('a' + i)), Link: server.URL + "/story.html"}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		ids = append(ids, item.ID)
+	}
+	for _, id := range ids[:3] {
+		assert.True(t, extractor.Enqueue(id))
+	}
+	// The queue is full until the workers start.
+	assert.False(t, extractor.Enqueue(ids[3]))
+
+	extractor.Run(ctx)
+	require.Eventually(t, func() bool {
+		for _, id := range ids[:3] {
+			item, err := store.FetchItemByID(ctx, id)
+			if err != nil || item.ExtractedAt == nil {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	extractor.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 3)
+	for i := 1; i < len(requests); i++ {
+		// Allow for the clock's resolution.
+		assert.GreaterOrEqual(t, requests[i].Sub(requests[i-1]), interval-5*time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,16 +17,145 @@ type FeedSourcePartial struct {
 	Title         string    `json:"title"`
 	CategoryIDs   []string  `json:"categoryIDs,omitempty"`
 	LastCollected time.Time `json:"lastCollected"`
+	FeedMetadata
+	// FetchFullContent downloads the page each new item links to and keeps
+	// its main article as the item's content, for feeds that only give a
+	// teaser.
+	FetchFullContent bool `json:"fetchFullContent,omitempty"`
+	// Scrape is set for web pages without a feed, FeedURL being the page
+	// whose items are found with the selectors.
+	Scrape *ScrapeSelectors `json:"scrape,omitempty"`
+	// Fetch customises the requests made to collect the feed. Its secrets
+	// are stored encrypted and redacted by the API.
+	Fetch *FetchSettings `json:"fetch,omitempty"`
+	// URLHistory lists the URLs the feed had before FeedURL, oldest first.
+	URLHistory []FeedURLChange `json:"urlHistory,omitempty"`
+	// DuplicateOf is the ID of another source that has the URL this feed
+	// moved to. Scheduled collections skip the feed while it is set.
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+	// PrunedGUIDs of the items the retention policies removed that the feed
+	// still lists, which aren't collected again.
+	PrunedGUIDs []string `json:"prunedGuids,omitempty"`
+	// DeadAt is set when the feed was last served as 410 Gone. Scheduled
+	// collections skip the feed while it is set.
+	DeadAt *time.Time `json:"deadAt,omitempty"`
+	// DeletedAt is set when the feed is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Reasons a feed's URL changed.
+const (
+	// URLChangeRedirect is a permanent redirect, 301 or 308.
+	URLChangeRedirect = "redirect"
+	// URLChangeNewFeedURL is the itunes:new-feed-url of a podcast.
+	URLChangeNewFeedURL = "newFeedUrl"
+	// URLChangeSelfLink is the self link of the feed's document.
+	URLChangeSelfLink = "selfLink"
+	// URLChangeEdited is a change made through the API.
+	URLChangeEdited = "edited"
+)
+
+// FeedURLChange records a URL a feed had and why it changed.
+type FeedURLChange struct {
+	// URL the feed had before the change.
+	URL       string    `json:"url"`
+	Reason    string    `json:"reason"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// FeedMetadata describes a feed as its document does, refreshed each time it
+// is collected.
+type FeedMetadata struct {
+	Description string `json:"description,omitempty"`
+	// SiteLink is the website the feed is for.
+	SiteLink  string         `json:"siteLink,omitempty"`
+	Language  string         `json:"language,omitempty"`
+	Image     *FeedItemImage `json:"image,omitempty"`
+	Authors   []FeedAuthor   `json:"authors,omitempty"`
+	Copyright string         `json:"copyright,omitempty"`
+	Generator string         `json:"generator,omitempty"`
+	// FeedType is rss, atom or json, with its FeedVersion, e.g. 2.0.
+	FeedType    string `json:"feedType,omitempty"`
+	FeedVersion string `json:"feedVersion,omitempty"`
+}
+
+// ScrapeSelectors are the CSS selectors that find the items on a web page.
+// Item selects each item, the others select within it, taking the first
+// element they match.
+type ScrapeSelectors struct {
+	Item string `json:"item"`
+	// Title of the item, the text of its link when it isn't set.
+	Title string `json:"title,omitempty"`
+	// Link to the item, its first link with an href when it isn't set.
+	Link string `json:"link,omitempty"`
+	// Date the item was published, taken from the datetime or content
+	// attribute when the element has one, otherwise its text.
+	Date string `json:"date,omitempty"`
+	// DateLayout parses the date as time.Parse would, common formats are
+	// tried when it isn't set.
+	DateLayout string `json:"dateLayout,omitempty"`
+	// Summary becomes the item's description, keeping its HTML.
+	Summary string `json:"summary,omitempty"`
+}
+
+// FetchSettings customise the requests made to collect a feed, for feeds
+// that need credentials or won't serve the default client.
+type FetchSettings struct {
+	// Username and Password are sent with basic authentication.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// BearerToken is sent in the Authorization header, in place of any
+	// basic authentication.
+	BearerToken string `json:"bearerToken,omitempty"`
+	// Headers and Cookies sent with each request, by name.
+	Headers map[string]string `json:"headers,omitempty"`
+	Cookies map[string]string `json:"cookies,omitempty"`
+	// UserAgent replaces the collector's user agent.
+	UserAgent string `json:"userAgent,omitempty"`
+	// ProxyURL requests are made through, http, https or socks5.
+	ProxyURL string `json:"proxyUrl,omitempty"`
+	// TLS options for feeds served with private certificates.
+	TLS *FetchTLSSettings `json:"tls,omitempty"`
+}
+
+// FetchTLSSettings of the connection made to collect a feed. Certificates
+// and keys are PEM encoded.
+type FetchTLSSettings struct {
+	// CACertificate the server's certificate is verified with, in addition
+	// to the system's.
+	CACertificate string `json:"caCertificate,omitempty"`
+	// ClientCertificate and ClientKey are presented to servers that ask for
+	// a client certificate.
+	ClientCertificate string `json:"clientCertificate,omitempty"`
+	ClientKey         string `json:"clientKey,omitempty"`
+	// ServerName the certificate is verified against in place of the host.
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify accepts any certificate the server presents.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// FeedAuthor is a person or organisation named by a feed as its author.
+type FeedAuthor struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
 }
 
 func NewFeedSourcePartial(source FeedSource) FeedSourcePartial {
 	return FeedSourcePartial{
-		ID:            source.ID,
-		Link:          FeedSourceLink(source.ID),
-		FeedURL:       source.FeedURL,
-		Title:         source.Title,
-		CategoryIDs:   source.CategoryIDs,
-		LastCollected: source.LastCollected,
+		ID:               source.ID,
+		Link:             FeedSourceLink(source.ID),
+		FeedURL:          source.FeedURL,
+		Title:            source.Title,
+		CategoryIDs:      source.CategoryIDs,
+		LastCollected:    source.LastCollected,
+		FeedMetadata:     source.FeedMetadata,
+		FetchFullContent: source.FetchFullContent,
+		Scrape:           source.Scrape,
+		Fetch:            source.Fetch,
+		URLHistory:       source.URLHistory,
+		DuplicateOf:      source.DuplicateOf,
+		DeadAt:           source.DeadAt,
+		DeletedAt:        source.DeletedAt,
 	}
 }
 
@@ -56,6 +186,32 @@ type FeedItem struct {
 	Categories  []string          `json:"categories,omitempty"`
 	CategoryIDs []string          `json:"categoryIds,omitempty"`
 	Custom      map[string]string `json:"custom,omitempty"`
+	// Enclosures are the files attached to the item, such as the audio of a
+	// podcast episode.
+	Enclosures []FeedItemEnclosure `json:"enclosures,omitempty"`
+	// Media holds the iTunes and Media RSS details of podcast episodes and
+	// other media.
+	Media *FeedItemMedia `json:"media,omitempty"`
+	// OriginalLink is the link as the feed gave it, when Link is its
+	// canonical form without tracking parameters or the page a redirector
+	// link leads to.
+	OriginalLink string `json:"originalLink,omitempty"`
+	// Summary is plain text taken from the description, or the content when
+	// there is no description, for listing items without rendering HTML.
+	Summary string `json:"summary,omitempty"`
+	// RawDescription and RawContent are the description and content as the
+	// feed gave them, before they were sanitized, when they are kept.
+	RawDescription string `json:"rawDescription,omitempty"`
+	RawContent     string `json:"rawContent,omitempty"`
+	// ExtractedAt is set when the content is the article extracted from the
+	// page the item links to rather than what the feed gave. Collecting the
+	// item again keeps the extracted content.
+	ExtractedAt *time.Time `json:"extractedAt,omitempty"`
+	// Starred items are kept regardless of any retention policy. Collecting
+	// an item again leaves it starred.
+	Starred bool `json:"starred,omitempty"`
+	// DeletedAt is set when the item is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 type FeedItems []*FeedItem
@@ -65,7 +221,15 @@ func (f FeedItems) Len() int {
 }
 
 func (f FeedItems) Less(i, j int) bool {
-	return f[i].Published.Unix() < f[j].Published.Unix()
+	return publishedUnix(f[i]) < publishedUnix(f[j])
+}
+
+// publishedUnix treats items without a published date as the oldest.
+func publishedUnix(item *FeedItem) int64 {
+	if item.Published == nil {
+		return 0
+	}
+	return item.Published.Unix()
 }
 
 func (f FeedItems) Swap(i, j int) {
@@ -77,9 +241,61 @@ type FeedItemImage struct {
 	Title string `json:"title"`
 }
 
+// FeedItemEnclosure is a file attached to an item.
+type FeedItemEnclosure struct {
+	URL string `json:"url"`
+	// Type is the media type of the file, e.g. audio/mpeg.
+	Type string `json:"type,omitempty"`
+	// Length of the file in bytes, when the feed gives it.
+	Length int64 `json:"length,omitempty"`
+}
+
+// FeedItemMedia describes a podcast episode or other media item, taken from
+// the iTunes and Media RSS extensions. Numbers the feed doesn't give are zero.
+type FeedItemMedia struct {
+	// Duration in seconds.
+	Duration    int                      `json:"duration,omitempty"`
+	Episode     int                      `json:"episode,omitempty"`
+	Season      int                      `json:"season,omitempty"`
+	EpisodeType string                   `json:"episodeType,omitempty"`
+	Explicit    bool                     `json:"explicit,omitempty"`
+	Thumbnails  []FeedItemMediaThumbnail `json:"thumbnails,omitempty"`
+}
+
+type FeedItemMediaThumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// AnyEnclosure matches items with an enclosure of any type.
+const AnyEnclosure = "any"
+
+// HasEnclosure reports whether the item has an enclosure of mediaType. A
+// type without a subtype, e.g. audio, matches any subtype, and AnyEnclosure
+// matches every enclosure. Types are compared ignoring case.
+func (f FeedItem) HasEnclosure(mediaType string) bool {
+	for _, enclosure := range f.Enclosures {
+		if mediaType == AnyEnclosure {
+			return true
+		}
+		enclosureType := strings.ToLower(enclosure.Type)
+		if strings.Contains(mediaType, "/") {
+			if enclosureType == strings.ToLower(mediaType) {
+				return true
+			}
+		} else if strings.SplitN(enclosureType, "/", 2)[0] == strings.ToLower(mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
 type FeedCategory struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
+	// DeletedAt is set when the category is in the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 func NewCategory(name string) FeedCategory {
@@ -92,3 +308,12 @@ func NewCategory(name string) FeedCategory {
 		Name: name,
 	}
 }
+
+// Trash holds the deleted feeds, items and categories that can still be
+// restored. Items deleted along with their feed are restored with it and so
+// are not listed separately.
+type Trash struct {
+	Feeds      []FeedSourcePartial `json:"feeds"`
+	Items      FeedItems           `json:"items"`
+	Categories []FeedCategory      `json:"categories"`
+}
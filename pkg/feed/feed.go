@@ -1,26 +1,86 @@
 package feed
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
 	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
 
 	"github.com/mmcdole/gofeed"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultUserAgent sent when fetching a feed unless the Source has its own.
+const DefaultUserAgent = "Gofeed/1.0"
+
+// maxRedirects followed by clients that don't limit them themselves, as
+// many as the http.Client follows by default.
+const maxRedirects = 10
+
 type Source struct {
-	ID            string
-	FeedURL       string
+	ID      string
+	FeedURL string
+	// UserAgent sent when fetching the feed.
+	UserAgent string
+	// Header sent when fetching the feed, replacing the User-Agent when it
+	// has one.
+	Header        http.Header
 	address       *url.URL
 	feedParser    *gofeed.Parser
+	client        *http.Client
 	LastCollected time.Time
 	Feed          *gofeed.Feed
+	// Bytes of the feed document read by the last Collect.
+	Bytes int64
+	// MovedTo is the URL the last Collect was permanently redirected to,
+	// when every redirect it followed was permanent.
+	MovedTo string
+	// Selectors, when set, scrape the items from the web page at FeedURL
+	// rather than parsing it as a feed.
+	Selectors *rsscollector.ScrapeSelectors
+	// Policy, when set, checks the feed may be fetched and limits the
+	// response it is read from. The client must dial with the same policy
+	// for the addresses the feed resolves to to be checked.
+	Policy *fetchpolicy.Policy
+}
+
+// HTTPError is returned by Collect when the feed is served with a status
+// other than 200 OK.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e HTTPError) Error() string {
+	return fmt.Sprintf("http error: %s", e.Status)
+}
+
+// ParseError is returned by Collect when the feed document can't be parsed.
+type ParseError struct {
+	Err error
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("failed to parse feed: %s", e.Err)
+}
+
+func (e ParseError) Unwrap() error {
+	return e.Err
 }
 
-func NewSource(feedURL string) (*Source, error) {
+// NewSource for the feedURL which will be fetched with client. When client is
+// nil the http.DefaultClient is used.
+func NewSource(feedURL string, client *http.Client) (*Source, error) {
 	if !validFeedURL(feedURL) {
 		return nil, fmt.Errorf("invalid feedURL: %s", feedURL)
 	}
@@ -30,10 +90,16 @@ func NewSource(feedURL string) (*Source, error) {
 		return nil, err
 	}
 
+	if client == nil {
+		client = http.DefaultClient
+	}
+
 	return &Source{
 		FeedURL:       address.String(),
 		address:       address,
 		feedParser:    gofeed.NewParser(),
+		client:        client,
+		UserAgent:     DefaultUserAgent,
 		LastCollected: time.Time{},
 	}, nil
 }
@@ -42,19 +108,170 @@ func (s Source) String() string {
 	return s.ID
 }
 
-func (s *Source) Collect() error {
+// Collect fetches and parses the feed, giving up when ctx is done. Feeds
+// served with an unexpected status result in an HTTPError and documents that
+// can't be parsed in a ParseError.
+func (s *Source) Collect(ctx context.Context) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "feed.Fetch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPURLKey.String(s.FeedURL)))
 	defer func() {
 		s.LastCollected = time.Now()
+		span.SetAttributes(attribute.Int64("feed.bytes", s.Bytes))
+		tracing.EndSpan(span, &err)
 	}()
+	s.Bytes = 0
+	s.MovedTo = ""
 
-	collected, err := s.feedParser.ParseURL(s.address.String())
+	if s.Policy != nil {
+		if err := s.Policy.CheckURL(s.address); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.address.String(), nil)
 	if err != nil {
 		return err
 	}
+	req.Header.Set("User-Agent", s.UserAgent)
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+
+	resp, err := s.redirectTracking().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		return HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if s.Policy != nil {
+		if err := s.Policy.CheckResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	if s.Selectors != nil {
+		return s.scrape(ctx, resp)
+	}
+	return s.parse(ctx, resp.Body)
+}
+
+// redirectTracking copies the client to record where the feed was
+// permanently redirected to in MovedTo. A temporary redirect anywhere in the
+// chain, before or after the permanent ones, means the feed hasn't moved.
+func (s *Source) redirectTracking() *http.Client {
+	client := *s.client
+	checkRedirect := client.CheckRedirect
+	permanent := true
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if checkRedirect != nil {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		status := req.Response.StatusCode
+		permanent = permanent && (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect)
+		if permanent {
+			s.MovedTo = req.URL.String()
+		} else {
+			// Permanent redirects ahead of a temporary one are forgotten.
+			s.MovedTo = ""
+		}
+		return nil
+	}
+	return &client
+}
+
+// DeclaredURL is the URL the feed document last collected says the feed
+// has, with the reason, either rsscollector.URLChangeNewFeedURL or
+// rsscollector.URLChangeSelfLink. It is empty when the document doesn't
+// give another http or https URL, or gives an http URL for a feed collected
+// over https.
+func (s *Source) DeclaredURL() (string, string) {
+	if s.Feed == nil || s.Selectors != nil {
+		return "", ""
+	}
+	current := s.address
+	if len(s.MovedTo) > 0 {
+		if moved, err := url.Parse(s.MovedTo); err == nil {
+			current = moved
+		}
+	}
+	if s.Feed.ITunesExt != nil {
+		// The new URL of a podcast is more deliberate than its self link.
+		if declared := resolveDeclared(current, s.Feed.ITunesExt.NewFeedURL); len(declared) > 0 {
+			return declared, rsscollector.URLChangeNewFeedURL
+		}
+	}
+	if declared := resolveDeclared(current, s.Feed.FeedLink); len(declared) > 0 {
+		return declared, rsscollector.URLChangeSelfLink
+	}
+	return "", ""
+}
+
+// resolveDeclared link against the URL the feed was collected from, which
+// it must differ from without dropping https.
+func resolveDeclared(current *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if len(link) == 0 {
+		return ""
+	}
+	resolved, err := current.Parse(link)
+	if err != nil || !validFeedURL(resolved.String()) {
+		return ""
+	}
+	// A link differing only in how it is written is the same feed.
+	if urlnorm.Key(resolved.String()) == urlnorm.Key(current.String()) || (current.Scheme == "https" && resolved.Scheme == "http") {
+		return ""
+	}
+	return resolved.String()
+}
+
+// parse the feed document as it is read from body.
+func (s *Source) parse(ctx context.Context, body io.Reader) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "feed.Parse")
+	defer tracing.EndSpan(span, &err)
+
+	counter := &countingReader{reader: body}
+	collected, err := s.feedParser.Parse(counter)
+	s.Bytes = counter.count
+	if err != nil {
+		// A read cut short by ctx is a failure to fetch, not to parse.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if counter.err != nil {
+			return counter.err
+		}
+		return ParseError{Err: err}
+	}
+	span.SetAttributes(attribute.Int("feed.items", len(collected.Items)))
 	s.Feed = collected
 	return nil
 }
 
+// countingReader counts the bytes read from reader and keeps any read error
+// so that it can be told apart from a parse error.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+	err    error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
 func (s *Source) Items() rsscollector.FeedItems {
 	return itemsFromItems(s.Feed.Items)
 }
@@ -67,20 +284,54 @@ func (s *Source) FeedSource() rsscollector.FeedSource {
 			FeedURL:       s.FeedURL,
 			Title:         s.Feed.Title,
 			LastCollected: s.LastCollected,
+			FeedMetadata:  metadataFromFeed(s.Feed),
+			Scrape:        s.Selectors,
 		},
 		FeedItems: s.Items(),
 	}
 }
 
+// metadataFromFeed takes the details of the feed from the parsed document.
+// Languages are lower cased so they compare equal however the feed gives them.
+func metadataFromFeed(feed *gofeed.Feed) rsscollector.FeedMetadata {
+	metadata := rsscollector.FeedMetadata{
+		Description: strings.TrimSpace(feed.Description),
+		SiteLink:    strings.TrimSpace(feed.Link),
+		Language:    strings.ToLower(strings.TrimSpace(feed.Language)),
+		Image:       ItemImageFromImage(feed.Image),
+		Copyright:   strings.TrimSpace(feed.Copyright),
+		Generator:   strings.TrimSpace(feed.Generator),
+		FeedType:    feed.FeedType,
+		FeedVersion: feed.FeedVersion,
+	}
+	if metadata.Image == nil && feed.ITunesExt != nil && len(feed.ITunesExt.Image) > 0 {
+		metadata.Image = &rsscollector.FeedItemImage{URL: feed.ITunesExt.Image}
+	}
+	// The parser gives a single author, podcasts often only name theirs in
+	// the iTunes extension.
+	author := rsscollector.FeedAuthor{}
+	if feed.Author != nil {
+		author = rsscollector.FeedAuthor{
+			Name:  strings.TrimSpace(feed.Author.Name),
+			Email: strings.TrimSpace(feed.Author.Email),
+		}
+	}
+	if len(author.Name) == 0 && feed.ITunesExt != nil {
+		author.Name = strings.TrimSpace(feed.ITunesExt.Author)
+	}
+	if len(author.Name) > 0 || len(author.Email) > 0 {
+		metadata.Authors = []rsscollector.FeedAuthor{author}
+	}
+	return metadata
+}
+
+// validFeedURL reports whether the URL is an absolute http or https URL.
 func validFeedURL(feedURL string) bool {
-	switch {
-	case len(strings.Replace(feedURL, " ", "", -1)) == 0:
-		return false
-	case !strings.HasPrefix(feedURL, "http"):
+	u, err := url.Parse(feedURL)
+	if err != nil {
 		return false
-	default:
-		return true
 	}
+	return (u.Scheme == "http" || u.Scheme == "https") && len(u.Hostname()) > 0
 }
 
 func itemsFromItems(items []*gofeed.Item) rsscollector.FeedItems {
@@ -98,19 +349,28 @@ func itemFromItem(item gofeed.Item) *rsscollector.FeedItem {
 	if item.Author != nil {
 		author = item.Author.Name
 	}
+	// Links are kept in their canonical form, along with the link the feed
+	// gave when that differs. Links that aren't http are kept as they are.
+	link, originalLink := strings.TrimSpace(item.Link), ""
+	if normalized, err := urlnorm.Normalize(link); err == nil && normalized != link {
+		link, originalLink = normalized, item.Link
+	}
 
 	return &rsscollector.FeedItem{
-		Title:       item.Title,
-		Description: item.Description,
-		Content:     item.Content,
-		Link:        item.Link,
-		Updated:     item.UpdatedParsed,
-		Published:   item.PublishedParsed,
-		Author:      author,
-		GUID:        item.GUID,
-		Image:       ItemImageFromImage(item.Image),
-		Categories:  item.Categories,
-		Custom:      item.Custom,
+		Title:        item.Title,
+		Description:  item.Description,
+		Content:      item.Content,
+		Link:         link,
+		OriginalLink: originalLink,
+		Updated:      item.UpdatedParsed,
+		Published:    item.PublishedParsed,
+		Author:       author,
+		GUID:         item.GUID,
+		Image:        ItemImageFromImage(item.Image),
+		Categories:   item.Categories,
+		Custom:       item.Custom,
+		Enclosures:   enclosuresFromItem(item),
+		Media:        mediaFromItem(item),
 	}
 }
 
@@ -1,18 +1,230 @@
 package feed
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/mmcdole/gofeed"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
 )
 
 func TestCollection(t *testing.T) {
-	source, err := NewSource("http://feeds.bbci.co.uk/news/uk/rss.xml")
+	source, err := NewSource("http://feeds.bbci.co.uk/news/uk/rss.xml", nil)
 	require.Nil(t, err)
 
-	require.Nil(t, source.Collect())
+	require.Nil(t, source.Collect(context.Background()))
 	require.NotNil(t, source.Feed)
 	assert.Equal(t, "BBC News - UK", source.Feed.Title)
 	assert.NotEmpty(t, source.Feed.Items)
 }
+
+func TestFeedMetadata(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Document string
+		Expected rsscollector.FeedMetadata
+	}{
+		{
+			"RSS",
+			`<?xml version="1.0"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+  <title>Example</title>
+  <link>http://example.com/</link>
+  <description> All the news </description>
+  <language>en-GB</language>
+  <copyright>Example Ltd</copyright>
+  <generator>Hugo</generator>
+  <itunes:author>Jo Bloggs</itunes:author>
+  <itunes:image href="http://example.com/cover.jpg"/>
+</channel>
+</rss>`,
+			rsscollector.FeedMetadata{
+				Description: "All the news",
+				SiteLink:    "http://example.com/",
+				Language:    "en-gb",
+				Image:       &rsscollector.FeedItemImage{URL: "http://example.com/cover.jpg"},
+				Authors:     []rsscollector.FeedAuthor{{Name: "Jo Bloggs"}},
+				Copyright:   "Example Ltd",
+				Generator:   "Hugo",
+				FeedType:    "rss",
+				FeedVersion: "2.0",
+			},
+		},
+		{
+			"Atom",
+			`<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="fr">
+  <title>Exemple</title>
+  <subtitle>Les nouvelles</subtitle>
+  <link href="http://example.fr/"/>
+  <logo>http://example.fr/logo.png</logo>
+  <author><name>Jean</name><email>jean@example.fr</email></author>
+  <rights>Exemple SA</rights>
+  <generator>WordPress</generator>
+  <id>urn:example</id>
+  <updated>2021-04-08T09:00:00Z</updated>
+</feed>`,
+			rsscollector.FeedMetadata{
+				Description: "Les nouvelles",
+				SiteLink:    "http://example.fr/",
+				Language:    "fr",
+				Image:       &rsscollector.FeedItemImage{URL: "http://example.fr/logo.png"},
+				Authors:     []rsscollector.FeedAuthor{{Name: "Jean", Email: "jean@example.fr"}},
+				Copyright:   "Exemple SA",
+				Generator:   "WordPress",
+				FeedType:    "atom",
+				FeedVersion: "1.0",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			source, err := NewSource("http://example.com/feed.xml", nil)
+			require.Nil(t, err)
+			require.Nil(t, source.parse(context.Background(), strings.NewReader(tc.Document)))
+			assert.Equal(t, tc.Expected, source.FeedSource().FeedMetadata)
+		})
+	}
+}
+
+func TestMovedTo(t *testing.T) {
+	feed := `<rss version="2.0"><channel><title>Example</title></channel></rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/moved-again", http.StatusMovedPermanently)
+		case "/moved-again":
+			http.Redirect(w, r, "/feed.xml", http.StatusPermanentRedirect)
+		case "/temporary":
+			http.Redirect(w, r, "/moved-again", http.StatusFound)
+		case "/moved-then-temporary":
+			http.Redirect(w, r, "/temporary-after", http.StatusMovedPermanently)
+		case "/temporary-after":
+			http.Redirect(w, r, "/feed.xml", http.StatusFound)
+		case "/feed.xml":
+			_, _ = w.Write([]byte(feed))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		Name     string
+		Path     string
+		Expected string
+	}{
+		{"Not redirected", "/feed.xml", ""},
+		{"Permanently", "/moved", server.URL + "/feed.xml"},
+		{"Temporarily first", "/temporary", ""},
+		{"Temporarily last", "/moved-then-temporary", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			source, err := NewSource(server.URL+tc.Path, server.Client())
+			require.Nil(t, err)
+			require.Nil(t, source.Collect(context.Background()))
+			assert.Equal(t, tc.Expected, source.MovedTo)
+		})
+	}
+}
+
+func TestDeclaredURL(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		FeedURL  string
+		Document string
+		URL      string
+		Reason   string
+	}{
+		{
+			"None",
+			"https://example.com/feed.xml",
+			`<rss version="2.0"><channel><title>Example</title></channel></rss>`,
+			"", "",
+		},
+		{
+			"Self link",
+			"https://example.com/feed.xml",
+			`<feed xmlns="http://www.w3.org/2005/Atom"><link rel="self" href="https://feeds.example.com/atom"/></feed>`,
+			"https://feeds.example.com/atom", rsscollector.URLChangeSelfLink,
+		},
+		{
+			"Relative self link",
+			"https://example.com/feed.xml",
+			`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+<atom:link rel="self" href="/rss"/></channel></rss>`,
+			"https://example.com/rss", rsscollector.URLChangeSelfLink,
+		},
+		{
+			"Self link unchanged",
+			"https://example.com/feed.xml",
+			`<feed xmlns="http://www.w3.org/2005/Atom"><link rel="self" href="https://example.com/feed.xml"/></feed>`,
+			"", "",
+		},
+		{
+			"Self link drops https",
+			"https://example.com/feed.xml",
+			`<feed xmlns="http://www.w3.org/2005/Atom"><link rel="self" href="http://example.com/atom"/></feed>`,
+			"", "",
+		},
+		{
+			"New feed URL before self link",
+			"http://example.com/podcast.xml",
+			`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+<atom:link rel="self" href="http://example.com/podcast.xml"/>
+<itunes:new-feed-url>https://podcasts.example.com/show.xml</itunes:new-feed-url></channel></rss>`,
+			"https://podcasts.example.com/show.xml", rsscollector.URLChangeNewFeedURL,
+		},
+		{
+			"Not http",
+			"https://example.com/feed.xml",
+			`<feed xmlns="http://www.w3.org/2005/Atom"><link rel="self" href="ftp://example.com/atom"/></feed>`,
+			"", "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			source, err := NewSource(tc.FeedURL, nil)
+			require.Nil(t, err)
+			require.Nil(t, source.parse(context.Background(), strings.NewReader(tc.Document)))
+			declared, reason := source.DeclaredURL()
+			assert.Equal(t, tc.URL, declared)
+			assert.Equal(t, tc.Reason, reason)
+		})
+	}
+}
+
+func TestItemLinks(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Link     string
+		Expected string
+		Original string
+	}{
+		{"Canonical", "https://example.com/story", "https://example.com/story", ""},
+		{"Tracking", "HTTPS://Example.com/story?utm_source=rss&id=1", "https://example.com/story?id=1", "HTTPS://Example.com/story?utm_source=rss&id=1"},
+		{"Not http", "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a", ""},
+		{"None", "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			item := itemFromItem(gofeed.Item{Link: tc.Link, GUID: tc.Link})
+			assert.Equal(t, tc.Expected, item.Link)
+			assert.Equal(t, tc.Original, item.OriginalLink)
+			// Items are still matched by the GUID the feed gave.
+			assert.Equal(t, tc.Link, item.GUID)
+		})
+	}
+}
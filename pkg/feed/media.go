@@ -0,0 +1,166 @@
+package feed
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// enclosuresFromItem takes the RSS enclosures and Atom enclosure links of the
+// item, which gofeed provides as Enclosures, followed by any Media RSS content
+// not already among them.
+func enclosuresFromItem(item gofeed.Item) []rsscollector.FeedItemEnclosure {
+	var enclosures []rsscollector.FeedItemEnclosure
+	seen := make(map[string]struct{})
+	add := func(enclosure rsscollector.FeedItemEnclosure) {
+		if len(enclosure.URL) == 0 {
+			return
+		}
+		if _, ok := seen[enclosure.URL]; ok {
+			return
+		}
+		seen[enclosure.URL] = struct{}{}
+		enclosures = append(enclosures, enclosure)
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if enclosure == nil {
+			continue
+		}
+		length, _ := strconv.ParseInt(strings.TrimSpace(enclosure.Length), 10, 64)
+		add(rsscollector.FeedItemEnclosure{
+			URL:    strings.TrimSpace(enclosure.URL),
+			Type:   strings.TrimSpace(enclosure.Type),
+			Length: length,
+		})
+	}
+	for _, content := range mediaElements(item.Extensions, "content") {
+		length, _ := strconv.ParseInt(content.Attrs["fileSize"], 10, 64)
+		add(rsscollector.FeedItemEnclosure{
+			URL:    strings.TrimSpace(content.Attrs["url"]),
+			Type:   mediaContentType(content),
+			Length: length,
+		})
+	}
+	return enclosures
+}
+
+// mediaContentType is the type of a Media RSS content element, falling back
+// to its medium, e.g. audio, when it has no type.
+func mediaContentType(content ext.Extension) string {
+	if contentType := strings.TrimSpace(content.Attrs["type"]); len(contentType) > 0 {
+		return contentType
+	}
+	return strings.TrimSpace(content.Attrs["medium"])
+}
+
+// mediaFromItem takes the details of a podcast episode or other media from the
+// iTunes extension, falling back to the Media RSS extension. It is nil when
+// the item has neither.
+func mediaFromItem(item gofeed.Item) *rsscollector.FeedItemMedia {
+	media := rsscollector.FeedItemMedia{}
+	if itunes := item.ITunesExt; itunes != nil {
+		media.Duration = parseDuration(itunes.Duration)
+		media.Episode, _ = strconv.Atoi(strings.TrimSpace(itunes.Episode))
+		media.Season, _ = strconv.Atoi(strings.TrimSpace(itunes.Season))
+		media.EpisodeType = strings.TrimSpace(itunes.EpisodeType)
+		media.Explicit = parseExplicit(itunes.Explicit)
+		if image := strings.TrimSpace(itunes.Image); len(image) > 0 {
+			media.Thumbnails = append(media.Thumbnails, rsscollector.FeedItemMediaThumbnail{URL: image})
+		}
+	}
+
+	contents := mediaElements(item.Extensions, "content")
+	if media.Duration == 0 {
+		for _, content := range contents {
+			if duration := parseDuration(content.Attrs["duration"]); duration > 0 {
+				media.Duration = duration
+				break
+			}
+		}
+	}
+	if !media.Explicit {
+		for _, rating := range mediaElements(item.Extensions, "rating") {
+			if strings.EqualFold(strings.TrimSpace(rating.Value), "adult") {
+				media.Explicit = true
+			}
+		}
+	}
+
+	thumbnails := mediaElements(item.Extensions, "thumbnail")
+	for _, content := range contents {
+		thumbnails = append(thumbnails, content.Children["thumbnail"]...)
+	}
+	for _, thumbnail := range thumbnails {
+		url := strings.TrimSpace(thumbnail.Attrs["url"])
+		if len(url) == 0 {
+			continue
+		}
+		width, _ := strconv.Atoi(thumbnail.Attrs["width"])
+		height, _ := strconv.Atoi(thumbnail.Attrs["height"])
+		media.Thumbnails = append(media.Thumbnails,
+			rsscollector.FeedItemMediaThumbnail{URL: url, Width: width, Height: height})
+	}
+
+	if media.Duration == 0 && media.Episode == 0 && media.Season == 0 && len(media.EpisodeType) == 0 &&
+		!media.Explicit && len(media.Thumbnails) == 0 {
+		return nil
+	}
+	return &media
+}
+
+// mediaElements finds the Media RSS elements with name on the item, both on
+// their own and within media:group elements.
+func mediaElements(extensions ext.Extensions, name string) []ext.Extension {
+	media, ok := extensions["media"]
+	if !ok {
+		return nil
+	}
+	elements := append([]ext.Extension{}, media[name]...)
+	for _, group := range media["group"] {
+		elements = append(elements, group.Children[name]...)
+	}
+	return elements
+}
+
+// parseDuration of an iTunes duration in seconds, which is given either as a
+// number of seconds or as [[HH:]MM:]SS, possibly with a fraction of a second.
+// Durations that can't be parsed are zero.
+func parseDuration(duration string) int {
+	duration = strings.TrimSpace(duration)
+	if len(duration) == 0 {
+		return 0
+	}
+	parts := strings.Split(duration, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+	seconds := 0
+	for i, part := range parts {
+		// Only the seconds may have a fraction, which is dropped.
+		if i == len(parts)-1 {
+			part = strings.SplitN(part, ".", 2)[0]
+		}
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return 0
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds
+}
+
+// parseExplicit reports whether an iTunes explicit value marks the item as
+// explicit. Feeds use yes, true and explicit.
+func parseExplicit(explicit string) bool {
+	switch strings.ToLower(strings.TrimSpace(explicit)) {
+	case "yes", "true", "explicit":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package feed
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+const testPodcast = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"
+  xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+  <title>Example Podcast</title>
+  <item>
+    <title>Episode 3</title>
+    <guid>episode-3</guid>
+    <enclosure url="http://example.com/3.mp3" length="12345678" type="audio/mpeg"/>
+    <itunes:duration>1:02:03</itunes:duration>
+    <itunes:episode>3</itunes:episode>
+    <itunes:season>2</itunes:season>
+    <itunes:episodeType>full</itunes:episodeType>
+    <itunes:explicit>yes</itunes:explicit>
+    <itunes:image href="http://example.com/3.jpg"/>
+  </item>
+  <item>
+    <title>Video</title>
+    <guid>video</guid>
+    <media:group>
+      <media:content url="http://example.com/video.mp4" type="video/mp4" fileSize="100" duration="90"/>
+      <media:content url="http://example.com/video.webm" medium="video"/>
+      <media:thumbnail url="http://example.com/video.jpg" width="320" height="180"/>
+    </media:group>
+  </item>
+  <item>
+    <title>Article</title>
+    <guid>article</guid>
+  </item>
+</channel>
+</rss>`
+
+func TestEnclosuresAndMedia(t *testing.T) {
+	source, err := NewSource("http://example.com/podcast.xml", nil)
+	require.Nil(t, err)
+	require.Nil(t, source.parse(context.Background(), strings.NewReader(testPodcast)))
+	items := source.Items()
+	require.Len(t, items, 3)
+
+	episode := items[0]
+	assert.Equal(t, []rsscollector.FeedItemEnclosure{
+		{URL: "http://example.com/3.mp3", Type: "audio/mpeg", Length: 12345678},
+	}, episode.Enclosures)
+	assert.Equal(t, &rsscollector.FeedItemMedia{
+		Duration:    3723,
+		Episode:     3,
+		Season:      2,
+		EpisodeType: "full",
+		Explicit:    true,
+		Thumbnails:  []rsscollector.FeedItemMediaThumbnail{{URL: "http://example.com/3.jpg"}},
+	}, episode.Media)
+	assert.True(t, episode.HasEnclosure("audio"))
+	assert.True(t, episode.HasEnclosure("Audio/MPEG"))
+	assert.True(t, episode.HasEnclosure(rsscollector.AnyEnclosure))
+	assert.False(t, episode.HasEnclosure("video"))
+
+	video := items[1]
+	assert.Equal(t, []rsscollector.FeedItemEnclosure{
+		{URL: "http://example.com/video.mp4", Type: "video/mp4", Length: 100},
+		{URL: "http://example.com/video.webm", Type: "video"},
+	}, video.Enclosures)
+	assert.Equal(t, &rsscollector.FeedItemMedia{
+		Duration:   90,
+		Thumbnails: []rsscollector.FeedItemMediaThumbnail{{URL: "http://example.com/video.jpg", Width: 320, Height: 180}},
+	}, video.Media)
+	assert.True(t, video.HasEnclosure("video"))
+
+	article := items[2]
+	assert.Nil(t, article.Enclosures)
+	assert.Nil(t, article.Media)
+	assert.False(t, article.HasEnclosure(rsscollector.AnyEnclosure))
+}
+
+func TestParseDuration(t *testing.T) {
+	testCases := map[string]int{
+		"":         0,
+		"95":       95,
+		"1:35":     95,
+		"01:01:35": 3695,
+		"95.5":     95,
+		"1:2:3:4":  0,
+		"soon":     0,
+		"-5":       0,
+	}
+	for duration, expected := range testCases {
+		assert.Equal(t, expected, parseDuration(duration), duration)
+	}
+}
@@ -0,0 +1,218 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/mmcdole/gofeed"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/html/charset"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+)
+
+// ScrapedFeedType is the FeedType of sources scraped from a web page.
+const ScrapedFeedType = "html"
+
+// ErrNoItems is returned, wrapped in a ParseError, when the item selector of
+// a scraped source matches nothing on the page.
+var ErrNoItems = errors.New("the item selector matched nothing on the page")
+
+// dateLayouts tried in turn for the dates of scraped items when the
+// selectors don't give one.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Monday, January 2, 2006",
+	"Monday 2 January 2006",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// ValidateSelectors reports the first of the selectors that isn't valid CSS.
+// The item selector is required, the others are optional.
+func ValidateSelectors(selectors rsscollector.ScrapeSelectors) error {
+	if len(strings.TrimSpace(selectors.Item)) == 0 {
+		return errors.New("an item selector is required")
+	}
+	for _, selector := range []struct {
+		name  string
+		value string
+	}{
+		{"item", selectors.Item},
+		{"title", selectors.Title},
+		{"link", selectors.Link},
+		{"date", selectors.Date},
+		{"summary", selectors.Summary},
+	} {
+		if len(selector.value) == 0 {
+			continue
+		}
+		if _, err := cascadia.Compile(selector.value); err != nil {
+			return fmt.Errorf("invalid %s selector %q: %w", selector.name, selector.value, err)
+		}
+	}
+	return nil
+}
+
+// scrape the items from the web page as it is read from resp, in place of a
+// feed document.
+func (s *Source) scrape(ctx context.Context, resp *http.Response) (err error) {
+	_, span := tracing.Tracer().Start(ctx, "feed.Scrape")
+	defer tracing.EndSpan(span, &err)
+
+	if err := ValidateSelectors(*s.Selectors); err != nil {
+		return ParseError{Err: err}
+	}
+
+	counter := &countingReader{reader: resp.Body}
+	// Pages not in UTF-8 say so in their header or a meta element.
+	body, err := charset.NewReader(counter, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ParseError{Err: err}
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	s.Bytes = counter.count
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if counter.err != nil {
+			return counter.err
+		}
+		return ParseError{Err: err}
+	}
+
+	// Links are relative to the page the request ended up on after any
+	// redirects.
+	collected := scrapeFeed(doc, resp.Request.URL, *s.Selectors)
+	if len(collected.Items) == 0 {
+		return ParseError{Err: ErrNoItems}
+	}
+	span.SetAttributes(attribute.Int("feed.items", len(collected.Items)))
+	s.Feed = collected
+	return nil
+}
+
+// scrapeFeed builds a feed from the page, with an item for each element the
+// item selector matches that has a title or a link.
+func scrapeFeed(doc *goquery.Document, page *url.URL, selectors rsscollector.ScrapeSelectors) *gofeed.Feed {
+	description, _ := doc.Find(`meta[name="description"]`).First().Attr("content")
+	language, _ := doc.Find("html").First().Attr("lang")
+	collected := &gofeed.Feed{
+		Title:       collapseSpace(doc.Find("title").First().Text()),
+		Description: description,
+		Link:        page.String(),
+		Language:    language,
+		FeedType:    ScrapedFeedType,
+	}
+
+	doc.Find(selectors.Item).Each(func(_ int, container *goquery.Selection) {
+		item := scrapeItem(container, page, selectors)
+		if len(item.Title) > 0 || len(item.Link) > 0 {
+			collected.Items = append(collected.Items, item)
+		}
+	})
+	return collected
+}
+
+// scrapeItem takes the details of an item from the element holding it.
+func scrapeItem(container *goquery.Selection, page *url.URL, selectors rsscollector.ScrapeSelectors) *gofeed.Item {
+	item := &gofeed.Item{}
+
+	link := linkElement(container, selectors.Link)
+	if href, ok := link.Attr("href"); ok {
+		if resolved, err := page.Parse(strings.TrimSpace(href)); err == nil {
+			item.Link = resolved.String()
+		}
+	}
+
+	if len(selectors.Title) > 0 {
+		item.Title = collapseSpace(container.Find(selectors.Title).First().Text())
+	} else {
+		item.Title = collapseSpace(link.Text())
+	}
+
+	if len(selectors.Date) > 0 {
+		date := container.Find(selectors.Date).First()
+		value, ok := date.Attr("datetime")
+		if !ok {
+			value, ok = date.Attr("content")
+		}
+		if !ok {
+			value = date.Text()
+		}
+		item.Published = collapseSpace(value)
+		item.PublishedParsed = parseDate(item.Published, selectors.DateLayout)
+	}
+
+	if len(selectors.Summary) > 0 {
+		if summary, err := container.Find(selectors.Summary).First().Html(); err == nil {
+			item.Description = strings.TrimSpace(summary)
+		}
+	}
+
+	// Pages give items no ID of their own, the link is the best there is.
+	item.GUID = item.Link
+	if len(item.GUID) == 0 {
+		item.GUID = item.Title
+	}
+	return item
+}
+
+// linkElement of the item, the first element the selector matches or the
+// first link with an href within it. Without a selector the item itself is
+// taken when it is a link.
+func linkElement(container *goquery.Selection, selector string) *goquery.Selection {
+	link := container
+	if len(selector) > 0 {
+		link = container.Find(selector).First()
+	}
+	if _, ok := link.Attr("href"); ok {
+		return link
+	}
+	return link.Find("a[href]").First()
+}
+
+// parseDate with the layout when there is one, otherwise the first of the
+// dateLayouts that fits. Dates that can't be parsed are left out.
+func parseDate(value, layout string) *time.Time {
+	if len(value) == 0 {
+		return nil
+	}
+	layouts := dateLayouts
+	if len(layout) > 0 {
+		layouts = []string{layout}
+	}
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			parsed = parsed.UTC()
+			return &parsed
+		}
+	}
+	return nil
+}
+
+// collapseSpace trims the text and replaces each run of whitespace within it
+// with a single space.
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,148 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+const testPage = `<!DOCTYPE html>
+<html lang="en-GB">
+<head>
+<title> Example   News </title>
+<meta name="description" content="The latest news">
+</head>
+<body>
+<ul class="stories">
+  <li class="story">
+    <h2><a href="/news/first.html">First   story</a></h2>
+    <time datetime="2021-04-11T09:00:00+01:00">Sunday</time>
+    <p class="standfirst">The <b>first</b> story.</p>
+  </li>
+  <li class="story">
+    <h2>Second story</h2>
+    <a class="more" href="second.html">Read more</a>
+    <span class="date">11 April 2021</span>
+  </li>
+  <li class="story"><span class="date">Undated</span></li>
+</ul>
+</body>
+</html>`
+
+func TestScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer server.Close()
+
+	source, err := NewSource(server.URL+"/news/", server.Client())
+	require.Nil(t, err)
+	source.Selectors = &rsscollector.ScrapeSelectors{
+		Item:    "li.story",
+		Title:   "h2",
+		Link:    "h2 a, a.more",
+		Date:    "time, .date",
+		Summary: ".standfirst",
+	}
+	require.Nil(t, source.Collect(context.Background()))
+	assert.Greater(t, source.Bytes, int64(0))
+
+	collected := source.FeedSource()
+	assert.Equal(t, "Example News", collected.Title)
+	assert.Equal(t, source.Selectors, collected.Scrape)
+	assert.Equal(t, rsscollector.FeedMetadata{
+		Description: "The latest news",
+		SiteLink:    server.URL + "/news/",
+		Language:    "en-gb",
+		FeedType:    ScrapedFeedType,
+	}, collected.FeedMetadata)
+
+	// The item without a title or link is left out.
+	require.Len(t, collected.FeedItems, 2)
+	first, second := collected.FeedItems[0], collected.FeedItems[1]
+	assert.Equal(t, "First story", first.Title)
+	assert.Equal(t, server.URL+"/news/first.html", first.Link)
+	assert.Equal(t, first.Link, first.GUID)
+	require.NotNil(t, first.Published)
+	assert.Equal(t, time.Date(2021, 4, 11, 8, 0, 0, 0, time.UTC), *first.Published)
+	assert.Equal(t, "The <b>first</b> story.", first.Description)
+
+	assert.Equal(t, "Second story", second.Title)
+	assert.Equal(t, server.URL+"/news/second.html", second.Link)
+	require.NotNil(t, second.Published)
+	assert.Equal(t, time.Date(2021, 4, 11, 0, 0, 0, 0, time.UTC), *second.Published)
+	assert.Empty(t, second.Description)
+}
+
+func TestScrapeDefaults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><body>
+<a class="story" href="/one">One</a>
+<div class="story"><a name="top"></a><a href="/two"> Two </a><i>11/04/2021</i></div>
+</body></html>`))
+	}))
+	defer server.Close()
+
+	source, err := NewSource(server.URL, server.Client())
+	require.Nil(t, err)
+	source.Selectors = &rsscollector.ScrapeSelectors{Item: ".story", Date: "i", DateLayout: "02/01/2006"}
+	require.Nil(t, source.Collect(context.Background()))
+
+	items := source.FeedSource().FeedItems
+	require.Len(t, items, 2)
+	// Without title or link selectors the item's own link or its first link
+	// with an href is used for both.
+	assert.Equal(t, "One", items[0].Title)
+	assert.Equal(t, server.URL+"/one", items[0].Link)
+	assert.Nil(t, items[0].Published)
+	assert.Equal(t, "Two", items[1].Title)
+	assert.Equal(t, server.URL+"/two", items[1].Link)
+	require.NotNil(t, items[1].Published)
+	assert.Equal(t, time.Date(2021, 4, 11, 0, 0, 0, 0, time.UTC), *items[1].Published)
+}
+
+func TestScrapeNoItems(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testPage))
+	}))
+	defer server.Close()
+
+	source, err := NewSource(server.URL, server.Client())
+	require.Nil(t, err)
+	source.Selectors = &rsscollector.ScrapeSelectors{Item: "article"}
+	err = source.Collect(context.Background())
+	var parseErr ParseError
+	require.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, ErrNoItems, parseErr.Err)
+}
+
+func TestValidateSelectors(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Selectors rsscollector.ScrapeSelectors
+		Valid     bool
+	}{
+		{"Item only", rsscollector.ScrapeSelectors{Item: "article"}, true},
+		{"All", rsscollector.ScrapeSelectors{Item: "li.story", Title: "h2", Link: "a", Date: "time", Summary: "p"}, true},
+		{"No item", rsscollector.ScrapeSelectors{Title: "h2"}, false},
+		{"Blank item", rsscollector.ScrapeSelectors{Item: "  "}, false},
+		{"Invalid item", rsscollector.ScrapeSelectors{Item: "li["}, false},
+		{"Invalid summary", rsscollector.ScrapeSelectors{Item: "li", Summary: "p:nope"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateSelectors(tc.Selectors)
+			assert.Equal(t, tc.Valid, err == nil, err)
+		})
+	}
+}
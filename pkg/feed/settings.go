@@ -0,0 +1,174 @@
+package feed
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// proxySchemes are those the http.Transport can send requests through.
+var proxySchemes = map[string]struct{}{
+	"http":   {},
+	"https":  {},
+	"socks5": {},
+}
+
+// Configure the requests made for the feed with the settings, whose secrets
+// must already be decrypted. Proxy and TLS settings give the source a client
+// of its own, copied from the one it was created with.
+func (s *Source) Configure(settings rsscollector.FetchSettings) error {
+	header, client, err := applySettings(settings, s.client)
+	if err != nil {
+		return err
+	}
+	if len(settings.UserAgent) > 0 {
+		s.UserAgent = settings.UserAgent
+	}
+	s.Header = header
+	s.client = client
+	return nil
+}
+
+// ValidateFetchSettings reports the first problem with the settings, whose
+// secrets must not be encrypted.
+func ValidateFetchSettings(settings rsscollector.FetchSettings) error {
+	_, _, err := applySettings(settings, http.DefaultClient)
+	return err
+}
+
+// applySettings returns the headers to send with each request and the client
+// to send them with.
+func applySettings(settings rsscollector.FetchSettings, client *http.Client) (http.Header, *http.Client, error) {
+	header := make(http.Header)
+	switch {
+	case len(settings.BearerToken) > 0:
+		header.Set("Authorization", "Bearer "+settings.BearerToken)
+	case len(settings.Username) > 0 || len(settings.Password) > 0:
+		if strings.Contains(settings.Username, ":") {
+			return nil, nil, errors.New("username must not contain a colon")
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(settings.Username + ":" + settings.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	if len(settings.Cookies) > 0 {
+		cookies := make([]string, 0, len(settings.Cookies))
+		for _, name := range sortedKeys(settings.Cookies) {
+			cookie := &http.Cookie{Name: name, Value: settings.Cookies[name]}
+			// Invalid cookies are rendered empty.
+			if len(name) == 0 || len(cookie.String()) == 0 {
+				return nil, nil, fmt.Errorf("invalid cookie %q", name)
+			}
+			cookies = append(cookies, cookie.String())
+		}
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	// Headers are applied last so they can replace any of the above.
+	for _, name := range sortedKeys(settings.Headers) {
+		value := settings.Headers[name]
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, nil, fmt.Errorf("invalid value for header %s", name)
+		}
+		header.Set(name, value)
+	}
+
+	if len(settings.UserAgent) > 0 && !httpguts.ValidHeaderFieldValue(settings.UserAgent) {
+		return nil, nil, errors.New("invalid user agent")
+	}
+
+	if len(settings.ProxyURL) == 0 && settings.TLS == nil {
+		return header, client, nil
+	}
+	transport, err := cloneTransport(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(settings.ProxyURL) > 0 {
+		proxy, err := url.Parse(settings.ProxyURL)
+		if err != nil {
+			// The URL may hold a password, which the error would repeat.
+			return nil, nil, errors.New("invalid proxy URL")
+		}
+		if _, ok := proxySchemes[proxy.Scheme]; !ok || len(proxy.Host) == 0 {
+			return nil, nil, fmt.Errorf("proxy URL must be http, https or socks5 with a host: %s", proxy.Redacted())
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if settings.TLS != nil {
+		tlsConfig, err := tlsConfig(*settings.TLS, transport.TLSClientConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	configured := *client
+	configured.Transport = transport
+	return header, &configured, nil
+}
+
+// cloneTransport of the client so its settings can be changed for one feed.
+func cloneTransport(client *http.Client) (*http.Transport, error) {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("proxy and TLS settings can't be applied to a %T", base)
+	}
+	return transport.Clone(), nil
+}
+
+// tlsConfig applying the settings to a copy of base, which may be nil.
+func tlsConfig(settings rsscollector.FetchTLSSettings, base *tls.Config) (*tls.Config, error) {
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	config.InsecureSkipVerify = settings.InsecureSkipVerify
+	if len(settings.ServerName) > 0 {
+		config.ServerName = settings.ServerName
+	}
+	if len(settings.CACertificate) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(settings.CACertificate)) {
+			return nil, errors.New("CA certificate is not a PEM encoded certificate")
+		}
+		config.RootCAs = pool
+	}
+	if len(settings.ClientCertificate) > 0 || len(settings.ClientKey) > 0 {
+		certificate, err := tls.X509KeyPair([]byte(settings.ClientCertificate), []byte(settings.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate or key: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+const testFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Private</title>
+<item><title>First</title><link>http://example.com/first</link></item>
+</channel></rss>`
+
+func TestConfigure(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Settings rsscollector.FetchSettings
+		Check    func(t *testing.T, r *http.Request)
+	}{
+		{
+			"Basic auth",
+			rsscollector.FetchSettings{Username: "reader", Password: "secret"},
+			func(t *testing.T, r *http.Request) {
+				username, password, ok := r.BasicAuth()
+				assert.True(t, ok)
+				assert.Equal(t, "reader", username)
+				assert.Equal(t, "secret", password)
+				assert.Equal(t, "test-agent", r.UserAgent())
+			},
+		},
+		{
+			"Bearer token",
+			rsscollector.FetchSettings{Username: "reader", BearerToken: "token"},
+			func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			},
+		},
+		{
+			"Headers, cookies and user agent",
+			rsscollector.FetchSettings{
+				Headers:   map[string]string{"X-Api-Key": "key", "Accept-Language": "en-GB"},
+				Cookies:   map[string]string{"session": "token", "consent": "yes"},
+				UserAgent: "feed-agent",
+			},
+			func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "key", r.Header.Get("X-Api-Key"))
+				assert.Equal(t, "en-GB", r.Header.Get("Accept-Language"))
+				assert.Equal(t, "consent=yes; session=token", r.Header.Get("Cookie"))
+				assert.Equal(t, "feed-agent", r.UserAgent())
+				assert.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.Check(t, r)
+				_, _ = w.Write([]byte(testFeed))
+			}))
+			defer server.Close()
+
+			source, err := NewSource(server.URL, server.Client())
+			require.Nil(t, err)
+			source.UserAgent = "test-agent"
+			require.Nil(t, source.Configure(tc.Settings))
+			require.Nil(t, source.Collect(context.Background()))
+			assert.Equal(t, "Private", source.FeedSource().Title)
+		})
+	}
+}
+
+func TestConfigureProxy(t *testing.T) {
+	proxied := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests through a proxy give the whole URL.
+		proxied <- r.URL.String()
+		_, _ = w.Write([]byte(testFeed))
+	}))
+	defer proxy.Close()
+
+	source, err := NewSource("http://feeds.internal/feed.xml", http.DefaultClient)
+	require.Nil(t, err)
+	require.Nil(t, source.Configure(rsscollector.FetchSettings{ProxyURL: proxy.URL}))
+	require.Nil(t, source.Collect(context.Background()))
+	assert.Equal(t, "http://feeds.internal/feed.xml", <-proxied)
+	// The client the source was created with is left as it was.
+	assert.Nil(t, http.DefaultClient.Transport)
+}
+
+func TestValidateFetchSettings(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Settings rsscollector.FetchSettings
+		Valid    bool
+	}{
+		{"Empty", rsscollector.FetchSettings{}, true},
+		{"Basic auth", rsscollector.FetchSettings{Username: "reader", Password: "secret"}, true},
+		{"Colon in username", rsscollector.FetchSettings{Username: "read:er", Password: "secret"}, false},
+		{"Invalid header name", rsscollector.FetchSettings{Headers: map[string]string{"X Key": "key"}}, false},
+		{"Invalid header value", rsscollector.FetchSettings{Headers: map[string]string{"X-Key": "a\nb"}}, false},
+		{"Invalid cookie", rsscollector.FetchSettings{Cookies: map[string]string{"a b": "c"}}, false},
+		{"Invalid user agent", rsscollector.FetchSettings{UserAgent: "agent\r\n"}, false},
+		{"Socks proxy", rsscollector.FetchSettings{ProxyURL: "socks5://proxy.internal:1080"}, true},
+		{"Unsupported proxy", rsscollector.FetchSettings{ProxyURL: "ftp://proxy.internal"}, false},
+		{"Proxy without host", rsscollector.FetchSettings{ProxyURL: "http://"}, false},
+		{"Server name", rsscollector.FetchSettings{TLS: &rsscollector.FetchTLSSettings{ServerName: "feeds.internal"}}, true},
+		{"Invalid CA", rsscollector.FetchSettings{TLS: &rsscollector.FetchTLSSettings{CACertificate: "nope"}}, false},
+		{"Key without certificate", rsscollector.FetchSettings{TLS: &rsscollector.FetchTLSSettings{ClientKey: "nope"}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateFetchSettings(tc.Settings)
+			assert.Equal(t, tc.Valid, err == nil, err)
+		})
+	}
+}
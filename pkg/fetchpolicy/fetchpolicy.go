@@ -0,0 +1,332 @@
+// Package fetchpolicy guards the requests made for URLs users and feeds
+// supply, so the server can't be made to fetch from itself or the private
+// networks it runs in.
+//
+// Addresses are checked when they are dialled, after the host has been
+// resolved, so every redirect and every address a name resolves to is
+// checked, however the URL was written. Loopback, link-local, private,
+// shared, reserved and cloud metadata addresses are blocked unless they are
+// allowed.
+package fetchpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultContentTypes feeds and the pages scraped in place of them may be
+// served as. Feeds are often served with a generic type, and sometimes none.
+var DefaultContentTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/rdf+xml",
+	"application/xml",
+	"text/xml",
+	"application/feed+json",
+	"application/json",
+	"text/html",
+	"application/xhtml+xml",
+	"text/plain",
+	"application/octet-stream",
+}
+
+// blockedNetworks can't be fetched from unless they are allowed.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // this network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // shared, carrier grade NAT
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local, including cloud metadata services
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, including broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"64:ff9b::/96",    // IPv4 translation, which could reach any of the above
+	"100::/64",        // discard
+	"2001:db8::/32",   // documentation
+	"fc00::/7",        // unique local, including fd00:ec2::254 metadata
+	"fe80::/10",       // link-local
+	"ff00::/8",        // multicast
+)
+
+// ErrTooLarge is returned reading a response body longer than MaxBytes.
+var ErrTooLarge = errors.New("response is larger than the maximum allowed")
+
+// BlockedError is returned when a URL or the address it resolves to may not
+// be fetched.
+type BlockedError struct {
+	// Host that was to be fetched.
+	Host string
+	// Reason it may not be.
+	Reason string
+}
+
+func (e BlockedError) Error() string {
+	return fmt.Sprintf("fetching %s is not allowed: %s", e.Host, e.Reason)
+}
+
+// ContentTypeError is returned when a response is served as a content type
+// that isn't allowed.
+type ContentTypeError struct {
+	ContentType string
+}
+
+func (e ContentTypeError) Error() string {
+	return fmt.Sprintf("content type %q is not allowed", e.ContentType)
+}
+
+// Config of a Policy.
+type Config struct {
+	// Allow lists the networks, as CIDRs or single IPs, and host names that
+	// may be fetched from though they would otherwise be blocked. Proxies
+	// feeds are fetched through must be allowed when they are in a blocked
+	// network.
+	Allow []string
+	// MaxRedirects followed for a single request, none when zero.
+	MaxRedirects int
+	// MaxBytes of a response body, unlimited when zero.
+	MaxBytes int64
+	// ContentTypes responses may be served as, DefaultContentTypes when
+	// empty. A type given as type/* allows any of its subtypes. Responses
+	// without a content type are always allowed.
+	ContentTypes []string
+}
+
+// Policy of what may be fetched.
+type Policy struct {
+	allowedNetworks []*net.IPNet
+	allowedHosts    map[string]struct{}
+	maxRedirects    int
+	maxBytes        int64
+	contentTypes    []string
+	dialer          *net.Dialer
+}
+
+// New Policy from the config, which fails when an entry of Allow is neither
+// a network nor a host name.
+func New(config Config) (*Policy, error) {
+	p := &Policy{
+		allowedHosts: make(map[string]struct{}),
+		maxRedirects: config.MaxRedirects,
+		maxBytes:     config.MaxBytes,
+		contentTypes: config.ContentTypes,
+	}
+	if len(p.contentTypes) == 0 {
+		p.contentTypes = DefaultContentTypes
+	}
+	for _, entry := range config.Allow {
+		entry = strings.TrimSpace(entry)
+		if network, err := parseNetwork(entry); err == nil {
+			p.allowedNetworks = append(p.allowedNetworks, network)
+			continue
+		}
+		host := normaliseHost(entry)
+		if len(host) == 0 || strings.ContainsAny(host, "/:") {
+			return nil, fmt.Errorf("allowed entry %q is neither a network nor a host name", entry)
+		}
+		p.allowedHosts[host] = struct{}{}
+	}
+	p.dialer = &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+	return p, nil
+}
+
+// CheckURL reports why the URL may not be fetched, if it can already tell
+// without resolving its host. Only http and https URLs may be fetched.
+func (p *Policy) CheckURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return BlockedError{Host: u.Host, Reason: "only http and https URLs can be fetched"}
+	}
+	host := normaliseHost(u.Hostname())
+	if len(host) == 0 {
+		return BlockedError{Host: u.Host, Reason: "the URL has no host"}
+	}
+	if p.hostAllowed(host) {
+		return nil
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return BlockedError{Host: host, Reason: "it is the local machine"}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(host, ip)
+	}
+	return nil
+}
+
+// Client making requests with the policy, which gives up after timeout
+// unless it is zero. Its Transport is an *http.Transport so that it can be
+// cloned and configured for individual feeds.
+//
+// The proxies given by the HTTP_PROXY and HTTPS_PROXY envvars aren't used,
+// only the address of the proxy would be checked and it could then fetch
+// from any internal address. Feeds are fetched through a proxy only when
+// their own settings name one.
+func (p *Policy) Client(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = p.dialContext
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: p.checkRedirect,
+	}
+}
+
+// CheckResponse reports why the response may not be read, and limits its
+// body to MaxBytes.
+func (p *Policy) CheckResponse(resp *http.Response) error {
+	if contentType := resp.Header.Get("Content-Type"); len(contentType) > 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !p.contentTypeAllowed(mediaType) {
+			return ContentTypeError{ContentType: contentType}
+		}
+	}
+	if p.maxBytes > 0 {
+		if resp.ContentLength > p.maxBytes {
+			return ErrTooLarge
+		}
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: p.maxBytes}
+	}
+	return nil
+}
+
+// dialContext dials hosts that are allowed by name directly, and all others
+// through a dialer that checks each address before connecting to it.
+func (p *Policy) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if p.hostAllowed(normaliseHost(host)) {
+		dialer := *p.dialer
+		dialer.Control = nil
+		return dialer.DialContext(ctx, network, address)
+	}
+	return p.dialer.DialContext(ctx, network, address)
+}
+
+// control is called with each address resolved for a host before it is
+// connected to.
+func (p *Policy) control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return BlockedError{Host: host, Reason: "the address can't be checked"}
+	}
+	return p.checkIP(host, ip)
+}
+
+func (p *Policy) checkRedirect(req *http.Request, via []*http.Request) error {
+	if p.maxRedirects <= 0 {
+		return errors.New("redirects are not followed")
+	}
+	// The first request is not a redirect.
+	if len(via) > p.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", p.maxRedirects)
+	}
+	return p.CheckURL(req.URL)
+}
+
+func (p *Policy) checkIP(host string, ip net.IP) error {
+	for _, network := range p.allowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return BlockedError{Host: host, Reason: fmt.Sprintf("%s is in the blocked network %s", ip, network)}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) hostAllowed(host string) bool {
+	_, ok := p.allowedHosts[host]
+	return ok
+}
+
+func (p *Policy) contentTypeAllowed(mediaType string) bool {
+	for _, allowed := range p.contentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedBody fails with ErrTooLarge once more than remaining bytes have been
+// read, rather than ending early as though the body were complete.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		b.remaining = 0
+		return n - 1, ErrTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// parseNetwork given as a CIDR or a single IP.
+func parseNetwork(entry string) (*net.IPNet, error) {
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	return network, err
+}
+
+// normaliseHost so that names compare equal however they are written.
+func normaliseHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,223 @@
+package fetchpolicy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckURL(t *testing.T) {
+	policy, err := New(Config{Allow: []string{"10.1.0.0/16", "192.168.1.10", "feeds.internal"}})
+	require.Nil(t, err)
+
+	testCases := []struct {
+		Name    string
+		URL     string
+		Allowed bool
+	}{
+		{"Public", "https://example.com/feed.xml", true},
+		{"Public IP", "http://93.184.216.34/feed.xml", true},
+		{"Not http", "ftp://example.com/feed.xml", false},
+		{"No host", "http:///feed.xml", false},
+		{"Localhost", "http://localhost:8080/", false},
+		{"Localhost subdomain", "http://app.localhost/", false},
+		{"Loopback", "http://127.0.0.2/", false},
+		{"Metadata", "http://169.254.169.254/latest/meta-data/", false},
+		{"Private", "http://10.0.0.1/", false},
+		{"Shared", "http://100.64.0.1/", false},
+		{"IPv6 loopback", "http://[::1]/", false},
+		{"IPv4 mapped loopback", "http://[::ffff:127.0.0.1]/", false},
+		{"IPv6 unique local", "http://[fd00:ec2::254]/", false},
+		{"Allowed network", "http://10.1.2.3/", true},
+		{"Allowed IP", "http://192.168.1.10/", true},
+		{"Next to allowed IP", "http://192.168.1.11/", false},
+		{"Allowed host", "http://Feeds.Internal./feed.xml", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			u, err := url.Parse(tc.URL)
+			require.Nil(t, err)
+			err = policy.CheckURL(u)
+			if tc.Allowed {
+				assert.Nil(t, err)
+				return
+			}
+			var blocked BlockedError
+			assert.True(t, errors.As(err, &blocked), err)
+		})
+	}
+}
+
+func TestNewInvalidAllow(t *testing.T) {
+	for _, entry := range []string{"", "10.0.0.0/33", "http://feeds.internal/"} {
+		_, err := New(Config{Allow: []string{entry}})
+		assert.NotNil(t, err, entry)
+	}
+}
+
+func TestClientBlocksAtDial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	policy, err := New(Config{})
+	require.Nil(t, err)
+	// The name is only resolved to a loopback address when it is dialled.
+	_, err = policy.Client(time.Second).Get(fmt.Sprintf("http://localhost:%d/", port))
+	var blocked BlockedError
+	require.True(t, errors.As(err, &blocked), err)
+	assert.Equal(t, "127.0.0.1", blocked.Host)
+
+	allowed, err := New(Config{Allow: []string{"127.0.0.1"}})
+	require.Nil(t, err)
+	resp, err := allowed.Client(time.Second).Get(server.URL)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestClientIgnoresEnvironmentProxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		_, _ = w.Write([]byte("metadata"))
+	}))
+	defer proxy.Close()
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		previous, ok := os.LookupEnv(name)
+		require.Nil(t, os.Setenv(name, proxy.URL))
+		defer func(name string) {
+			if ok {
+				_ = os.Setenv(name, previous)
+			} else {
+				_ = os.Unsetenv(name)
+			}
+		}(name)
+	}
+
+	// The proxy is allowed, so it would be dialled, and fetch the internal
+	// host for the client were it used.
+	policy, err := New(Config{Allow: []string{"127.0.0.1"}})
+	require.Nil(t, err)
+	client := policy.Client(time.Second)
+	assert.Nil(t, client.Transport.(*http.Transport).Proxy)
+	_, err = client.Get("http://metadata.internal.invalid/latest/meta-data/")
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&proxied))
+}
+
+func TestClientRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/internal":
+			http.Redirect(w, r, server.URL+"/feed.xml", http.StatusFound)
+		case "/chain/3":
+			http.Redirect(w, r, "/feed.xml", http.StatusFound)
+		case "/feed.xml":
+			_, _ = w.Write([]byte("feed"))
+		default:
+			n := strings.TrimPrefix(r.URL.Path, "/chain/")
+			http.Redirect(w, r, "/chain/"+string(rune(n[0]+1)), http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	// Allowed by name, the server can't redirect to its own address.
+	byName, err := New(Config{Allow: []string{"localhost"}, MaxRedirects: 5})
+	require.Nil(t, err)
+	_, err = byName.Client(time.Second).Get(fmt.Sprintf("http://localhost:%d/internal", port))
+	var blocked BlockedError
+	assert.True(t, errors.As(err, &blocked), err)
+
+	testCases := []struct {
+		Name         string
+		MaxRedirects int
+		Path         string
+		Error        bool
+	}{
+		{"Within the limit", 4, "/chain/0", false},
+		{"Beyond the limit", 3, "/chain/0", true},
+		{"Not followed", 0, "/chain/3", true},
+		{"No redirect", 0, "/feed.xml", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			policy, err := New(Config{Allow: []string{"127.0.0.0/8"}, MaxRedirects: tc.MaxRedirects})
+			require.Nil(t, err)
+			resp, err := policy.Client(time.Second).Get(server.URL + tc.Path)
+			if tc.Error {
+				assert.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	policy, err := New(Config{MaxBytes: 10, ContentTypes: []string{"application/rss+xml", "text/*"}})
+	require.Nil(t, err)
+
+	testCases := []struct {
+		Name          string
+		ContentType   string
+		Body          string
+		ContentLength int64
+		Error         error
+	}{
+		{"Allowed", "application/rss+xml; charset=utf-8", "<rss/>", -1, nil},
+		{"Any subtype", "text/xml", "<rss/>", -1, nil},
+		{"No content type", "", "<rss/>", -1, nil},
+		{"Not allowed", "image/png", "", -1, ContentTypeError{ContentType: "image/png"}},
+		{"Invalid", "text/", "", -1, ContentTypeError{ContentType: "text/"}},
+		{"Declared too large", "text/xml", "", 11, ErrTooLarge},
+		{"Exactly the limit", "text/xml", "0123456789", -1, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:        http.Header{},
+				Body:          io.NopCloser(strings.NewReader(tc.Body)),
+				ContentLength: tc.ContentLength,
+			}
+			if len(tc.ContentType) > 0 {
+				resp.Header.Set("Content-Type", tc.ContentType)
+			}
+			err := policy.CheckResponse(resp)
+			assert.Equal(t, tc.Error, err)
+			if err != nil {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			require.Nil(t, err)
+			assert.Equal(t, tc.Body, string(body))
+		})
+	}
+
+	resp := &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader("01234567890")), ContentLength: -1}
+	require.Nil(t, policy.CheckResponse(resp))
+	body, err := io.ReadAll(resp.Body)
+	assert.Equal(t, ErrTooLarge, err)
+	assert.Equal(t, "0123456789", string(body))
+}
@@ -0,0 +1,311 @@
+// Package icon finds an icon for each feed, the image the feed gives for
+// itself or else its site's favicon, and keeps a copy in a BlobStore so it
+// can be served without relying on the site.
+package icon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// Defaults used for zero Config values.
+const (
+	DefaultMaxBytes        = 256 << 10
+	DefaultRefreshInterval = 24 * time.Hour
+	// maxPageBytes of a site's home page read looking for its icon links.
+	maxPageBytes = 512 << 10
+)
+
+// contentTypes of the images accepted as icons. SVG is left out as it can
+// carry scripts that would run when the icon is opened directly.
+var contentTypes = map[string]struct{}{
+	"image/png":                {},
+	"image/jpeg":               {},
+	"image/gif":                {},
+	"image/webp":               {},
+	"image/x-icon":             {},
+	"image/vnd.microsoft.icon": {},
+}
+
+// Config for a Fetcher.
+type Config struct {
+	// MaxBytes of an icon, larger images are not downloaded.
+	MaxBytes int64
+	// RefreshInterval between fetching a feed's icon again, whether or not
+	// the last attempt found one.
+	RefreshInterval time.Duration
+	// Timeout bounds fetching the icon of a single feed, zero leaves it to
+	// the context.
+	Timeout time.Duration
+	// UserAgent sent when fetching icons.
+	UserAgent string
+}
+
+// Fetcher finds and downloads feed icons, keeping them in a BlobStore under
+// the feed ID.
+type Fetcher struct {
+	store      BlobStore
+	httpClient *http.Client
+	config     Config
+
+	mu sync.Mutex
+	// attempted records when each feed's icon was last looked for, so feeds
+	// without one aren't tried on every collection.
+	attempted map[string]time.Time
+}
+
+func NewFetcher(store BlobStore, httpClient *http.Client, config Config) *Fetcher {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultRefreshInterval
+	}
+	return &Fetcher{
+		store:      store,
+		httpClient: httpClient,
+		config:     config,
+		attempted:  make(map[string]time.Time),
+	}
+}
+
+// Get the stored icon of the feed, ErrNotFound when there is none.
+func (f *Fetcher) Get(ctx context.Context, feedID string) (Blob, error) {
+	return f.store.Get(ctx, feedID)
+}
+
+// Refresh the icon of the source unless it was fetched, or found to have
+// none, within the RefreshInterval.
+func (f *Fetcher) Refresh(ctx context.Context, source rsscollector.FeedSourcePartial) error {
+	now := time.Now()
+	f.mu.Lock()
+	last, ok := f.attempted[source.ID]
+	f.mu.Unlock()
+	if ok && now.Sub(last) < f.config.RefreshInterval {
+		return nil
+	}
+	if !ok {
+		// The icon may have been stored before a restart.
+		blob, err := f.store.Get(ctx, source.ID)
+		if err == nil && now.Sub(blob.ModTime) < f.config.RefreshInterval {
+			f.markAttempted(source.ID, blob.ModTime)
+			return nil
+		}
+	}
+	f.markAttempted(source.ID, now)
+	return f.Fetch(ctx, source)
+}
+
+func (f *Fetcher) markAttempted(feedID string, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempted[feedID] = at
+}
+
+// Fetch the icon of the source and store it, trying the feed's own image,
+// the icons linked from its site's home page and then the site's
+// /favicon.ico in turn.
+func (f *Fetcher) Fetch(ctx context.Context, source rsscollector.FeedSourcePartial) error {
+	if f.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.config.Timeout)
+		defer cancel()
+	}
+
+	site, err := siteURL(source)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	if source.Image != nil && len(source.Image.URL) > 0 {
+		candidates = append(candidates, source.Image.URL)
+	}
+	links, err := f.pageIcons(ctx, site)
+	if err != nil {
+		log.Debug().Err(err).Str("feedId", source.ID).Str("site", site.String()).
+			Msg("failed to read site for icon links")
+	}
+	candidates = append(candidates, links...)
+	candidates = append(candidates, (&url.URL{Scheme: site.Scheme, Host: site.Host, Path: "/favicon.ico"}).String())
+
+	var errs []string
+	for _, candidate := range candidates {
+		iconURL, err := site.Parse(candidate)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		blob, err := f.download(ctx, iconURL)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", iconURL, err))
+			continue
+		}
+		return f.store.Put(ctx, source.ID, blob)
+	}
+	return fmt.Errorf("no icon found for feed %s: %s", source.ID, strings.Join(errs, ", "))
+}
+
+// siteURL of the source, its site link or else the root of the host serving
+// the feed.
+func siteURL(source rsscollector.FeedSourcePartial) (*url.URL, error) {
+	if len(source.SiteLink) > 0 {
+		if site, err := url.Parse(source.SiteLink); err == nil && isHTTP(site) {
+			return site, nil
+		}
+	}
+	feedURL, err := url.Parse(source.FeedURL)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTP(feedURL) {
+		return nil, fmt.Errorf("feed URL %s is not http or https", source.FeedURL)
+	}
+	return &url.URL{Scheme: feedURL.Scheme, Host: feedURL.Host, Path: "/"}, nil
+}
+
+func isHTTP(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+// get the URL, failing unless it responds with 200 OK. The caller closes the
+// body.
+func (f *Fetcher) get(ctx context.Context, target *url.URL) (*http.Response, error) {
+	if !isHTTP(target) {
+		return nil, fmt.Errorf("%s is not http or https", target)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.config.UserAgent) > 0 {
+		req.Header.Set("User-Agent", f.config.UserAgent)
+	}
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// download the image at iconURL, checking its size and type.
+func (f *Fetcher) download(ctx context.Context, iconURL *url.URL) (Blob, error) {
+	resp, err := f.get(ctx, iconURL)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength > f.config.MaxBytes {
+		return Blob{}, fmt.Errorf("icon of %d bytes is larger than %d", resp.ContentLength, f.config.MaxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxBytes+1))
+	if err != nil {
+		return Blob{}, err
+	}
+	if int64(len(data)) > f.config.MaxBytes {
+		return Blob{}, fmt.Errorf("icon is larger than %d bytes", f.config.MaxBytes)
+	}
+	if len(data) == 0 {
+		return Blob{}, errors.New("icon is empty")
+	}
+
+	// Servers often give icons a generic type, the content decides then.
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if _, ok := contentTypes[contentType]; !ok {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if _, ok := contentTypes[contentType]; !ok {
+		return Blob{}, fmt.Errorf("unsupported icon type %s", contentType)
+	}
+	return Blob{ContentType: contentType, Data: data, ModTime: time.Now()}, nil
+}
+
+// pageIcons reads the page at site for the icons it links to, ordered with
+// the larger apple-touch-icon links last as they are mostly designed to fill
+// a home screen rather than sit beside a title.
+func (f *Fetcher) pageIcons(ctx context.Context, site *url.URL) ([]string, error) {
+	resp, err := f.get(ctx, site)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Links are relative to the page the request ended up on after any
+	// redirects.
+	base := resp.Request.URL
+	var icons, touchIcons []string
+	tokenizer := html.NewTokenizer(io.LimitReader(resp.Body, maxPageBytes))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return append(icons, touchIcons...), nil
+			}
+			return append(icons, touchIcons...), tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) == "body" {
+				return append(icons, touchIcons...), nil
+			}
+			if string(name) != "link" || !hasAttr {
+				continue
+			}
+			var rel, href string
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				switch string(key) {
+				case "rel":
+					rel = strings.ToLower(string(value))
+				case "href":
+					href = strings.TrimSpace(string(value))
+				}
+			}
+			if len(href) == 0 {
+				continue
+			}
+			resolved, err := base.Parse(href)
+			if err != nil {
+				continue
+			}
+			switch rels := strings.Fields(rel); {
+			case hasRel(rels, "icon"):
+				icons = append(icons, resolved.String())
+			case hasRel(rels, "apple-touch-icon"):
+				touchIcons = append(touchIcons, resolved.String())
+			}
+		}
+	}
+}
+
+// hasRel reports whether want is among the link relations, e.g. icon in
+// "shortcut icon".
+func hasRel(rels []string, want string) bool {
+	for _, rel := range rels {
+		if rel == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package icon
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// testPNG is the start of a PNG, enough for its type to be detected.
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestFetch(t *testing.T) {
+	large := append(append([]byte{}, testPNG...), bytes.Repeat([]byte{0}, 100)...)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/linked/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><link rel="stylesheet" href="/style.css">` +
+			`<link rel="apple-touch-icon" href="/touch.png"><link rel="Shortcut Icon" href="icon.png">` +
+			`</head><body><link rel="icon" href="/ignored.png"></body></html>`))
+	})
+	mux.HandleFunc("/linked/icon.png", func(w http.ResponseWriter, r *http.Request) {
+		// Served with a generic type, the content shows it is a PNG.
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(testPNG)
+	})
+	mux.HandleFunc("/logo.gif", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		_, _ = w.Write([]byte("GIF89a"))
+	})
+	mux.HandleFunc("/large.png", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(large)
+	})
+	mux.HandleFunc("/page.png", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html>not an image</html>"))
+	})
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-agent", r.UserAgent())
+		_, _ = w.Write([]byte("\x00\x00\x01\x00\x01\x00"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testCases := []struct {
+		Name                string
+		Source              rsscollector.FeedSourcePartial
+		ExpectedContentType string
+		ExpectedData        []byte
+	}{
+		{
+			"Feed image",
+			rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml", FeedMetadata: rsscollector.FeedMetadata{
+				SiteLink: server.URL + "/linked/",
+				Image:    &rsscollector.FeedItemImage{URL: "/logo.gif"},
+			}},
+			"image/gif",
+			[]byte("GIF89a"),
+		},
+		{
+			"Linked from the site",
+			rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml", FeedMetadata: rsscollector.FeedMetadata{
+				SiteLink: server.URL + "/linked/",
+			}},
+			"image/png",
+			testPNG,
+		},
+		{
+			"Favicon of the feed's host",
+			rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml"},
+			"image/x-icon",
+			[]byte("\x00\x00\x01\x00\x01\x00"),
+		},
+		{
+			"Too large",
+			rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml", FeedMetadata: rsscollector.FeedMetadata{
+				Image: &rsscollector.FeedItemImage{URL: server.URL + "/large.png"},
+			}},
+			"image/x-icon",
+			[]byte("\x00\x00\x01\x00\x01\x00"),
+		},
+		{
+			"Not an image",
+			rsscollector.FeedSourcePartial{FeedURL: server.URL + "/feed.xml", FeedMetadata: rsscollector.FeedMetadata{
+				Image: &rsscollector.FeedItemImage{URL: server.URL + "/page.png"},
+			}},
+			"image/x-icon",
+			[]byte("\x00\x00\x01\x00\x01\x00"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			store, err := NewFileStore(t.TempDir())
+			require.Nil(t, err)
+			fetcher := NewFetcher(store, server.Client(), Config{MaxBytes: int64(len(large) - 1), UserAgent: "test-agent"})
+			tc.Source.ID = "c3ae3dc2-d157-4a42-9e53-c992d74486c4"
+
+			require.Nil(t, fetcher.Fetch(context.Background(), tc.Source))
+			blob, err := fetcher.Get(context.Background(), tc.Source.ID)
+			require.Nil(t, err)
+			assert.Equal(t, tc.ExpectedContentType, blob.ContentType)
+			assert.Equal(t, tc.ExpectedData, blob.Data)
+		})
+	}
+}
+
+func TestFetchNoIcon(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	store, err := NewFileStore(t.TempDir())
+	require.Nil(t, err)
+	fetcher := NewFetcher(store, server.Client(), Config{})
+	source := rsscollector.FeedSourcePartial{ID: "1", FeedURL: server.URL + "/feed.xml"}
+
+	assert.NotNil(t, fetcher.Fetch(context.Background(), source))
+	_, err = fetcher.Get(context.Background(), source.ID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestRefresh(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	store, err := NewFileStore(t.TempDir())
+	require.Nil(t, err)
+	fetcher := NewFetcher(store, server.Client(), Config{RefreshInterval: time.Hour})
+	source := rsscollector.FeedSourcePartial{ID: "1", FeedURL: server.URL + "/feed.xml"}
+
+	assert.NotNil(t, fetcher.Refresh(context.Background(), source))
+	tried := requests
+	assert.Greater(t, tried, 0)
+	// Feeds without an icon aren't tried again until the interval passes.
+	assert.Nil(t, fetcher.Refresh(context.Background(), source))
+	assert.Equal(t, tried, requests)
+
+	// Neither are feeds whose icon was stored recently, before a restart.
+	require.Nil(t, store.Put(context.Background(), "2", Blob{ContentType: "image/png", Data: testPNG}))
+	restarted := NewFetcher(store, server.Client(), Config{RefreshInterval: time.Hour})
+	assert.Nil(t, restarted.Refresh(context.Background(),
+		rsscollector.FeedSourcePartial{ID: "2", FeedURL: server.URL + "/feed.xml"}))
+	assert.Equal(t, tried, requests)
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	require.Nil(t, err)
+
+	_, err = store.Get(ctx, "missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.Nil(t, store.Put(ctx, "icon", Blob{ContentType: "image/png", Data: []byte("first")}))
+	require.Nil(t, store.Put(ctx, "icon", Blob{ContentType: "image/gif", Data: []byte("second\nline")}))
+	blob, err := store.Get(ctx, "icon")
+	require.Nil(t, err)
+	assert.Equal(t, "image/gif", blob.ContentType)
+	assert.Equal(t, []byte("second\nline"), blob.Data)
+	assert.False(t, blob.ModTime.IsZero())
+
+	assert.NotNil(t, store.Put(ctx, "../escape", Blob{ContentType: "image/png"}))
+	assert.NotNil(t, store.Put(ctx, "icon", Blob{ContentType: "image/png\nimage/gif"}))
+}
+
+func TestPlaceholder(t *testing.T) {
+	blob := Placeholder(rsscollector.FeedSourcePartial{ID: "1", Title: " — bbc News"})
+	assert.Equal(t, "image/svg+xml", blob.ContentType)
+	assert.True(t, strings.Contains(string(blob.Data), ">B</text>"))
+	assert.Equal(t, blob, Placeholder(rsscollector.FeedSourcePartial{ID: "1", Title: " — bbc News"}))
+
+	untitled := Placeholder(rsscollector.FeedSourcePartial{ID: "1"})
+	assert.True(t, strings.Contains(string(untitled.Data), ">?</text>"))
+}
@@ -0,0 +1,41 @@
+package icon
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// placeholderColours are the backgrounds of placeholder icons, picked by the
+// feed ID so each feed keeps its colour.
+var placeholderColours = []string{
+	"#1abc9c", "#2ecc71", "#3498db", "#9b59b6", "#34495e",
+	"#16a085", "#27ae60", "#2980b9", "#8e44ad", "#e67e22",
+	"#e74c3c", "#d35400", "#c0392b", "#7f8c8d",
+}
+
+// Placeholder generates an icon for a feed that has none, the first letter
+// or digit of its title on a coloured square. Only letters and digits are
+// used so the title never needs escaping.
+func Placeholder(source rsscollector.FeedSourcePartial) Blob {
+	letter := "?"
+	for _, r := range strings.TrimSpace(source.Title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			letter = string(unicode.ToUpper(r))
+			break
+		}
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(source.ID))
+	colour := placeholderColours[hash.Sum32()%uint32(len(placeholderColours))]
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="64" height="64" viewBox="0 0 64 64">`+
+		`<rect width="64" height="64" rx="8" fill="%s"/>`+
+		`<text x="32" y="32" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="36" fill="#ffffff">%s</text>`+
+		`</svg>`, colour, letter)
+	return Blob{ContentType: "image/svg+xml", Data: []byte(svg)}
+}
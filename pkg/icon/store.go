@@ -0,0 +1,119 @@
+package icon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned by a BlobStore that holds nothing for a key.
+var ErrNotFound = errors.New("blob not found")
+
+// Blob is an image held by a BlobStore.
+type Blob struct {
+	ContentType string
+	Data        []byte
+	// ModTime is when the blob was stored.
+	ModTime time.Time
+}
+
+// BlobStore holds images by key, replacing any already held for the key.
+type BlobStore interface {
+	Get(ctx context.Context, key string) (Blob, error)
+	Put(ctx context.Context, key string, blob Blob) error
+}
+
+// keyPattern restricts keys to names that are safe to use as file names.
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// FileStore is a BlobStore keeping each blob in a file in a directory. The
+// first line of the file is the content type, followed by the data.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore keeping blobs in dir, which is created if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create icon directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(key string) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(f.dir, key), nil
+}
+
+func (f *FileStore) Get(_ context.Context, key string) (Blob, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return Blob{}, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Blob{}, err
+	}
+	reader := bufio.NewReader(file)
+	contentType, err := reader.ReadString('\n')
+	if err != nil {
+		return Blob{}, fmt.Errorf("invalid blob %s: %w", key, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return Blob{}, err
+	}
+	return Blob{
+		ContentType: contentType[:len(contentType)-1],
+		Data:        data,
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so a
+// reader never sees part of a blob.
+func (f *FileStore) Put(_ context.Context, key string, blob Blob) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	if bytes.ContainsAny([]byte(blob.ContentType), "\r\n") {
+		return fmt.Errorf("invalid content type %q", blob.ContentType)
+	}
+
+	temp, err := os.CreateTemp(f.dir, "."+key+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := fmt.Fprintf(temp, "%s\n", blob.ContentType); err != nil {
+		temp.Close()
+		return err
+	}
+	if _, err := temp.Write(blob.Data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,203 @@
+// Package metrics defines the Prometheus metrics exported by the service.
+//
+// Metrics are registered with the default Prometheus registry and served by
+// the HTTP server at /metrics. Labels are limited to values from small, fixed
+// sets with the exception of the feed label, which has one value per feed
+// source and can be collapsed with SetPerFeedLabels.
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const namespace = "rsscollector"
+
+// AllFeeds replaces the feed ID in the feed label when per feed labels are
+// disabled.
+const AllFeeds = "all"
+
+// Fetch outcomes used for the outcome label of FeedFetches.
+const (
+	OutcomeSuccess      = "success"
+	OutcomeHTTPError    = "http_error"
+	OutcomeNetworkError = "network_error"
+	OutcomeParseError   = "parse_error"
+	OutcomeTimeout      = "timeout"
+)
+
+var (
+	FeedFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_fetches_total",
+		Help:      "Feed fetch attempts by feed and outcome.",
+	}, []string{"feed", "outcome"})
+
+	FeedFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "feed_fetch_duration_seconds",
+		Help:      "Time taken to fetch and parse a feed.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"feed"})
+
+	FeedFetchBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_fetch_bytes_total",
+		Help:      "Bytes of feed documents fetched.",
+	}, []string{"feed"})
+
+	FetchQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "fetch_queue_depth",
+		Help:      "Feeds waiting to be fetched, for a worker, their host or the rate limit.",
+	})
+
+	FetchWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_wait_seconds",
+		Help:      "Time feeds waited for their host and the rate limit before being fetched.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	})
+
+	FeedMoves = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_moves_total",
+		Help:      "Feeds moved to a new URL, by the reason they moved.",
+	}, []string{"reason"})
+
+	ItemsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "items_stored_total",
+		Help:      "Collected items stored for the first time.",
+	}, []string{"feed"})
+
+	ItemsDeduplicated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "items_deduplicated_total",
+		Help:      "Collected items that updated an item already stored with the same GUID.",
+	}, []string{"feed"})
+
+	ItemsPruned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "items_pruned_total",
+		Help:      "Items removed by the retention policies.",
+	}, []string{"feed"})
+
+	TrashPurged = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trash_purged_total",
+		Help:      "Feeds, items and categories permanently removed from the trash.",
+	})
+
+	RepositoryCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_call_duration_seconds",
+		Help:      "Latency of object store calls by backend, method and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "method", "outcome"})
+
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_cache_lookups_total",
+		Help:      "Response cache lookups by result, either hit or miss.",
+	}, []string{"result"})
+
+	TotalFeeds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "feeds",
+		Help:      "Feed sources currently stored.",
+	})
+
+	TotalItems = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "items",
+		Help:      "Feed items currently stored.",
+	})
+
+	TotalCategories = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "categories",
+		Help:      "Categories currently stored.",
+	})
+)
+
+var perFeedLabels int32 = 1
+
+// SetPerFeedLabels controls whether feed metrics are labelled with the feed
+// ID or all recorded under AllFeeds, bounding their cardinality regardless of
+// the number of feeds.
+func SetPerFeedLabels(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&perFeedLabels, value)
+}
+
+// FeedLabel provides the value of the feed label for the feed.
+func FeedLabel(feedID string) string {
+	if atomic.LoadInt32(&perFeedLabels) == 0 || len(feedID) == 0 {
+		return AllFeeds
+	}
+	return feedID
+}
+
+// Outcome provides the value of an outcome label for an error.
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Totals of the objects held in the stores.
+type Totals struct {
+	Feeds      int
+	Items      int
+	Categories int
+}
+
+// TotalsFunc counts the objects held in the stores.
+type TotalsFunc func(ctx context.Context) (Totals, error)
+
+// UpdateTotals sets the object count gauges from count every interval until
+// ctx is done.
+func UpdateTotals(ctx context.Context, count TotalsFunc, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		totals, err := count(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to count stored objects")
+		} else {
+			TotalFeeds.Set(float64(totals.Feeds))
+			TotalItems.Set(float64(totals.Items))
+			TotalCategories.Set(float64(totals.Categories))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
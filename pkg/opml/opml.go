@@ -0,0 +1,152 @@
+// Package opml reads and writes feed subscription lists in the OPML 2.0
+// format used by feed readers to import and export their subscriptions.
+package opml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Document is an OPML document.
+type Document struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Body struct {
+	Outlines []Outline `xml:"outline"`
+}
+
+// Outline is either a feed subscription, when XMLURL is set, or a folder of
+// further outlines.
+type Outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	Outlines []Outline `xml:"outline"`
+}
+
+// Subscription to a feed found in a document.
+type Subscription struct {
+	FeedURL string
+	Title   string
+	// Categories are the titles of the folders the feed was found in. A
+	// feed found in more than one folder is only returned once.
+	Categories []string
+}
+
+// Parse the document read from r.
+func Parse(r io.Reader) (*Document, error) {
+	var doc Document
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid OPML: %w", err)
+	}
+	return &doc, nil
+}
+
+// Subscriptions in the document, in the order they first appear.
+func (d *Document) Subscriptions() []Subscription {
+	var subscriptions []Subscription
+	index := make(map[string]int)
+
+	var walk func(outlines []Outline, category string)
+	walk = func(outlines []Outline, category string) {
+		for _, o := range outlines {
+			if len(o.XMLURL) == 0 {
+				walk(o.Outlines, o.label())
+				continue
+			}
+			i, ok := index[o.XMLURL]
+			if !ok {
+				i = len(subscriptions)
+				index[o.XMLURL] = i
+				subscriptions = append(subscriptions, Subscription{FeedURL: o.XMLURL, Title: o.label()})
+			}
+			if len(category) > 0 && !contains(subscriptions[i].Categories, category) {
+				subscriptions[i].Categories = append(subscriptions[i].Categories, category)
+			}
+		}
+	}
+	walk(d.Body.Outlines, "")
+	return subscriptions
+}
+
+func (o Outline) label() string {
+	if len(o.Title) > 0 {
+		return o.Title
+	}
+	return o.Text
+}
+
+// New document holding the subscriptions, placing those with categories in
+// a folder for each category and the rest at the top level.
+func New(title string, created time.Time, subscriptions []Subscription) *Document {
+	doc := &Document{
+		Version: "2.0",
+		Head: Head{
+			Title:       title,
+			DateCreated: created.UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	folders := make(map[string][]Outline)
+	for _, s := range subscriptions {
+		outline := Outline{Text: s.Title, Title: s.Title, Type: "rss", XMLURL: s.FeedURL}
+		if len(s.Categories) == 0 {
+			doc.Body.Outlines = append(doc.Body.Outlines, outline)
+			continue
+		}
+		for _, category := range s.Categories {
+			folders[category] = append(folders[category], outline)
+		}
+	}
+
+	categories := make([]string, 0, len(folders))
+	for category := range folders {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		doc.Body.Outlines = append(doc.Body.Outlines, Outline{
+			Text:     category,
+			Title:    category,
+			Outlines: folders[category],
+		})
+	}
+	return doc
+}
+
+// Write the document to w.
+func (d *Document) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(d); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package opml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <head><title>Subscriptions</title></head>
+  <body>
+    <outline text="Uncategorised" type="rss" xmlUrl="http://example.com/uncategorised.xml"/>
+    <outline text="News">
+      <outline text="BBC" title="BBC News" type="rss" xmlUrl="http://feeds.bbci.co.uk/news/rss.xml"/>
+      <outline text="Sky" type="rss" xmlUrl="http://feeds.skynews.com/feeds/rss/home.xml"/>
+    </outline>
+    <outline text="Favourites">
+      <outline text="Nested">
+        <outline text="BBC" type="rss" xmlUrl="http://feeds.bbci.co.uk/news/rss.xml"/>
+      </outline>
+    </outline>
+  </body>
+</opml>`
+
+func TestSubscriptions(t *testing.T) {
+	doc, err := Parse(strings.NewReader(testDocument))
+	require.Nil(t, err)
+
+	assert.Equal(t, []Subscription{
+		{FeedURL: "http://example.com/uncategorised.xml", Title: "Uncategorised"},
+		{FeedURL: "http://feeds.bbci.co.uk/news/rss.xml", Title: "BBC News", Categories: []string{"News", "Nested"}},
+		{FeedURL: "http://feeds.skynews.com/feeds/rss/home.xml", Title: "Sky", Categories: []string{"News"}},
+	}, doc.Subscriptions())
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse(strings.NewReader("<rss>"))
+	assert.NotNil(t, err)
+}
+
+func TestWriteAndParse(t *testing.T) {
+	subscriptions := []Subscription{
+		{FeedURL: "http://example.com/a.xml", Title: "A"},
+		{FeedURL: "http://example.com/b.xml", Title: "B", Categories: []string{"Tech", "News"}},
+		{FeedURL: "http://example.com/c.xml", Title: "C", Categories: []string{"News"}},
+	}
+
+	var buf bytes.Buffer
+	require.Nil(t, New("Export", time.Now(), subscriptions).Write(&buf))
+
+	doc, err := Parse(&buf)
+	require.Nil(t, err)
+	assert.Equal(t, "Export", doc.Head.Title)
+	assert.Equal(t, []Subscription{
+		{FeedURL: "http://example.com/a.xml", Title: "A"},
+		{FeedURL: "http://example.com/b.xml", Title: "B", Categories: []string{"News", "Tech"}},
+		{FeedURL: "http://example.com/c.xml", Title: "C", Categories: []string{"News"}},
+	}, doc.Subscriptions())
+}
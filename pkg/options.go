@@ -3,4 +3,9 @@ package pkg
 type ItemOptions struct {
 	SourceID    string
 	CategoryIDs []string
+	// EnclosureType limits the items to those with an enclosure of the type,
+	// as matched by FeedItem.HasEnclosure.
+	EnclosureType string
+	// Deleted gives the items in the trash rather than those outside it.
+	Deleted bool
 }
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+)
+
+// InstrumentedStore records the latency and outcome of every call made to the
+// Store it wraps and traces each call with a span.
+type InstrumentedStore struct {
+	store   Store
+	backend string
+}
+
+// NewInstrumentedStore wraps store, labelling its metrics with backend, e.g.
+// "postgres" or "memory".
+func NewInstrumentedStore(store Store, backend string) *InstrumentedStore {
+	return &InstrumentedStore{store: store, backend: backend}
+}
+
+// start a span for a call to method. The returned function is deferred by
+// each call with a pointer to its named error result so that the outcome is
+// known when it runs.
+func (s *InstrumentedStore) start(ctx context.Context, method string) (context.Context, func(err *error)) {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "repository."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", s.backend)))
+
+	return ctx, func(err *error) {
+		tracing.EndSpan(span, err)
+		metrics.RepositoryCallDuration.WithLabelValues(s.backend, method, metrics.Outcome(*err)).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+func (s *InstrumentedStore) StoreSource(ctx context.Context, source *rsscollector.FeedSource) (err error) {
+	ctx, done := s.start(ctx, "StoreSource")
+	defer done(&err)
+	return s.store.StoreSource(ctx, source)
+}
+
+func (s *InstrumentedStore) FetchSource(ctx context.Context, feedID string) (source rsscollector.FeedSource, err error) {
+	ctx, done := s.start(ctx, "FetchSource")
+	defer done(&err)
+	return s.store.FetchSource(ctx, feedID)
+}
+
+func (s *InstrumentedStore) FetchAllSources(ctx context.Context) (sources []rsscollector.FeedSourcePartial, err error) {
+	ctx, done := s.start(ctx, "FetchAllSources")
+	defer done(&err)
+	return s.store.FetchAllSources(ctx)
+}
+
+func (s *InstrumentedStore) DeleteSourceByID(ctx context.Context, feedID string) (err error) {
+	ctx, done := s.start(ctx, "DeleteSourceByID")
+	defer done(&err)
+	return s.store.DeleteSourceByID(ctx, feedID)
+}
+
+func (s *InstrumentedStore) StoreItem(ctx context.Context, sourceID string, item *rsscollector.FeedItem) (err error) {
+	ctx, done := s.start(ctx, "StoreItem")
+	defer done(&err)
+	return s.store.StoreItem(ctx, sourceID, item)
+}
+
+func (s *InstrumentedStore) StoreItems(ctx context.Context, sourceID string, items []*rsscollector.FeedItem) (err error) {
+	ctx, done := s.start(ctx, "StoreItems")
+	defer done(&err)
+	return s.store.StoreItems(ctx, sourceID, items)
+}
+
+func (s *InstrumentedStore) FetchItemByID(ctx context.Context, id string) (item rsscollector.FeedItem, err error) {
+	ctx, done := s.start(ctx, "FetchItemByID")
+	defer done(&err)
+	return s.store.FetchItemByID(ctx, id)
+}
+
+func (s *InstrumentedStore) FetchAllItems(ctx context.Context, options rsscollector.ItemOptions) (items rsscollector.FeedItems, err error) {
+	ctx, done := s.start(ctx, "FetchAllItems")
+	defer done(&err)
+	return s.store.FetchAllItems(ctx, options)
+}
+
+func (s *InstrumentedStore) DeleteItemByID(ctx context.Context, id string) (err error) {
+	ctx, done := s.start(ctx, "DeleteItemByID")
+	defer done(&err)
+	return s.store.DeleteItemByID(ctx, id)
+}
+
+func (s *InstrumentedStore) DeleteItemsByID(ctx context.Context, ids []string) (err error) {
+	ctx, done := s.start(ctx, "DeleteItemsByID")
+	defer done(&err)
+	return s.store.DeleteItemsByID(ctx, ids)
+}
+
+func (s *InstrumentedStore) StoreCategory(ctx context.Context, category *rsscollector.FeedCategory) (err error) {
+	ctx, done := s.start(ctx, "StoreCategory")
+	defer done(&err)
+	return s.store.StoreCategory(ctx, category)
+}
+
+func (s *InstrumentedStore) FetchAllCategories(ctx context.Context) (categories []rsscollector.FeedCategory, err error) {
+	ctx, done := s.start(ctx, "FetchAllCategories")
+	defer done(&err)
+	return s.store.FetchAllCategories(ctx)
+}
+
+func (s *InstrumentedStore) FetchCategoryByID(ctx context.Context, id string) (category rsscollector.FeedCategory, err error) {
+	ctx, done := s.start(ctx, "FetchCategoryByID")
+	defer done(&err)
+	return s.store.FetchCategoryByID(ctx, id)
+}
+
+func (s *InstrumentedStore) FetchCategoryByName(ctx context.Context, name string) (category rsscollector.FeedCategory, err error) {
+	ctx, done := s.start(ctx, "FetchCategoryByName")
+	defer done(&err)
+	return s.store.FetchCategoryByName(ctx, name)
+}
+
+func (s *InstrumentedStore) FetchCategoriesForIDs(ctx context.Context, ids []string) (categories []rsscollector.FeedCategory, err error) {
+	ctx, done := s.start(ctx, "FetchCategoriesForIDs")
+	defer done(&err)
+	return s.store.FetchCategoriesForIDs(ctx, ids)
+}
+
+func (s *InstrumentedStore) DeleteCategoryByID(ctx context.Context, id string) (err error) {
+	ctx, done := s.start(ctx, "DeleteCategoryByID")
+	defer done(&err)
+	return s.store.DeleteCategoryByID(ctx, id)
+}
+
+// CheckHealth of the wrapped store, which is always healthy if it doesn't
+// implement HealthChecker.
+func (s *InstrumentedStore) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.store.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+func (s *InstrumentedStore) FetchTrash(ctx context.Context) (trash rsscollector.Trash, err error) {
+	ctx, done := s.start(ctx, "FetchTrash")
+	defer done(&err)
+	return s.store.FetchTrash(ctx)
+}
+
+func (s *InstrumentedStore) RestoreSource(ctx context.Context, feedID string) (err error) {
+	ctx, done := s.start(ctx, "RestoreSource")
+	defer done(&err)
+	return s.store.RestoreSource(ctx, feedID)
+}
+
+func (s *InstrumentedStore) RestoreItem(ctx context.Context, id string) (err error) {
+	ctx, done := s.start(ctx, "RestoreItem")
+	defer done(&err)
+	return s.store.RestoreItem(ctx, id)
+}
+
+func (s *InstrumentedStore) RestoreCategory(ctx context.Context, id string) (err error) {
+	ctx, done := s.start(ctx, "RestoreCategory")
+	defer done(&err)
+	return s.store.RestoreCategory(ctx, id)
+}
+
+func (s *InstrumentedStore) PurgeTrash(ctx context.Context, deletedBefore time.Time) (purged int, err error) {
+	ctx, done := s.start(ctx, "PurgeTrash")
+	defer done(&err)
+	return s.store.PurgeTrash(ctx, deletedBefore)
+}
+
+// Count the objects in the wrapped store.
+func (s *InstrumentedStore) Count(ctx context.Context) (metrics.Totals, error) {
+	if counter, ok := s.store.(Counter); ok {
+		return counter.Count(ctx)
+	}
+	return metrics.Totals{}, errors.New("store does not support counting")
+}
+
+// Close the wrapped store if it holds any connections.
+func (s *InstrumentedStore) Close() error {
+	if closer, ok := s.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -1,38 +1,87 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
 	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
 )
 
+// MemoryFeedStore keeps deleted feeds and items in place with DeletedAt set,
+// so deleted items are still indexed by GUID, while deleted categories are
+// moved to trashedCategories so their names can be reused.
+//
+// Every change is made by an unexported method that must be called with the
+// write lock held and doesn't read the clock, so that a persistent store can
+// replay its write log through the same methods.
 type MemoryFeedStore struct {
-	feeds            map[string]rsscollector.FeedSource
-	items            map[string]rsscollector.FeedItems
-	itemsByID        map[string]rsscollector.FeedItem
-	categoriesByID   map[string]string
-	categoriesByName map[string]string
+	feeds             map[string]rsscollector.FeedSource
+	items             map[string][]string
+	itemsByID         map[string]rsscollector.FeedItem
+	itemIDsByGUID     map[string]map[string]string
+	categoriesByID    map[string]string
+	categoriesByName  map[string]string
+	trashedCategories map[string]rsscollector.FeedCategory
+	// persistence is nil unless the store was opened with
+	// NewPersistentMemoryStore.
+	persistence *memoryPersistence
 	sync.RWMutex
 }
 
 func NewMemoryStore() *MemoryFeedStore {
 	return &MemoryFeedStore{
-		feeds:            make(map[string]rsscollector.FeedSource),
-		items:            make(map[string]rsscollector.FeedItems),
-		itemsByID:        make(map[string]rsscollector.FeedItem),
-		categoriesByID:   make(map[string]string),
-		categoriesByName: make(map[string]string),
-		RWMutex:          sync.RWMutex{},
+		feeds:             make(map[string]rsscollector.FeedSource),
+		items:             make(map[string][]string),
+		itemsByID:         make(map[string]rsscollector.FeedItem),
+		itemIDsByGUID:     make(map[string]map[string]string),
+		categoriesByID:    make(map[string]string),
+		categoriesByName:  make(map[string]string),
+		trashedCategories: make(map[string]rsscollector.FeedCategory),
+		RWMutex:           sync.RWMutex{},
 	}
 }
 
-func (m *MemoryFeedStore) StoreSource(source *rsscollector.FeedSource) error {
+func (m *MemoryFeedStore) StoreSource(ctx context.Context, source *rsscollector.FeedSource) error {
 	defer m.Unlock()
 	m.Lock()
+	if err := m.checkStoredURL(source); err != nil {
+		return err
+	}
+	if err := m.storeSource(source); err != nil {
+		return err
+	}
+	return m.logWrite(memoryLogRecord{Op: opStoreSource, Source: source})
+}
+
+// checkStoredURL must be called with the write lock held. It isn't applied
+// when replaying the log, which only holds writes already checked.
+func (m *MemoryFeedStore) checkStoredURL(source *rsscollector.FeedSource) error {
+	previous := m.feeds[source.ID]
+	if len(previous.DuplicateOf) > 0 {
+		previous.FeedURL = ""
+	}
+	return checkStoredURL(source, previous.FeedURL, m.liveSources())
+}
+
+// liveSources outside the trash, which must be called with the lock held.
+func (m *MemoryFeedStore) liveSources() []rsscollector.FeedSourcePartial {
+	live := make([]rsscollector.FeedSourcePartial, 0, len(m.feeds))
+	for _, feed := range m.feeds {
+		if feed.DeletedAt == nil {
+			live = append(live, feed.FeedSourcePartial)
+		}
+	}
+	return live
+}
+
+// storeSource must be called with the write lock held.
+func (m *MemoryFeedStore) storeSource(source *rsscollector.FeedSource) error {
 	if len(source.ID) == 0 {
 		u, err := uuid.NewRandom()
 		if err != nil {
@@ -40,160 +89,262 @@ func (m *MemoryFeedStore) StoreSource(source *rsscollector.FeedSource) error {
 		}
 		source.ID = u.String()
 	}
-	m.feeds[source.ID] = *source
+	source.Link = rsscollector.FeedSourceLink(source.ID)
+	normalizeFeedURL(source)
+	stored := *source
+	stored.CategoryIDs = copyStrings(source.CategoryIDs)
+	stored.FeedMetadata = copyMetadata(source.FeedMetadata)
+	stored.Scrape = copyScrape(source.Scrape)
+	stored.Fetch = copyFetch(source.Fetch)
+	stored.URLHistory = copyURLHistory(source.URLHistory)
+	stored.PrunedGUIDs = copyStrings(source.PrunedGUIDs)
+	stored.FeedItems = nil
+	// Storing a feed never moves it in or out of the trash, nor drops its
+	// hidden links to deleted categories.
+	previous := m.feeds[source.ID]
+	stored.DeletedAt = previous.DeletedAt
+	stored.CategoryIDs = mergeStrings(stored.CategoryIDs, m.trashedCategoryIDs(previous.CategoryIDs))
+	m.feeds[source.ID] = stored
 	return nil
 }
 
-func (m *MemoryFeedStore) FetchSource(feedID string) (rsscollector.FeedSource, error) {
+func (m *MemoryFeedStore) FetchSource(ctx context.Context, feedID string) (rsscollector.FeedSource, error) {
 	defer m.RUnlock()
 	m.RLock()
-	if f, ok := m.feeds[feedID]; ok {
-		return f, nil
+	if f, ok := m.feeds[feedID]; ok && f.DeletedAt == nil {
+		return m.copySource(f), nil
 	}
 	return rsscollector.FeedSource{}, fmt.Errorf("no feed found for feedID: %s", feedID)
 }
 
-func (m *MemoryFeedStore) FetchAllSources() ([]rsscollector.FeedSourcePartial, error) {
+func (m *MemoryFeedStore) FetchAllSources(ctx context.Context) ([]rsscollector.FeedSourcePartial, error) {
 	defer m.RUnlock()
 	m.RLock()
 	feeds := make([]rsscollector.FeedSourcePartial, 0)
 	for _, v := range m.feeds {
-		feeds = append(feeds, rsscollector.NewFeedSourcePartial(v))
+		if v.DeletedAt == nil {
+			feeds = append(feeds, rsscollector.NewFeedSourcePartial(m.copySource(v)))
+		}
 	}
 	return feeds, nil
 }
 
-func (m *MemoryFeedStore) DeleteSourceByID(id string) error {
+// DeleteSourceByID moves the source to the trash along with the items not
+// already there, marking them as deleted at the same time so they can be
+// restored together.
+func (m *MemoryFeedStore) DeleteSourceByID(ctx context.Context, id string) error {
 	defer m.Unlock()
 	m.Lock()
-	delete(m.items, id)
-	for itemID, item := range m.itemsByID {
-		if item.SourceID == id {
-			delete(m.itemsByID, itemID)
-		}
+	feed, ok := m.feeds[id]
+	if !ok || feed.DeletedAt != nil {
+		return nil
 	}
-	delete(m.feeds, id)
-	return nil
+	now := time.Now().UTC()
+	m.deleteSource(id, now)
+	return m.logWrite(memoryLogRecord{Op: opDeleteSource, ID: id, Time: &now})
 }
 
-func (m *MemoryFeedStore) StoreItem(sourceID string, item *rsscollector.FeedItem) error {
-	defer m.Unlock()
-	m.Lock()
-	if len(item.ID) > 0 {
-		m.itemsByID[item.ID] = *item
-		for idx := range m.items[sourceID] {
-			if m.items[sourceID][idx].ID == item.ID {
-				m.items[sourceID][idx] = item
-			}
+// deleteSource must be called with the write lock held.
+func (m *MemoryFeedStore) deleteSource(id string, deletedAt time.Time) {
+	feed, ok := m.feeds[id]
+	if !ok || feed.DeletedAt != nil {
+		return
+	}
+	feed.DeletedAt = &deletedAt
+	m.feeds[feed.ID] = feed
+	for _, itemID := range m.items[id] {
+		m.trashItem(itemID, deletedAt)
+	}
+}
+
+func (m *MemoryFeedStore) StoreItem(ctx context.Context, sourceID string, item *rsscollector.FeedItem) error {
+	return m.StoreItems(ctx, sourceID, []*rsscollector.FeedItem{item})
+}
+
+// storeItem must be called with the write lock held.
+//
+// An item without an ID is matched by GUID against the items already stored
+// for the source. When there is a match the stored item is updated in place,
+// keeping its ID and whether it is starred and gaining any new CategoryIDs.
+func (m *MemoryFeedStore) storeItem(sourceID string, item *rsscollector.FeedItem) error {
+	item.SourceID = sourceID
+	if len(item.ID) == 0 && len(item.GUID) > 0 {
+		if existingID, ok := m.itemIDsByGUID[sourceID][item.GUID]; ok {
+			item.ID = existingID
+			item.CategoryIDs = mergeStrings(m.itemsByID[existingID].CategoryIDs, item.CategoryIDs)
+			item.Starred = m.itemsByID[existingID].Starred
 		}
-		return nil
 	}
-	id, err := uuid.NewRandom()
-	if err != nil {
-		return err
+	if len(item.ID) == 0 {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		item.ID = id.String()
 	}
-	item.ID = id.String()
-	if _, ok := m.itemsByID[sourceID]; ok {
-		m.items[sourceID] = append(m.items[sourceID], item)
-		m.itemsByID[item.ID] = *item
-		return nil
+
+	stored := copyItem(*item)
+	stored.DeletedAt = nil
+	if previous, ok := m.itemsByID[item.ID]; ok {
+		// Storing an item never moves it in or out of the trash, nor drops its
+		// hidden links to deleted categories.
+		stored.DeletedAt = previous.DeletedAt
+		stored.CategoryIDs = mergeStrings(stored.CategoryIDs, m.trashedCategoryIDs(previous.CategoryIDs))
+		m.unindexItem(previous)
 	}
-	m.items[sourceID] = []*rsscollector.FeedItem{item}
-	m.itemsByID[item.ID] = *item
+	m.items[sourceID] = append(m.items[sourceID], item.ID)
+	if len(item.GUID) > 0 {
+		if _, ok := m.itemIDsByGUID[sourceID]; !ok {
+			m.itemIDsByGUID[sourceID] = make(map[string]string)
+		}
+		m.itemIDsByGUID[sourceID][item.GUID] = item.ID
+	}
+	m.itemsByID[item.ID] = stored
 	return nil
 }
 
-func (m *MemoryFeedStore) FetchItemByID(id string) (rsscollector.FeedItem, error) {
+// unindexItem removes the item from the per source indexes. It must be called
+// with the write lock held.
+func (m *MemoryFeedStore) unindexItem(item rsscollector.FeedItem) {
+	m.items[item.SourceID] = removeString(m.items[item.SourceID], item.ID)
+	if m.itemIDsByGUID[item.SourceID][item.GUID] == item.ID {
+		delete(m.itemIDsByGUID[item.SourceID], item.GUID)
+	}
+}
+
+func (m *MemoryFeedStore) FetchItemByID(ctx context.Context, id string) (rsscollector.FeedItem, error) {
 	defer m.RUnlock()
 	m.RLock()
-	if item, ok := m.itemsByID[id]; ok {
-		return item, nil
+	if item, ok := m.itemsByID[id]; ok && item.DeletedAt == nil {
+		return m.liveItem(item), nil
 	}
 	return rsscollector.FeedItem{}, fmt.Errorf("no feed item found with id: %s", id)
 }
 
-func (m *MemoryFeedStore) StoreItems(sourceID string, items []*rsscollector.FeedItem) error {
+func (m *MemoryFeedStore) StoreItems(ctx context.Context, sourceID string, items []*rsscollector.FeedItem) error {
 	defer m.Unlock()
 	m.Lock()
 
-	for idx := range items {
-		id, err := uuid.NewRandom()
-		if err != nil {
+	for i, item := range items {
+		if err := m.storeItem(sourceID, item); err != nil {
+			// The items already stored are kept so are logged too.
+			if logErr := m.logWrite(memoryLogRecord{Op: opStoreItems, SourceID: sourceID, Items: items[:i]}); logErr != nil {
+				return logErr
+			}
 			return err
 		}
-		items[idx].ID = id.String()
-		items[idx].SourceID = sourceID
-		m.itemsByID[id.String()] = *items[idx]
 	}
-	m.items[sourceID] = items
-	return nil
+	// The items now hold their IDs and the values merged from any they
+	// updated, so replaying them stores the same items.
+	return m.logWrite(memoryLogRecord{Op: opStoreItems, SourceID: sourceID, Items: items})
 }
 
-func (m *MemoryFeedStore) FetchAllItems(options rsscollector.ItemOptions) (rsscollector.FeedItems, error) {
+func (m *MemoryFeedStore) FetchAllItems(ctx context.Context, options rsscollector.ItemOptions) (rsscollector.FeedItems, error) {
 	defer m.RUnlock()
 	m.RLock()
 
 	results := make(rsscollector.FeedItems, 0)
-	for sourceID, storedItems := range m.items {
+	for sourceID, itemIDs := range m.items {
 		if len(options.SourceID) > 0 {
 			if sourceID != options.SourceID {
 				continue
 			}
 		}
-		items := make(rsscollector.FeedItems, 0)
-		for _, storedItem := range storedItems {
+		for _, itemID := range itemIDs {
+			if (m.itemsByID[itemID].DeletedAt != nil) != options.Deleted {
+				continue
+			}
+			storedItem := m.liveItem(m.itemsByID[itemID])
+			if len(options.EnclosureType) > 0 && !storedItem.HasEnclosure(options.EnclosureType) {
+				continue
+			}
 			if len(options.CategoryIDs) > 0 {
-				var hasRequestedCategories bool
-				for _, categoryID := range options.CategoryIDs {
-					for _, itemCategoryID := range storedItem.CategoryIDs {
-						if itemCategoryID == categoryID {
-							hasRequestedCategories = true
-						}
-					}
-				}
-				if hasRequestedCategories {
-					items = append(items, storedItem)
+				if hasAnyString(storedItem.CategoryIDs, options.CategoryIDs) {
+					results = append(results, &storedItem)
 				}
 			} else {
-				items = append(items, storedItem)
+				results = append(results, &storedItem)
 			}
 		}
-		results = append(results, items...)
 	}
 
-	if len(results) > 0 {
-		sort.Sort(results)
-		return results, nil
-	}
+	sort.Sort(results)
+	return results, nil
+}
 
-	return nil, fmt.Errorf("no items found for ItemOptions: %v", options)
+func (m *MemoryFeedStore) DeleteItemByID(ctx context.Context, id string) error {
+	return m.DeleteItemsByID(ctx, []string{id})
 }
 
-func (m *MemoryFeedStore) DeleteItemByID(id string) error {
+func (m *MemoryFeedStore) DeleteItemsByID(ctx context.Context, ids []string) error {
 	defer m.Unlock()
 	m.Lock()
-	if _, ok := m.itemsByID[id]; ok {
-		delete(m.itemsByID, id)
+	now := time.Now().UTC()
+	for _, id := range ids {
+		m.trashItem(id, now)
 	}
-	for sourceID, sourceItems := range m.items {
-		for idx, sourceItem := range sourceItems {
-			if sourceItem.ID == id {
-				m.items[sourceID][idx] = nil
-			}
-		}
+	return m.logWrite(memoryLogRecord{Op: opDeleteItems, IDs: ids, Time: &now})
+}
+
+// trashItem marks the item as deleted unless it already is. It must be
+// called with the write lock held.
+func (m *MemoryFeedStore) trashItem(id string, deletedAt time.Time) {
+	item, ok := m.itemsByID[id]
+	if !ok || item.DeletedAt != nil {
+		return
+	}
+	item.DeletedAt = &deletedAt
+	m.itemsByID[item.ID] = item
+}
+
+// purgeItem removes the item entirely. It must be called with the write lock
+// held.
+func (m *MemoryFeedStore) purgeItem(id string) {
+	if item, ok := m.itemsByID[id]; ok {
+		delete(m.itemsByID, id)
+		m.unindexItem(item)
 	}
-	return nil
 }
 
-func (m *MemoryFeedStore) StoreCategory(category *rsscollector.FeedCategory) error {
+func (m *MemoryFeedStore) StoreCategory(ctx context.Context, category *rsscollector.FeedCategory) error {
 	defer m.Unlock()
 	m.Lock()
+	// A trashed category keeps its name out of the way of live ones. Like the
+	// URLs of feeds, names aren't checked when replaying the log.
+	if _, trashed := m.trashedCategories[category.ID]; !trashed {
+		if id, ok := m.categoriesByName[category.Name]; ok && id != category.ID {
+			return DuplicateCategoryError{Name: category.Name, ID: id}
+		}
+	}
+	if err := m.storeCategory(category); err != nil {
+		return err
+	}
+	return m.logWrite(memoryLogRecord{Op: opStoreCategory, Category: category})
+}
+
+// storeCategory must be called with the write lock held.
+func (m *MemoryFeedStore) storeCategory(category *rsscollector.FeedCategory) error {
+	if len(category.ID) == 0 {
+		u, err := uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		category.ID = u.String()
+	}
+	if trashed, ok := m.trashedCategories[category.ID]; ok {
+		trashed.Name = category.Name
+		m.trashedCategories[trashed.ID] = trashed
+		return nil
+	}
+	if previousName, ok := m.categoriesByID[category.ID]; ok {
+		delete(m.categoriesByName, previousName)
+	}
 	m.categoriesByID[category.ID] = category.Name
 	m.categoriesByName[category.Name] = category.ID
 	return nil
 }
 
-func (m *MemoryFeedStore) FetchCategoryByID(id string) (rsscollector.FeedCategory, error) {
+func (m *MemoryFeedStore) FetchCategoryByID(ctx context.Context, id string) (rsscollector.FeedCategory, error) {
 	defer m.RUnlock()
 	m.RLock()
 	if _, ok := m.categoriesByID[id]; !ok {
@@ -206,7 +357,7 @@ func (m *MemoryFeedStore) FetchCategoryByID(id string) (rsscollector.FeedCategor
 	return category, nil
 }
 
-func (m *MemoryFeedStore) FetchCategoryByName(name string) (rsscollector.FeedCategory, error) {
+func (m *MemoryFeedStore) FetchCategoryByName(ctx context.Context, name string) (rsscollector.FeedCategory, error) {
 	defer m.RUnlock()
 	m.RLock()
 	if id, ok := m.categoriesByName[name]; ok {
@@ -218,38 +369,34 @@ func (m *MemoryFeedStore) FetchCategoryByName(name string) (rsscollector.FeedCat
 	return rsscollector.FeedCategory{}, fmt.Errorf("no category found with name: %s", name)
 }
 
-func (m *MemoryFeedStore) DeleteCategoryByID(id string) error {
+// DeleteCategoryByID moves the category to the trash. Its links to items and
+// sources are kept, though hidden, so that they return if it is restored.
+func (m *MemoryFeedStore) DeleteCategoryByID(ctx context.Context, id string) error {
 	defer m.Unlock()
 	m.Lock()
+	if _, ok := m.categoriesByID[id]; !ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	m.deleteCategory(id, now)
+	return m.logWrite(memoryLogRecord{Op: opDeleteCategory, ID: id, Time: &now})
+}
+
+// deleteCategory must be called with the write lock held.
+func (m *MemoryFeedStore) deleteCategory(id string, deletedAt time.Time) {
 	if categoryName, ok := m.categoriesByID[id]; ok {
-		for _, v := range m.itemsByID {
-			for idx, itemCategory := range v.CategoryIDs {
-				if itemCategory == id {
-					v.CategoryIDs[idx] = ""
-				}
-			}
-		}
-		for _, sourceItems := range m.items {
-			for _, sourceItem := range sourceItems {
-				for idx, itemCategory := range sourceItem.CategoryIDs {
-					if itemCategory == id {
-						sourceItem.CategoryIDs[idx] = ""
-					}
-				}
-			}
-		}
-		if _, ok := m.categoriesByName[categoryName]; ok {
-			delete(m.categoriesByName, categoryName)
-		}
+		// The stored ID is kept as the key as id may be reused by the caller.
+		storedID := m.categoriesByName[categoryName]
+		m.trashedCategories[storedID] = rsscollector.FeedCategory{ID: storedID, Name: categoryName, DeletedAt: &deletedAt}
+		delete(m.categoriesByName, categoryName)
 		delete(m.categoriesByID, id)
 	}
-	return nil
 }
 
-func (m *MemoryFeedStore) FetchCategoriesForIDs(ids []string) ([]rsscollector.FeedCategory, error) {
+func (m *MemoryFeedStore) FetchCategoriesForIDs(ctx context.Context, ids []string) ([]rsscollector.FeedCategory, error) {
 	categories := make([]rsscollector.FeedCategory, 0)
 	for _, v := range ids {
-		category, err := m.FetchCategoryByID(v)
+		category, err := m.FetchCategoryByID(ctx, v)
 		if err != nil {
 			return nil, err
 		}
@@ -258,7 +405,7 @@ func (m *MemoryFeedStore) FetchCategoriesForIDs(ids []string) ([]rsscollector.Fe
 	return categories, nil
 }
 
-func (m *MemoryFeedStore) FetchAllCategories() ([]rsscollector.FeedCategory, error) {
+func (m *MemoryFeedStore) FetchAllCategories(ctx context.Context) ([]rsscollector.FeedCategory, error) {
 	defer m.RUnlock()
 	m.RLock()
 	categories := make([]rsscollector.FeedCategory, 0)
@@ -270,3 +417,341 @@ func (m *MemoryFeedStore) FetchAllCategories() ([]rsscollector.FeedCategory, err
 	}
 	return categories, nil
 }
+
+func (m *MemoryFeedStore) FetchTrash(ctx context.Context) (rsscollector.Trash, error) {
+	defer m.RUnlock()
+	m.RLock()
+	trash := rsscollector.Trash{
+		Feeds:      make([]rsscollector.FeedSourcePartial, 0),
+		Items:      make(rsscollector.FeedItems, 0),
+		Categories: make([]rsscollector.FeedCategory, 0),
+	}
+	for _, feed := range m.feeds {
+		if feed.DeletedAt != nil {
+			trash.Feeds = append(trash.Feeds, rsscollector.NewFeedSourcePartial(m.copySource(feed)))
+		}
+	}
+	for _, item := range m.itemsByID {
+		if item.DeletedAt != nil && m.feeds[item.SourceID].DeletedAt == nil {
+			trashed := m.liveItem(item)
+			trash.Items = append(trash.Items, &trashed)
+		}
+	}
+	sort.Sort(trash.Items)
+	for _, category := range m.trashedCategories {
+		trash.Categories = append(trash.Categories, category)
+	}
+	return trash, nil
+}
+
+func (m *MemoryFeedStore) RestoreSource(ctx context.Context, feedID string) error {
+	defer m.Unlock()
+	m.Lock()
+	// A feed can't be restored while another has its URL.
+	if feed, ok := m.feeds[feedID]; ok && feed.DeletedAt != nil {
+		if err := checkStoredURL(&feed, "", m.liveSources()); err != nil {
+			return err
+		}
+	}
+	if err := m.restoreSource(feedID); err != nil {
+		return err
+	}
+	return m.logWrite(memoryLogRecord{Op: opRestoreSource, ID: feedID})
+}
+
+// restoreSource must be called with the write lock held.
+func (m *MemoryFeedStore) restoreSource(feedID string) error {
+	feed, ok := m.feeds[feedID]
+	if !ok || feed.DeletedAt == nil {
+		return fmt.Errorf("no deleted feed found with id: %s", feedID)
+	}
+	deletedAt := *feed.DeletedAt
+	feed.DeletedAt = nil
+	m.feeds[feed.ID] = feed
+	for _, itemID := range m.items[feedID] {
+		item := m.itemsByID[itemID]
+		if item.DeletedAt != nil && item.DeletedAt.Equal(deletedAt) {
+			item.DeletedAt = nil
+			m.itemsByID[itemID] = item
+		}
+	}
+	return nil
+}
+
+func (m *MemoryFeedStore) RestoreItem(ctx context.Context, id string) error {
+	defer m.Unlock()
+	m.Lock()
+	if err := m.restoreItem(id); err != nil {
+		return err
+	}
+	return m.logWrite(memoryLogRecord{Op: opRestoreItem, ID: id})
+}
+
+// restoreItem must be called with the write lock held.
+func (m *MemoryFeedStore) restoreItem(id string) error {
+	item, ok := m.itemsByID[id]
+	if !ok || item.DeletedAt == nil {
+		return fmt.Errorf("no deleted item found with id: %s", id)
+	}
+	if m.feeds[item.SourceID].DeletedAt != nil {
+		return fmt.Errorf("the feed of item %s is deleted, restore it first", id)
+	}
+	item.DeletedAt = nil
+	m.itemsByID[item.ID] = item
+	return nil
+}
+
+func (m *MemoryFeedStore) RestoreCategory(ctx context.Context, id string) error {
+	defer m.Unlock()
+	m.Lock()
+	if err := m.restoreCategory(id); err != nil {
+		return err
+	}
+	return m.logWrite(memoryLogRecord{Op: opRestoreCategory, ID: id})
+}
+
+// restoreCategory must be called with the write lock held.
+func (m *MemoryFeedStore) restoreCategory(id string) error {
+	category, ok := m.trashedCategories[id]
+	if !ok {
+		return fmt.Errorf("no deleted category found with id: %s", id)
+	}
+	if existing, ok := m.categoriesByName[category.Name]; ok {
+		return DuplicateCategoryError{Name: category.Name, ID: existing}
+	}
+	delete(m.trashedCategories, id)
+	m.categoriesByID[category.ID] = category.Name
+	m.categoriesByName[category.Name] = category.ID
+	return nil
+}
+
+// PurgeTrash removes the feeds, with all of their items, the items and the
+// categories, with their links, deleted before deletedBefore.
+func (m *MemoryFeedStore) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int, error) {
+	defer m.Unlock()
+	m.Lock()
+	purged := m.purgeTrash(deletedBefore)
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, m.logWrite(memoryLogRecord{Op: opPurgeTrash, Time: &deletedBefore})
+}
+
+// purgeTrash must be called with the write lock held.
+func (m *MemoryFeedStore) purgeTrash(deletedBefore time.Time) int {
+	purged := 0
+	for feedID, feed := range m.feeds {
+		if feed.DeletedAt == nil || !feed.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		for _, itemID := range m.items[feedID] {
+			delete(m.itemsByID, itemID)
+			purged++
+		}
+		delete(m.items, feedID)
+		delete(m.itemIDsByGUID, feedID)
+		delete(m.feeds, feedID)
+		purged++
+	}
+	for itemID, item := range m.itemsByID {
+		if item.DeletedAt != nil && item.DeletedAt.Before(deletedBefore) {
+			m.purgeItem(itemID)
+			purged++
+		}
+	}
+	for id, category := range m.trashedCategories {
+		if !category.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		for itemID, item := range m.itemsByID {
+			item.CategoryIDs = removeString(item.CategoryIDs, id)
+			m.itemsByID[itemID] = item
+		}
+		for feedID, feed := range m.feeds {
+			feed.CategoryIDs = removeString(feed.CategoryIDs, id)
+			m.feeds[feedID] = feed
+		}
+		delete(m.trashedCategories, id)
+		purged++
+	}
+	return purged
+}
+
+// Count the feeds, items and categories stored, excluding those in the trash.
+func (m *MemoryFeedStore) Count(ctx context.Context) (metrics.Totals, error) {
+	m.RLock()
+	defer m.RUnlock()
+	totals := metrics.Totals{Categories: len(m.categoriesByID)}
+	for _, feed := range m.feeds {
+		if feed.DeletedAt == nil {
+			totals.Feeds++
+		}
+	}
+	for _, item := range m.itemsByID {
+		if item.DeletedAt == nil {
+			totals.Items++
+		}
+	}
+	return totals, nil
+}
+
+// copySource takes a copy of the source without links to deleted
+// categories. It must be called with the read lock held.
+func (m *MemoryFeedStore) copySource(source rsscollector.FeedSource) rsscollector.FeedSource {
+	source.CategoryIDs = m.liveCategoryIDs(source.CategoryIDs)
+	source.FeedMetadata = copyMetadata(source.FeedMetadata)
+	source.Scrape = copyScrape(source.Scrape)
+	source.Fetch = copyFetch(source.Fetch)
+	source.URLHistory = copyURLHistory(source.URLHistory)
+	source.PrunedGUIDs = copyStrings(source.PrunedGUIDs)
+	return source
+}
+
+// copyMetadata takes a copy of the metadata that does not share the image or
+// authors.
+func copyMetadata(metadata rsscollector.FeedMetadata) rsscollector.FeedMetadata {
+	if metadata.Image != nil {
+		image := *metadata.Image
+		metadata.Image = &image
+	}
+	if metadata.Authors != nil {
+		metadata.Authors = append(make([]rsscollector.FeedAuthor, 0, len(metadata.Authors)), metadata.Authors...)
+	}
+	return metadata
+}
+
+// copyScrape takes a copy of the selectors of a scraped source.
+func copyScrape(selectors *rsscollector.ScrapeSelectors) *rsscollector.ScrapeSelectors {
+	if selectors == nil {
+		return nil
+	}
+	copied := *selectors
+	return &copied
+}
+
+// copyFetch takes a copy of the fetch settings of a source that does not
+// share their headers, cookies or TLS settings.
+func copyFetch(settings *rsscollector.FetchSettings) *rsscollector.FetchSettings {
+	if settings == nil {
+		return nil
+	}
+	copied := *settings
+	copied.Headers = copyStringMap(settings.Headers)
+	copied.Cookies = copyStringMap(settings.Cookies)
+	if settings.TLS != nil {
+		tls := *settings.TLS
+		copied.TLS = &tls
+	}
+	return &copied
+}
+
+// copyURLHistory takes a copy of the URLs a source had.
+func copyURLHistory(history []rsscollector.FeedURLChange) []rsscollector.FeedURLChange {
+	if history == nil {
+		return nil
+	}
+	return append(make([]rsscollector.FeedURLChange, 0, len(history)), history...)
+}
+
+// liveItem takes a copy of the item without links to deleted categories. It
+// must be called with the read lock held.
+func (m *MemoryFeedStore) liveItem(item rsscollector.FeedItem) rsscollector.FeedItem {
+	item = copyItem(item)
+	item.CategoryIDs = m.liveCategoryIDs(item.CategoryIDs)
+	return item
+}
+
+func (m *MemoryFeedStore) trashedCategoryIDs(ids []string) []string {
+	var trashed []string
+	for _, id := range ids {
+		if _, ok := m.trashedCategories[id]; ok {
+			trashed = append(trashed, id)
+		}
+	}
+	return trashed
+}
+
+func (m *MemoryFeedStore) liveCategoryIDs(ids []string) []string {
+	if ids == nil {
+		return nil
+	}
+	live := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := m.trashedCategories[id]; !ok {
+			live = append(live, id)
+		}
+	}
+	return live
+}
+
+// copyItem takes a copy of the item that does not share the category ID,
+// enclosure or media details so callers can't modify stored items through the
+// returned value.
+func copyItem(item rsscollector.FeedItem) rsscollector.FeedItem {
+	item.CategoryIDs = copyStrings(item.CategoryIDs)
+	if item.Enclosures != nil {
+		item.Enclosures = append(make([]rsscollector.FeedItemEnclosure, 0, len(item.Enclosures)), item.Enclosures...)
+	}
+	if item.Media != nil {
+		media := *item.Media
+		if media.Thumbnails != nil {
+			media.Thumbnails = append(make([]rsscollector.FeedItemMediaThumbnail, 0, len(media.Thumbnails)), media.Thumbnails...)
+		}
+		item.Media = &media
+	}
+	return item
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// removeString returns a copy of list without any occurrences of s.
+func removeString(list []string, s string) []string {
+	if list == nil {
+		return nil
+	}
+	results := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != s {
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+// mergeStrings returns the values of a followed by any in b not already in a.
+func mergeStrings(a, b []string) []string {
+	results := copyStrings(a)
+	for _, v := range b {
+		if !hasAnyString(results, []string{v}) {
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+func hasAnyString(list, wanted []string) bool {
+	for _, w := range wanted {
+		for _, v := range list {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
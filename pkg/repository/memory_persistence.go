@@ -0,0 +1,399 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// A persistent MemoryFeedStore keeps a snapshot of its maps and a write log
+// of the changes made since in its directory. Each change is appended to the
+// log as it is made, while the write lock is still held so the log is in the
+// order the changes were made, and the log is emptied whenever a snapshot is
+// written. Opening the store loads the snapshot and replays the log.
+//
+// Changes are numbered so that those already in a snapshot are skipped, should
+// the process stop between writing the snapshot and emptying the log. A change
+// that was being appended when the process stopped is discarded.
+
+const (
+	memorySnapshotFile = "snapshot.json"
+	memoryLogFile      = "writes.log"
+)
+
+// memorySnapshotVersion is the version of the snapshot format written.
+const memorySnapshotVersion = 1
+
+// MemoryStoreConfig of a persistent MemoryFeedStore.
+type MemoryStoreConfig struct {
+	// Dir holds the snapshot and write log, it is created if need be.
+	Dir string
+	// SnapshotInterval between snapshots, which bounds the size of the write
+	// log. Zero only writes a snapshot when the store is closed.
+	SnapshotInterval time.Duration
+	// SyncWrites flushes every change to disk before it is acknowledged.
+	// Otherwise changes survive the process stopping but those made since
+	// the last snapshot may be lost if the machine does.
+	SyncWrites bool
+}
+
+// memoryPersistence holds the state of a persistent MemoryFeedStore.
+type memoryPersistence struct {
+	config MemoryStoreConfig
+	// log is only written with the store's write lock held, or its read lock
+	// and snapshotMu held when it is emptied.
+	log *os.File
+	// seq is the number of the last change made.
+	seq        uint64
+	snapshotMu sync.Mutex
+	stop       chan struct{}
+	running    sync.WaitGroup
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+// The operations recorded in the write log.
+const (
+	opStoreSource     = "storeSource"
+	opDeleteSource    = "deleteSource"
+	opStoreItems      = "storeItems"
+	opDeleteItems     = "deleteItems"
+	opStoreCategory   = "storeCategory"
+	opDeleteCategory  = "deleteCategory"
+	opRestoreSource   = "restoreSource"
+	opRestoreItem     = "restoreItem"
+	opRestoreCategory = "restoreCategory"
+	opPurgeTrash      = "purgeTrash"
+)
+
+// memoryLogRecord is a single change in the write log. Time is the time the
+// objects were deleted, or purged before, so replaying the change doesn't
+// depend on the clock.
+type memoryLogRecord struct {
+	Seq      uint64                     `json:"seq"`
+	Op       string                     `json:"op"`
+	ID       string                     `json:"id,omitempty"`
+	IDs      []string                   `json:"ids,omitempty"`
+	SourceID string                     `json:"sourceId,omitempty"`
+	Time     *time.Time                 `json:"time,omitempty"`
+	Source   *rsscollector.FeedSource   `json:"source,omitempty"`
+	Items    []*rsscollector.FeedItem   `json:"items,omitempty"`
+	Category *rsscollector.FeedCategory `json:"category,omitempty"`
+}
+
+// memorySnapshot holds every map of the store, other than categoriesByName
+// which is rebuilt from categoriesByID, along with the number of the last
+// change it includes.
+type memorySnapshot struct {
+	Version           int                                  `json:"version"`
+	Seq               uint64                               `json:"seq"`
+	Feeds             map[string]rsscollector.FeedSource   `json:"feeds"`
+	Items             map[string][]string                  `json:"items"`
+	ItemsByID         map[string]rsscollector.FeedItem     `json:"itemsById"`
+	ItemIDsByGUID     map[string]map[string]string         `json:"itemIdsByGuid"`
+	Categories        map[string]string                    `json:"categories"`
+	TrashedCategories map[string]rsscollector.FeedCategory `json:"trashedCategories"`
+}
+
+// NewPersistentMemoryStore opens a MemoryFeedStore that survives restarts,
+// recovering what was stored in cfg.Dir. It must be closed to stop taking
+// snapshots and to write the final one.
+func NewPersistentMemoryStore(cfg MemoryStoreConfig) (*MemoryFeedStore, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	m := NewMemoryStore()
+	p := &memoryPersistence{config: cfg, stop: make(chan struct{})}
+	if err := m.loadSnapshot(p); err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(cfg.Dir, memoryLogFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := m.replayLog(p, logFile)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	p.log = logFile
+	m.persistence = p
+	log.Info().Str("dir", cfg.Dir).Int("replayed", replayed).Msg("recovered memory store")
+
+	if cfg.SnapshotInterval > 0 {
+		p.running.Add(1)
+		go m.runSnapshots(cfg.SnapshotInterval)
+	}
+	return m, nil
+}
+
+// loadSnapshot into the empty store, if there is one.
+func (m *MemoryFeedStore) loadSnapshot(p *memoryPersistence) error {
+	f, err := os.Open(filepath.Join(p.config.Dir, memorySnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snapshot memorySnapshot
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&snapshot); err != nil {
+		return fmt.Errorf("invalid memory store snapshot: %w", err)
+	}
+	if snapshot.Version != memorySnapshotVersion {
+		return fmt.Errorf("unsupported memory store snapshot version %d", snapshot.Version)
+	}
+
+	p.seq = snapshot.Seq
+	if snapshot.Feeds != nil {
+		m.feeds = snapshot.Feeds
+	}
+	if snapshot.Items != nil {
+		m.items = snapshot.Items
+	}
+	if snapshot.ItemsByID != nil {
+		m.itemsByID = snapshot.ItemsByID
+	}
+	if snapshot.ItemIDsByGUID != nil {
+		m.itemIDsByGUID = snapshot.ItemIDsByGUID
+	}
+	if snapshot.Categories != nil {
+		m.categoriesByID = snapshot.Categories
+	}
+	if snapshot.TrashedCategories != nil {
+		m.trashedCategories = snapshot.TrashedCategories
+	}
+	for id, name := range m.categoriesByID {
+		m.categoriesByName[name] = id
+	}
+	return nil
+}
+
+// replayLog applies the changes in the write log made since the snapshot,
+// returning the number applied. A final record without its line ending was
+// being written when the process stopped and is removed from the log.
+func (m *MemoryFeedStore) replayLog(p *memoryPersistence, logFile *os.File) (int, error) {
+	reader := bufio.NewReader(logFile)
+	var offset int64
+	replayed := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Warn().Int64("offset", offset).Msg("discarding incomplete write at the end of the memory store log")
+				if err := logFile.Truncate(offset); err != nil {
+					return replayed, err
+				}
+			}
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		var record memoryLogRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return replayed, fmt.Errorf("invalid memory store log record at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+		if record.Seq <= p.seq {
+			continue
+		}
+		if err := m.apply(record); err != nil {
+			return replayed, fmt.Errorf("failed to replay memory store log record %d: %w", record.Seq, err)
+		}
+		p.seq = record.Seq
+		replayed++
+	}
+}
+
+// apply a change from the write log. It must be called with the write lock
+// held, or before the store is shared.
+func (m *MemoryFeedStore) apply(record memoryLogRecord) error {
+	switch record.Op {
+	case opStoreSource:
+		if record.Source == nil {
+			return errors.New("no source")
+		}
+		return m.storeSource(record.Source)
+	case opDeleteSource:
+		if record.Time == nil {
+			return errors.New("no time")
+		}
+		m.deleteSource(record.ID, *record.Time)
+	case opStoreItems:
+		for _, item := range record.Items {
+			if err := m.storeItem(record.SourceID, item); err != nil {
+				return err
+			}
+		}
+	case opDeleteItems:
+		if record.Time == nil {
+			return errors.New("no time")
+		}
+		for _, id := range record.IDs {
+			m.trashItem(id, *record.Time)
+		}
+	case opStoreCategory:
+		if record.Category == nil {
+			return errors.New("no category")
+		}
+		return m.storeCategory(record.Category)
+	case opDeleteCategory:
+		if record.Time == nil {
+			return errors.New("no time")
+		}
+		m.deleteCategory(record.ID, *record.Time)
+	case opRestoreSource:
+		return m.restoreSource(record.ID)
+	case opRestoreItem:
+		return m.restoreItem(record.ID)
+	case opRestoreCategory:
+		return m.restoreCategory(record.ID)
+	case opPurgeTrash:
+		if record.Time == nil {
+			return errors.New("no time")
+		}
+		m.purgeTrash(*record.Time)
+	default:
+		return fmt.Errorf("unknown operation %q", record.Op)
+	}
+	return nil
+}
+
+// logWrite appends a change to the write log of a persistent store. It must
+// be called with the write lock held, once the change has been made. Should
+// it fail, the change is kept in memory but may be lost on restart.
+func (m *MemoryFeedStore) logWrite(record memoryLogRecord) error {
+	p := m.persistence
+	if p == nil {
+		return nil
+	}
+	record.Seq = p.seq + 1
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := p.log.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to log write to memory store: %w", err)
+	}
+	p.seq = record.Seq
+	if p.config.SyncWrites {
+		if err := p.log.Sync(); err != nil {
+			return fmt.Errorf("failed to sync memory store log: %w", err)
+		}
+	}
+	return nil
+}
+
+// Snapshot writes the maps of a persistent store to disk and empties its
+// write log. Changes wait for the snapshot to be written. It does nothing
+// unless the store is persistent.
+func (m *MemoryFeedStore) Snapshot() error {
+	p := m.persistence
+	if p == nil {
+		return nil
+	}
+	p.snapshotMu.Lock()
+	defer p.snapshotMu.Unlock()
+	m.RLock()
+	defer m.RUnlock()
+
+	snapshot := memorySnapshot{
+		Version:           memorySnapshotVersion,
+		Seq:               p.seq,
+		Feeds:             m.feeds,
+		Items:             m.items,
+		ItemsByID:         m.itemsByID,
+		ItemIDsByGUID:     m.itemIDsByGUID,
+		Categories:        m.categoriesByID,
+		TrashedCategories: m.trashedCategories,
+	}
+
+	// The snapshot replaces the previous one only once it is complete.
+	path := filepath.Join(p.config.Dir, memorySnapshotFile)
+	tmp, err := os.CreateTemp(p.config.Dir, memorySnapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	buffered := bufio.NewWriter(tmp)
+	if err := json.NewEncoder(buffered).Encode(snapshot); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if err := p.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to empty memory store log: %w", err)
+	}
+	return nil
+}
+
+// runSnapshots takes a snapshot every interval until the store is closed.
+func (m *MemoryFeedStore) runSnapshots(interval time.Duration) {
+	p := m.persistence
+	defer p.running.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := m.Snapshot(); err != nil {
+				log.Error().Err(err).Msg("failed to snapshot memory store")
+			}
+		}
+	}
+}
+
+// Close a persistent store, writing a final snapshot. The store must not be
+// changed once closed.
+func (m *MemoryFeedStore) Close() error {
+	p := m.persistence
+	if p == nil {
+		return nil
+	}
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		p.running.Wait()
+		if err := m.Snapshot(); err != nil {
+			p.closeErr = err
+			p.log.Close()
+			return
+		}
+		p.closeErr = p.log.Close()
+	})
+	return p.closeErr
+}
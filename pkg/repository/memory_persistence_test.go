@@ -0,0 +1,201 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/repository/repositorytest"
+)
+
+func TestPersistentMemoryFeedStore(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Store {
+		store, err := repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{Dir: t.TempDir()})
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			assert.Nil(t, store.Close())
+		})
+		return store
+	})
+}
+
+// populate stores a feed in a category with two items, one of which is
+// deleted, returning the source and the items.
+func populate(t *testing.T, store repository.Store) (rsscollector.FeedSource, []*rsscollector.FeedItem) {
+	ctx := context.Background()
+	category := rsscollector.FeedCategory{Name: "News"}
+	require.Nil(t, store.StoreCategory(ctx, &category))
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{
+			FeedURL:     "http://example.com/feed.xml",
+			CategoryIDs: []string{category.ID},
+		},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	items := []*rsscollector.FeedItem{{GUID: "one", Starred: true}, {GUID: "two"}}
+	require.Nil(t, store.StoreItems(ctx, source.ID, items))
+	require.Nil(t, store.DeleteItemByID(ctx, items[1].ID))
+	return source, items
+}
+
+// assertRecovered checks that store holds what populate stored.
+func assertRecovered(t *testing.T, store repository.Store, source rsscollector.FeedSource, items []*rsscollector.FeedItem) {
+	ctx := context.Background()
+	fetched, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, source.FeedURL, fetched.FeedURL)
+	assert.Equal(t, source.CategoryIDs, fetched.CategoryIDs)
+
+	category, err := store.FetchCategoryByName(ctx, "News")
+	require.Nil(t, err)
+	assert.Equal(t, source.CategoryIDs, []string{category.ID})
+
+	item, err := store.FetchItemByID(ctx, items[0].ID)
+	require.Nil(t, err)
+	assert.True(t, item.Starred)
+
+	trash, err := store.FetchTrash(ctx)
+	require.Nil(t, err)
+	require.Len(t, trash.Items, 1)
+	assert.Equal(t, items[1].ID, trash.Items[0].ID)
+
+	// Collecting an item again still matches it by GUID.
+	again := rsscollector.FeedItem{GUID: "one"}
+	require.Nil(t, store.StoreItem(ctx, source.ID, &again))
+	assert.Equal(t, items[0].ID, again.ID)
+}
+
+func TestPersistentMemoryStoreRecovery(t *testing.T) {
+	testCases := []struct {
+		Name string
+		// stop the first store once populated, as the process would.
+		Stop func(t *testing.T, store *repository.MemoryFeedStore, dir string)
+	}{
+		{
+			"From the log after a crash",
+			func(t *testing.T, store *repository.MemoryFeedStore, dir string) {},
+		},
+		{
+			"From a snapshot after closing",
+			func(t *testing.T, store *repository.MemoryFeedStore, dir string) {
+				require.Nil(t, store.Close())
+				info, err := os.Stat(filepath.Join(dir, "writes.log"))
+				require.Nil(t, err)
+				assert.Zero(t, info.Size())
+			},
+		},
+		{
+			"From a snapshot and the log written since",
+			func(t *testing.T, store *repository.MemoryFeedStore, dir string) {
+				require.Nil(t, store.Snapshot())
+				require.Nil(t, store.RestoreItem(context.Background(), mustTrashedItem(t, store)))
+				require.Nil(t, store.DeleteItemsByID(context.Background(), []string{mustItemWithGUID(t, store, "two")}))
+			},
+		},
+		{
+			"From a snapshot with the log it includes",
+			func(t *testing.T, store *repository.MemoryFeedStore, dir string) {
+				log, err := os.ReadFile(filepath.Join(dir, "writes.log"))
+				require.Nil(t, err)
+				require.Nil(t, store.Snapshot())
+				// Replaying the changes in the snapshot again would fail to
+				// restore a category that isn't deleted.
+				require.Nil(t, os.WriteFile(filepath.Join(dir, "writes.log"), log, 0600))
+			},
+		},
+		{
+			"Discarding an incomplete write",
+			func(t *testing.T, store *repository.MemoryFeedStore, dir string) {
+				f, err := os.OpenFile(filepath.Join(dir, "writes.log"), os.O_WRONLY|os.O_APPEND, 0600)
+				require.Nil(t, err)
+				_, err = f.WriteString(`{"seq":99,"op":"storeSou`)
+				require.Nil(t, err)
+				require.Nil(t, f.Close())
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{Dir: dir})
+			require.Nil(t, err)
+			source, items := populate(t, store)
+			// A restored category is logged so replaying it twice would fail.
+			ctx := context.Background()
+			category := rsscollector.FeedCategory{Name: "Archive"}
+			require.Nil(t, store.StoreCategory(ctx, &category))
+			require.Nil(t, store.DeleteCategoryByID(ctx, category.ID))
+			require.Nil(t, store.RestoreCategory(ctx, category.ID))
+			tc.Stop(t, store, dir)
+
+			recovered, err := repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{Dir: dir})
+			require.Nil(t, err)
+			defer recovered.Close()
+			assertRecovered(t, recovered, source, items)
+			_, err = recovered.FetchCategoryByID(ctx, category.ID)
+			assert.Nil(t, err)
+
+			// Writes made after recovering are kept too.
+			require.Nil(t, recovered.DeleteSourceByID(ctx, source.ID))
+			again, err := repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{Dir: dir})
+			require.Nil(t, err)
+			defer again.Close()
+			_, err = again.FetchSource(ctx, source.ID)
+			assert.NotNil(t, err)
+			require.Nil(t, again.RestoreSource(ctx, source.ID))
+		})
+	}
+}
+
+func TestPersistentMemoryStoreSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	store, err := repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{
+		Dir:              dir,
+		SnapshotInterval: 10 * time.Millisecond,
+		SyncWrites:       true,
+	})
+	require.Nil(t, err)
+	defer store.Close()
+	populate(t, store)
+
+	assert.Eventually(t, func() bool {
+		info, err := os.Stat(filepath.Join(dir, "writes.log"))
+		return err == nil && info.Size() == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = os.Stat(filepath.Join(dir, "snapshot.json"))
+	assert.Nil(t, err)
+}
+
+func TestPersistentMemoryStoreCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "writes.log"), []byte("not json\n"), 0600))
+	_, err := repository.NewPersistentMemoryStore(repository.MemoryStoreConfig{Dir: dir})
+	assert.NotNil(t, err)
+}
+
+func mustTrashedItem(t *testing.T, store repository.Store) string {
+	trash, err := store.FetchTrash(context.Background())
+	require.Nil(t, err)
+	require.Len(t, trash.Items, 1)
+	return trash.Items[0].ID
+}
+
+func mustItemWithGUID(t *testing.T, store repository.Store, guid string) string {
+	items, err := store.FetchAllItems(context.Background(), rsscollector.ItemOptions{})
+	require.Nil(t, err)
+	for _, item := range items {
+		if item.GUID == guid {
+			return item.ID
+		}
+	}
+	t.Fatalf("no item with GUID %s", guid)
+	return ""
+}
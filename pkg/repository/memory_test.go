@@ -0,0 +1,20 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/repository/repositorytest"
+)
+
+func TestMemoryFeedStore(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewMemoryStore()
+	})
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewInstrumentedStore(repository.NewMemoryStore(), "memory")
+	})
+}
@@ -1,21 +1,28 @@
 package repository
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 
+	"github.com/JonPulfer/rss_collector/migrations"
 	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
 )
 
 const ConnectionRetryLimit = 20
@@ -23,32 +30,53 @@ const ConnectionRetryDelay = 3 * time.Second
 
 type PostgresDB struct {
 	conn *sql.DB
+	// migrationsDirectory the schema is checked against, the embedded
+	// migrations when it is empty.
+	migrationsDirectory string
 }
 
-func (p PostgresDB) StoreCategory(category *rsscollector.FeedCategory) error {
-	insertSql := `
-insert into categories (id, category_name) values($1, $2)
-on conflict do nothing;`
-
+func (p PostgresDB) StoreCategory(ctx context.Context, category *rsscollector.FeedCategory) error {
 	if len(category.ID) == 0 {
 		u, err := uuid.NewRandom()
 		if err != nil {
 			return err
 		}
 		category.ID = u.String()
-		_, err = p.conn.Exec(insertSql, category.ID, category.Name)
+	}
+
+	// A category with an unknown ID, such as one being restored from a
+	// backup, is created with it.
+	upsertSql := `
+insert into categories (id, category_name) values($1, $2)
+on conflict (id) do update set category_name = excluded.category_name;`
+	_, err := p.conn.ExecContext(ctx, upsertSql, category.ID, category.Name)
+	return p.categoryNameError(ctx, err, category.Name)
+}
+
+// categoryNameError reports a violation of the unique index on the names of
+// live categories as a DuplicateCategoryError.
+func (p PostgresDB) categoryNameError(ctx context.Context, err error, name string) error {
+	if !isUniqueViolation(err, "categories_category_name_idx") {
+		return err
+	}
+	selectSql := `select id from categories where category_name = $1 and deleted_at is null;`
+	var id string
+	if selectErr := p.conn.QueryRowContext(ctx, selectSql, name).Scan(&id); selectErr != nil {
 		return err
 	}
+	return DuplicateCategoryError{Name: name, ID: id}
+}
 
-	updateSql := `update categories set category_name = $2 where id = $1;`
-	_, err := p.conn.Exec(updateSql, category.ID, category.Name)
-	return err
+// isUniqueViolation reports whether err is a violation of the unique index.
+func isUniqueViolation(err error, index string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == index
 }
 
-func (p PostgresDB) FetchCategoryByID(id string) (rsscollector.FeedCategory, error) {
-	selectSql := `select category_name from categories where id = $1;`
+func (p PostgresDB) FetchCategoryByID(ctx context.Context, id string) (rsscollector.FeedCategory, error) {
+	selectSql := `select category_name from categories where id = $1 and deleted_at is null;`
 	var result rsscollector.FeedCategory
-	rows, err := p.conn.Query(selectSql, id)
+	rows, err := p.conn.QueryContext(ctx, selectSql, id)
 	if err != nil {
 		return rsscollector.FeedCategory{}, err
 	}
@@ -75,10 +103,10 @@ func (p PostgresDB) FetchCategoryByID(id string) (rsscollector.FeedCategory, err
 	return rsscollector.FeedCategory{}, fmt.Errorf("no category found with id: %s", id)
 }
 
-func (p PostgresDB) FetchCategoryByName(name string) (rsscollector.FeedCategory, error) {
-	selectSql := `select id from categories where category_name = $1;`
+func (p PostgresDB) FetchCategoryByName(ctx context.Context, name string) (rsscollector.FeedCategory, error) {
+	selectSql := `select id from categories where category_name = $1 and deleted_at is null;`
 	var result rsscollector.FeedCategory
-	rows, err := p.conn.Query(selectSql, name)
+	rows, err := p.conn.QueryContext(ctx, selectSql, name)
 	if err != nil {
 		return rsscollector.FeedCategory{}, err
 	}
@@ -105,14 +133,14 @@ func (p PostgresDB) FetchCategoryByName(name string) (rsscollector.FeedCategory,
 	return rsscollector.FeedCategory{}, fmt.Errorf("no category found with name: %s", name)
 }
 
-func (p PostgresDB) FetchCategoriesForIDs(ids []string) ([]rsscollector.FeedCategory, error) {
+func (p PostgresDB) FetchCategoriesForIDs(ctx context.Context, ids []string) ([]rsscollector.FeedCategory, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
 
-	selectSql := `select id, category_name from categories where id = ANY($1);`
+	selectSql := `select id, category_name from categories where id = ANY($1) and deleted_at is null;`
 	results := make([]rsscollector.FeedCategory, 0)
-	rows, err := p.conn.Query(selectSql, pq.Array(ids))
+	rows, err := p.conn.QueryContext(ctx, selectSql, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -139,9 +167,9 @@ func (p PostgresDB) FetchCategoriesForIDs(ids []string) ([]rsscollector.FeedCate
 	return nil, fmt.Errorf("no categories found with ids: %v", ids)
 }
 
-func (p PostgresDB) FetchAllCategories() ([]rsscollector.FeedCategory, error) {
-	selectSql := `select id, category_name from categories;`
-	rows, err := p.conn.Query(selectSql)
+func (p PostgresDB) FetchAllCategories(ctx context.Context) ([]rsscollector.FeedCategory, error) {
+	selectSql := `select id, category_name from categories where deleted_at is null;`
+	rows, err := p.conn.QueryContext(ctx, selectSql)
 	if err != nil {
 		return nil, err
 	}
@@ -164,393 +192,787 @@ func (p PostgresDB) FetchAllCategories() ([]rsscollector.FeedCategory, error) {
 	return results, nil
 }
 
-func (p PostgresDB) DeleteCategoryByID(id string) error {
-	deleteItemLinksSql := `delete from item_categories where category_id = $1;`
-	_, err := p.conn.Exec(deleteItemLinksSql, id)
-	if err != nil {
-		return err
-	}
-	deleteSourceLinksSql := `delete from feed_categories where category_id = $1;`
-	_, err = p.conn.Exec(deleteSourceLinksSql, id)
+// DeleteCategoryByID moves the category to the trash. Its links to items and
+// sources are kept, though hidden, so that they return if it is restored.
+func (p PostgresDB) DeleteCategoryByID(ctx context.Context, id string) error {
+	updateSql := `update categories set deleted_at = now() where id = $1 and deleted_at is null;`
+	_, err := p.conn.ExecContext(ctx, updateSql, id)
+	return err
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx so that helpers can be
+// shared between transactional and non-transactional callers.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// withTx runs fn inside a transaction which is committed if fn succeeds and
+// rolled back otherwise, including when ctx is cancelled.
+func (p PostgresDB) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.conn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	deleteCategorySql := `delete from categories where id = $1;`
-	_, err = p.conn.Exec(deleteCategorySql, id)
-	if err != nil {
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Error().Err(rollbackErr).Msg("failed to roll back transaction")
+		}
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (p PostgresDB) StoreItem(sourceID string, item *rsscollector.FeedItem) error {
-
-	var buf bytes.Buffer
+// itemColumns are selected by every items query and scanned by scanItem.
+const itemColumns = `id, source_id, title, description, content, link, guid, published, updated,
+author, image_url, image_title, categories, custom, enclosures, media, summary, raw_description,
+raw_content, extracted_at, starred, original_link`
+
+// itemColumnCount is the number of values itemValues provides per item.
+const itemColumnCount = 22
+
+// itemBatchSize limits the number of rows in each multi-row insert to keep
+// well within the Postgres limit on bind parameters.
+const itemBatchSize = 500
+
+// itemUpdateColumns are overwritten when an insert conflicts with an existing
+// item. Whether the item is starred is only changed when it is stored by ID,
+// collecting it again leaves it as it was.
+const itemUpdateColumns = `source_id = excluded.source_id, title = excluded.title,
+description = excluded.description, content = excluded.content, link = excluded.link,
+guid = excluded.guid, published = excluded.published, updated = excluded.updated,
+author = excluded.author, image_url = excluded.image_url, image_title = excluded.image_title,
+categories = excluded.categories, custom = excluded.custom, enclosures = excluded.enclosures,
+media = excluded.media, summary = excluded.summary, raw_description = excluded.raw_description,
+raw_content = excluded.raw_content, extracted_at = excluded.extracted_at,
+original_link = excluded.original_link`
+
+func (p PostgresDB) StoreItem(ctx context.Context, sourceID string, item *rsscollector.FeedItem) error {
+	return p.StoreItems(ctx, sourceID, []*rsscollector.FeedItem{item})
+}
 
-	if len(item.ID) == 0 {
-		insertSql := `insert into items (id, source_id, item_data) 
-values($1, $2, $3) on conflict do nothing;`
+// StoreItems stores the items in a single transaction using batched
+// multi-row inserts.
+//
+// Items with an ID are upserted and their category links replaced with their
+// CategoryIDs. Items without an ID are new to us unless the source already has
+// an item with the same GUID, in which case the stored item is updated in
+// place, keeps its ID, existing category links and whether it is starred, and
+// gains any CategoryIDs.
+func (p PostgresDB) StoreItems(ctx context.Context, sourceID string, items []*rsscollector.FeedItem) error {
+	existing := make([]*rsscollector.FeedItem, 0)
+	collected := make([]*rsscollector.FeedItem, 0)
+	for _, item := range items {
+		item.SourceID = sourceID
+		if len(item.ID) > 0 {
+			existing = append(existing, item)
+			continue
+		}
 		u, err := uuid.NewRandom()
 		if err != nil {
 			return err
 		}
 		item.ID = u.String()
-		if err := json.NewEncoder(&buf).Encode(&item); err != nil {
-			return err
+		collected = append(collected, item)
+	}
+
+	return p.withTx(ctx, func(tx *sql.Tx) error {
+		for start := 0; start < len(existing); start += itemBatchSize {
+			batch := existing[start:minInt(start+itemBatchSize, len(existing))]
+			if err := upsertItemsByID(ctx, tx, batch); err != nil {
+				return err
+			}
+			if err := replaceItemCategories(ctx, tx, batch); err != nil {
+				return err
+			}
 		}
 
-		_, err = p.conn.Exec(insertSql, item.ID, sourceID, buf.String())
-		if err != nil {
-			return err
+		for start := 0; start < len(collected); start += itemBatchSize {
+			batch := collected[start:minInt(start+itemBatchSize, len(collected))]
+			if err := upsertItemsByGUID(ctx, tx, batch); err != nil {
+				return err
+			}
+			if err := addItemCategories(ctx, tx, batch); err != nil {
+				return err
+			}
 		}
-		return p.updateCategoriesForItem(item)
-	}
+		return nil
+	})
+}
 
-	if err := p.updateCategoriesForItem(item); err != nil {
+func upsertItemsByID(ctx context.Context, q queryer, items []*rsscollector.FeedItem) error {
+	values, err := itemsValues(items)
+	if err != nil {
 		return err
 	}
-	if err := json.NewEncoder(&buf).Encode(&item); err != nil {
+	upsertSql := `insert into items (` + itemColumns + `) values ` +
+		valuesPlaceholders(len(items), itemColumnCount) + `
+on conflict (id) do update set ` + itemUpdateColumns + `, starred = excluded.starred;`
+	_, err = q.ExecContext(ctx, upsertSql, values...)
+	return err
+}
+
+// upsertItemsByGUID inserts newly collected items and updates those that the
+// source already has an item for with the same GUID. The IDs of updated items
+// are replaced with the stored ones.
+func upsertItemsByGUID(ctx context.Context, q queryer, items []*rsscollector.FeedItem) error {
+	// A statement can't update the same row twice so only the last of any
+	// items sharing a GUID is written, the others take on its ID.
+	lastByGUID := make(map[string]*rsscollector.FeedItem)
+	for _, item := range items {
+		if len(item.GUID) > 0 {
+			lastByGUID[item.GUID] = item
+		}
+	}
+	unique := make([]*rsscollector.FeedItem, 0, len(items))
+	for _, item := range items {
+		if len(item.GUID) == 0 || lastByGUID[item.GUID] == item {
+			unique = append(unique, item)
+		}
+	}
+
+	values, err := itemsValues(unique)
+	if err != nil {
 		return err
 	}
-	updateSql := `update items set (source_id, item_data) = ($2, $3) where id = $1;`
-	_, err := p.conn.Exec(updateSql, item.ID, sourceID, buf.String())
+	upsertSql := `insert into items (` + itemColumns + `) values ` +
+		valuesPlaceholders(len(unique), itemColumnCount) + `
+on conflict (source_id, guid) where guid <> '' do update set ` + itemUpdateColumns + `
+returning id, guid;`
+	rows, err := q.QueryContext(ctx, upsertSql, values...)
 	if err != nil {
 		return err
 	}
-	return nil
-}
+	defer rows.Close()
 
-func (p PostgresDB) updateCategoriesForItem(item *rsscollector.FeedItem) error {
-	if len(item.CategoryIDs) > 0 {
-		storedCategoryIDs := make([]string, 0)
-		selectItemCategoriesSql := `select category_id from item_categories where item_id = $1`
-		rows, err := p.conn.Query(selectItemCategoriesSql, item.ID)
-		if err != nil {
+	storedIDs := make(map[string]string)
+	for rows.Next() {
+		var id, guid string
+		if err := rows.Scan(&id, &guid); err != nil {
 			return err
 		}
-		if rows.Err() != nil {
-			return rows.Err()
+		if len(guid) > 0 {
+			storedIDs[guid] = id
 		}
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
 
-		for rows.Next() {
-			var storedCategoryID string
-			if err := rows.Scan(&storedCategoryID); err != nil {
-				return err
-			}
-			storedCategoryIDs = append(storedCategoryIDs, storedCategoryID)
+	for _, item := range items {
+		if id, ok := storedIDs[item.GUID]; ok && len(item.GUID) > 0 {
+			item.ID = id
 		}
-		rows.Close()
+	}
+	return nil
+}
 
-		categoryIDsToAdd := linkCategoryIDs(item.CategoryIDs, storedCategoryIDs)
-		if len(categoryIDsToAdd) > 0 {
-			for _, categoryID := range categoryIDsToAdd {
-				relateCategorySql := `
-insert into item_categories (item_id, category_id) values ($1, $2) on conflict do nothing;`
-				_, err := p.conn.Exec(relateCategorySql, item.ID, categoryID)
-				if err != nil {
-					return err
-				}
-			}
-		}
+// replaceItemCategories makes the stored category links for the items match
+// their CategoryIDs, keeping any hidden links to deleted categories.
+func replaceItemCategories(ctx context.Context, q queryer, items []*rsscollector.FeedItem) error {
+	itemIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		itemIDs = append(itemIDs, item.ID)
+	}
+	deleteSql := `delete from item_categories where item_id = ANY($1)
+and category_id in (select id from categories where deleted_at is null);`
+	if _, err := q.ExecContext(ctx, deleteSql, pq.Array(itemIDs)); err != nil {
+		return err
+	}
+	return addItemCategories(ctx, q, items)
+}
 
-		categoryIDsToDelete := unlinkCategoryIDs(item.CategoryIDs, storedCategoryIDs)
-		if len(categoryIDsToDelete) > 0 {
-			for _, deleteCategoryID := range categoryIDsToDelete {
-				deleteSql := `delete from item_categories where item_id = $1 and category_id = $2;`
-				_, err := p.conn.Exec(deleteSql, item.ID, deleteCategoryID)
-				if err != nil {
-					return err
-				}
-			}
+// addItemCategories links the items to their CategoryIDs, leaving any existing
+// links in place.
+func addItemCategories(ctx context.Context, q queryer, items []*rsscollector.FeedItem) error {
+	itemIDs := make([]string, 0)
+	categoryIDs := make([]string, 0)
+	for _, item := range items {
+		for _, categoryID := range item.CategoryIDs {
+			itemIDs = append(itemIDs, item.ID)
+			categoryIDs = append(categoryIDs, categoryID)
 		}
 	}
-	return nil
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	insertSql := `
+insert into item_categories (item_id, category_id)
+select * from unnest($1::varchar[], $2::varchar[]) on conflict do nothing;`
+	_, err := q.ExecContext(ctx, insertSql, pq.Array(itemIDs), pq.Array(categoryIDs))
+	return err
 }
 
-func linkCategoryIDs(newList, oldList []string) []string {
-	results := make([]string, 0)
-	for _, item := range newList {
-		var itemFound bool
-		for _, potentialMatch := range oldList {
-			if item == potentialMatch {
-				itemFound = true
+// valuesPlaceholders builds the VALUES list for a multi-row insert of rows
+// with the given number of columns.
+func valuesPlaceholders(rows, columns int) string {
+	var b strings.Builder
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for column := 0; column < columns; column++ {
+			if column > 0 {
+				b.WriteString(", ")
 			}
+			fmt.Fprintf(&b, "$%d", row*columns+column+1)
 		}
-		if !itemFound {
-			results = append(results, item)
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func itemsValues(items []*rsscollector.FeedItem) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(items)*itemColumnCount)
+	for _, item := range items {
+		itemValues, err := itemValues(item)
+		if err != nil {
+			return nil, err
 		}
+		values = append(values, itemValues...)
 	}
-	return results
+	return values, nil
 }
 
-func unlinkCategoryIDs(newList, oldList []string) []string {
-	results := make([]string, 0)
-	for _, oldItem := range oldList {
-		var stillRequired bool
-		for _, potentialMatch := range newList {
-			if oldItem == potentialMatch {
-				stillRequired = true
-			}
+// itemValues provides the values for itemColumns in order.
+func itemValues(item *rsscollector.FeedItem) ([]interface{}, error) {
+	var imageURL, imageTitle sql.NullString
+	if item.Image != nil {
+		imageURL = sql.NullString{String: item.Image.URL, Valid: true}
+		imageTitle = sql.NullString{String: item.Image.Title, Valid: true}
+	}
+	categories := item.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+	var custom, enclosures, media sql.NullString
+	if len(item.Custom) > 0 {
+		data, err := json.Marshal(item.Custom)
+		if err != nil {
+			return nil, err
+		}
+		custom = sql.NullString{String: string(data), Valid: true}
+	}
+	if len(item.Enclosures) > 0 {
+		data, err := json.Marshal(item.Enclosures)
+		if err != nil {
+			return nil, err
 		}
-		if !stillRequired {
-			results = append(results, oldItem)
+		enclosures = sql.NullString{String: string(data), Valid: true}
+	}
+	if item.Media != nil {
+		data, err := json.Marshal(item.Media)
+		if err != nil {
+			return nil, err
 		}
+		media = sql.NullString{String: string(data), Valid: true}
 	}
-	return results
+	return []interface{}{
+		item.ID,
+		item.SourceID,
+		item.Title,
+		item.Description,
+		item.Content,
+		item.Link,
+		item.GUID,
+		nullTime(item.Published),
+		nullTime(item.Updated),
+		item.Author,
+		imageURL,
+		imageTitle,
+		pq.Array(categories),
+		custom,
+		enclosures,
+		media,
+		item.Summary,
+		item.RawDescription,
+		item.RawContent,
+		nullTime(item.ExtractedAt),
+		item.Starred,
+		item.OriginalLink,
+	}, nil
 }
 
-func (p PostgresDB) StoreItems(sourceID string, items []*rsscollector.FeedItem) error {
-	for _, item := range items {
-		if err := p.StoreItem(sourceID, item); err != nil {
-			return err
+// scanItem reads a row selected with itemColumns.
+func scanItem(rows *sql.Rows) (*rsscollector.FeedItem, error) {
+	var item rsscollector.FeedItem
+	var published, updated, extractedAt sql.NullTime
+	var imageURL, imageTitle sql.NullString
+	var categories pq.StringArray
+	var custom, enclosures, media []byte
+	if err := rows.Scan(&item.ID, &item.SourceID, &item.Title, &item.Description,
+		&item.Content, &item.Link, &item.GUID, &published, &updated, &item.Author,
+		&imageURL, &imageTitle, &categories, &custom, &enclosures, &media, &item.Summary,
+		&item.RawDescription, &item.RawContent, &extractedAt, &item.Starred, &item.OriginalLink); err != nil {
+		return nil, err
+	}
+	item.Published = timeFromNull(published)
+	item.Updated = timeFromNull(updated)
+	item.ExtractedAt = timeFromNull(extractedAt)
+	if imageURL.Valid {
+		item.Image = &rsscollector.FeedItemImage{
+			URL:   imageURL.String,
+			Title: imageTitle.String,
 		}
 	}
-	return nil
+	if len(categories) > 0 {
+		item.Categories = categories
+	}
+	if len(custom) > 0 {
+		if err := json.Unmarshal(custom, &item.Custom); err != nil {
+			return nil, err
+		}
+	}
+	if len(enclosures) > 0 {
+		if err := json.Unmarshal(enclosures, &item.Enclosures); err != nil {
+			return nil, err
+		}
+	}
+	if len(media) > 0 {
+		if err := json.Unmarshal(media, &item.Media); err != nil {
+			return nil, err
+		}
+	}
+	return &item, nil
+}
+
+// nullTime stores missing and zero times as NULL.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil || t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func timeFromNull(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
 }
 
-func (p PostgresDB) FetchItemByID(id string) (rsscollector.FeedItem, error) {
-	selectSql := `select source_id, item_data from items where id = $1;`
-	rows, err := p.conn.Query(selectSql, id)
+// linkedCategoryIDs runs a query selecting (object ID, category ID) pairs from
+// one of the category link tables and groups the category IDs by object ID.
+// The queries skip links to deleted categories.
+func linkedCategoryIDs(ctx context.Context, q queryer, selectSql string, ids []string) (map[string][]string, error) {
+	results := make(map[string][]string)
+	if len(ids) == 0 {
+		return results, nil
+	}
+	rows, err := q.QueryContext(ctx, selectSql, pq.Array(ids))
 	if err != nil {
-		return rsscollector.FeedItem{}, err
+		return nil, err
 	}
 	if rows.Err() != nil {
-		return rsscollector.FeedItem{}, rows.Err()
+		return nil, rows.Err()
 	}
 	defer rows.Close()
 
-	var result rsscollector.FeedItem
 	for rows.Next() {
-		var sourceID, data string
-		if err := rows.Scan(&sourceID, &data); err != nil {
-			return rsscollector.FeedItem{}, err
-		}
-		if err := json.Unmarshal([]byte(data), &result); err != nil {
-			return rsscollector.FeedItem{}, err
+		var id, categoryID string
+		if err := rows.Scan(&id, &categoryID); err != nil {
+			return nil, err
 		}
-		result.SourceID = sourceID
+		results[id] = append(results[id], categoryID)
 	}
-	if len(result.ID) > 0 {
-		return result, nil
+	return results, nil
+}
+
+func itemCategoryIDs(ctx context.Context, q queryer, itemIDs []string) (map[string][]string, error) {
+	return linkedCategoryIDs(ctx, q,
+		`select item_id, category_id from item_categories
+join categories on categories.id = category_id
+where item_id = ANY($1) and categories.deleted_at is null;`, itemIDs)
+}
+
+func feedCategoryIDs(ctx context.Context, q queryer, feedIDs []string) (map[string][]string, error) {
+	return linkedCategoryIDs(ctx, q,
+		`select feed_id, category_id from feed_categories
+join categories on categories.id = category_id
+where feed_id = ANY($1) and categories.deleted_at is null;`, feedIDs)
+}
+
+func (p PostgresDB) FetchItemByID(ctx context.Context, id string) (rsscollector.FeedItem, error) {
+	selectSql := `select ` + itemColumns + ` from items where id = $1 and deleted_at is null;`
+	items, err := p.fetchItems(ctx, selectSql, id)
+	if err != nil {
+		return rsscollector.FeedItem{}, err
+	}
+	if len(items) > 0 {
+		return *items[0], nil
 	}
 	return rsscollector.FeedItem{}, fmt.Errorf("no item found with id: %s", id)
 }
 
-func (p PostgresDB) FetchAllItems(options rsscollector.ItemOptions) (rsscollector.FeedItems, error) {
-	var rows *sql.Rows
-	var err error
-
+func (p PostgresDB) FetchAllItems(ctx context.Context, options rsscollector.ItemOptions) (rsscollector.FeedItems, error) {
+	conditions := []string{"deleted_at is null"}
+	if options.Deleted {
+		conditions[0] = "deleted_at is not null"
+	}
+	args := make([]interface{}, 0)
+	if len(options.SourceID) > 0 {
+		args = append(args, options.SourceID)
+		conditions = append(conditions, fmt.Sprintf("source_id = $%d", len(args)))
+	}
 	if len(options.CategoryIDs) > 0 {
-		itemIDs := make([]string, 0)
-		for _, categoryID := range options.CategoryIDs {
-			selectSql := `select item_id from item_categories where category_id = $1;`
-			rows, err := p.conn.Query(selectSql, categoryID)
-			if err != nil {
-				return rsscollector.FeedItems{}, err
-			}
-			if rows.Err() != nil {
-				return rsscollector.FeedItems{}, rows.Err()
-			}
+		args = append(args, pq.Array(options.CategoryIDs))
+		conditions = append(conditions, fmt.Sprintf(
+			`id in (select item_id from item_categories
+join categories on categories.id = category_id
+where category_id = ANY($%d) and categories.deleted_at is null)`, len(args)))
+	}
+	switch {
+	case options.EnclosureType == rsscollector.AnyEnclosure:
+		conditions = append(conditions, "enclosures is not null")
+	case strings.Contains(options.EnclosureType, "/"):
+		args = append(args, strings.ToLower(options.EnclosureType))
+		conditions = append(conditions, fmt.Sprintf(`exists (select 1 from jsonb_array_elements(enclosures) as enclosure
+where lower(enclosure ->> 'type') = $%d)`, len(args)))
+	case len(options.EnclosureType) > 0:
+		args = append(args, strings.ToLower(options.EnclosureType))
+		conditions = append(conditions, fmt.Sprintf(`exists (select 1 from jsonb_array_elements(enclosures) as enclosure
+where split_part(lower(enclosure ->> 'type'), '/', 1) = $%d)`, len(args)))
+	}
 
-			for rows.Next() {
-				var itemID string
-				if err := rows.Scan(&itemID); err != nil {
-					return rsscollector.FeedItems{}, err
-				}
-				itemIDs = append(itemIDs, itemID)
-			}
-			rows.Close()
-		}
-		if len(options.SourceID) > 0 {
-			selectSql := `select id, source_id, item_data from items where source_id = $1 and id = ANY($2);`
-			rows, err = p.conn.Query(selectSql, options.SourceID, pq.Array(itemIDs))
-			if err != nil {
-				return rsscollector.FeedItems{}, err
-			}
-			if rows.Err() != nil {
-				return rsscollector.FeedItems{}, rows.Err()
-			}
-		} else {
-			selectSql := `select id, source_id, item_data from items where id = ANY($1);`
-			rows, err = p.conn.Query(selectSql, pq.Array(itemIDs))
-			if err != nil {
-				return rsscollector.FeedItems{}, err
-			}
-			if rows.Err() != nil {
-				return rsscollector.FeedItems{}, rows.Err()
-			}
+	selectSql := `select ` + itemColumns + ` from items where ` + strings.Join(conditions, " and ") +
+		` order by published asc nulls first;`
 
-		}
-	} else if len(options.SourceID) > 0 {
-		selectSql := `select id, source_id, item_data from items where source_id = $1;`
-		rows, err = p.conn.Query(selectSql, options.SourceID)
-		if err != nil {
-			return rsscollector.FeedItems{}, err
-		}
-		if rows.Err() != nil {
-			return rsscollector.FeedItems{}, rows.Err()
-		}
-	} else {
-		selectSql := `select id, source_id, item_data from items;`
-		rows, err = p.conn.Query(selectSql)
-		if err != nil {
-			return rsscollector.FeedItems{}, err
-		}
-		if rows.Err() != nil {
-			return rsscollector.FeedItems{}, rows.Err()
-		}
+	return p.fetchItems(ctx, selectSql, args...)
+}
+
+// fetchItems runs a query selecting itemColumns and fills in the category IDs
+// each item is linked to.
+func (p PostgresDB) fetchItems(ctx context.Context, selectSql string, args ...interface{}) (rsscollector.FeedItems, error) {
+	rows, err := p.conn.QueryContext(ctx, selectSql, args...)
+	if err != nil {
+		return rsscollector.FeedItems{}, err
+	}
+	if rows.Err() != nil {
+		return rsscollector.FeedItems{}, rows.Err()
 	}
 	defer rows.Close()
 
 	results := make(rsscollector.FeedItems, 0)
 	for rows.Next() {
-		var id, sourceID, data string
-		if err := rows.Scan(&id, &sourceID, &data); err != nil {
-			return rsscollector.FeedItems{}, err
-		}
-		var feedItem rsscollector.FeedItem
-		if err := json.Unmarshal([]byte(data), &feedItem); err != nil {
+		item, err := scanItem(rows)
+		if err != nil {
 			return rsscollector.FeedItems{}, err
 		}
-		feedItem.SourceID = sourceID
-		results = append(results, &feedItem)
+		results = append(results, item)
 	}
 
-	if len(results) > 0 {
-		return results, nil
+	itemIDs := make([]string, 0, len(results))
+	for _, item := range results {
+		itemIDs = append(itemIDs, item.ID)
+	}
+	categoryIDs, err := itemCategoryIDs(ctx, p.conn, itemIDs)
+	if err != nil {
+		return rsscollector.FeedItems{}, err
+	}
+	for _, item := range results {
+		item.CategoryIDs = categoryIDs[item.ID]
 	}
 
-	return rsscollector.FeedItems{}, fmt.Errorf("no items found for ItemOptions: %v", options)
+	return results, nil
 }
 
-func (p PostgresDB) DeleteItemByID(id string) error {
-	deleteItemCategoriesSql := `delete from item_categories where item_id = $1;`
-	_, err := p.conn.Exec(deleteItemCategoriesSql, id)
-	if err != nil {
-		return err
+// DeleteItemByID moves the item to the trash.
+func (p PostgresDB) DeleteItemByID(ctx context.Context, id string) error {
+	return p.DeleteItemsByID(ctx, []string{id})
+}
+
+// DeleteItemsByID moves the items to the trash, keeping their category
+// links for when they are restored.
+func (p PostgresDB) DeleteItemsByID(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
-	deleteSql := `delete from items where id = $1;`
-	_, err = p.conn.Exec(deleteSql, id)
+	updateSql := `update items set deleted_at = now() where id = ANY($1) and deleted_at is null;`
+	_, err := p.conn.ExecContext(ctx, updateSql, pq.Array(ids))
 	return err
 }
 
-func (p PostgresDB) StoreSource(source *rsscollector.FeedSource) error {
-
+// StoreSource stores the source and replaces its category links, other than
+// hidden links to deleted categories, in a single transaction.
+func (p PostgresDB) StoreSource(ctx context.Context, source *rsscollector.FeedSource) error {
 	if len(source.ID) == 0 {
-		insertSql := `insert into feeds (id, feed_url, feed_data) values($1, $2, $3);`
 		u, err := uuid.NewRandom()
 		if err != nil {
 			return err
 		}
 		source.ID = u.String()
-		source.Link = rsscollector.FeedSourceLink(source.ID)
-		var buf bytes.Buffer
-		if err := json.NewEncoder(&buf).Encode(&source); err != nil {
+	}
+	source.Link = rsscollector.FeedSourceLink(source.ID)
+	normalizeFeedURL(source)
+
+	err := p.withTx(ctx, func(tx *sql.Tx) error {
+		// A source with an unknown ID, such as one being restored from a
+		// backup, is created with it.
+		if err := p.checkStoredURL(ctx, tx, source); err != nil {
 			return err
 		}
-		if len(source.CategoryIDs) > 0 {
-			for _, categoryID := range source.CategoryIDs {
-				linkCategorySql := `insert into feed_categories (feed_id, category_id) values ($1, $2);`
-				_, err := p.conn.Exec(linkCategorySql, source.ID, categoryID)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		_, err = p.conn.Exec(insertSql, source.ID, source.FeedURL, buf.String())
+		values, err := feedValues(source.FeedSourcePartial)
 		if err != nil {
 			return err
 		}
-		return nil
-	}
+		upsertSql := `insert into feeds (` + feedColumns + `) values ` + valuesPlaceholders(1, feedColumnCount) + `
+on conflict (id) do update set (` + feedColumns + `) = (` + excludedColumns(feedColumns) + `);`
+		if _, err := tx.ExecContext(ctx, upsertSql, values...); err != nil {
+			return err
+		}
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(&source); err != nil {
+		deleteLinksSql := `delete from feed_categories where feed_id = $1
+and category_id in (select id from categories where deleted_at is null);`
+		if _, err := tx.ExecContext(ctx, deleteLinksSql, source.ID); err != nil {
+			return err
+		}
+		if len(source.CategoryIDs) == 0 {
+			return nil
+		}
+		linkCategoriesSql := `
+insert into feed_categories (feed_id, category_id)
+select distinct $1::varchar, category_id from unnest($2::varchar[]) as category_id;`
+		_, err = tx.ExecContext(ctx, linkCategoriesSql, source.ID, pq.Array(source.CategoryIDs))
+		return err
+	})
+	return p.feedURLError(ctx, err, source.FeedURL)
+}
+
+// feedURLError reports a violation of the unique index on the URLs of live
+// feeds not flagged as duplicates as a DuplicateSourceError, should a feed
+// get past checkStoredURL.
+func (p PostgresDB) feedURLError(ctx context.Context, err error, feedURL string) error {
+	if !isUniqueViolation(err, "feeds_feeds_url_idx") {
 		return err
 	}
+	selectSql := `select id from feeds where feed_url = $1 and deleted_at is null and duplicate_of is null;`
+	var id string
+	if selectErr := p.conn.QueryRowContext(ctx, selectSql, feedURL).Scan(&id); selectErr != nil {
+		return err
+	}
+	return DuplicateSourceError{FeedURL: feedURL, ID: id}
+}
 
-	updateSql := `update feeds set (feed_url, feed_data) = ($2, $3) where id = $1;`
-	_, err := p.conn.Exec(updateSql, source.ID, source.FeedURL, buf.String())
+// feedURLLock is the transaction level advisory lock taken to check the URL
+// of a feed being stored, so that two feeds can't be stored under the same
+// URL written differently at once.
+const feedURLLock = 7240518
+
+// checkStoredURL rejects storing the source under the URL of another live
+// feed as checkStoredURL does, holding feedURLLock until tx ends.
+func (p PostgresDB) checkStoredURL(ctx context.Context, tx *sql.Tx, source *rsscollector.FeedSource) error {
+	if len(source.DuplicateOf) > 0 {
+		return nil
+	}
+	var previousURL string
+	var duplicateOf sql.NullString
+	selectSql := `select feed_url, duplicate_of from feeds where id = $1;`
+	err := tx.QueryRowContext(ctx, selectSql, source.ID).Scan(&previousURL, &duplicateOf)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if duplicateOf.Valid {
+		previousURL = ""
+	}
+	others, err := liveFeedURLs(ctx, tx, source.ID)
 	if err != nil {
 		return err
 	}
+	return checkStoredURL(source, previousURL, others)
+}
 
-	if len(source.CategoryIDs) > 0 {
-		selectCategoriesSql := `select category_id from feed_categories where feed_id = $1;`
-		rows, err := p.conn.Query(selectCategoriesSql, source.ID)
-		if err != nil {
-			return err
-		}
-		if rows.Err() != nil {
-			return rows.Err()
-		}
-		storedCategoryIDs := make([]string, 0)
-		for rows.Next() {
-			var categoryID string
-			if err := rows.Scan(&categoryID); err != nil {
-				return err
-			}
-			storedCategoryIDs = append(storedCategoryIDs, categoryID)
+// liveFeedURLs gives the IDs, URLs and DuplicateOf of the live feeds other
+// than feedID, taking feedURLLock until tx ends.
+func liveFeedURLs(ctx context.Context, tx *sql.Tx, feedID string) ([]rsscollector.FeedSourcePartial, error) {
+	if _, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock($1);`, feedURLLock); err != nil {
+		return nil, err
+	}
+	selectSql := `select id, feed_url, duplicate_of from feeds where deleted_at is null and id <> $1;`
+	rows, err := tx.QueryContext(ctx, selectSql, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var others []rsscollector.FeedSourcePartial
+	for rows.Next() {
+		var other rsscollector.FeedSourcePartial
+		var duplicateOf sql.NullString
+		if err := rows.Scan(&other.ID, &other.FeedURL, &duplicateOf); err != nil {
+			return nil, err
 		}
-		rows.Close()
+		other.DuplicateOf = duplicateOf.String
+		others = append(others, other)
+	}
+	return others, rows.Err()
+}
 
-		feedCategoriesToAdd := linkCategoryIDs(source.CategoryIDs, storedCategoryIDs)
-		if len(feedCategoriesToAdd) > 0 {
-			for _, categoryToAdd := range feedCategoriesToAdd {
-				linkFeedCategoriesSql := `insert into feed_categories (feed_id, category_id) values ($1, $2);`
-				_, err := p.conn.Exec(linkFeedCategoriesSql, source.ID, categoryToAdd)
-				if err != nil {
-					return err
-				}
-			}
-		}
+// feedColumns are selected by every feeds query and scanned by scanFeed.
+const feedColumns = `id, feed_url, title, last_collected, description, site_link, language,
+image_url, image_title, authors, copyright, generator, feed_type, feed_version, fetch_full_content,
+scrape, fetch_settings, url_history, duplicate_of, dead_at, pruned_guids`
 
-		feedCategoriesToRemove := unlinkCategoryIDs(source.CategoryIDs, storedCategoryIDs)
-		if len(feedCategoriesToRemove) > 0 {
-			for _, categoryToRemove := range feedCategoriesToRemove {
-				unlinkFeedCategorySql := `delete from feed_categories where feed_id = $1 and category_id = $2;`
-				_, err := p.conn.Exec(unlinkFeedCategorySql, source.ID, categoryToRemove)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
+// feedColumnCount is the number of values feedValues provides.
+const feedColumnCount = 21
 
-	return nil
+// excludedColumns refers to each of the comma separated columns in the
+// excluded row of an upsert.
+func excludedColumns(columns string) string {
+	names := strings.Split(columns, ",")
+	for i, name := range names {
+		names[i] = "excluded." + strings.TrimSpace(name)
+	}
+	return strings.Join(names, ", ")
 }
 
-func (p PostgresDB) FetchSource(feedID string) (rsscollector.FeedSource, error) {
-	selectSql := `select feed_data from feeds where id = $1`
-	rows, err := p.conn.Query(selectSql, feedID)
+// feedValues provides the values for feedColumns in order.
+func feedValues(feed rsscollector.FeedSourcePartial) ([]interface{}, error) {
+	var imageURL, imageTitle sql.NullString
+	if feed.Image != nil {
+		imageURL = sql.NullString{String: feed.Image.URL, Valid: true}
+		imageTitle = sql.NullString{String: feed.Image.Title, Valid: true}
+	}
+	authors, err := nullJSON(feed.Authors, len(feed.Authors) > 0)
 	if err != nil {
-		return rsscollector.FeedSource{}, err
+		return nil, err
 	}
-	if rows.Err() != nil {
-		return rsscollector.FeedSource{}, rows.Err()
+	scrape, err := nullJSON(feed.Scrape, feed.Scrape != nil)
+	if err != nil {
+		return nil, err
 	}
-	defer rows.Close()
+	// The secrets of the settings are encrypted before they reach the store.
+	fetchSettings, err := nullJSON(feed.Fetch, feed.Fetch != nil)
+	if err != nil {
+		return nil, err
+	}
+	urlHistory, err := nullJSON(feed.URLHistory, len(feed.URLHistory) > 0)
+	if err != nil {
+		return nil, err
+	}
+	prunedGUIDs, err := nullJSON(feed.PrunedGUIDs, len(feed.PrunedGUIDs) > 0)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		feed.ID,
+		feed.FeedURL,
+		feed.Title,
+		nullTime(&feed.LastCollected),
+		feed.Description,
+		feed.SiteLink,
+		feed.Language,
+		imageURL,
+		imageTitle,
+		authors,
+		feed.Copyright,
+		feed.Generator,
+		feed.FeedType,
+		feed.FeedVersion,
+		feed.FetchFullContent,
+		scrape,
+		fetchSettings,
+		urlHistory,
+		sql.NullString{String: feed.DuplicateOf, Valid: len(feed.DuplicateOf) > 0},
+		nullTime(feed.DeadAt),
+		prunedGUIDs,
+	}, nil
+}
 
-	var result rsscollector.FeedSource
-	for rows.Next() {
-		var data string
-		if err := rows.Scan(&data); err != nil {
-			return rsscollector.FeedSource{}, err
+// nullJSON encodes the value as JSON when it is set and NULL otherwise.
+func nullJSON(value interface{}, set bool) (sql.NullString, error) {
+	if !set {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// scanFeed reads a row selected with feedColumns.
+func scanFeed(rows *sql.Rows) (rsscollector.FeedSourcePartial, error) {
+	var feed rsscollector.FeedSourcePartial
+	var lastCollected, deadAt sql.NullTime
+	var imageURL, imageTitle, duplicateOf sql.NullString
+	var authors, scrape, fetchSettings, urlHistory, prunedGUIDs []byte
+	if err := rows.Scan(&feed.ID, &feed.FeedURL, &feed.Title, &lastCollected, &feed.Description,
+		&feed.SiteLink, &feed.Language, &imageURL, &imageTitle, &authors, &feed.Copyright,
+		&feed.Generator, &feed.FeedType, &feed.FeedVersion, &feed.FetchFullContent, &scrape,
+		&fetchSettings, &urlHistory, &duplicateOf, &deadAt, &prunedGUIDs); err != nil {
+		return feed, err
+	}
+	feed.Link = rsscollector.FeedSourceLink(feed.ID)
+	if lastCollected.Valid {
+		feed.LastCollected = lastCollected.Time
+	}
+	if imageURL.Valid {
+		feed.Image = &rsscollector.FeedItemImage{
+			URL:   imageURL.String,
+			Title: imageTitle.String,
 		}
-		if err := json.Unmarshal([]byte(data), &result); err != nil {
-			return rsscollector.FeedSource{}, err
+	}
+	if len(authors) > 0 {
+		if err := json.Unmarshal(authors, &feed.Authors); err != nil {
+			return feed, err
 		}
 	}
-	if len(result.ID) > 0 {
-		return result, nil
+	if len(scrape) > 0 {
+		feed.Scrape = &rsscollector.ScrapeSelectors{}
+		if err := json.Unmarshal(scrape, feed.Scrape); err != nil {
+			return feed, err
+		}
+	}
+	if len(fetchSettings) > 0 {
+		feed.Fetch = &rsscollector.FetchSettings{}
+		if err := json.Unmarshal(fetchSettings, feed.Fetch); err != nil {
+			return feed, err
+		}
+	}
+	if len(urlHistory) > 0 {
+		if err := json.Unmarshal(urlHistory, &feed.URLHistory); err != nil {
+			return feed, err
+		}
+	}
+	if len(prunedGUIDs) > 0 {
+		if err := json.Unmarshal(prunedGUIDs, &feed.PrunedGUIDs); err != nil {
+			return feed, err
+		}
+	}
+	feed.DuplicateOf = duplicateOf.String
+	feed.DeadAt = timeFromNull(deadAt)
+	return feed, nil
+}
+
+func (p PostgresDB) FetchSource(ctx context.Context, feedID string) (rsscollector.FeedSource, error) {
+	selectSql := `select ` + feedColumns + ` from feeds where id = $1 and deleted_at is null;`
+	sources, err := p.fetchSources(ctx, selectSql, feedID)
+	if err != nil {
+		return rsscollector.FeedSource{}, err
+	}
+	if len(sources) > 0 {
+		return rsscollector.FeedSource{FeedSourcePartial: sources[0]}, nil
 	}
 
 	return rsscollector.FeedSource{}, fmt.Errorf("no feed source found for id: %s", feedID)
 }
 
-func (p PostgresDB) FetchAllSources() ([]rsscollector.FeedSourcePartial, error) {
-	selectSql := `select feed_data from feeds;`
-	rows, err := p.conn.Query(selectSql)
+func (p PostgresDB) FetchAllSources(ctx context.Context) ([]rsscollector.FeedSourcePartial, error) {
+	selectSql := `select ` + feedColumns + ` from feeds where deleted_at is null;`
+	return p.fetchSources(ctx, selectSql)
+}
+
+// fetchSources runs a query selecting feedColumns and fills in the category
+// IDs each feed is linked to.
+func (p PostgresDB) fetchSources(ctx context.Context, selectSql string, args ...interface{}) ([]rsscollector.FeedSourcePartial, error) {
+	rows, err := p.conn.QueryContext(ctx, selectSql, args...)
 	if err != nil {
 		return []rsscollector.FeedSourcePartial{}, err
 	}
@@ -561,62 +983,261 @@ func (p PostgresDB) FetchAllSources() ([]rsscollector.FeedSourcePartial, error)
 
 	results := make([]rsscollector.FeedSourcePartial, 0)
 	for rows.Next() {
-		var data string
-		if err := rows.Scan(&data); err != nil {
-			return []rsscollector.FeedSourcePartial{}, err
-		}
-		var feed rsscollector.FeedSourcePartial
-		if err := json.Unmarshal([]byte(data), &feed); err != nil {
+		feed, err := scanFeed(rows)
+		if err != nil {
 			return []rsscollector.FeedSourcePartial{}, err
 		}
 		results = append(results, feed)
 	}
 
-	if len(results) > 0 {
-		return results, nil
+	feedIDs := make([]string, 0, len(results))
+	for _, feed := range results {
+		feedIDs = append(feedIDs, feed.ID)
+	}
+	categoryIDs, err := feedCategoryIDs(ctx, p.conn, feedIDs)
+	if err != nil {
+		return []rsscollector.FeedSourcePartial{}, err
+	}
+	for idx := range results {
+		results[idx].CategoryIDs = categoryIDs[results[idx].ID]
 	}
 
-	return []rsscollector.FeedSourcePartial{}, fmt.Errorf("no feeds found")
+	return results, nil
 }
 
-func (p PostgresDB) DeleteSourceByID(id string) error {
-	selectSourceItemIDs := `select id from items where source_id = $1;`
-	rows, err := p.conn.Query(selectSourceItemIDs, id)
-	if err != nil {
+// DeleteSourceByID moves the source to the trash along with the items not
+// already there in a single transaction, marking them as deleted at the same
+// time so they can be restored together.
+func (p PostgresDB) DeleteSourceByID(ctx context.Context, id string) error {
+	return p.withTx(ctx, func(tx *sql.Tx) error {
+		var deletedAt time.Time
+		updateSourceSql := `
+update feeds set deleted_at = now() where id = $1 and deleted_at is null returning deleted_at;`
+		err := tx.QueryRowContext(ctx, updateSourceSql, id).Scan(&deletedAt)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		updateItemsSql := `update items set deleted_at = $2 where source_id = $1 and deleted_at is null;`
+		_, err = tx.ExecContext(ctx, updateItemsSql, id, deletedAt)
 		return err
+	})
+}
+
+// trashedItemsSql selects the items in the trash that weren't deleted along
+// with their feed.
+const trashedItemsSql = `select ` + itemColumns + ` from items
+where deleted_at is not null
+and source_id not in (select id from feeds where deleted_at is not null)
+order by published asc nulls first;`
+
+func (p PostgresDB) FetchTrash(ctx context.Context) (rsscollector.Trash, error) {
+	trash := rsscollector.Trash{Categories: make([]rsscollector.FeedCategory, 0)}
+
+	feeds, err := p.fetchSources(ctx, `select `+feedColumns+` from feeds where deleted_at is not null;`)
+	if err != nil {
+		return rsscollector.Trash{}, err
 	}
-	if rows.Err() != nil {
-		return rows.Err()
+	trash.Feeds = feeds
+
+	items, err := p.fetchItems(ctx, trashedItemsSql)
+	if err != nil {
+		return rsscollector.Trash{}, err
+	}
+	trash.Items = items
+
+	rows, err := p.conn.QueryContext(ctx,
+		`select id, category_name, deleted_at from categories where deleted_at is not null;`)
+	if err != nil {
+		return rsscollector.Trash{}, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		var itemID string
-		if err := rows.Scan(&itemID); err != nil {
+		var category rsscollector.FeedCategory
+		var deletedAt time.Time
+		if err := rows.Scan(&category.ID, &category.Name, &deletedAt); err != nil {
+			return rsscollector.Trash{}, err
+		}
+		category.DeletedAt = &deletedAt
+		trash.Categories = append(trash.Categories, category)
+	}
+	return trash, rows.Err()
+}
+
+// RestoreSource restores the source and the items deleted with it in a single
+// transaction. It fails if another source has since been added for the same
+// feed URL.
+func (p PostgresDB) RestoreSource(ctx context.Context, feedID string) error {
+	var restored rsscollector.FeedSource
+	err := p.withTx(ctx, func(tx *sql.Tx) error {
+		var deletedAt time.Time
+		var duplicateOf sql.NullString
+		selectSql := `select deleted_at, feed_url, duplicate_of from feeds where id = $1 and deleted_at is not null;`
+		err := tx.QueryRowContext(ctx, selectSql, feedID).Scan(&deletedAt, &restored.FeedURL, &duplicateOf)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no deleted feed found with id: %s", feedID)
+		}
+		if err != nil {
 			return err
 		}
-		if err := p.DeleteItemByID(itemID); err != nil {
+		restored.ID = feedID
+		restored.DuplicateOf = duplicateOf.String
+		others, err := liveFeedURLs(ctx, tx, feedID)
+		if err != nil {
+			return err
+		}
+		if err := checkStoredURL(&restored, "", others); err != nil {
 			return err
 		}
+
+		updateItemsSql := `update items set deleted_at = null where source_id = $1 and deleted_at = $2;`
+		if _, err := tx.ExecContext(ctx, updateItemsSql, feedID, deletedAt); err != nil {
+			return err
+		}
+		updateSourceSql := `update feeds set deleted_at = null where id = $1;`
+		_, err = tx.ExecContext(ctx, updateSourceSql, feedID)
+		return err
+	})
+	return p.feedURLError(ctx, err, restored.FeedURL)
+}
+
+// RestoreItem restores the item unless its feed is deleted.
+func (p PostgresDB) RestoreItem(ctx context.Context, id string) error {
+	updateSql := `
+update items set deleted_at = null
+where id = $1 and deleted_at is not null
+and source_id in (select id from feeds where deleted_at is null);`
+	result, err := p.conn.ExecContext(ctx, updateSql, id)
+	if err != nil {
+		return err
+	}
+	if restored, err := result.RowsAffected(); err != nil || restored > 0 {
+		return err
 	}
-	rows.Close()
+	return fmt.Errorf("no deleted item found with id %s in a feed that isn't deleted", id)
+}
 
-	deleteSourceCategoriesSql := `delete from feed_categories where feed_id = $1;`
-	_, err = p.conn.Exec(deleteSourceCategoriesSql, id)
+// RestoreCategory restores the category. It fails if another category has
+// since been given the same name.
+func (p PostgresDB) RestoreCategory(ctx context.Context, id string) error {
+	updateSql := `update categories set deleted_at = null where id = $1 and deleted_at is not null;`
+	result, err := p.conn.ExecContext(ctx, updateSql, id)
+	if isUniqueViolation(err, "categories_category_name_idx") {
+		var name string
+		if nameErr := p.conn.QueryRowContext(ctx, `select category_name from categories where id = $1;`, id).Scan(&name); nameErr == nil {
+			return p.categoryNameError(ctx, err, name)
+		}
+	}
 	if err != nil {
 		return err
 	}
+	if restored, err := result.RowsAffected(); err != nil || restored > 0 {
+		return err
+	}
+	return fmt.Errorf("no deleted category found with id: %s", id)
+}
 
-	deleteSourceSql := `delete from feeds where id = $1;`
-	_, err = p.conn.Exec(deleteSourceSql, id)
-	return err
+// PurgeTrash removes the feeds, with all of their items, the items and the
+// categories, with their links, deleted before deletedBefore in a single
+// transaction.
+func (p PostgresDB) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int, error) {
+	purged := 0
+	err := p.withTx(ctx, func(tx *sql.Tx) error {
+		purgedItemsSql := `select id from items where deleted_at < $1
+or source_id in (select id from feeds where deleted_at < $1)`
+		purgedCategoriesSql := `select id from categories where deleted_at < $1`
+		statements := []struct {
+			sql     string
+			counted bool
+		}{
+			{`delete from item_categories where item_id in (` + purgedItemsSql + `)
+or category_id in (` + purgedCategoriesSql + `);`, false},
+			{`delete from feed_categories where feed_id in (select id from feeds where deleted_at < $1)
+or category_id in (` + purgedCategoriesSql + `);`, false},
+			{`delete from items where id in (` + purgedItemsSql + `);`, true},
+			{`delete from feeds where deleted_at < $1;`, true},
+			{`delete from categories where deleted_at < $1;`, true},
+		}
+		for _, statement := range statements {
+			result, err := tx.ExecContext(ctx, statement.sql, deletedBefore)
+			if err != nil {
+				return err
+			}
+			if statement.counted {
+				deleted, err := result.RowsAffected()
+				if err != nil {
+					return err
+				}
+				purged += int(deleted)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
 }
 
-func NewPostgresDB(connectionString string) (*PostgresDB, error) {
+// NewPostgresDB connected to the database, whose health is checked against
+// the migrations in migrationsDirectory, or those embedded in the binary when
+// it is empty.
+func NewPostgresDB(connectionString, migrationsDirectory string) (*PostgresDB, error) {
 	conn, err := retryConnection(connectionString)
 	if err != nil {
 		return nil, err
 	}
 
-	return &PostgresDB{conn: conn}, nil
+	return &PostgresDB{conn: conn, migrationsDirectory: migrationsDirectory}, nil
+}
+
+// CheckHealth verifies the database is reachable and that every schema
+// migration has been applied cleanly, so that a database left behind, by a
+// rolling deploy or migrating down, isn't reported ready.
+func (p PostgresDB) CheckHealth(ctx context.Context) error {
+	if err := p.conn.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+	return p.CheckSchema(ctx, p.migrationsDirectory)
+}
+
+// SchemaVersion reports the version of the most recently applied migration
+// and whether it failed part way through. Version 0 means no migrations have
+// been applied.
+func (p PostgresDB) SchemaVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	var exists bool
+	existsSql := `select to_regclass('schema_migrations') is not null;`
+	if err := p.conn.QueryRowContext(ctx, existsSql).Scan(&exists); err != nil {
+		return 0, false, err
+	}
+	if !exists {
+		return 0, false, nil
+	}
+
+	selectSql := `select version, dirty from schema_migrations limit 1;`
+	err = p.conn.QueryRowContext(ctx, selectSql).Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Count the feeds, items and categories stored, excluding those in the trash.
+func (p PostgresDB) Count(ctx context.Context) (metrics.Totals, error) {
+	var totals metrics.Totals
+	selectSql := `select (select count(*) from feeds where deleted_at is null),
+		(select count(*) from items where deleted_at is null),
+		(select count(*) from categories where deleted_at is null);`
+	err := p.conn.QueryRowContext(ctx, selectSql).Scan(&totals.Feeds, &totals.Items, &totals.Categories)
+	return totals, err
+}
+
+// Close the database connection pool.
+func (p PostgresDB) Close() error {
+	return p.conn.Close()
 }
 
 func retryConnection(connectionString string) (*sql.DB, error) {
@@ -633,18 +1254,34 @@ func retryConnection(connectionString string) (*sql.DB, error) {
 		ConnectionRetryLimit)
 }
 
+// Migrate applies every migration not yet applied. The migrations embedded
+// in the binary are used unless migrationsDirectory is given.
 func (p PostgresDB) Migrate(migrationsDirectory string) error {
-	driver, err := postgres.WithInstance(p.conn, &postgres.Config{})
+	m, err := p.migrator(migrationsDirectory)
 	if err != nil {
 		return err
 	}
-	m, err := migrate.NewWithDatabaseInstance(
-		fmt.Sprintf("file:///%s", migrationsDirectory),
-		"postgres", driver)
+	err = m.Up()
+	if err != nil {
+		if err != migrate.ErrNoChange {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the most recently applied steps migrations. The
+// migrations embedded in the binary are used unless migrationsDirectory is
+// given.
+func (p PostgresDB) MigrateDown(migrationsDirectory string, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	m, err := p.migrator(migrationsDirectory)
 	if err != nil {
 		return err
 	}
-	err = m.Up()
+	err = m.Steps(-steps)
 	if err != nil {
 		if err != migrate.ErrNoChange {
 			return err
@@ -652,3 +1289,73 @@ func (p PostgresDB) Migrate(migrationsDirectory string) error {
 	}
 	return nil
 }
+
+// CheckSchema verifies that every migration has been applied cleanly, so
+// the schema is the one this binary expects. A schema newer than the latest
+// migration, left by a newer release, is allowed as migrations only add to
+// it.
+func (p PostgresDB) CheckSchema(ctx context.Context, migrationsDirectory string) error {
+	latest, err := LatestMigration(migrationsDirectory)
+	if err != nil {
+		return err
+	}
+	version, dirty, err := p.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("database schema version %d is dirty, the migration failed and must be fixed by hand", version)
+	}
+	if version < latest {
+		return fmt.Errorf("database schema version %d is behind version %d, run rsscollector migrate up", version, latest)
+	}
+	return nil
+}
+
+// LatestMigration is the version of the newest migration. The migrations
+// embedded in the binary are used unless migrationsDirectory is given.
+func LatestMigration(migrationsDirectory string) (uint, error) {
+	src, err := migrationSource(migrationsDirectory)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("no migrations found: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// migrator for the migrations. It isn't closed as that would close the
+// database connection it shares.
+func (p PostgresDB) migrator(migrationsDirectory string) (*migrate.Migrate, error) {
+	src, err := migrationSource(migrationsDirectory)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := postgres.WithInstance(p.conn, &postgres.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithInstance("migrations", src, "postgres", driver)
+}
+
+// migrationSource reads the migrations in migrationsDirectory, or those
+// embedded in the binary when it is empty.
+func migrationSource(migrationsDirectory string) (source.Driver, error) {
+	if len(migrationsDirectory) == 0 {
+		return iofs.New(migrations.FS, ".")
+	}
+	return (&file.File{}).Open(fmt.Sprintf("file://%s", migrationsDirectory))
+}
@@ -0,0 +1,67 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/repository/repositorytest"
+)
+
+// TestPostgresDB runs the conformance suite against the database given in
+// TEST_DATABASE_URL. Every table is truncated between tests so the database
+// should be dedicated to testing.
+func TestPostgresDB(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if len(dsn) == 0 {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := repository.NewPostgresDB(dsn, "")
+	require.Nil(t, err)
+	require.Nil(t, db.Migrate(""))
+	require.Nil(t, db.CheckSchema(context.Background(), ""))
+
+	// A database behind the latest migration isn't ready.
+	require.Nil(t, db.MigrateDown("", 1))
+	assert.NotNil(t, db.CheckHealth(context.Background()))
+	require.Nil(t, db.Migrate(""))
+	require.Nil(t, db.CheckHealth(context.Background()))
+
+	conn, err := sql.Open("postgres", dsn)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	repositorytest.Run(t, func(t *testing.T) repository.Store {
+		_, err := conn.Exec(`truncate table item_categories, feed_categories, items, categories, feeds;`)
+		require.Nil(t, err)
+		return db
+	})
+}
+
+func TestLatestMigration(t *testing.T) {
+	migrationsDir, err := filepath.Abs("../../migrations")
+	require.Nil(t, err)
+	newest, err := filepath.Glob(filepath.Join(migrationsDir, "*.up.sql"))
+	require.Nil(t, err)
+	require.NotEmpty(t, newest)
+
+	embedded, err := repository.LatestMigration("")
+	require.Nil(t, err)
+	fromDir, err := repository.LatestMigration(migrationsDir)
+	require.Nil(t, err)
+
+	// The file names sort by version so the last is the newest.
+	assert.Equal(t, fromDir, embedded)
+	assert.Contains(t, filepath.Base(newest[len(newest)-1]), fmt.Sprint(embedded))
+
+	_, err = repository.LatestMigration(t.TempDir())
+	assert.NotNil(t, err)
+}
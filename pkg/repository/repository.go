@@ -1,29 +1,228 @@
 package repository
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
 	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
 )
 
+// The object stores take a context with every call so that work can be
+// abandoned when the client disconnects, the server shuts down or an
+// operation exceeds its timeout.
+
+// FeedSourceStore holds feed sources. StoreSource puts the FeedURL of the
+// source in its canonical form, as urlnorm.Normalize gives it, and returns a
+// DuplicateSourceError rather than store a new or changed URL that is
+// another feed's however it is written, unless the source is flagged as a
+// duplicate.
 type FeedSourceStore interface {
-	StoreSource(source *rsscollector.FeedSource) error
-	FetchSource(feedID string) (rsscollector.FeedSource, error)
-	FetchAllSources() ([]rsscollector.FeedSourcePartial, error)
-	DeleteSourceByID(feedID string) error
+	StoreSource(ctx context.Context, source *rsscollector.FeedSource) error
+	FetchSource(ctx context.Context, feedID string) (rsscollector.FeedSource, error)
+	FetchAllSources(ctx context.Context) ([]rsscollector.FeedSourcePartial, error)
+	DeleteSourceByID(ctx context.Context, feedID string) error
 }
 
 type FeedItemStore interface {
-	StoreItem(sourceID string, item *rsscollector.FeedItem) error
-	StoreItems(sourceID string, items []*rsscollector.FeedItem) error
-	FetchItemByID(id string) (rsscollector.FeedItem, error)
-	FetchAllItems(options rsscollector.ItemOptions) (rsscollector.FeedItems, error)
-	DeleteItemByID(id string) error
+	StoreItem(ctx context.Context, sourceID string, item *rsscollector.FeedItem) error
+	StoreItems(ctx context.Context, sourceID string, items []*rsscollector.FeedItem) error
+	FetchItemByID(ctx context.Context, id string) (rsscollector.FeedItem, error)
+	FetchAllItems(ctx context.Context, options rsscollector.ItemOptions) (rsscollector.FeedItems, error)
+	DeleteItemByID(ctx context.Context, id string) error
+	// DeleteItemsByID moves the items to the trash. Unknown IDs are ignored.
+	DeleteItemsByID(ctx context.Context, ids []string) error
 }
 
+// FeedCategoryStore holds categories. StoreCategory returns a
+// DuplicateCategoryError rather than give a second live category the name of
+// another.
 type FeedCategoryStore interface {
-	StoreCategory(category *rsscollector.FeedCategory) error
-	FetchAllCategories() ([]rsscollector.FeedCategory, error)
-	FetchCategoryByID(id string) (rsscollector.FeedCategory, error)
-	FetchCategoryByName(name string) (rsscollector.FeedCategory, error)
-	FetchCategoriesForIDs(ids []string) ([]rsscollector.FeedCategory, error)
-	DeleteCategoryByID(id string) error
+	StoreCategory(ctx context.Context, category *rsscollector.FeedCategory) error
+	FetchAllCategories(ctx context.Context) ([]rsscollector.FeedCategory, error)
+	FetchCategoryByID(ctx context.Context, id string) (rsscollector.FeedCategory, error)
+	FetchCategoryByName(ctx context.Context, name string) (rsscollector.FeedCategory, error)
+	FetchCategoriesForIDs(ctx context.Context, ids []string) ([]rsscollector.FeedCategory, error)
+	DeleteCategoryByID(ctx context.Context, id string) error
+}
+
+// TrashStore holds deleted objects until they are restored or purged.
+//
+// Deleting a feed, item or category moves it to the trash, hiding it from
+// every other store method. A deleted feed takes its items with it. Deleted
+// items stay matched by GUID, so collecting one again updates it in the trash
+// rather than storing it anew.
+type TrashStore interface {
+	FetchTrash(ctx context.Context) (rsscollector.Trash, error)
+	// RestoreSource restores the feed along with the items deleted with it.
+	// It returns a DuplicateSourceError while a live feed has its URL,
+	// unless the feed is flagged as a duplicate.
+	RestoreSource(ctx context.Context, feedID string) error
+	// RestoreCategory returns a DuplicateCategoryError while a live
+	// category has its name.
+	// RestoreItem restores the item, which fails while its feed is deleted.
+	RestoreItem(ctx context.Context, id string) error
+	RestoreCategory(ctx context.Context, id string) error
+	// PurgeTrash permanently removes the objects deleted before the given
+	// time, returning the number removed.
+	PurgeTrash(ctx context.Context, deletedBefore time.Time) (int, error)
+}
+
+// HealthChecker is implemented by stores that depend on an external service
+// which can become unavailable.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// Store satisfies all of the object store interfaces.
+type Store interface {
+	FeedSourceStore
+	FeedItemStore
+	FeedCategoryStore
+	TrashStore
+}
+
+// Counter is implemented by stores that can count the objects they hold
+// without fetching them.
+type Counter interface {
+	Count(ctx context.Context) (metrics.Totals, error)
+}
+
+// DuplicateCategoryError is returned for a category name that is the name
+// of the live category with ID.
+type DuplicateCategoryError struct {
+	Name string
+	ID   string
+}
+
+func (d DuplicateCategoryError) Error() string {
+	return fmt.Sprintf("a category named %s already exists as %s", d.Name, d.ID)
+}
+
+// DuplicateSourceError is returned for a feed URL that is the URL of the
+// feed with ID, however it is written.
+type DuplicateSourceError struct {
+	FeedURL string
+	ID      string
+}
+
+func (d DuplicateSourceError) Error() string {
+	return fmt.Sprintf("feed %s has already been added as %s", d.FeedURL, d.ID)
+}
+
+// CheckNotAdded returns a DuplicateSourceError when a feed other than the
+// one with feedID has the feedURL, however it is written.
+func CheckNotAdded(ctx context.Context, store FeedSourceStore, feedURL, feedID string) error {
+	sources, err := store.FetchAllSources(ctx)
+	if err != nil {
+		return err
+	}
+	return findDuplicate(sources, feedURL, feedID)
+}
+
+// findDuplicate of the feedURL among the sources other than feedID.
+func findDuplicate(sources []rsscollector.FeedSourcePartial, feedURL, feedID string) error {
+	key := urlnorm.Key(feedURL)
+	for _, source := range sources {
+		if source.ID != feedID && urlnorm.Key(source.FeedURL) == key {
+			return DuplicateSourceError{FeedURL: feedURL, ID: source.ID}
+		}
+	}
+	return nil
+}
+
+// checkStoredURL rejects storing the source under a URL that is new to it
+// but is the URL of one of the live others not flagged as duplicates, unless
+// the source is flagged as a duplicate itself. A source keeps a URL it
+// already has, so a store holding duplicates from before they were checked
+// can still be written, but one that was flagged is given an empty
+// previousURL so it can't stop being a duplicate while another feed has its
+// URL.
+func checkStoredURL(source *rsscollector.FeedSource, previousURL string, others []rsscollector.FeedSourcePartial) error {
+	if len(source.DuplicateOf) > 0 {
+		return nil
+	}
+	if len(previousURL) > 0 && urlnorm.Key(previousURL) == urlnorm.Key(source.FeedURL) {
+		return nil
+	}
+	owners := make([]rsscollector.FeedSourcePartial, 0, len(others))
+	for _, other := range others {
+		if len(other.DuplicateOf) == 0 {
+			owners = append(owners, other)
+		}
+	}
+	return findDuplicate(owners, source.FeedURL, source.ID)
+}
+
+// NormalizeSourceURLs puts the URLs of the stored feeds in their canonical
+// form, which those stored before feed URLs were normalised may not be in.
+// Feeds whose URLs turn out to be the same are flagged as duplicates of one
+// of them, preferring a feed not flagged already, then one whose URL is
+// already in canonical form, then the lowest ID. It returns the number of
+// feeds changed.
+func NormalizeSourceURLs(ctx context.Context, store FeedSourceStore) (int, error) {
+	sources, err := store.FetchAllSources(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byKey := make(map[string][]rsscollector.FeedSourcePartial)
+	for _, source := range sources {
+		key := urlnorm.Key(source.FeedURL)
+		byKey[key] = append(byKey[key], source)
+	}
+
+	// Duplicates are stored first so that none of them holds the URL the
+	// feed they duplicate is given.
+	var duplicates, renamed []rsscollector.FeedSourcePartial
+	for _, group := range byKey {
+		sort.Slice(group, func(i, j int) bool {
+			a, b := group[i], group[j]
+			if len(a.DuplicateOf) == 0 != (len(b.DuplicateOf) == 0) {
+				return len(a.DuplicateOf) == 0
+			}
+			if isNormalized(a.FeedURL) != isNormalized(b.FeedURL) {
+				return isNormalized(a.FeedURL)
+			}
+			return a.ID < b.ID
+		})
+		for i, source := range group {
+			switch {
+			case i > 0 && len(source.DuplicateOf) == 0:
+				source.DuplicateOf = group[0].ID
+				duplicates = append(duplicates, source)
+			case !isNormalized(source.FeedURL):
+				renamed = append(renamed, source)
+			}
+		}
+	}
+
+	for _, source := range append(duplicates, renamed...) {
+		stored, err := store.FetchSource(ctx, source.ID)
+		if err != nil {
+			return 0, err
+		}
+		stored.DuplicateOf = source.DuplicateOf
+		if err := store.StoreSource(ctx, &stored); err != nil {
+			return 0, fmt.Errorf("failed to normalise the URL of feed %s: %w", source.ID, err)
+		}
+	}
+	return len(duplicates) + len(renamed), nil
+}
+
+// isNormalized reports whether the feed URL is in canonical form, or can't be
+// put in it.
+func isNormalized(feedURL string) bool {
+	normalized, err := urlnorm.Normalize(feedURL)
+	return err != nil || normalized == feedURL
+}
+
+// normalizeFeedURL of the source, leaving a URL that can't be normalised as
+// it is.
+func normalizeFeedURL(source *rsscollector.FeedSource) {
+	if normalized, err := urlnorm.Normalize(source.FeedURL); err == nil {
+		source.FeedURL = normalized
+	}
 }
@@ -0,0 +1,116 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
+)
+
+// unnormalizedStore holds feeds as a store did before their URLs were
+// normalised, normalising only those it is given from then on.
+type unnormalizedStore struct {
+	feeds map[string]rsscollector.FeedSource
+}
+
+func (u *unnormalizedStore) StoreSource(ctx context.Context, source *rsscollector.FeedSource) error {
+	if normalized, err := urlnorm.Normalize(source.FeedURL); err == nil {
+		source.FeedURL = normalized
+	}
+	u.feeds[source.ID] = *source
+	return nil
+}
+
+func (u *unnormalizedStore) FetchSource(ctx context.Context, feedID string) (rsscollector.FeedSource, error) {
+	if source, ok := u.feeds[feedID]; ok {
+		return source, nil
+	}
+	return rsscollector.FeedSource{}, fmt.Errorf("no feed found for feedID: %s", feedID)
+}
+
+func (u *unnormalizedStore) FetchAllSources(ctx context.Context) ([]rsscollector.FeedSourcePartial, error) {
+	sources := make([]rsscollector.FeedSourcePartial, 0, len(u.feeds))
+	for _, source := range u.feeds {
+		sources = append(sources, source.FeedSourcePartial)
+	}
+	return sources, nil
+}
+
+func (u *unnormalizedStore) DeleteSourceByID(ctx context.Context, feedID string) error {
+	delete(u.feeds, feedID)
+	return nil
+}
+
+func newUnnormalizedStore(urls map[string]string) *unnormalizedStore {
+	store := &unnormalizedStore{feeds: make(map[string]rsscollector.FeedSource)}
+	for id, feedURL := range urls {
+		store.feeds[id] = rsscollector.FeedSource{
+			FeedSourcePartial: rsscollector.FeedSourcePartial{ID: id, FeedURL: feedURL},
+		}
+	}
+	return store
+}
+
+func TestNormalizeSourceURLs(t *testing.T) {
+	ctx := context.Background()
+	store := newUnnormalizedStore(map[string]string{
+		"a": "HTTPS://Example.com:443/feed.xml",
+		"b": "https://example.com/feed.xml",
+		"c": "http://example.com/feed.xml/",
+		"d": "https://example.com/other.xml",
+		"e": "https://Example.com/lonely.xml?utm_source=x",
+	})
+
+	normalized, err := repository.NormalizeSourceURLs(ctx, store)
+	require.Nil(t, err)
+	assert.Equal(t, 3, normalized)
+
+	// The feed already in canonical form is the one the others duplicate.
+	assert.Equal(t, "https://example.com/feed.xml", store.feeds["a"].FeedURL)
+	assert.Equal(t, "b", store.feeds["a"].DuplicateOf)
+	assert.Empty(t, store.feeds["b"].DuplicateOf)
+	assert.Equal(t, "b", store.feeds["c"].DuplicateOf)
+	assert.Empty(t, store.feeds["d"].DuplicateOf)
+	assert.Equal(t, "https://example.com/lonely.xml", store.feeds["e"].FeedURL)
+	assert.Empty(t, store.feeds["e"].DuplicateOf)
+
+	// Nothing is left to do the next time.
+	normalized, err = repository.NormalizeSourceURLs(ctx, store)
+	require.Nil(t, err)
+	assert.Zero(t, normalized)
+}
+
+func TestNormalizeSourceURLsWithoutCanonicalFeed(t *testing.T) {
+	ctx := context.Background()
+	store := newUnnormalizedStore(map[string]string{
+		"b": "HTTPS://Example.com/feed.xml",
+		"a": "https://Example.com:443/feed.xml",
+	})
+
+	normalized, err := repository.NormalizeSourceURLs(ctx, store)
+	require.Nil(t, err)
+	assert.Equal(t, 2, normalized)
+	assert.Empty(t, store.feeds["a"].DuplicateOf)
+	assert.Equal(t, "https://example.com/feed.xml", store.feeds["a"].FeedURL)
+	assert.Equal(t, "a", store.feeds["b"].DuplicateOf)
+}
+
+func TestCheckNotAdded(t *testing.T) {
+	ctx := context.Background()
+	store := newUnnormalizedStore(map[string]string{"a": "https://example.com/feed.xml"})
+
+	err := repository.CheckNotAdded(ctx, store, "http://Example.com/feed.xml#top", "")
+	var duplicate repository.DuplicateSourceError
+	require.True(t, errors.As(err, &duplicate), "got %v", err)
+	assert.Equal(t, "a", duplicate.ID)
+
+	assert.Nil(t, repository.CheckNotAdded(ctx, store, "https://example.com/feed.xml", "a"))
+	assert.Nil(t, repository.CheckNotAdded(ctx, store, "https://example.com/other.xml", ""))
+}
@@ -0,0 +1,1196 @@
+// Package repositorytest provides a conformance test suite for implementations
+// of the object store interfaces in the repository package. Every backend is
+// expected to pass the same suite so that the server behaves identically
+// whichever one it is configured with.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+// NewStoreFunc provides an empty store for a single test.
+type NewStoreFunc func(t *testing.T) repository.Store
+
+// Run the full conformance suite against the stores returned by newStore.
+func Run(t *testing.T, newStore NewStoreFunc) {
+	t.Run("FeedSourceStore", func(t *testing.T) {
+		RunFeedSourceStoreTests(t, newStore)
+	})
+	t.Run("FeedItemStore", func(t *testing.T) {
+		RunFeedItemStoreTests(t, newStore)
+	})
+	t.Run("FeedCategoryStore", func(t *testing.T) {
+		RunFeedCategoryStoreTests(t, newStore)
+	})
+	t.Run("TrashStore", func(t *testing.T) {
+		RunTrashStoreTests(t, newStore)
+	})
+}
+
+// RunFeedSourceStoreTests covers storing, fetching, updating and deleting
+// feed sources.
+func RunFeedSourceStoreTests(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+
+	t.Run("StoreAssignsID", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("http://example.com/feed.xml")
+		require.Nil(t, store.StoreSource(ctx, &source))
+		assert.NotEmpty(t, source.ID)
+		assert.Equal(t, rsscollector.FeedSourceLink(source.ID), source.Link)
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, source.ID, fetched.ID)
+		assert.Equal(t, source.FeedURL, fetched.FeedURL)
+		assert.Equal(t, source.Title, fetched.Title)
+		assert.Equal(t, source.Link, fetched.Link)
+	})
+
+	t.Run("StoreKeepsGivenID", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("http://example.com/feed.xml")
+		source.ID = "0b5c8f4e-7b0a-4f6e-9d4c-2f1e8a6b3c5d"
+		require.Nil(t, store.StoreSource(ctx, &source))
+		assert.Equal(t, "0b5c8f4e-7b0a-4f6e-9d4c-2f1e8a6b3c5d", source.ID)
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, source.FeedURL, fetched.FeedURL)
+	})
+
+	t.Run("FetchUnknown", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.FetchSource(ctx, "ca7cf0c5-1a7b-4cb4-a2a2-6a1f5f2c3c47")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("FetchAllEmpty", func(t *testing.T) {
+		store := newStore(t)
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		assert.Empty(t, sources)
+	})
+
+	t.Run("FetchAll", func(t *testing.T) {
+		store := newStore(t)
+		first := newSource("http://example.com/first.xml")
+		second := newSource("http://example.com/second.xml")
+		require.Nil(t, store.StoreSource(ctx, &first))
+		require.Nil(t, store.StoreSource(ctx, &second))
+
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		require.Len(t, sources, 2)
+		assert.ElementsMatch(t,
+			[]string{first.ID, second.ID},
+			[]string{sources[0].ID, sources[1].ID})
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("http://example.com/feed.xml")
+		require.Nil(t, store.StoreSource(ctx, &source))
+		id := source.ID
+
+		source.FeedURL = "http://example.com/moved.xml"
+		source.Title = "Moved"
+		require.Nil(t, store.StoreSource(ctx, &source))
+		assert.Equal(t, id, source.ID)
+
+		fetched, err := store.FetchSource(ctx, id)
+		require.Nil(t, err)
+		assert.Equal(t, "http://example.com/moved.xml", fetched.FeedURL)
+		assert.Equal(t, "Moved", fetched.Title)
+
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		assert.Len(t, sources, 1)
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("http://example.com/feed.xml")
+		source.FeedMetadata = rsscollector.FeedMetadata{
+			Description: "All the news",
+			SiteLink:    "http://example.com/",
+			Language:    "en-gb",
+			Image:       &rsscollector.FeedItemImage{URL: "http://example.com/logo.png", Title: "Logo"},
+			Authors:     []rsscollector.FeedAuthor{{Name: "Jo Bloggs", Email: "jo@example.com"}},
+			Copyright:   "Example Ltd",
+			Generator:   "Hugo",
+			FeedType:    "rss",
+			FeedVersion: "2.0",
+		}
+		require.Nil(t, store.StoreSource(ctx, &source))
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, source.FeedMetadata, fetched.FeedMetadata)
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		require.Len(t, sources, 1)
+		assert.Equal(t, source.FeedMetadata, sources[0].FeedMetadata)
+
+		// Collecting again replaces the metadata rather than merging it.
+		source.FeedMetadata = rsscollector.FeedMetadata{FeedType: "atom", FeedVersion: "1.0"}
+		require.Nil(t, store.StoreSource(ctx, &source))
+		fetched, err = store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, source.FeedMetadata, fetched.FeedMetadata)
+	})
+
+	t.Run("FetchFullContent", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("http://example.com/feed.xml")
+		source.FetchFullContent = true
+		require.Nil(t, store.StoreSource(ctx, &source))
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.True(t, fetched.FetchFullContent)
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		require.Len(t, sources, 1)
+		assert.True(t, sources[0].FetchFullContent)
+	})
+
+	t.Run("Scrape", func(t *testing.T) {
+		store := newStore(t)
+		feed := storeSource(t, store, "http://example.com/feed.xml")
+		page := newSource("http://example.com/news/")
+		page.Scrape = &rsscollector.ScrapeSelectors{Item: "li.story", Title: "h2", DateLayout: "2 Jan 2006"}
+		require.Nil(t, store.StoreSource(ctx, &page))
+
+		fetched, err := store.FetchSource(ctx, page.ID)
+		require.Nil(t, err)
+		assert.Equal(t, page.Scrape, fetched.Scrape)
+		fetched, err = store.FetchSource(ctx, feed.ID)
+		require.Nil(t, err)
+		assert.Nil(t, fetched.Scrape)
+
+		// The stored selectors can't be changed through the source given.
+		page.Scrape.Item = "article"
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		require.Len(t, sources, 2)
+		for _, source := range sources {
+			if source.ID == page.ID {
+				assert.Equal(t, "li.story", source.Scrape.Item)
+			}
+		}
+	})
+
+	t.Run("FetchSettings", func(t *testing.T) {
+		store := newStore(t)
+		feed := storeSource(t, store, "http://example.com/feed.xml")
+		private := newSource("http://example.com/private.xml")
+		private.Fetch = &rsscollector.FetchSettings{
+			Username: "reader",
+			Password: "enc:v1:c2VjcmV0",
+			Headers:  map[string]string{"X-Api-Key": "enc:v1:a2V5"},
+			TLS:      &rsscollector.FetchTLSSettings{ServerName: "feeds.internal"},
+		}
+		require.Nil(t, store.StoreSource(ctx, &private))
+
+		fetched, err := store.FetchSource(ctx, private.ID)
+		require.Nil(t, err)
+		assert.Equal(t, private.Fetch, fetched.Fetch)
+		fetched, err = store.FetchSource(ctx, feed.ID)
+		require.Nil(t, err)
+		assert.Nil(t, fetched.Fetch)
+
+		// The stored settings can't be changed through the source given.
+		private.Fetch.Headers["X-Api-Key"] = "changed"
+		private.Fetch.TLS.ServerName = "changed"
+		fetched, err = store.FetchSource(ctx, private.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "enc:v1:a2V5", fetched.Fetch.Headers["X-Api-Key"])
+		assert.Equal(t, "feeds.internal", fetched.Fetch.TLS.ServerName)
+	})
+
+	t.Run("NormalizesFeedURL", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("HTTPS://Example.com:443/feed.xml?utm_source=y&page=2")
+		require.Nil(t, store.StoreSource(ctx, &source))
+		assert.Equal(t, "https://example.com/feed.xml?page=2", source.FeedURL)
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "https://example.com/feed.xml?page=2", fetched.FeedURL)
+	})
+
+	t.Run("PrunedGUIDs", func(t *testing.T) {
+		store := newStore(t)
+		source := newSource("https://example.com/feed.xml")
+		source.PrunedGUIDs = []string{"one", "two"}
+		require.Nil(t, store.StoreSource(ctx, &source))
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, []string{"one", "two"}, fetched.PrunedGUIDs)
+
+		fetched.PrunedGUIDs = nil
+		require.Nil(t, store.StoreSource(ctx, &fetched))
+		fetched, err = store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.PrunedGUIDs)
+	})
+
+	t.Run("RejectsDuplicateFeedURL", func(t *testing.T) {
+		store := newStore(t)
+		stored := storeSource(t, store, "https://example.com/feed.xml")
+
+		duplicate := newSource("http://Example.com/feed.xml/#latest")
+		err := store.StoreSource(ctx, &duplicate)
+		var duplicateErr repository.DuplicateSourceError
+		require.True(t, errors.As(err, &duplicateErr), "got %v", err)
+		assert.Equal(t, stored.ID, duplicateErr.ID)
+
+		// Nor can another feed be moved onto the URL.
+		other := storeSource(t, store, "https://example.com/other.xml")
+		other.FeedURL = "https://example.com/feed.xml"
+		assert.True(t, errors.As(store.StoreSource(ctx, &other), &duplicateErr))
+
+		// The feed itself keeps its URL however it is written.
+		stored.FeedURL = "http://example.com/feed.xml"
+		require.Nil(t, store.StoreSource(ctx, &stored))
+
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		assert.Len(t, sources, 2)
+	})
+
+	t.Run("StoresFlaggedDuplicateFeedURL", func(t *testing.T) {
+		store := newStore(t)
+		stored := storeSource(t, store, "https://example.com/feed.xml")
+
+		duplicate := newSource("HTTPS://Example.com:443/feed.xml")
+		duplicate.DuplicateOf = stored.ID
+		require.Nil(t, store.StoreSource(ctx, &duplicate))
+		fetched, err := store.FetchSource(ctx, duplicate.ID)
+		require.Nil(t, err)
+		assert.Equal(t, stored.FeedURL, fetched.FeedURL)
+		assert.Equal(t, stored.ID, fetched.DuplicateOf)
+	})
+
+	t.Run("KeepsDuplicateFlaggedWhileURLTaken", func(t *testing.T) {
+		store := newStore(t)
+		stored := storeSource(t, store, "https://example.com/feed.xml")
+		duplicate := newSource("https://example.com/feed.xml")
+		duplicate.DuplicateOf = stored.ID
+		require.Nil(t, store.StoreSource(ctx, &duplicate))
+
+		// The duplicate can't stop being one while it has the URL of a feed
+		// that isn't flagged.
+		duplicate.DuplicateOf = ""
+		err := store.StoreSource(ctx, &duplicate)
+		var duplicateErr repository.DuplicateSourceError
+		require.True(t, errors.As(err, &duplicateErr), "got %v", err)
+		assert.Equal(t, stored.ID, duplicateErr.ID)
+		fetched, err := store.FetchSource(ctx, duplicate.ID)
+		require.Nil(t, err)
+		assert.Equal(t, stored.ID, fetched.DuplicateOf)
+
+		// Once the other is flagged too, the URL is free to be taken.
+		stored.DuplicateOf = duplicate.ID
+		require.Nil(t, store.StoreSource(ctx, &stored))
+		require.Nil(t, store.StoreSource(ctx, &duplicate))
+		fetched, err = store.FetchSource(ctx, duplicate.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.DuplicateOf)
+	})
+
+	t.Run("StoresFeedURLOfDeletedFeed", func(t *testing.T) {
+		store := newStore(t)
+		deleted := storeSource(t, store, "https://example.com/feed.xml")
+		require.Nil(t, store.DeleteSourceByID(ctx, deleted.ID))
+
+		storeSource(t, store, "https://example.com/feed.xml")
+	})
+
+	t.Run("Moves", func(t *testing.T) {
+		store := newStore(t)
+		target := storeSource(t, store, "https://example.com/feed.xml")
+		moved := newSource("http://example.com/old.xml")
+		changedAt := time.Now().UTC().Truncate(time.Second)
+		deadAt := changedAt.Add(time.Hour)
+		moved.URLHistory = []rsscollector.FeedURLChange{
+			{URL: "http://example.com/oldest.xml", Reason: rsscollector.URLChangeEdited, ChangedAt: changedAt},
+			{URL: "http://example.com/older.xml", Reason: rsscollector.URLChangeRedirect, ChangedAt: changedAt},
+		}
+		moved.DuplicateOf = target.ID
+		moved.DeadAt = &deadAt
+		require.Nil(t, store.StoreSource(ctx, &moved))
+
+		fetched, err := store.FetchSource(ctx, moved.ID)
+		require.Nil(t, err)
+		require.Len(t, fetched.URLHistory, 2)
+		assert.Equal(t, "http://example.com/oldest.xml", fetched.URLHistory[0].URL)
+		assert.Equal(t, rsscollector.URLChangeRedirect, fetched.URLHistory[1].Reason)
+		assert.True(t, changedAt.Equal(fetched.URLHistory[1].ChangedAt))
+		assert.Equal(t, target.ID, fetched.DuplicateOf)
+		require.NotNil(t, fetched.DeadAt)
+		assert.True(t, deadAt.Equal(*fetched.DeadAt))
+
+		fetched, err = store.FetchSource(ctx, target.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.URLHistory)
+		assert.Empty(t, fetched.DuplicateOf)
+		assert.Nil(t, fetched.DeadAt)
+
+		// Reviving the feed clears them.
+		moved.DuplicateOf = ""
+		moved.DeadAt = nil
+		require.Nil(t, store.StoreSource(ctx, &moved))
+		fetched, err = store.FetchSource(ctx, moved.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.DuplicateOf)
+		assert.Nil(t, fetched.DeadAt)
+	})
+
+	t.Run("CategoryLinks", func(t *testing.T) {
+		store := newStore(t)
+		news := storeCategory(t, store, "News")
+		tech := storeCategory(t, store, "Technology")
+
+		source := newSource("http://example.com/feed.xml")
+		source.CategoryIDs = []string{news.ID}
+		require.Nil(t, store.StoreSource(ctx, &source))
+
+		fetched, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []string{news.ID}, fetched.CategoryIDs)
+
+		source.CategoryIDs = []string{tech.ID}
+		require.Nil(t, store.StoreSource(ctx, &source))
+		fetched, err = store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []string{tech.ID}, fetched.CategoryIDs)
+
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		require.Len(t, sources, 1)
+		assert.ElementsMatch(t, []string{tech.ID}, sources[0].CategoryIDs)
+
+		source.CategoryIDs = nil
+		require.Nil(t, store.StoreSource(ctx, &source))
+		fetched, err = store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.CategoryIDs)
+	})
+
+	t.Run("DeleteCascadesToItems", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		other := storeSource(t, store, "http://example.com/other.xml")
+		category := storeCategory(t, store, "News")
+
+		item := newItem("one")
+		item.CategoryIDs = []string{category.ID}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		otherItem := newItem("two")
+		require.Nil(t, store.StoreItem(ctx, other.ID, &otherItem))
+
+		require.Nil(t, store.DeleteSourceByID(ctx, source.ID))
+
+		_, err := store.FetchSource(ctx, source.ID)
+		assert.NotNil(t, err)
+		_, err = store.FetchItemByID(ctx, item.ID)
+		assert.NotNil(t, err)
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, []string{otherItem.ID}, itemIDs(items))
+
+		items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{
+			CategoryIDs: []string{category.ID},
+		})
+		require.Nil(t, err)
+		assert.Empty(t, items)
+
+		sources, err := store.FetchAllSources(ctx)
+		require.Nil(t, err)
+		require.Len(t, sources, 1)
+		assert.Equal(t, other.ID, sources[0].ID)
+	})
+}
+
+// RunFeedItemStoreTests covers storing, fetching, filtering and deleting feed
+// items.
+func RunFeedItemStoreTests(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+
+	t.Run("StoreAssignsID", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		item := newItem("one")
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		assert.NotEmpty(t, item.ID)
+		assert.Equal(t, source.ID, item.SourceID)
+
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, item.ID, fetched.ID)
+		assert.Equal(t, source.ID, fetched.SourceID)
+		assert.Equal(t, item.Title, fetched.Title)
+		assert.Equal(t, item.Link, fetched.Link)
+		assert.Equal(t, item.GUID, fetched.GUID)
+		require.NotNil(t, fetched.Published)
+		assert.True(t, item.Published.Equal(*fetched.Published))
+	})
+
+	t.Run("StoreKeepsGivenID", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		item := newItem("one")
+		item.ID = "0b5c8f4e-7b0a-4f6e-9d4c-2f1e8a6b3c5d"
+		item.Starred = true
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&item}))
+		assert.Equal(t, "0b5c8f4e-7b0a-4f6e-9d4c-2f1e8a6b3c5d", item.ID)
+
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, source.ID, fetched.SourceID)
+		assert.Equal(t, item.GUID, fetched.GUID)
+		assert.True(t, fetched.Starred)
+	})
+
+	t.Run("EnclosuresAndMedia", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/podcast.xml")
+
+		item := newItem("episode")
+		item.Enclosures = []rsscollector.FeedItemEnclosure{
+			{URL: "http://example.com/episode.mp3", Type: "audio/mpeg", Length: 1024},
+			{URL: "http://example.com/episode.ogg"},
+		}
+		item.Media = &rsscollector.FeedItemMedia{
+			Duration:   3600,
+			Episode:    3,
+			Season:     2,
+			Explicit:   true,
+			Thumbnails: []rsscollector.FeedItemMediaThumbnail{{URL: "http://example.com/episode.jpg", Width: 100}},
+		}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, item.Enclosures, fetched.Enclosures)
+		assert.Equal(t, item.Media, fetched.Media)
+
+		// Collecting the item again replaces them.
+		again := newItem("episode")
+		require.Nil(t, store.StoreItem(ctx, source.ID, &again))
+		fetched, err = store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.Enclosures)
+		assert.Nil(t, fetched.Media)
+	})
+
+	t.Run("SummaryAndRawHTML", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		item := newItem("story")
+		item.Description = "<p>A story</p>"
+		item.Summary = "A story"
+		item.RawDescription = `<p onclick="track()">A story</p>`
+		item.RawContent = "<script>track()</script>"
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "<p>A story</p>", fetched.Description)
+		assert.Equal(t, "A story", fetched.Summary)
+		assert.Equal(t, item.RawDescription, fetched.RawDescription)
+		assert.Equal(t, item.RawContent, fetched.RawContent)
+	})
+
+	t.Run("OriginalLink", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		item := newItem("story")
+		item.OriginalLink = "http://example.com/story?utm_source=rss"
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, item.Link, fetched.Link)
+		assert.Equal(t, "http://example.com/story?utm_source=rss", fetched.OriginalLink)
+	})
+
+	t.Run("ExtractedContent", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		item := newItem("story")
+		item.Content = "<p>A teaser</p>"
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Nil(t, fetched.ExtractedAt)
+
+		extractedAt := time.Date(2021, 4, 10, 9, 0, 0, 0, time.UTC)
+		fetched.Content = "<p>The whole story</p>"
+		fetched.ExtractedAt = &extractedAt
+		require.Nil(t, store.StoreItem(ctx, source.ID, &fetched))
+
+		fetched, err = store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "<p>The whole story</p>", fetched.Content)
+		require.NotNil(t, fetched.ExtractedAt)
+		assert.True(t, extractedAt.Equal(*fetched.ExtractedAt))
+	})
+
+	t.Run("FetchUnknown", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.FetchItemByID(ctx, "ca7cf0c5-1a7b-4cb4-a2a2-6a1f5f2c3c47")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("StoreItems", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		first, second := newItem("one"), newItem("two")
+		items := []*rsscollector.FeedItem{&first, &second}
+		require.Nil(t, store.StoreItems(ctx, source.ID, items))
+		for _, item := range items {
+			assert.NotEmpty(t, item.ID)
+			assert.Equal(t, source.ID, item.SourceID)
+		}
+
+		third := newItem("three")
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&third}))
+
+		stored, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []string{first.ID, second.ID, third.ID}, itemIDs(stored))
+	})
+
+	t.Run("StoreItemsDeduplicatesByGUID", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		other := storeSource(t, store, "http://example.com/other.xml")
+		category := storeCategory(t, store, "News")
+
+		first := newItem("one")
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&first}))
+		first.CategoryIDs = []string{category.ID}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &first))
+
+		recollected, added := newItem("one"), newItem("two")
+		recollected.Title = "Item one, revised"
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&recollected, &added}))
+		assert.Equal(t, first.ID, recollected.ID)
+		assert.NotEqual(t, first.ID, added.ID)
+
+		fetched, err := store.FetchItemByID(ctx, first.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "Item one, revised", fetched.Title)
+		assert.Equal(t, []string{category.ID}, fetched.CategoryIDs)
+
+		sameGUID := newItem("one")
+		require.Nil(t, store.StoreItem(ctx, other.ID, &sameGUID))
+		assert.NotEqual(t, first.ID, sameGUID.ID)
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []string{first.ID, added.ID}, itemIDs(items))
+	})
+
+	t.Run("StarredKeptWhenRecollected", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		item := newItem("one")
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		item.Starred = true
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		recollected := newItem("one")
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&recollected}))
+		assert.Equal(t, item.ID, recollected.ID)
+
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.True(t, fetched.Starred)
+
+		fetched.Starred = false
+		require.Nil(t, store.StoreItem(ctx, source.ID, &fetched))
+		fetched, err = store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.False(t, fetched.Starred)
+	})
+
+	t.Run("FetchAllOrderedByPublished", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+
+		now := time.Now().UTC().Truncate(time.Second)
+		earlier := now.Add(-time.Hour)
+		latest, oldest, undated := newItem("latest"), newItem("oldest"), newItem("undated")
+		latest.Published = &now
+		oldest.Published = &earlier
+		undated.Published = nil
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&latest, &oldest, &undated}))
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, []string{undated.ID, oldest.ID, latest.ID}, itemIDs(items))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		category := storeCategory(t, store, "News")
+
+		item := newItem("one")
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		id := item.ID
+
+		item.Title = "updated"
+		item.CategoryIDs = []string{category.ID}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		assert.Equal(t, id, item.ID)
+
+		fetched, err := store.FetchItemByID(ctx, id)
+		require.Nil(t, err)
+		assert.Equal(t, "updated", fetched.Title)
+		assert.ElementsMatch(t, []string{category.ID}, fetched.CategoryIDs)
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		assert.Equal(t, []string{id}, itemIDs(items))
+
+		fetched.CategoryIDs = nil
+		require.Nil(t, store.StoreItem(ctx, source.ID, &fetched))
+		fetched, err = store.FetchItemByID(ctx, id)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.CategoryIDs)
+	})
+
+	t.Run("FetchAllEmpty", func(t *testing.T) {
+		store := newStore(t)
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+		require.Nil(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("FetchAllFiltering", func(t *testing.T) {
+		store := newStore(t)
+		first := storeSource(t, store, "http://example.com/first.xml")
+		second := storeSource(t, store, "http://example.com/second.xml")
+		news := storeCategory(t, store, "News")
+		tech := storeCategory(t, store, "Technology")
+
+		firstNews := newItem("first-news")
+		firstNews.CategoryIDs = []string{news.ID}
+		firstPlain := newItem("first-plain")
+		secondTech := newItem("second-tech")
+		secondTech.CategoryIDs = []string{tech.ID}
+		secondBoth := newItem("second-both")
+		secondBoth.CategoryIDs = []string{news.ID, tech.ID}
+		firstPlain.Enclosures = []rsscollector.FeedItemEnclosure{
+			{URL: "http://example.com/episode.mp3", Type: "audio/mpeg", Length: 1024},
+		}
+		secondTech.Enclosures = []rsscollector.FeedItemEnclosure{
+			{URL: "http://example.com/talk.mp4", Type: "video/mp4"},
+		}
+		require.Nil(t, store.StoreItems(ctx, first.ID, []*rsscollector.FeedItem{&firstNews, &firstPlain}))
+		require.Nil(t, store.StoreItems(ctx, second.ID, []*rsscollector.FeedItem{&secondTech, &secondBoth}))
+
+		testCases := []struct {
+			Name     string
+			Options  rsscollector.ItemOptions
+			Expected []string
+		}{
+			{
+				"All items",
+				rsscollector.ItemOptions{},
+				[]string{firstNews.ID, firstPlain.ID, secondTech.ID, secondBoth.ID},
+			},
+			{
+				"By source",
+				rsscollector.ItemOptions{SourceID: first.ID},
+				[]string{firstNews.ID, firstPlain.ID},
+			},
+			{
+				"By category",
+				rsscollector.ItemOptions{CategoryIDs: []string{news.ID}},
+				[]string{firstNews.ID, secondBoth.ID},
+			},
+			{
+				"By any of several categories",
+				rsscollector.ItemOptions{CategoryIDs: []string{news.ID, tech.ID}},
+				[]string{firstNews.ID, secondTech.ID, secondBoth.ID},
+			},
+			{
+				"By source and category",
+				rsscollector.ItemOptions{SourceID: second.ID, CategoryIDs: []string{news.ID}},
+				[]string{secondBoth.ID},
+			},
+			{
+				"No matches",
+				rsscollector.ItemOptions{SourceID: first.ID, CategoryIDs: []string{tech.ID}},
+				[]string{},
+			},
+			{
+				"By enclosure type",
+				rsscollector.ItemOptions{EnclosureType: "audio"},
+				[]string{firstPlain.ID},
+			},
+			{
+				"By enclosure type and subtype",
+				rsscollector.ItemOptions{EnclosureType: "video/MP4"},
+				[]string{secondTech.ID},
+			},
+			{
+				"By any enclosure",
+				rsscollector.ItemOptions{EnclosureType: rsscollector.AnyEnclosure},
+				[]string{firstPlain.ID, secondTech.ID},
+			},
+			{
+				"By enclosure type and category",
+				rsscollector.ItemOptions{EnclosureType: "video", CategoryIDs: []string{news.ID}},
+				[]string{},
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.Name, func(t *testing.T) {
+				items, err := store.FetchAllItems(ctx, tc.Options)
+				require.Nil(t, err)
+				assert.ElementsMatch(t, tc.Expected, itemIDs(items))
+			})
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		category := storeCategory(t, store, "News")
+
+		first, second := newItem("one"), newItem("two")
+		first.CategoryIDs = []string{category.ID}
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&first, &second}))
+
+		require.Nil(t, store.DeleteItemByID(ctx, first.ID))
+
+		_, err := store.FetchItemByID(ctx, first.ID)
+		assert.NotNil(t, err)
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		assert.Equal(t, []string{second.ID}, itemIDs(items))
+		for _, item := range items {
+			assert.NotNil(t, item)
+		}
+
+		items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{CategoryIDs: []string{category.ID}})
+		require.Nil(t, err)
+		assert.Empty(t, items)
+
+		items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID, Deleted: true})
+		require.Nil(t, err)
+		assert.Equal(t, []string{first.ID}, itemIDs(items))
+	})
+
+	t.Run("DeleteSeveral", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		category := storeCategory(t, store, "News")
+
+		first, second, third := newItem("one"), newItem("two"), newItem("three")
+		second.CategoryIDs = []string{category.ID}
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&first, &second, &third}))
+
+		require.Nil(t, store.DeleteItemsByID(ctx, []string{first.ID, second.ID, "ca7cf0c5-1a7b-4cb4-a2a2-6a1f5f2c3c47"}))
+		require.Nil(t, store.DeleteItemsByID(ctx, nil))
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		assert.Equal(t, []string{third.ID}, itemIDs(items))
+
+		items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{CategoryIDs: []string{category.ID}})
+		require.Nil(t, err)
+		assert.Empty(t, items)
+
+		// A deleted item collected again stays deleted.
+		recollected := newItem("one")
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&recollected}))
+		assert.Equal(t, first.ID, recollected.ID)
+		_, err = store.FetchItemByID(ctx, first.ID)
+		assert.NotNil(t, err)
+	})
+}
+
+// RunFeedCategoryStoreTests covers storing, fetching, renaming and deleting
+// categories along with the removal of their links to items and sources.
+func RunFeedCategoryStoreTests(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+
+	t.Run("StoreAssignsID", func(t *testing.T) {
+		store := newStore(t)
+		category := rsscollector.FeedCategory{Name: "News"}
+		require.Nil(t, store.StoreCategory(ctx, &category))
+		assert.NotEmpty(t, category.ID)
+
+		byID, err := store.FetchCategoryByID(ctx, category.ID)
+		require.Nil(t, err)
+		assert.Equal(t, category, byID)
+
+		byName, err := store.FetchCategoryByName(ctx, "News")
+		require.Nil(t, err)
+		assert.Equal(t, category, byName)
+	})
+
+	t.Run("StoreKeepsGivenID", func(t *testing.T) {
+		store := newStore(t)
+		category := rsscollector.FeedCategory{ID: "0b5c8f4e-7b0a-4f6e-9d4c-2f1e8a6b3c5d", Name: "News"}
+		require.Nil(t, store.StoreCategory(ctx, &category))
+
+		byName, err := store.FetchCategoryByName(ctx, "News")
+		require.Nil(t, err)
+		assert.Equal(t, category, byName)
+	})
+
+	t.Run("FetchUnknown", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.FetchCategoryByID(ctx, "ca7cf0c5-1a7b-4cb4-a2a2-6a1f5f2c3c47")
+		assert.NotNil(t, err)
+		_, err = store.FetchCategoryByName(ctx, "missing")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		store := newStore(t)
+		category := storeCategory(t, store, "News")
+
+		category.Name = "Headlines"
+		require.Nil(t, store.StoreCategory(ctx, &category))
+
+		fetched, err := store.FetchCategoryByID(ctx, category.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "Headlines", fetched.Name)
+
+		_, err = store.FetchCategoryByName(ctx, "News")
+		assert.NotNil(t, err)
+		byName, err := store.FetchCategoryByName(ctx, "Headlines")
+		require.Nil(t, err)
+		assert.Equal(t, category.ID, byName.ID)
+
+		categories, err := store.FetchAllCategories(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, []rsscollector.FeedCategory{category}, categories)
+	})
+
+	t.Run("RejectsDuplicateName", func(t *testing.T) {
+		store := newStore(t)
+		news := storeCategory(t, store, "News")
+
+		duplicate := rsscollector.FeedCategory{Name: "News"}
+		err := store.StoreCategory(ctx, &duplicate)
+		var duplicateErr repository.DuplicateCategoryError
+		require.True(t, errors.As(err, &duplicateErr), "got %v", err)
+		assert.Equal(t, news.ID, duplicateErr.ID)
+
+		// Nor can another category be renamed to it.
+		other := storeCategory(t, store, "Sport")
+		other.Name = "News"
+		assert.True(t, errors.As(store.StoreCategory(ctx, &other), &duplicateErr))
+
+		categories, err := store.FetchAllCategories(ctx)
+		require.Nil(t, err)
+		assert.Len(t, categories, 2)
+		byName, err := store.FetchCategoryByName(ctx, "News")
+		require.Nil(t, err)
+		assert.Equal(t, news.ID, byName.ID)
+	})
+
+	t.Run("FetchAll", func(t *testing.T) {
+		store := newStore(t)
+		categories, err := store.FetchAllCategories(ctx)
+		require.Nil(t, err)
+		assert.Empty(t, categories)
+
+		news := storeCategory(t, store, "News")
+		tech := storeCategory(t, store, "Technology")
+		categories, err = store.FetchAllCategories(ctx)
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []rsscollector.FeedCategory{news, tech}, categories)
+	})
+
+	t.Run("FetchForIDs", func(t *testing.T) {
+		store := newStore(t)
+		news := storeCategory(t, store, "News")
+		tech := storeCategory(t, store, "Technology")
+		storeCategory(t, store, "Sport")
+
+		categories, err := store.FetchCategoriesForIDs(ctx, []string{news.ID, tech.ID})
+		require.Nil(t, err)
+		assert.ElementsMatch(t, []rsscollector.FeedCategory{news, tech}, categories)
+	})
+
+	t.Run("DeleteRemovesLinks", func(t *testing.T) {
+		store := newStore(t)
+		news := storeCategory(t, store, "News")
+		tech := storeCategory(t, store, "Technology")
+
+		source := newSource("http://example.com/feed.xml")
+		source.CategoryIDs = []string{news.ID, tech.ID}
+		require.Nil(t, store.StoreSource(ctx, &source))
+
+		item := newItem("one")
+		item.CategoryIDs = []string{news.ID, tech.ID}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		require.Nil(t, store.DeleteCategoryByID(ctx, news.ID))
+
+		_, err := store.FetchCategoryByID(ctx, news.ID)
+		assert.NotNil(t, err)
+		_, err = store.FetchCategoryByName(ctx, news.Name)
+		assert.NotNil(t, err)
+
+		fetchedItem, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, []string{tech.ID}, fetchedItem.CategoryIDs)
+
+		fetchedSource, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, []string{tech.ID}, fetchedSource.CategoryIDs)
+
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{CategoryIDs: []string{news.ID}})
+		require.Nil(t, err)
+		assert.Empty(t, items)
+
+		categories, err := store.FetchAllCategories(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, []rsscollector.FeedCategory{tech}, categories)
+	})
+}
+
+// RunTrashStoreTests covers moving feeds, items and categories to the trash,
+// restoring them and purging them.
+func RunTrashStoreTests(t *testing.T, newStore NewStoreFunc) {
+	ctx := context.Background()
+
+	t.Run("Empty", func(t *testing.T) {
+		store := newStore(t)
+		trash, err := store.FetchTrash(ctx)
+		require.Nil(t, err)
+		assert.Empty(t, trash.Feeds)
+		assert.Empty(t, trash.Items)
+		assert.Empty(t, trash.Categories)
+	})
+
+	t.Run("RestoreSourceWithItsItems", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		category := storeCategory(t, store, "News")
+
+		earlier, withFeed := newItem("earlier"), newItem("with-feed")
+		withFeed.CategoryIDs = []string{category.ID}
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&earlier, &withFeed}))
+		require.Nil(t, store.DeleteItemByID(ctx, earlier.ID))
+		require.Nil(t, store.DeleteSourceByID(ctx, source.ID))
+
+		trash, err := store.FetchTrash(ctx)
+		require.Nil(t, err)
+		require.Len(t, trash.Feeds, 1)
+		assert.Equal(t, source.ID, trash.Feeds[0].ID)
+		assert.NotNil(t, trash.Feeds[0].DeletedAt)
+		assert.Empty(t, trash.Items, "items deleted with their feed are restored with it")
+
+		// Items can't be restored on their own while their feed is deleted.
+		assert.NotNil(t, store.RestoreItem(ctx, earlier.ID))
+
+		require.Nil(t, store.RestoreSource(ctx, source.ID))
+		assert.NotNil(t, store.RestoreSource(ctx, source.ID))
+		_, err = store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: source.ID})
+		require.Nil(t, err)
+		require.Equal(t, []string{withFeed.ID}, itemIDs(items))
+		assert.Equal(t, []string{category.ID}, items[0].CategoryIDs)
+
+		trash, err = store.FetchTrash(ctx)
+		require.Nil(t, err)
+		assert.Empty(t, trash.Feeds)
+		require.Equal(t, []string{earlier.ID}, itemIDs(trash.Items))
+		assert.NotNil(t, trash.Items[0].DeletedAt)
+	})
+
+	t.Run("RestoreSourceWhileAnotherHasItsURL", func(t *testing.T) {
+		store := newStore(t)
+		deleted := storeSource(t, store, "https://example.com/feed.xml")
+		require.Nil(t, store.DeleteSourceByID(ctx, deleted.ID))
+		live := storeSource(t, store, "http://example.com/feed.xml/")
+
+		var duplicateErr repository.DuplicateSourceError
+		require.True(t, errors.As(store.RestoreSource(ctx, deleted.ID), &duplicateErr))
+		assert.Equal(t, live.ID, duplicateErr.ID)
+		trash, err := store.FetchTrash(ctx)
+		require.Nil(t, err)
+		assert.Len(t, trash.Feeds, 1)
+
+		require.Nil(t, store.DeleteSourceByID(ctx, live.ID))
+		require.Nil(t, store.RestoreSource(ctx, deleted.ID))
+		_, err = store.FetchSource(ctx, deleted.ID)
+		assert.Nil(t, err)
+	})
+
+	t.Run("RestoreItem", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		item := newItem("one")
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		assert.NotNil(t, store.RestoreItem(ctx, item.ID), "the item isn't deleted")
+		require.Nil(t, store.DeleteItemByID(ctx, item.ID))
+
+		// Collecting the item again updates it in the trash.
+		recollected := newItem("one")
+		recollected.Title = "Item one, revised"
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&recollected}))
+		_, err := store.FetchItemByID(ctx, item.ID)
+		assert.NotNil(t, err)
+
+		require.Nil(t, store.RestoreItem(ctx, item.ID))
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, "Item one, revised", fetched.Title)
+	})
+
+	t.Run("RestoreCategoryWithItsLinks", func(t *testing.T) {
+		store := newStore(t)
+		news := storeCategory(t, store, "News")
+		source := newSource("http://example.com/feed.xml")
+		source.CategoryIDs = []string{news.ID}
+		require.Nil(t, store.StoreSource(ctx, &source))
+		item := newItem("one")
+		item.CategoryIDs = []string{news.ID}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+		require.Nil(t, store.DeleteCategoryByID(ctx, news.ID))
+		trash, err := store.FetchTrash(ctx)
+		require.Nil(t, err)
+		require.Len(t, trash.Categories, 1)
+		assert.Equal(t, "News", trash.Categories[0].Name)
+
+		// Updating the item while the category is deleted keeps the link.
+		fetched, err := store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Empty(t, fetched.CategoryIDs)
+		require.Nil(t, store.StoreItem(ctx, source.ID, &fetched))
+
+		// The name can't be restored while another category has it.
+		other := storeCategory(t, store, "News")
+		var duplicateErr repository.DuplicateCategoryError
+		require.True(t, errors.As(store.RestoreCategory(ctx, news.ID), &duplicateErr))
+		assert.Equal(t, other.ID, duplicateErr.ID)
+		require.Nil(t, store.DeleteCategoryByID(ctx, other.ID))
+
+		require.Nil(t, store.RestoreCategory(ctx, news.ID))
+		restored, err := store.FetchCategoryByName(ctx, "News")
+		require.Nil(t, err)
+		assert.Equal(t, news.ID, restored.ID)
+		fetched, err = store.FetchItemByID(ctx, item.ID)
+		require.Nil(t, err)
+		assert.Equal(t, []string{news.ID}, fetched.CategoryIDs)
+		fetchedSource, err := store.FetchSource(ctx, source.ID)
+		require.Nil(t, err)
+		assert.Equal(t, []string{news.ID}, fetchedSource.CategoryIDs)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		store := newStore(t)
+		source := storeSource(t, store, "http://example.com/feed.xml")
+		other := storeSource(t, store, "http://example.com/other.xml")
+		news := storeCategory(t, store, "News")
+
+		first, second, kept := newItem("one"), newItem("two"), newItem("kept")
+		kept.CategoryIDs = []string{news.ID}
+		require.Nil(t, store.StoreItems(ctx, source.ID, []*rsscollector.FeedItem{&first, &second}))
+		require.Nil(t, store.StoreItem(ctx, other.ID, &kept))
+
+		require.Nil(t, store.DeleteSourceByID(ctx, source.ID))
+		require.Nil(t, store.DeleteCategoryByID(ctx, news.ID))
+
+		purged, err := store.PurgeTrash(ctx, time.Now().Add(-time.Hour))
+		require.Nil(t, err)
+		assert.Equal(t, 0, purged)
+
+		purged, err = store.PurgeTrash(ctx, time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		assert.Equal(t, 4, purged)
+
+		trash, err := store.FetchTrash(ctx)
+		require.Nil(t, err)
+		assert.Empty(t, trash.Feeds)
+		assert.Empty(t, trash.Items)
+		assert.Empty(t, trash.Categories)
+		assert.NotNil(t, store.RestoreSource(ctx, source.ID))
+
+		// The feed can be added again once purged.
+		storeSource(t, store, "http://example.com/feed.xml")
+		items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+		require.Nil(t, err)
+		require.Equal(t, []string{kept.ID}, itemIDs(items))
+		assert.Empty(t, items[0].CategoryIDs)
+	})
+}
+
+func newSource(feedURL string) rsscollector.FeedSource {
+	return rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{
+			FeedURL:       feedURL,
+			Title:         "Example feed",
+			LastCollected: time.Now().UTC().Truncate(time.Second),
+		},
+	}
+}
+
+func storeSource(t *testing.T, store repository.Store, feedURL string) rsscollector.FeedSource {
+	source := newSource(feedURL)
+	require.Nil(t, store.StoreSource(context.Background(), &source))
+	return source
+}
+
+func newItem(guid string) rsscollector.FeedItem {
+	published := time.Now().UTC().Truncate(time.Second)
+	return rsscollector.FeedItem{
+		Title:       "Item " + guid,
+		Description: "Description of " + guid,
+		Link:        "http://example.com/items/" + guid,
+		GUID:        guid,
+		Published:   &published,
+	}
+}
+
+func storeCategory(t *testing.T, store repository.Store, name string) rsscollector.FeedCategory {
+	category := rsscollector.FeedCategory{Name: name}
+	require.Nil(t, store.StoreCategory(context.Background(), &category))
+	return category
+}
+
+func itemIDs(items rsscollector.FeedItems) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
@@ -0,0 +1,456 @@
+// Package retention removes collected items that are no longer wanted
+// according to retention policies, either on demand or on a schedule.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+)
+
+// Policy for the items kept for a feed. Zero values keep every item.
+//
+// Starred items and items linked to a category are never removed and are
+// not counted towards MaxItems.
+type Policy struct {
+	// MaxAge of the items kept, by their published or, failing that, updated
+	// time. Items with neither are kept regardless of age.
+	MaxAge time.Duration
+	// MaxItems kept for the feed, the most recently published first.
+	MaxItems int
+}
+
+// KeepsEverything is true when the policy never removes an item.
+func (p Policy) KeepsEverything() bool {
+	return p.MaxAge <= 0 && p.MaxItems <= 0
+}
+
+// union keeps every item that either policy keeps.
+func (p Policy) union(other Policy) Policy {
+	return Policy{
+		MaxAge:   time.Duration(maxUnlessZero(int64(p.MaxAge), int64(other.MaxAge))),
+		MaxItems: int(maxUnlessZero(int64(p.MaxItems), int64(other.MaxItems))),
+	}
+}
+
+// maxUnlessZero treats zero, meaning no limit, as the largest value.
+func maxUnlessZero(a, b int64) int64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// policyJSON writes MaxAge as a Go duration string such as "720h0m0s".
+type policyJSON struct {
+	MaxAge   string `json:"maxAge,omitempty"`
+	MaxItems int    `json:"maxItems,omitempty"`
+}
+
+func (p Policy) MarshalJSON() ([]byte, error) {
+	var maxAge string
+	if p.MaxAge > 0 {
+		maxAge = p.MaxAge.String()
+	}
+	return json.Marshal(policyJSON{maxAge, p.MaxItems})
+}
+
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	var decoded policyJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = Policy{MaxItems: decoded.MaxItems}
+	if len(decoded.MaxAge) > 0 {
+		maxAge, err := time.ParseDuration(decoded.MaxAge)
+		if err != nil {
+			return err
+		}
+		p.MaxAge = maxAge
+	}
+	return nil
+}
+
+// Policies to apply to each feed. The policy for a feed is the first of:
+// the one for its feed URL in Feeds; the policies in Categories for the
+// categories the feed belongs to, keeping any item one of them would keep;
+// and Default.
+type Policies struct {
+	Default Policy
+	// Feeds holds the policies for individual feeds by feed URL.
+	Feeds map[string]Policy
+	// Categories holds the policies for the feeds in a category by category
+	// name.
+	Categories map[string]Policy
+}
+
+// KeepsEverything is true when none of the policies ever remove an item.
+func (p Policies) KeepsEverything() bool {
+	if !p.Default.KeepsEverything() {
+		return false
+	}
+	for _, policy := range p.Feeds {
+		if !policy.KeepsEverything() {
+			return false
+		}
+	}
+	for _, policy := range p.Categories {
+		if !policy.KeepsEverything() {
+			return false
+		}
+	}
+	return true
+}
+
+// forFeed resolves the policy for the feed. categoryNames maps category IDs
+// to their names.
+func (p Policies) forFeed(feed rsscollector.FeedSourcePartial, categoryNames map[string]string) Policy {
+	if policy, ok := p.Feeds[feed.FeedURL]; ok {
+		return policy
+	}
+	var policy Policy
+	found := false
+	for _, categoryID := range feed.CategoryIDs {
+		categoryPolicy, ok := p.Categories[categoryNames[categoryID]]
+		switch {
+		case !ok:
+		case !found:
+			policy, found = categoryPolicy, true
+		default:
+			policy = policy.union(categoryPolicy)
+		}
+	}
+	if found {
+		return policy
+	}
+	return p.Default
+}
+
+// Report of the items removed, or that would be removed by a dry run.
+type Report struct {
+	DryRun bool `json:"dryRun"`
+	// Removed is the total number of items removed from every feed.
+	Removed int          `json:"removed"`
+	Feeds   []FeedReport `json:"feeds"`
+}
+
+// FeedReport of the items removed from a single feed.
+type FeedReport struct {
+	FeedID  string `json:"feedId"`
+	FeedURL string `json:"feedUrl"`
+	Policy  Policy `json:"policy"`
+	// Kept is the number of items left once those removed are gone.
+	Kept    int      `json:"kept"`
+	Removed int      `json:"removed"`
+	ItemIDs []string `json:"itemIds,omitempty"`
+}
+
+// ErrStopped is returned for a prune or purge started once the pruner has
+// been stopped.
+var ErrStopped = errors.New("the pruner has been stopped")
+
+// Pruner applies retention policies to the stored items.
+type Pruner struct {
+	feedRepos     repository.FeedSourceStore
+	itemRepos     repository.FeedItemStore
+	categoryRepos repository.FeedCategoryStore
+	trashRepos    repository.TrashStore
+	policies      Policies
+	// trashPeriod objects are kept in the trash for, zero keeps them forever.
+	trashPeriod time.Duration
+	// now is replaced by tests.
+	now     func() time.Time
+	running sync.WaitGroup
+	// stopMu orders counting work in progress with Stop, so that none is
+	// counted once Wait may have started waiting.
+	stopMu sync.Mutex
+	stop   chan struct{}
+}
+
+func NewPruner(
+	feedRepos repository.FeedSourceStore,
+	itemRepos repository.FeedItemStore,
+	categoryRepos repository.FeedCategoryStore,
+	trashRepos repository.TrashStore,
+	policies Policies,
+	trashPeriod time.Duration) *Pruner {
+	return &Pruner{
+		feedRepos:     feedRepos,
+		itemRepos:     itemRepos,
+		categoryRepos: categoryRepos,
+		trashRepos:    trashRepos,
+		policies:      policies,
+		trashPeriod:   trashPeriod,
+		now:           time.Now,
+		stop:          make(chan struct{}),
+	}
+}
+
+// Prune moves the items of every feed that its policy doesn't keep to the
+// trash. A dry run only reports the items that would be removed.
+func (p *Pruner) Prune(ctx context.Context, dryRun bool) (Report, error) {
+	if !p.begin() {
+		return Report{}, ErrStopped
+	}
+	defer p.running.Done()
+	return p.prune(ctx, dryRun)
+}
+
+// prune for Prune, or for Run which has already counted it in progress.
+func (p *Pruner) prune(ctx context.Context, dryRun bool) (report Report, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "retention.Prune",
+		trace.WithAttributes(attribute.Bool("retention.dry_run", dryRun)))
+	defer tracing.EndSpan(span, &err)
+
+	report = Report{DryRun: dryRun, Feeds: make([]FeedReport, 0)}
+	if p.policies.KeepsEverything() {
+		return report, nil
+	}
+
+	feeds, err := p.feedRepos.FetchAllSources(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].FeedURL < feeds[j].FeedURL
+	})
+	categoryNames, err := p.categoryNames(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+
+	now := p.now()
+	for _, feed := range feeds {
+		policy := p.policies.forFeed(feed, categoryNames)
+		if policy.KeepsEverything() {
+			continue
+		}
+		items, err := p.itemRepos.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: feed.ID})
+		if err != nil {
+			return Report{}, err
+		}
+
+		removed := expired(items, policy, now)
+		if len(removed) == 0 {
+			continue
+		}
+		if !dryRun {
+			if err := p.itemRepos.DeleteItemsByID(ctx, removed); err != nil {
+				return Report{}, err
+			}
+			if err := p.recordPruned(ctx, feed.ID, items, removed); err != nil {
+				return Report{}, err
+			}
+			metrics.ItemsPruned.WithLabelValues(metrics.FeedLabel(feed.ID)).Add(float64(len(removed)))
+			log.Info().Str("feedID", feed.ID).Int("removed", len(removed)).Msg("pruned items")
+		}
+		report.Removed += len(removed)
+		report.Feeds = append(report.Feeds, FeedReport{
+			FeedID:  feed.ID,
+			FeedURL: feed.FeedURL,
+			Policy:  policy,
+			Kept:    len(items) - len(removed),
+			Removed: len(removed),
+			ItemIDs: removed,
+		})
+	}
+	span.SetAttributes(attribute.Int("retention.removed", report.Removed))
+	return report, nil
+}
+
+// recordPruned adds the GUIDs of the removed items to the PrunedGUIDs of the
+// feed, so that they aren't collected again once they are purged from the
+// trash while the feed still lists them.
+func (p *Pruner) recordPruned(ctx context.Context, feedID string, items rsscollector.FeedItems, removed []string) error {
+	removedIDs := make(map[string]struct{}, len(removed))
+	for _, id := range removed {
+		removedIDs[id] = struct{}{}
+	}
+	source, err := p.feedRepos.FetchSource(ctx, feedID)
+	if err != nil {
+		return err
+	}
+	pruned := make(map[string]struct{}, len(source.PrunedGUIDs))
+	for _, guid := range source.PrunedGUIDs {
+		pruned[guid] = struct{}{}
+	}
+	for _, item := range items {
+		if _, ok := removedIDs[item.ID]; !ok || len(item.GUID) == 0 {
+			continue
+		}
+		if _, ok := pruned[item.GUID]; !ok {
+			pruned[item.GUID] = struct{}{}
+			source.PrunedGUIDs = append(source.PrunedGUIDs, item.GUID)
+		}
+	}
+	return p.feedRepos.StoreSource(ctx, &source)
+}
+
+// PurgeTrash permanently removes the objects that have been in the trash for
+// longer than the trash period, returning how many were removed.
+func (p *Pruner) PurgeTrash(ctx context.Context) (int, error) {
+	if !p.begin() {
+		return 0, ErrStopped
+	}
+	defer p.running.Done()
+	return p.purgeTrash(ctx)
+}
+
+// purgeTrash for PurgeTrash, or for Run which has already counted it in
+// progress.
+func (p *Pruner) purgeTrash(ctx context.Context) (purged int, err error) {
+
+	ctx, span := tracing.Tracer().Start(ctx, "retention.PurgeTrash")
+	defer tracing.EndSpan(span, &err)
+
+	if p.trashPeriod <= 0 {
+		return 0, nil
+	}
+	purged, err = p.trashRepos.PurgeTrash(ctx, p.now().Add(-p.trashPeriod))
+	if err != nil {
+		return 0, err
+	}
+	if purged > 0 {
+		metrics.TrashPurged.Add(float64(purged))
+		log.Info().Int("purged", purged).Msg("purged trash")
+	}
+	span.SetAttributes(attribute.Int("retention.purged", purged))
+	return purged, nil
+}
+
+func (p *Pruner) categoryNames(ctx context.Context) (map[string]string, error) {
+	names := make(map[string]string)
+	if len(p.policies.Categories) == 0 {
+		return names, nil
+	}
+	categories, err := p.categoryRepos.FetchAllCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range categories {
+		names[category.ID] = category.Name
+	}
+	return names, nil
+}
+
+// expired returns the IDs of the items the policy doesn't keep. The items
+// are ordered by published time, oldest first.
+func expired(items rsscollector.FeedItems, policy Policy, now time.Time) []string {
+	var removed []string
+	kept := 0
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.Starred || len(item.CategoryIDs) > 0 {
+			continue
+		}
+		tooMany := policy.MaxItems > 0 && kept >= policy.MaxItems
+		tooOld := false
+		if t := itemTime(item); policy.MaxAge > 0 && t != nil {
+			tooOld = now.Sub(*t) > policy.MaxAge
+		}
+		if tooMany || tooOld {
+			removed = append(removed, item.ID)
+			continue
+		}
+		kept++
+	}
+	return removed
+}
+
+func itemTime(item *rsscollector.FeedItem) *time.Time {
+	if item.Published != nil {
+		return item.Published
+	}
+	return item.Updated
+}
+
+// Run prunes the items and purges the trash on every tick of interval until
+// ctx is done. It returns straight away when interval isn't positive or there
+// is nothing to remove, as the policies keep every item and the trash is kept
+// forever.
+func (p *Pruner) Run(ctx context.Context, interval time.Duration) {
+	if !p.begin() {
+		return
+	}
+	defer p.running.Done()
+
+	if interval <= 0 || (p.policies.KeepsEverything() && p.trashPeriod <= 0) {
+		log.Info().Msg("scheduled pruning disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			// A tick that came due while stopping is dropped.
+			if p.stopped() {
+				return
+			}
+			if _, err := p.prune(ctx, false); err != nil {
+				log.Error().Err(err).Msg("failed to prune items")
+			}
+			if _, err := p.purgeTrash(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to purge trash")
+			}
+		}
+	}
+}
+
+// Stop scheduling prunes, leaving those in progress to finish.
+func (p *Pruner) Stop() {
+	p.stopMu.Lock()
+	defer p.stopMu.Unlock()
+	if !p.stopped() {
+		close(p.stop)
+	}
+}
+
+// begin counts work in progress for Wait, unless the pruner has been
+// stopped.
+func (p *Pruner) begin() bool {
+	p.stopMu.Lock()
+	defer p.stopMu.Unlock()
+	if p.stopped() {
+		return false
+	}
+	p.running.Add(1)
+	return true
+}
+
+func (p *Pruner) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until Run has returned and there are no prunes in progress. It
+// must only be called once the pruner has been stopped, as nothing more is
+// started from then on.
+func (p *Pruner) Wait() {
+	p.running.Wait()
+}
@@ -0,0 +1,208 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+var testNow = time.Date(2021, 4, 5, 12, 0, 0, 0, time.UTC)
+
+// storeFeed stores a feed with an item published on each of the days
+// before testNow given by ages, returning the item IDs in the same order.
+func storeFeed(t *testing.T, store *repository.MemoryFeedStore, feedURL string, categoryIDs []string, ages ...int) (rsscollector.FeedSource, []string) {
+	ctx := context.Background()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: feedURL, CategoryIDs: categoryIDs},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	ids := make([]string, 0, len(ages))
+	for _, age := range ages {
+		published := testNow.Add(-time.Duration(age) * 24 * time.Hour)
+		item := rsscollector.FeedItem{GUID: fmt.Sprintf("%s/%d", feedURL, age), Published: &published}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+		ids = append(ids, item.ID)
+	}
+	return source, ids
+}
+
+func TestExpired(t *testing.T) {
+	day := 24 * time.Hour
+	published := func(age int) *time.Time {
+		t := testNow.Add(-time.Duration(age) * day)
+		return &t
+	}
+	// Ordered oldest first as the stores return them.
+	items := rsscollector.FeedItems{
+		{ID: "undated"},
+		{ID: "40 days, starred", Published: published(40), Starred: true},
+		{ID: "30 days", Published: published(30)},
+		{ID: "20 days, categorised", Published: published(20), CategoryIDs: []string{"news"}},
+		{ID: "10 days, updated", Updated: published(10)},
+		{ID: "1 day", Published: published(1)},
+	}
+
+	testCases := []struct {
+		Name     string
+		Policy   Policy
+		Expected []string
+	}{
+		{
+			"Keep everything",
+			Policy{},
+			nil,
+		},
+		{
+			"Max items",
+			Policy{MaxItems: 2},
+			[]string{"30 days", "undated"},
+		},
+		{
+			"Max age keeps undated",
+			Policy{MaxAge: 15 * day},
+			[]string{"30 days"},
+		},
+		{
+			"Both",
+			Policy{MaxAge: 5 * day, MaxItems: 3},
+			[]string{"10 days, updated", "30 days"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, expired(items, tc.Policy, testNow))
+		})
+	}
+}
+
+func TestPolicyForFeed(t *testing.T) {
+	policies := Policies{
+		Default: Policy{MaxItems: 100},
+		Feeds: map[string]Policy{
+			"http://example.com/feed.xml": {MaxItems: 10},
+		},
+		Categories: map[string]Policy{
+			"News":    {MaxAge: time.Hour, MaxItems: 50},
+			"Archive": {},
+			"Sport":   {MaxAge: 2 * time.Hour, MaxItems: 20},
+		},
+	}
+	categoryNames := map[string]string{"1": "News", "2": "Archive", "3": "Sport", "4": "Other"}
+
+	testCases := []struct {
+		Name        string
+		FeedURL     string
+		CategoryIDs []string
+		Expected    Policy
+	}{
+		{"Default", "http://example.com/other.xml", nil, Policy{MaxItems: 100}},
+		{"Uncategorised", "http://example.com/other.xml", []string{"4"}, Policy{MaxItems: 100}},
+		{"Feed overrides categories", "http://example.com/feed.xml", []string{"1"}, Policy{MaxItems: 10}},
+		{"Category", "http://example.com/other.xml", []string{"1", "4"}, Policy{MaxAge: time.Hour, MaxItems: 50}},
+		{"Most lenient category", "http://example.com/other.xml", []string{"1", "3"}, Policy{MaxAge: 2 * time.Hour, MaxItems: 50}},
+		{"Category keeping everything", "http://example.com/other.xml", []string{"1", "2"}, Policy{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			feed := rsscollector.FeedSourcePartial{FeedURL: tc.FeedURL, CategoryIDs: tc.CategoryIDs}
+			assert.Equal(t, tc.Expected, policies.forFeed(feed, categoryNames))
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	metrics.SetPerFeedLabels(true)
+	store := repository.NewMemoryStore()
+	archive := rsscollector.FeedCategory{Name: "Archive"}
+	require.Nil(t, store.StoreCategory(ctx, &archive))
+
+	daily, dailyIDs := storeFeed(t, store, "http://example.com/daily.xml", nil, 1, 2, 3, 4)
+	_, archivedIDs := storeFeed(t, store, "http://example.com/archive.xml", []string{archive.ID}, 1, 2, 3, 4)
+
+	starred, err := store.FetchItemByID(ctx, dailyIDs[3])
+	require.Nil(t, err)
+	starred.Starred = true
+	require.Nil(t, store.StoreItem(ctx, daily.ID, &starred))
+
+	pruner := NewPruner(store, store, store, store, Policies{
+		Default:    Policy{MaxItems: 2},
+		Categories: map[string]Policy{"Archive": {}},
+	}, time.Hour)
+	pruner.now = func() time.Time { return testNow }
+
+	preview, err := pruner.Prune(ctx, true)
+	require.Nil(t, err)
+	assert.Equal(t, Report{
+		DryRun:  true,
+		Removed: 1,
+		Feeds: []FeedReport{{
+			FeedID:  daily.ID,
+			FeedURL: daily.FeedURL,
+			Policy:  Policy{MaxItems: 2},
+			Kept:    3,
+			Removed: 1,
+			ItemIDs: []string{dailyIDs[2]},
+		}},
+	}, preview)
+
+	items, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+	require.Nil(t, err)
+	assert.Len(t, items, 8)
+
+	report, err := pruner.Prune(ctx, false)
+	require.Nil(t, err)
+	preview.DryRun = false
+	assert.Equal(t, preview, report)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ItemsPruned.WithLabelValues(daily.ID)))
+
+	items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{SourceID: daily.ID})
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{dailyIDs[0], dailyIDs[1], dailyIDs[3]}, itemIDsOf(items))
+	items, err = store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+	require.Nil(t, err)
+	assert.Len(t, items, 3+len(archivedIDs))
+
+	report, err = pruner.Prune(ctx, false)
+	require.Nil(t, err)
+	assert.Equal(t, 0, report.Removed)
+	assert.Empty(t, report.Feeds)
+
+	// Pruned items are purged from the trash after the trash period.
+	trash, err := store.FetchTrash(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []string{dailyIDs[2]}, itemIDsOf(trash.Items))
+
+	pruner.now = time.Now
+	purged, err := pruner.PurgeTrash(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 0, purged)
+
+	pruner.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	purged, err = pruner.PurgeTrash(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, purged)
+	trash, err = store.FetchTrash(ctx)
+	require.Nil(t, err)
+	assert.Empty(t, trash.Items)
+}
+
+func itemIDsOf(items rsscollector.FeedItems) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
@@ -0,0 +1,408 @@
+// Package sanitize cleans the HTML publishers put in item descriptions and
+// content before it is stored, so clients can render it without running
+// scripts, loading frames or being tracked. Elements and attributes are kept
+// only when a Policy allows them and links are resolved against the item's
+// own link so they still work away from the publisher's site.
+package sanitize
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// DefaultSummaryLength in characters of the plain text summary of an item.
+const DefaultSummaryLength = 300
+
+// Policy decides which HTML is kept.
+type Policy struct {
+	// Elements allowed, with the attributes allowed on each. Other elements
+	// are removed but their content is kept, other than for the elements
+	// that are never kept, such as script, whose content goes with them.
+	Elements map[string][]string
+	// Attributes allowed on every allowed element.
+	Attributes []string
+	// URLSchemes allowed in links and sources, once relative URLs have been
+	// resolved. Attributes with other schemes, e.g. javascript, are removed.
+	URLSchemes []string
+	// KeepTrackingPixels keeps images of a pixel or less, which are
+	// otherwise removed as they are only there to track readers.
+	KeepTrackingPixels bool
+}
+
+// DefaultPolicy allows the text formatting, links, lists, tables and images
+// found in articles.
+func DefaultPolicy() Policy {
+	return Policy{
+		Elements: map[string][]string{
+			"a":          {"href", "title"},
+			"abbr":       {"title"},
+			"b":          nil,
+			"blockquote": {"cite"},
+			"br":         nil,
+			"caption":    nil,
+			"cite":       nil,
+			"code":       nil,
+			"dd":         nil,
+			"del":        nil,
+			"details":    nil,
+			"div":        nil,
+			"dl":         nil,
+			"dt":         nil,
+			"em":         nil,
+			"figcaption": nil,
+			"figure":     nil,
+			"h1":         nil,
+			"h2":         nil,
+			"h3":         nil,
+			"h4":         nil,
+			"h5":         nil,
+			"h6":         nil,
+			"hr":         nil,
+			"i":          nil,
+			"img":        {"src", "alt", "title", "width", "height"},
+			"ins":        nil,
+			"kbd":        nil,
+			"li":         nil,
+			"mark":       nil,
+			"ol":         {"start"},
+			"p":          nil,
+			"pre":        nil,
+			"q":          {"cite"},
+			"s":          nil,
+			"samp":       nil,
+			"small":      nil,
+			"span":       nil,
+			"strong":     nil,
+			"sub":        nil,
+			"summary":    nil,
+			"sup":        nil,
+			"table":      nil,
+			"tbody":      nil,
+			"td":         {"colspan", "rowspan"},
+			"tfoot":      nil,
+			"th":         {"colspan", "rowspan", "scope"},
+			"thead":      nil,
+			"time":       {"datetime"},
+			"tr":         nil,
+			"u":          nil,
+			"ul":         nil,
+		},
+		Attributes: []string{"lang", "dir"},
+		URLSchemes: []string{"http", "https", "mailto"},
+	}
+}
+
+// neverKept are removed along with their content whatever the policy says,
+// as their content is either code or isn't meant to be read as text.
+var neverKept = map[atom.Atom]struct{}{
+	atom.Applet:   {},
+	atom.Base:     {},
+	atom.Embed:    {},
+	atom.Frame:    {},
+	atom.Frameset: {},
+	atom.Head:     {},
+	atom.Iframe:   {},
+	atom.Link:     {},
+	atom.Math:     {},
+	atom.Meta:     {},
+	atom.Noscript: {},
+	atom.Object:   {},
+	atom.Script:   {},
+	atom.Style:    {},
+	atom.Svg:      {},
+	atom.Template: {},
+	atom.Title:    {},
+}
+
+// urlAttributes hold a URL that is resolved and checked against the allowed
+// schemes.
+var urlAttributes = map[string]struct{}{
+	"cite": {},
+	"href": {},
+	"src":  {},
+}
+
+// blockElements separate the words either side of them in plain text.
+var blockElements = map[atom.Atom]struct{}{
+	atom.Address: {}, atom.Article: {}, atom.Aside: {}, atom.Blockquote: {}, atom.Br: {},
+	atom.Dd: {}, atom.Details: {}, atom.Div: {}, atom.Dl: {}, atom.Dt: {}, atom.Figcaption: {},
+	atom.Figure: {}, atom.Footer: {}, atom.H1: {}, atom.H2: {}, atom.H3: {}, atom.H4: {},
+	atom.H5: {}, atom.H6: {}, atom.Header: {}, atom.Hr: {}, atom.Li: {}, atom.Ol: {},
+	atom.P: {}, atom.Pre: {}, atom.Section: {}, atom.Table: {}, atom.Td: {}, atom.Th: {},
+	atom.Tr: {}, atom.Ul: {},
+}
+
+// Sanitizer applies a Policy to the HTML of items.
+type Sanitizer struct {
+	elements   map[string]map[string]struct{}
+	attributes map[string]struct{}
+	schemes    map[string]struct{}
+	policy     Policy
+	// KeepOriginal keeps the description and content as the feed gave them
+	// in the item's RawDescription and RawContent.
+	KeepOriginal bool
+	// SummaryLength in characters, DefaultSummaryLength when it is zero.
+	SummaryLength int
+}
+
+func New(policy Policy) *Sanitizer {
+	s := &Sanitizer{
+		elements:   make(map[string]map[string]struct{}, len(policy.Elements)),
+		attributes: toSet(policy.Attributes),
+		schemes:    toSet(policy.URLSchemes),
+		policy:     policy,
+	}
+	for element, attributes := range policy.Elements {
+		s.elements[strings.ToLower(element)] = toSet(attributes)
+	}
+	return s
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[strings.ToLower(value)] = struct{}{}
+	}
+	return set
+}
+
+// Item sanitizes the description and content of the item and sets its
+// summary. Relative URLs are resolved against the item's link, or base when
+// the item has no absolute link of its own.
+func (s *Sanitizer) Item(item *rsscollector.FeedItem, base *url.URL) {
+	if link, err := url.Parse(item.Link); err == nil && link.IsAbs() {
+		base = link
+	}
+	if s.KeepOriginal {
+		item.RawDescription = item.Description
+		item.RawContent = item.Content
+	}
+	item.Description = s.HTML(item.Description, base)
+	item.Content = s.HTML(item.Content, base)
+
+	summaryLength := s.SummaryLength
+	if summaryLength <= 0 {
+		summaryLength = DefaultSummaryLength
+	}
+	summary := Text(item.Description)
+	if len(summary) == 0 {
+		summary = Text(item.Content)
+	}
+	item.Summary = Truncate(summary, summaryLength)
+}
+
+// HTML cleans the fragment according to the policy, resolving relative URLs
+// against base when it isn't nil. Fragments that are only text are returned
+// with their special characters escaped.
+func (s *Sanitizer) HTML(fragment string, base *url.URL) string {
+	if len(strings.TrimSpace(fragment)) == 0 {
+		return ""
+	}
+	nodes, err := parseFragment(fragment)
+	if err != nil {
+		return html.EscapeString(fragment)
+	}
+
+	var b strings.Builder
+	for _, node := range nodes {
+		for _, cleaned := range s.clean(node, base) {
+			if err := html.Render(&b, cleaned); err != nil {
+				return ""
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parseFragment parses HTML as the content of a div.
+func parseFragment(fragment string) ([]*html.Node, error) {
+	return html.ParseFragment(strings.NewReader(fragment), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+}
+
+// clean returns the nodes that replace n, a copy of n with its allowed
+// attributes and cleaned children when the element is allowed, its cleaned
+// children when it isn't or nothing when it is never kept.
+func (s *Sanitizer) clean(n *html.Node, base *url.URL) []*html.Node {
+	switch n.Type {
+	case html.TextNode:
+		return []*html.Node{{Type: html.TextNode, Data: n.Data}}
+	case html.ElementNode:
+	default:
+		// Comments and doctypes.
+		return nil
+	}
+
+	if _, ok := neverKept[n.DataAtom]; ok {
+		return nil
+	}
+	var children []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, s.clean(child, base)...)
+	}
+
+	name := strings.ToLower(n.Data)
+	allowed, ok := s.elements[name]
+	if !ok {
+		return children
+	}
+
+	cleaned := &html.Node{Type: html.ElementNode, Data: name, DataAtom: atom.Lookup([]byte(name))}
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if len(attr.Namespace) > 0 || strings.HasPrefix(key, "on") {
+			continue
+		}
+		_, elementAllows := allowed[key]
+		_, globallyAllowed := s.attributes[key]
+		if !elementAllows && !globallyAllowed {
+			continue
+		}
+		value := attr.Val
+		if _, ok := urlAttributes[key]; ok {
+			var valid bool
+			if value, valid = s.cleanURL(value, base); !valid {
+				continue
+			}
+		}
+		cleaned.Attr = append(cleaned.Attr, html.Attribute{Key: key, Val: value})
+	}
+
+	switch name {
+	case "img":
+		if !hasAttr(cleaned, "src") || (!s.policy.KeepTrackingPixels && isTrackingPixel(cleaned)) {
+			return nil
+		}
+	case "a":
+		if hasAttr(cleaned, "href") {
+			cleaned.Attr = append(cleaned.Attr, html.Attribute{Key: "rel", Val: "nofollow noopener noreferrer"})
+		}
+	}
+
+	for _, child := range children {
+		cleaned.AppendChild(child)
+	}
+	return []*html.Node{cleaned}
+}
+
+// cleanURL resolves the URL against base and reports whether it has one of
+// the allowed schemes.
+func (s *Sanitizer) cleanURL(value string, base *url.URL) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if !u.IsAbs() {
+		// Without a base there is nothing to resolve it against so it is
+		// kept as it is, a relative URL can't change the scheme.
+		return u.String(), true
+	}
+	_, ok := s.schemes[strings.ToLower(u.Scheme)]
+	return u.String(), ok
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrackingPixel reports whether the image is at most a pixel wide and
+// high.
+func isTrackingPixel(n *html.Node) bool {
+	var width, height = -1, -1
+	for _, attr := range n.Attr {
+		value, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(attr.Val), "px"))
+		if err != nil {
+			continue
+		}
+		switch attr.Key {
+		case "width":
+			width = value
+		case "height":
+			height = value
+		}
+	}
+	return width >= 0 && width <= 1 && height >= 0 && height <= 1
+}
+
+// Text extracts the readable text of an HTML fragment, with the words either
+// side of block elements kept apart and runs of whitespace collapsed.
+func Text(fragment string) string {
+	if len(strings.TrimSpace(fragment)) == 0 {
+		return ""
+	}
+	nodes, err := parseFragment(fragment)
+	if err != nil {
+		return strings.Join(strings.Fields(fragment), " ")
+	}
+	var b strings.Builder
+	for _, node := range nodes {
+		writeText(&b, node)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func writeText(b *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.WriteString(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	if _, ok := neverKept[n.DataAtom]; ok {
+		return
+	}
+	_, block := blockElements[n.DataAtom]
+	if block {
+		b.WriteByte(' ')
+	}
+	if n.DataAtom == atom.Img {
+		for _, attr := range n.Attr {
+			if attr.Key == "alt" {
+				b.WriteString(" " + attr.Val + " ")
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeText(b, child)
+	}
+	if block {
+		b.WriteByte(' ')
+	}
+}
+
+// Truncate text to at most length characters, breaking between words where
+// there is one to break at and marking the cut with an ellipsis.
+func Truncate(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	runes := []rune(text)
+	cut := string(runes[:length-1])
+	// Unless the cut falls between words, the word it splits is dropped.
+	if runes[length-1] != ' ' {
+		if space := strings.LastIndex(cut, " "); space > 0 {
+			cut = cut[:space]
+		}
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
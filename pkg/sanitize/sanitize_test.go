@@ -0,0 +1,129 @@
+package sanitize
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+func TestHTML(t *testing.T) {
+	base, err := url.Parse("https://example.com/news/story.html")
+	require.Nil(t, err)
+
+	testCases := []struct {
+		Name     string
+		HTML     string
+		Expected string
+	}{
+		{"Empty", "  ", ""},
+		{"Text is escaped", "Fish & chips", "Fish &amp; chips"},
+		{"Allowed formatting", "<p>A <strong>bold</strong> <em>claim</em></p>", "<p>A <strong>bold</strong> <em>claim</em></p>"},
+		{"Script removed with its content", `<p>Hi</p><script>alert("x")</script>`, "<p>Hi</p>"},
+		{"Style removed with its content", "<style>p { color: red }</style><p>Hi</p>", "<p>Hi</p>"},
+		{"Iframe removed", `<iframe src="https://example.com/ad"></iframe><p>Hi</p>`, "<p>Hi</p>"},
+		{"Unknown element unwrapped", "<section><p>Hi</p></section>", "<p>Hi</p>"},
+		{"Event handlers removed", `<p onclick="steal()" class="x">Hi</p>`, "<p>Hi</p>"},
+		{"Global attributes kept", `<p lang="fr" style="color: red">Salut</p>`, `<p lang="fr">Salut</p>`},
+		{"Comments removed", "<p>Hi<!-- secret --></p>", "<p>Hi</p>"},
+		{
+			"Relative link resolved",
+			`<a href="../sport/">Sport</a>`,
+			`<a href="https://example.com/sport/" rel="nofollow noopener noreferrer">Sport</a>`,
+		},
+		{"Script link removed", `<a href="javascript:alert(1)">Click</a>`, "<a>Click</a>"},
+		{"Encoded script link removed", `<a href="jav&#x09;ascript:alert(1)">Click</a>`, "<a>Click</a>"},
+		{
+			"Image resolved",
+			`<img src="/pic.jpg" alt="A picture" onerror="steal()">`,
+			`<img src="https://example.com/pic.jpg" alt="A picture"/>`,
+		},
+		{"Tracking pixel removed", `<p>Hi<img src="https://tracker.example/p.gif" width="1" height="1"></p>`, "<p>Hi</p>"},
+		{"Image without a source removed", `<img alt="nothing">`, ""},
+		{"Data image removed", `<img src="data:image/png;base64,AAAA">`, ""},
+		{"Unclosed tags closed", "<p><b>Hi", "<p><b>Hi</b></p>"},
+	}
+
+	s := New(DefaultPolicy())
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, s.HTML(tc.HTML, base))
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	s := New(Policy{
+		Elements:           map[string][]string{"P": {"Class"}, "img": {"src", "width", "height"}},
+		URLSchemes:         []string{"https"},
+		KeepTrackingPixels: true,
+	})
+	assert.Equal(t, `<p class="intro">Hi <img src="https://example.com/p.gif" width="1" height="1"/></p>`,
+		s.HTML(`<p class="intro"><b>Hi</b> <img src="https://example.com/p.gif" width="1" height="1"></p>`, nil))
+	assert.Equal(t, "<p></p>", s.HTML(`<p><img src="http://example.com/p.gif"></p>`, nil))
+	// Without a base, relative URLs are left relative.
+	assert.Equal(t, `<img src="/p.gif"/>`, s.HTML(`<img src="/p.gif">`, nil))
+}
+
+func TestText(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		HTML     string
+		Expected string
+	}{
+		{"Empty", "", ""},
+		{"Plain", "Fish &amp; chips", "Fish & chips"},
+		{"Blocks separate words", "<p>One</p><p>Two</p><ul><li>Three</li><li>Four</li></ul>", "One Two Three Four"},
+		{"Inline elements don't", "<p>Un<b>believ</b>able</p>", "Unbelievable"},
+		{"Whitespace collapsed", "<p>  One\n\n  Two </p>", "One Two"},
+		{"Scripts left out", "<p>Hi</p><script>var x = 1</script>", "Hi"},
+		{"Image descriptions kept", `<p>Look<img src="a.jpg" alt="a cat"></p>`, "Look a cat"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, Text(tc.HTML))
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "Short", Truncate("Short", 10))
+	assert.Equal(t, "The quick…", Truncate("The quick brown fox", 12))
+	assert.Equal(t, "Überlängew…", Truncate("Überlängewort", 11))
+	assert.Equal(t, "One, two…", Truncate("One, two, three", 10))
+}
+
+func TestItem(t *testing.T) {
+	base, err := url.Parse("https://example.com/")
+	require.Nil(t, err)
+	item := rsscollector.FeedItem{
+		Link:        "https://example.com/news/story.html",
+		Description: `<p>A <a href="more.html">story</a></p><script>track()</script>`,
+		Content:     `<p>The whole story</p>`,
+	}
+
+	s := New(DefaultPolicy())
+	s.KeepOriginal = true
+	s.SummaryLength = 5
+	s.Item(&item, base)
+	assert.Equal(t,
+		`<p>A <a href="https://example.com/news/more.html" rel="nofollow noopener noreferrer">story</a></p>`,
+		item.Description)
+	assert.Equal(t, `<p>The whole story</p>`, item.Content)
+	assert.Equal(t, `<p>A <a href="more.html">story</a></p><script>track()</script>`, item.RawDescription)
+	assert.Equal(t, `<p>The whole story</p>`, item.RawContent)
+	assert.Equal(t, "A…", item.Summary)
+
+	// The summary comes from the content when there is no description and
+	// the originals aren't kept unless asked for.
+	item = rsscollector.FeedItem{Content: strings.Repeat("word ", 100)}
+	New(DefaultPolicy()).Item(&item, base)
+	assert.Empty(t, item.RawContent)
+	assert.LessOrEqual(t, len([]rune(item.Summary)), DefaultSummaryLength)
+	assert.True(t, strings.HasSuffix(item.Summary, "word…"))
+}
@@ -0,0 +1,250 @@
+// Package secrets encrypts the credentials feeds are collected with, so they
+// are only ever stored encrypted, and redacts them from API responses.
+//
+// The secrets of FetchSettings are the password, bearer token, client key,
+// the values of headers and cookies and the proxy URL when it holds a
+// password. Each is encrypted on its own with AES-GCM, leaving the rest of
+// the settings readable.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+)
+
+// KeySize of the key secrets are encrypted with, in bytes.
+const KeySize = 32
+
+// Redacted replaces secrets in API responses. A secret given as Redacted
+// when settings are updated keeps its stored value.
+const Redacted = "REDACTED"
+
+// prefix marks an encrypted value, with the version of the scheme.
+const prefix = "enc:v1:"
+
+// ErrNoKey is returned when there are secrets to encrypt or decrypt and no
+// key to do it with.
+var ErrNoKey = errors.New("no secrets key is configured")
+
+// Box encrypts and decrypts secrets with a key. A nil Box has no key, it can
+// only handle settings without secrets.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// ParseKey decodes a base64 encoded key of KeySize bytes.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key is %d bytes rather than %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+func NewBox(key []byte) (*Box, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// IsEncrypted reports whether the value was encrypted by a Box.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt the value, leaving values that already are as they are.
+func (b *Box) Encrypt(value string) (string, error) {
+	if IsEncrypted(value) {
+		return value, nil
+	}
+	if b == nil {
+		return "", ErrNoKey
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return prefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt the value, leaving values that aren't encrypted as they are.
+func (b *Box) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if b == nil {
+		return "", ErrNoKey
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", fmt.Errorf("malformed secret: %w", err)
+	}
+	if len(sealed) < b.aead.NonceSize() {
+		return "", errors.New("malformed secret: too short")
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, the key may have changed: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Seal returns a copy of the settings with their secrets encrypted, ready
+// to be stored.
+func (b *Box) Seal(settings *rsscollector.FetchSettings) (*rsscollector.FetchSettings, error) {
+	return transform(settings, b.Encrypt)
+}
+
+// Open returns a copy of the stored settings with their secrets decrypted,
+// ready to make requests with.
+func (b *Box) Open(settings *rsscollector.FetchSettings) (*rsscollector.FetchSettings, error) {
+	return transform(settings, b.Decrypt)
+}
+
+// Redact returns a copy of the settings with each of their secrets replaced
+// by Redacted.
+func Redact(settings *rsscollector.FetchSettings) *rsscollector.FetchSettings {
+	redacted, _ := transform(settings, func(string) (string, error) {
+		return Redacted, nil
+	})
+	return redacted
+}
+
+// KeepRedacted returns a copy of the updated settings where each secret
+// given as Redacted takes its value from the stored settings, so settings
+// fetched from the API can be sent back with a change.
+func KeepRedacted(updated, stored *rsscollector.FetchSettings) *rsscollector.FetchSettings {
+	if updated == nil {
+		return nil
+	}
+	if stored == nil {
+		stored = &rsscollector.FetchSettings{}
+	}
+	kept := copySettings(updated)
+	keep := func(value *string, storedValue string) {
+		if *value == Redacted {
+			*value = storedValue
+		}
+	}
+	keep(&kept.Password, stored.Password)
+	keep(&kept.BearerToken, stored.BearerToken)
+	keep(&kept.ProxyURL, stored.ProxyURL)
+	for name, value := range kept.Headers {
+		keep(&value, stored.Headers[name])
+		kept.Headers[name] = value
+	}
+	for name, value := range kept.Cookies {
+		keep(&value, stored.Cookies[name])
+		kept.Cookies[name] = value
+	}
+	if kept.TLS != nil {
+		storedKey := ""
+		if stored.TLS != nil {
+			storedKey = stored.TLS.ClientKey
+		}
+		keep(&kept.TLS.ClientKey, storedKey)
+	}
+	return kept
+}
+
+// transform returns a copy of the settings with fn applied to each secret
+// that is set.
+func transform(
+	settings *rsscollector.FetchSettings,
+	fn func(value string) (string, error)) (*rsscollector.FetchSettings, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	transformed := copySettings(settings)
+	apply := func(value *string) error {
+		if len(*value) == 0 {
+			return nil
+		}
+		result, err := fn(*value)
+		if err != nil {
+			return err
+		}
+		*value = result
+		return nil
+	}
+
+	secrets := []*string{&transformed.Password, &transformed.BearerToken}
+	if isSecretProxy(transformed.ProxyURL) {
+		secrets = append(secrets, &transformed.ProxyURL)
+	}
+	if transformed.TLS != nil {
+		secrets = append(secrets, &transformed.TLS.ClientKey)
+	}
+	for _, value := range secrets {
+		if err := apply(value); err != nil {
+			return nil, err
+		}
+	}
+	for _, values := range []map[string]string{transformed.Headers, transformed.Cookies} {
+		for name, value := range values {
+			if err := apply(&value); err != nil {
+				return nil, err
+			}
+			values[name] = value
+		}
+	}
+	return transformed, nil
+}
+
+// isSecretProxy reports whether the proxy URL holds a password, or is
+// already encrypted.
+func isSecretProxy(proxyURL string) bool {
+	if IsEncrypted(proxyURL) {
+		return true
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		// A URL that can't be parsed can't be shown without its password.
+		return len(proxyURL) > 0
+	}
+	_, ok := u.User.Password()
+	return ok
+}
+
+// copySettings takes a copy of the settings that shares nothing with them.
+func copySettings(settings *rsscollector.FetchSettings) *rsscollector.FetchSettings {
+	copied := *settings
+	copied.Headers = copyMap(settings.Headers)
+	copied.Cookies = copyMap(settings.Cookies)
+	if settings.TLS != nil {
+		tls := *settings.TLS
+		copied.TLS = &tls
+	}
+	return &copied
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requireAPIKey rejects requests that don't send one of the keys, either as
+// a bearer token in the Authorization header or in the X-API-Key header.
+func requireAPIKey(keys []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if len(key) > 0 && validAPIKey(keys, key) {
+			return c.Next()
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return fiber.ErrUnauthorized
+	}
+}
+
+// validAPIKey compares key with every one of the keys in constant time so
+// that the time taken doesn't reveal how much of a key was guessed.
+func validAPIKey(keys []string, key string) bool {
+	valid := 0
+	for _, k := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(k), []byte(key))
+	}
+	return valid == 1
+}
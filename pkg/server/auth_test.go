@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	store := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil,
+		&Config{APIKeys: []string{"first", "second"}})
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		Header         string
+		Value          string
+		ExpectedStatus int
+	}{
+		{"No key", "/categories/", "", "", http.StatusUnauthorized},
+		{"Wrong key", "/categories/", "X-API-Key", "third", http.StatusUnauthorized},
+		{"Key header", "/categories/", "X-API-Key", "first", http.StatusOK},
+		{"Bearer token", "/categories/", "Authorization", "Bearer second", http.StatusOK},
+		{"Wrong scheme", "/categories/", "Authorization", "Basic second", http.StatusUnauthorized},
+		{"Probes are open", "/healthz", "", "", http.StatusOK},
+		{"Metrics need a key", "/metrics", "", "", http.StatusUnauthorized},
+		{"Metrics with a key", "/metrics", "X-API-Key", "first", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.Path, nil)
+			if len(tc.Header) > 0 {
+				req.Header.Set(tc.Header, tc.Value)
+			}
+			resp, err := s.app.Test(req)
+			require.Nil(t, err)
+			assert.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestPublicMetrics(t *testing.T) {
+	store := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil,
+		&Config{APIKeys: []string{"first"}, PublicMetrics: true})
+
+	resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodGet, "/categories/", nil))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
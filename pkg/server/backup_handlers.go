@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/JonPulfer/rss_collector/pkg/backup"
+)
+
+// getExport responds with an archive of every feed, item and category, see
+// the backup package for its format. The archive is written to the client
+// as it is read from the stores, once the headers have been sent, so an
+// export that fails part way through ends the response early and is only
+// logged.
+func (h HTTPFeedServer) getExport(c *fiber.Ctx) error {
+	// The body is written after the handler returns, by which time the
+	// request's span has ended, so the context is taken from it now.
+	parent := traceContext(c)
+
+	// Attachment sets the content type from the file extension, which isn't
+	// one it knows.
+	c.Attachment(fmt.Sprintf("rsscollector-%s.ndjson", time.Now().UTC().Format("20060102T150405Z")))
+	c.Set(fiber.HeaderContentType, backup.ContentType)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := h.withRequestTimeout(parent)
+		defer cancel()
+
+		if _, err := backup.Export(ctx, w, h.feedRepos, h.itemRepos, h.categoryRepos); err != nil {
+			log.Error().Err(err).Msg("failed to export archive")
+		}
+	})
+	return nil
+}
+
+// postImport loads the archive in the request body, keeping the IDs of the
+// objects in it, and responds with the number of each imported. The body is
+// read as it arrives rather than held in memory, and isn't bound by the
+// BodyLimit or the ReadTimeout, only by the RequestTimeout. The import isn't
+// transactional: should it fail part way through, the objects imported so
+// far are left in place and importing the archive again overwrites them.
+func (h HTTPFeedServer) postImport(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.Context().Conn().SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	summary, err := backup.Import(ctx, requestBody(c), h.feedRepos, h.itemRepos, h.categoryRepos)
+	if err != nil {
+		return err
+	}
+	return c.JSON(summary)
+}
+
+// requestBody reads the body of a request as it arrives.
+func requestBody(c *fiber.Ctx) io.Reader {
+	if body := c.Context().RequestBodyStream(); body != nil {
+		return body
+	}
+	// Requests without a Content-Length or Transfer-Encoding have no stream.
+	return bytes.NewReader(c.Body())
+}
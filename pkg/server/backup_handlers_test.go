@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/backup"
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/feed.xml"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	item := rsscollector.FeedItem{GUID: "one"}
+	require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+	newServer := func(store *repository.MemoryFeedStore) *HTTPFeedServer {
+		// The cache is enabled to check that exports are never cached.
+		return NewHTTPFeedServer(store, store, store, store,
+			collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{CacheTTL: time.Minute})
+	}
+
+	resp, err := newServer(store).app.Test(httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, backup.ContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	archive, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	restored := repository.NewMemoryStore()
+	s := newServer(restored)
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(string(archive))))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var summary backup.Summary
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&summary))
+	assert.Equal(t, backup.Summary{Feeds: 1, Items: 1}, summary)
+
+	fetched, err := restored.FetchItemByID(ctx, item.ID)
+	require.Nil(t, err)
+	assert.Equal(t, source.ID, fetched.SourceID)
+
+	// The export following the import includes what was imported.
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	require.Nil(t, err)
+	reexported, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Contains(t, string(reexported), item.ID)
+
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader("{}")))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestImportLargerThanBodyLimit(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/feed.xml"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	for i := 0; i < 10; i++ {
+		item := rsscollector.FeedItem{GUID: fmt.Sprint(i), Description: strings.Repeat("x", 1024)}
+		require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+	}
+	var archive bytes.Buffer
+	_, err := backup.Export(ctx, &archive, store, store, store)
+	require.Nil(t, err)
+
+	restored := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(restored, restored, restored, restored,
+		collector.NewCollector(restored, restored, &collector.Config{}), nil, nil, &Config{BodyLimit: 1024})
+
+	// The archive is streamed to the import despite being larger than the
+	// limit.
+	resp, err := s.app.Test(httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(archive.Bytes())))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var summary backup.Summary
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&summary))
+	assert.Equal(t, backup.Summary{Feeds: 1, Items: 10}, summary)
+
+	// Other requests are held to it.
+	body := fmt.Sprintf(`{"feedUrl": "http://example.com/%s.xml"}`, strings.Repeat("x", 1024))
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodPost, "/feeds/", strings.NewReader(body)))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
@@ -7,7 +7,10 @@ import (
 )
 
 func (h HTTPFeedServer) getCategories(c *fiber.Ctx) error {
-	categories, err := h.categoryRepos.FetchAllCategories()
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	categories, err := h.categoryRepos.FetchAllCategories(ctx)
 	if err != nil {
 		return err
 	}
@@ -23,6 +26,9 @@ func (c CreateCategoryRequest) Validate() error {
 }
 
 func (h HTTPFeedServer) postCategories(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	var createCategoryRequest CreateCategoryRequest
 	if err := c.BodyParser(&createCategoryRequest); err != nil {
 		return err
@@ -35,20 +41,23 @@ func (h HTTPFeedServer) postCategories(c *fiber.Ctx) error {
 		Name: createCategoryRequest.Name,
 	}
 
-	if err := h.categoryRepos.StoreCategory(&category); err != nil {
-		return err
+	if err := h.categoryRepos.StoreCategory(ctx, &category); err != nil {
+		return categoryError(err)
 	}
 
 	return c.JSON(category)
 }
 
 func (h HTTPFeedServer) getCategory(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	categoryID := c.Params("id")
 	if err := validateID(categoryID); err != nil {
 		return err
 	}
 
-	category, err := h.categoryRepos.FetchCategoryByID(categoryID)
+	category, err := h.categoryRepos.FetchCategoryByID(ctx, categoryID)
 	if err != nil {
 		return err
 	}
@@ -65,6 +74,9 @@ func (u UpdateCategoryRequest) Validate() error {
 }
 
 func (h HTTPFeedServer) putCategory(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	categoryID := c.Params("id")
 	if err := validateID(categoryID); err != nil {
 		return err
@@ -77,26 +89,29 @@ func (h HTTPFeedServer) putCategory(c *fiber.Ctx) error {
 		return err
 	}
 
-	category, err := h.categoryRepos.FetchCategoryByID(categoryID)
+	category, err := h.categoryRepos.FetchCategoryByID(ctx, categoryID)
 	if err != nil {
 		return err
 	}
 
 	category.Name = updateCategoryRequest.Name
 
-	if err := h.categoryRepos.StoreCategory(&category); err != nil {
-		return err
+	if err := h.categoryRepos.StoreCategory(ctx, &category); err != nil {
+		return categoryError(err)
 	}
 
 	return c.JSON(category)
 }
 
 func (h HTTPFeedServer) deleteCategory(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	categoryID := c.Params("id")
 	if err := validateID(categoryID); err != nil {
 		return err
 	}
-	if err := h.categoryRepos.DeleteCategoryByID(categoryID); err != nil {
+	if err := h.categoryRepos.DeleteCategoryByID(ctx, categoryID); err != nil {
 		return err
 	}
 
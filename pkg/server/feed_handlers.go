@@ -1,33 +1,100 @@
 package server
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
 
 	rsscollector "github.com/JonPulfer/rss_collector/pkg"
-	"github.com/JonPulfer/rss_collector/pkg/feed"
+	"github.com/JonPulfer/rss_collector/pkg/icon"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
 )
 
 func (h HTTPFeedServer) getFeeds(c *fiber.Ctx) error {
-	feeds, err := h.feedRepos.FetchAllSources()
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	language := strings.ToLower(strings.TrimSpace(c.Query("language")))
+	feedType := strings.ToLower(strings.TrimSpace(c.Query("feedType")))
+	sortBy := c.Query("sort")
+	less, ok := feedOrders[sortBy]
+	if len(sortBy) > 0 && !ok {
+		return ValidationError{
+			Err: nil,
+			Msg: fmt.Sprintf("provided sort is not valid: %s", sortBy),
+		}
+	}
+
+	feeds, err := h.feedRepos.FetchAllSources(ctx)
 	if err != nil {
 		return err
 	}
 
-	return c.JSON(feeds)
+	filtered := make([]rsscollector.FeedSourcePartial, 0, len(feeds))
+	for _, feed := range feeds {
+		if len(language) > 0 && !matchesLanguage(feed.Language, language) {
+			continue
+		}
+		if len(feedType) > 0 && feed.FeedType != feedType {
+			continue
+		}
+		filtered = append(filtered, redactSource(feed))
+	}
+	if less != nil {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return less(filtered[i], filtered[j])
+		})
+	}
+
+	return c.JSON(filtered)
+}
+
+// feedOrders are the orders feeds can be listed in, by the sort query
+// parameter. Feeds are listed in the order the store gives them by default.
+var feedOrders = map[string]func(a, b rsscollector.FeedSourcePartial) bool{
+	"title": func(a, b rsscollector.FeedSourcePartial) bool {
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+	},
+	"language": func(a, b rsscollector.FeedSourcePartial) bool {
+		return a.Language < b.Language
+	},
+	"feedType": func(a, b rsscollector.FeedSourcePartial) bool {
+		return a.FeedType < b.FeedType
+	},
+	// Most recently collected first.
+	"lastCollected": func(a, b rsscollector.FeedSourcePartial) bool {
+		return a.LastCollected.After(b.LastCollected)
+	},
+}
+
+// matchesLanguage reports whether the feed language is the one asked for or
+// a variant of it, so en matches en-gb as well as en.
+func matchesLanguage(feedLanguage, language string) bool {
+	return feedLanguage == language || strings.HasPrefix(feedLanguage, language+"-")
 }
 
 func (h HTTPFeedServer) getFeed(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	feedID := c.Params("id")
 	if err := validateID(feedID); err != nil {
 		return err
 	}
-	sourceFeed, err := h.feedRepos.FetchSource(feedID)
+	sourceFeed, err := h.feedRepos.FetchSource(ctx, feedID)
 	if err != nil {
 		return err
 	}
-	feedItems, err := h.itemRepos.FetchAllItems(
+	feedItems, err := h.itemRepos.FetchAllItems(ctx,
 		rsscollector.ItemOptions{
 			SourceID: sourceFeed.ID,
 		})
@@ -36,17 +103,49 @@ func (h HTTPFeedServer) getFeed(c *fiber.Ctx) error {
 	}
 	sort.Sort(feedItems)
 	sourceFeed.FeedItems = feedItems
+	sourceFeed.FeedSourcePartial = redactSource(sourceFeed.FeedSourcePartial)
 
 	return c.JSON(sourceFeed)
 }
 
-// CreateFeedRequest to add a new feed source to track.
+// CreateFeedRequest to add a new feed source to track. With Scrape, FeedURL
+// is a web page without a feed whose items are found with the selectors.
 type CreateFeedRequest struct {
-	FeedURL string `json:"feedUrl"`
+	FeedURL string                        `json:"feedUrl"`
+	Scrape  *rsscollector.ScrapeSelectors `json:"scrape,omitempty"`
+	Fetch   *rsscollector.FetchSettings   `json:"fetch,omitempty"`
 }
 
 func (c CreateFeedRequest) Validate() error {
-	return validateFeedURL(c.FeedURL)
+	if err := validateFeedURL(c.FeedURL); err != nil {
+		return err
+	}
+	if c.Scrape != nil {
+		if err := validateSelectors(*c.Scrape); err != nil {
+			return err
+		}
+	}
+	if c.Fetch != nil {
+		return validateFetchSettings(*c.Fetch)
+	}
+	return nil
+}
+
+// source described by the request, with its URL normalised and the secrets
+// of its fetch settings encrypted.
+func (h HTTPFeedServer) newSource(c CreateFeedRequest) (rsscollector.FeedSourcePartial, error) {
+	feedURL, err := urlnorm.Normalize(c.FeedURL)
+	if err != nil {
+		return rsscollector.FeedSourcePartial{}, err
+	}
+	if err := h.checkFeedURL(feedURL); err != nil {
+		return rsscollector.FeedSourcePartial{}, err
+	}
+	sealed, err := h.sealFetchSettings(c.Fetch)
+	if err != nil {
+		return rsscollector.FeedSourcePartial{}, err
+	}
+	return rsscollector.FeedSourcePartial{FeedURL: feedURL, Scrape: c.Scrape, Fetch: sealed}, nil
 }
 
 // CreateFeedResponse provides the identifying information for the newly created
@@ -57,6 +156,9 @@ type CreateFeedResponse struct {
 }
 
 func (h HTTPFeedServer) postFeeds(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	var feedRequest CreateFeedRequest
 	if err := c.BodyParser(&feedRequest); err != nil {
 		return err
@@ -64,21 +166,13 @@ func (h HTTPFeedServer) postFeeds(c *fiber.Ctx) error {
 	if err := feedRequest.Validate(); err != nil {
 		return err
 	}
-	source, err := feed.NewSource(feedRequest.FeedURL)
+	from, err := h.newSource(feedRequest)
 	if err != nil {
 		return err
 	}
-	if err := source.Collect(); err != nil {
-		return err
-	}
-
-	feedSource := source.FeedSource()
-	if err := h.feedRepos.StoreSource(&feedSource); err != nil {
-		return err
-	}
-
-	if err := h.itemRepos.StoreItems(feedSource.ID, source.Items()); err != nil {
-		return err
+	feedSource, err := h.collector.AddSourceFrom(ctx, from)
+	if err != nil {
+		return fetchError(err)
 	}
 
 	resp := CreateFeedResponse{
@@ -89,10 +183,51 @@ func (h HTTPFeedServer) postFeeds(c *fiber.Ctx) error {
 	return c.JSON(resp)
 }
 
+// postFeedPreview scrapes the web page with the selectors and responds with
+// the source and items found, without storing them.
+func (h HTTPFeedServer) postFeedPreview(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	var previewRequest CreateFeedRequest
+	if err := c.BodyParser(&previewRequest); err != nil {
+		return err
+	}
+	if previewRequest.Scrape == nil {
+		return ValidationError{
+			Err: nil,
+			Msg: "scrape selectors are required",
+		}
+	}
+	if err := previewRequest.Validate(); err != nil {
+		return err
+	}
+
+	from, err := h.newSource(previewRequest)
+	if err != nil {
+		return err
+	}
+	preview, err := h.collector.PreviewSource(ctx, from)
+	if err != nil {
+		return fetchError(err)
+	}
+	preview.FeedSourcePartial = redactSource(preview.FeedSourcePartial)
+	return c.JSON(preview)
+}
+
 // UpdateFeedRequest with new and additional information.
 type UpdateFeedRequest struct {
+	// FeedURL replaces the URL of the feed, keeping the old one in its
+	// history, and revives the feed when it is dead or a duplicate.
 	FeedURL     string   `json:"feedURL"`
 	CategoryIDs []string `json:"categoryIDs"`
+	// FetchFullContent is left as it is when it isn't given.
+	FetchFullContent *bool `json:"fetchFullContent"`
+	// Scrape replaces the selectors of a scraped source.
+	Scrape *rsscollector.ScrapeSelectors `json:"scrape"`
+	// Fetch replaces the fetch settings, an empty object removes them.
+	// Secrets given as they were redacted keep their stored values.
+	Fetch *rsscollector.FetchSettings `json:"fetch"`
 }
 
 func (u UpdateFeedRequest) Validate() error {
@@ -106,6 +241,9 @@ func (u UpdateFeedRequest) Validate() error {
 			return err
 		}
 	}
+	if u.Scrape != nil {
+		return validateSelectors(*u.Scrape)
+	}
 	return nil
 }
 
@@ -115,6 +253,9 @@ type UpdateFeedResponse struct {
 }
 
 func (h HTTPFeedServer) putFeed(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	var updateRequest UpdateFeedRequest
 	if err := c.BodyParser(&updateRequest); err != nil {
 		return err
@@ -127,41 +268,166 @@ func (h HTTPFeedServer) putFeed(c *fiber.Ctx) error {
 		return err
 	}
 
-	feedSource, err := h.feedRepos.FetchSource(feedID)
+	feedSource, err := h.feedRepos.FetchSource(ctx, feedID)
 	if err != nil {
 		return err
 	}
 
 	if len(updateRequest.FeedURL) > 0 {
-		if feedSource.FeedURL != updateRequest.FeedURL {
-			if err := validateFeedURL(updateRequest.FeedURL); err != nil {
+		feedURL, err := urlnorm.Normalize(updateRequest.FeedURL)
+		if err != nil {
+			return err
+		}
+		if feedSource.FeedURL != feedURL {
+			if err := h.checkFeedURL(feedURL); err != nil {
 				return err
 			}
-			feedSource.FeedURL = updateRequest.FeedURL
+			feedSource.URLHistory = append(feedSource.URLHistory, rsscollector.FeedURLChange{
+				URL:       feedSource.FeedURL,
+				Reason:    rsscollector.URLChangeEdited,
+				ChangedAt: time.Now(),
+			})
+			feedSource.FeedURL = feedURL
+			// A duplicate given a new URL is one no longer, unless the URL
+			// is that of another feed, which the store refuses.
+			feedSource.DuplicateOf = ""
 		}
+		// Giving the URL, changed or not, revives a feed that is dead so
+		// that it is collected again.
+		feedSource.DeadAt = nil
 	}
 
 	if len(updateRequest.CategoryIDs) > 0 {
 		feedSource.CategoryIDs = updateRequest.CategoryIDs
 	}
 
-	if err := h.feedRepos.StoreSource(&feedSource); err != nil {
-		return err
+	if updateRequest.FetchFullContent != nil {
+		feedSource.FetchFullContent = *updateRequest.FetchFullContent
 	}
 
-	resp := UpdateFeedResponse{Feed: rsscollector.NewFeedSourcePartial(feedSource)}
+	if updateRequest.Scrape != nil {
+		if feedSource.Scrape == nil {
+			return ValidationError{
+				Err: nil,
+				Msg: "only scraped feeds have selectors",
+			}
+		}
+		feedSource.Scrape = updateRequest.Scrape
+	}
+
+	if updateRequest.Fetch != nil {
+		fetch, err := h.updateFetchSettings(updateRequest.Fetch, feedSource.Fetch)
+		if err != nil {
+			return err
+		}
+		feedSource.Fetch = fetch
+	}
+
+	if err := h.feedRepos.StoreSource(ctx, &feedSource); err != nil {
+		return duplicateError(err)
+	}
+
+	resp := UpdateFeedResponse{Feed: redactSource(rsscollector.NewFeedSourcePartial(feedSource))}
 
 	return c.JSON(resp)
 }
 
+// updateFetchSettings returns the updated settings, with the secrets given as
+// they were redacted taking their stored values, encrypted for storing.
+func (h HTTPFeedServer) updateFetchSettings(updated, stored *rsscollector.FetchSettings) (*rsscollector.FetchSettings, error) {
+	if reflect.DeepEqual(*updated, rsscollector.FetchSettings{}) {
+		return nil, nil
+	}
+	merged := secrets.KeepRedacted(updated, stored)
+	// Settings are validated with their secrets, stored ones included.
+	opened, err := h.config.Secrets.Open(merged)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFetchSettings(*opened); err != nil {
+		return nil, err
+	}
+	return h.sealFetchSettings(merged)
+}
+
+// sealFetchSettings encrypts the secrets of the settings, which can't be
+// stored when no secrets key is configured.
+func (h HTTPFeedServer) sealFetchSettings(settings *rsscollector.FetchSettings) (*rsscollector.FetchSettings, error) {
+	sealed, err := h.config.Secrets.Seal(settings)
+	if errors.Is(err, secrets.ErrNoKey) {
+		return nil, ValidationError{
+			Err: err,
+			Msg: "feeds can't be given credentials without a secrets key",
+		}
+	}
+	return sealed, err
+}
+
+// redactSource replaces the secrets of the source's fetch settings, which
+// are never given out by the API.
+func redactSource(source rsscollector.FeedSourcePartial) rsscollector.FeedSourcePartial {
+	source.Fetch = secrets.Redact(source.Fetch)
+	return source
+}
+
 func (h HTTPFeedServer) deleteFeed(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	feedID := c.Params("id")
 	if err := validateID(feedID); err != nil {
 		return err
 	}
-	if err := h.feedRepos.DeleteSourceByID(feedID); err != nil {
+	if err := h.feedRepos.DeleteSourceByID(ctx, feedID); err != nil {
 		return err
 	}
 
 	return c.JSON(feedID)
 }
+
+// Cache lifetimes of feed icons. Placeholders are cached for less time as
+// the feed's own icon may be found at its next collection.
+const (
+	iconMaxAge        = 24 * time.Hour
+	placeholderMaxAge = time.Hour
+)
+
+// getFeedIcon serves the icon kept for the feed, or a placeholder when it
+// has none or icons are disabled.
+func (h HTTPFeedServer) getFeedIcon(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	feedID := c.Params("id")
+	if err := validateID(feedID); err != nil {
+		return err
+	}
+	source, err := h.feedRepos.FetchSource(ctx, feedID)
+	if err != nil {
+		return err
+	}
+
+	blob, maxAge := icon.Placeholder(source.FeedSourcePartial), placeholderMaxAge
+	if h.icons != nil {
+		stored, err := h.icons.Get(ctx, feedID)
+		switch {
+		case err == nil:
+			blob, maxAge = stored, iconMaxAge
+		case !errors.Is(err, icon.ErrNotFound):
+			log.Error().Err(err).Str("feedId", feedID).Msg("failed to read feed icon")
+		}
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(blob.Data))
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	if !blob.ModTime.IsZero() {
+		c.Set(fiber.HeaderLastModified, blob.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, blob.ContentType)
+	return c.Send(blob.Data)
+}
@@ -0,0 +1,531 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/icon"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
+)
+
+func TestGetFeeds(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	for _, partial := range []rsscollector.FeedSourcePartial{
+		{FeedURL: "http://example.com/british.xml", Title: "British",
+			FeedMetadata: rsscollector.FeedMetadata{Language: "en-gb", FeedType: "rss"}},
+		{FeedURL: "http://example.com/american.xml", Title: "american",
+			FeedMetadata: rsscollector.FeedMetadata{Language: "en-us", FeedType: "atom"}},
+		{FeedURL: "http://example.com/french.xml", Title: "French",
+			FeedMetadata: rsscollector.FeedMetadata{Language: "fr", FeedType: "rss"}},
+	} {
+		source := rsscollector.FeedSource{FeedSourcePartial: partial}
+		require.Nil(t, store.StoreSource(ctx, &source))
+	}
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+
+	testCases := []struct {
+		Name           string
+		Query          string
+		ExpectedStatus int
+		ExpectedTitles []string
+	}{
+		{"Language", "?language=en&sort=title", http.StatusOK, []string{"american", "British"}},
+		{"Language variant", "?language=EN-GB", http.StatusOK, []string{"British"}},
+		{"Language prefix only", "?language=e", http.StatusOK, []string{}},
+		{"Feed type", "?feedType=rss&sort=title", http.StatusOK, []string{"British", "French"}},
+		{"Both", "?language=en&feedType=atom", http.StatusOK, []string{"american"}},
+		{"Sort by language", "?sort=language", http.StatusOK, []string{"British", "american", "French"}},
+		{"Unknown sort", "?sort=size", http.StatusInternalServerError, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/feeds/"+tc.Query, nil))
+			require.Nil(t, err)
+			require.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+			if tc.ExpectedTitles == nil {
+				return
+			}
+			var feeds []rsscollector.FeedSourcePartial
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&feeds))
+			titles := []string{}
+			for _, feed := range feeds {
+				titles = append(titles, feed.Title)
+			}
+			assert.Equal(t, tc.ExpectedTitles, titles)
+		})
+	}
+}
+
+func TestGetFeedIcon(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	withIcon := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/icon.xml", Title: "Icon"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &withIcon))
+	withoutIcon := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/none.xml", Title: "None"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &withoutIcon))
+
+	blobs, err := icon.NewFileStore(t.TempDir())
+	require.Nil(t, err)
+	require.Nil(t, blobs.Put(ctx, withIcon.ID, icon.Blob{ContentType: "image/png", Data: []byte("png")}))
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil,
+		icon.NewFetcher(blobs, nil, icon.Config{}), &Config{})
+
+	resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/feeds/"+withIcon.ID+"/icon", nil))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "public, max-age=86400", resp.Header.Get("Cache-Control"))
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Equal(t, "png", string(body))
+
+	req := httptest.NewRequest(http.MethodGet, "/feeds/"+withIcon.ID+"/icon", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp, err = s.app.Test(req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodGet, "/feeds/"+withoutIcon.ID+"/icon", nil))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/svg+xml", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "public, max-age=3600", resp.Header.Get("Cache-Control"))
+
+	resp, err = s.app.Test(httptest.NewRequest(http.MethodGet, "/feeds/unknown/icon", nil))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestFeedIconNotCached(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/icon.xml", Title: "Icon"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	blobs, err := icon.NewFileStore(t.TempDir())
+	require.Nil(t, err)
+	blob := icon.Blob{ContentType: "image/png", Data: []byte("png")}
+	require.Nil(t, blobs.Put(ctx, source.ID, blob))
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil,
+		icon.NewFetcher(blobs, nil, icon.Config{}), &Config{CacheTTL: time.Minute})
+
+	// A conditional request mustn't leave its 304 for the next client.
+	req := httptest.NewRequest(http.MethodGet, "/feeds/"+source.ID+"/icon", nil)
+	req.Header.Set("If-None-Match", fmt.Sprintf(`"%x"`, sha256.Sum256(blob.Data)))
+	resp, err := s.app.Test(req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	for i := 0; i < 2; i++ {
+		resp, err = s.app.Test(httptest.NewRequest(http.MethodGet, "/feeds/"+source.ID+"/icon", nil))
+		require.Nil(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "public, max-age=86400", resp.Header.Get("Cache-Control"))
+		assert.NotEmpty(t, resp.Header.Get("ETag"))
+		assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+		assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		assert.Equal(t, "png", string(body))
+	}
+}
+
+func TestPutFeedFetchFullContent(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/feed.xml", Title: "Teasers"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+
+	testCases := []struct {
+		Name     string
+		Body     string
+		Expected bool
+	}{
+		{"Enabled", `{"fetchFullContent": true}`, true},
+		{"Left as it is", `{"categoryIDs": []}`, true},
+		{"Disabled", `{"fetchFullContent": false}`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/feeds/"+source.ID, strings.NewReader(tc.Body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := s.app.Test(req)
+			require.Nil(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var updated UpdateFeedResponse
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&updated))
+			assert.Equal(t, tc.Expected, updated.Feed.FetchFullContent)
+			stored, err := store.FetchSource(ctx, source.ID)
+			require.Nil(t, err)
+			assert.Equal(t, tc.Expected, stored.FetchFullContent)
+		})
+	}
+}
+
+func TestPutFeedURL(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	deadAt := time.Now()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{
+			FeedURL:     "http://example.com/feed.xml",
+			DuplicateOf: "0b5c8f4e-7b0a-4f6e-9d4c-2f1e8a6b3c5d",
+			DeadAt:      &deadAt,
+		},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+
+	taken := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/taken.xml"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &taken))
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+
+	// The duplicate can't be given the URL of another feed.
+	req := httptest.NewRequest(http.MethodPut, "/feeds/"+source.ID,
+		strings.NewReader(`{"feedURL": "https://example.com/taken.xml"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.app.Test(req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Contains(t, string(body), "feed has already been added: "+taken.ID)
+
+	testCases := []struct {
+		Name        string
+		FeedURL     string
+		History     int
+		DuplicateOf string
+	}{
+		// Reviving a duplicate leaves it flagged.
+		{"Unchanged", "http://example.com/feed.xml", 0, source.DuplicateOf},
+		{"Changed", "https://example.com/feed.xml", 1, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/feeds/"+source.ID,
+				strings.NewReader(`{"feedURL": "`+tc.FeedURL+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := s.app.Test(req)
+			require.Nil(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			stored, err := store.FetchSource(ctx, source.ID)
+			require.Nil(t, err)
+			assert.Equal(t, tc.FeedURL, stored.FeedURL)
+			assert.Nil(t, stored.DeadAt)
+			assert.Equal(t, tc.DuplicateOf, stored.DuplicateOf)
+			require.Len(t, stored.URLHistory, tc.History)
+		})
+	}
+
+	stored, err := store.FetchSource(ctx, source.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "http://example.com/feed.xml", stored.URLHistory[0].URL)
+	assert.Equal(t, rsscollector.URLChangeEdited, stored.URLHistory[0].Reason)
+}
+
+func TestScrapedFeeds(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><title>Example</title></head><body>` +
+			`<article><h2><a href="/first">First</a></h2><p>The <script>x()</script>first story</p></article>` +
+			`<article><h2><a href="/second">Second</a></h2></article></body></html>`))
+	}))
+	defer page.Close()
+
+	store := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+	post := func(path, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.app.Test(req)
+		require.Nil(t, err)
+		return resp
+	}
+
+	testCases := []struct {
+		Name           string
+		Body           string
+		ExpectedStatus int
+		ExpectedItems  []string
+	}{
+		{"Preview", `{"feedUrl": "` + page.URL + `", "scrape": {"item": "article", "summary": "p"}}`, http.StatusOK, []string{"First", "Second"}},
+		{"No selectors", `{"feedUrl": "` + page.URL + `"}`, http.StatusInternalServerError, nil},
+		{"Invalid selector", `{"feedUrl": "` + page.URL + `", "scrape": {"item": "article["}}`, http.StatusInternalServerError, nil},
+		{"Nothing matched", `{"feedUrl": "` + page.URL + `", "scrape": {"item": "li"}}`, http.StatusInternalServerError, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := post("/feeds/preview", tc.Body)
+			require.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+			if tc.ExpectedItems == nil {
+				return
+			}
+			var preview rsscollector.FeedSource
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&preview))
+			titles := []string{}
+			for _, item := range preview.FeedItems {
+				titles = append(titles, item.Title)
+			}
+			assert.Equal(t, tc.ExpectedItems, titles)
+		})
+	}
+
+	// Previews aren't stored.
+	sources, err := store.FetchAllSources(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, sources)
+
+	resp := post("/feeds/", `{"feedUrl": "`+page.URL+`", "scrape": {"item": "article"}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created CreateFeedResponse
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&created))
+	stored, err := store.FetchSource(context.Background(), created.ID)
+	require.Nil(t, err)
+	assert.Equal(t, &rsscollector.ScrapeSelectors{Item: "article"}, stored.Scrape)
+	assert.Equal(t, "html", stored.FeedType)
+
+	req := httptest.NewRequest(http.MethodPut, "/feeds/"+created.ID, strings.NewReader(`{"scrape": {"item": "article h2"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.app.Test(req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stored, err = store.FetchSource(context.Background(), created.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "article h2", stored.Scrape.Item)
+}
+
+func TestFeedFetchSettings(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != "secret" || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`<rss version="2.0"><channel><title>Private</title></channel></rss>`))
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	box, err := secrets.NewBox(make([]byte, secrets.KeySize))
+	require.Nil(t, err)
+	store := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{Secrets: box}), nil, nil, &Config{Secrets: box})
+	send := func(method, path, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.app.Test(req)
+		require.Nil(t, err)
+		return resp
+	}
+
+	resp := send(http.MethodPost, "/feeds/", `{"feedUrl": "`+feedServer.URL+`", "fetch": {"headers": {"X Key": "key"}}}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp = send(http.MethodPost, "/feeds/", `{"feedUrl": "`+feedServer.URL+`",
+		"fetch": {"username": "reader", "password": "secret", "headers": {"X-Api-Key": "key"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var created CreateFeedResponse
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&created))
+
+	// The secrets are stored encrypted and redacted from responses.
+	stored, err := store.FetchSource(ctx, created.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "reader", stored.Fetch.Username)
+	assert.True(t, secrets.IsEncrypted(stored.Fetch.Password))
+	assert.True(t, secrets.IsEncrypted(stored.Fetch.Headers["X-Api-Key"]))
+	for _, path := range []string{"/feeds/", "/feeds/" + created.ID} {
+		resp = send(http.MethodGet, path, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		assert.Contains(t, string(body), `"password":"REDACTED"`)
+		assert.NotContains(t, string(body), "enc:v1:")
+	}
+
+	// Redacted secrets sent back keep their stored values.
+	resp = send(http.MethodPut, "/feeds/"+created.ID,
+		`{"fetch": {"username": "reader", "password": "REDACTED", "headers": {"X-Api-Key": "REDACTED", "Accept": "application/rss+xml"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var updated UpdateFeedResponse
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&updated))
+	assert.Equal(t, secrets.Redacted, updated.Feed.Fetch.Password)
+	assert.Equal(t, secrets.Redacted, updated.Feed.Fetch.Headers["Accept"])
+	stored, err = store.FetchSource(ctx, created.ID)
+	require.Nil(t, err)
+	opened, err := box.Open(stored.Fetch)
+	require.Nil(t, err)
+	assert.Equal(t, "secret", opened.Password)
+	assert.Equal(t, "key", opened.Headers["X-Api-Key"])
+	assert.Equal(t, "application/rss+xml", opened.Headers["Accept"])
+	require.Nil(t, s.collector.CollectSource(ctx, stored.FeedSourcePartial))
+
+	// An empty object removes the settings.
+	resp = send(http.MethodPut, "/feeds/"+created.ID, `{"fetch": {}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stored, err = store.FetchSource(ctx, created.ID)
+	require.Nil(t, err)
+	assert.Nil(t, stored.Fetch)
+
+	// Without a secrets key feeds can't be given credentials.
+	keyless := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+	req := httptest.NewRequest(http.MethodPost, "/feeds/",
+		strings.NewReader(`{"feedUrl": "`+feedServer.URL+`/other", "fetch": {"password": "secret"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = keyless.app.Test(req)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestPostFeedsFetchPolicy(t *testing.T) {
+	var feedServer *httptest.Server
+	feedServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, feedServer.URL+"/feed.xml", http.StatusFound)
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			_, _ = w.Write([]byte(`<rss version="2.0"><channel><title>Allowed</title></channel></rss>`))
+		}
+	}))
+	defer feedServer.Close()
+	// The feed server is only allowed by name, not by its address.
+	localURL := strings.Replace(feedServer.URL, "127.0.0.1", "localhost", 1)
+
+	policy, err := fetchpolicy.New(fetchpolicy.Config{Allow: []string{"localhost"}, MaxRedirects: 1})
+	require.Nil(t, err)
+	store := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{Policy: policy}), nil, nil, &Config{FetchPolicy: policy})
+
+	testCases := []struct {
+		Name           string
+		FeedURL        string
+		ExpectedStatus int
+		ExpectedError  string
+	}{
+		{"Allowed", localURL + "/feed.xml", http.StatusOK, ""},
+		{"Metadata", "http://169.254.169.254/latest/meta-data/", http.StatusInternalServerError, "169.254.0.0/16"},
+		{"Loopback", feedServer.URL + "/feed.xml", http.StatusInternalServerError, "127.0.0.0/8"},
+		{"Redirect to loopback", localURL + "/redirect", http.StatusInternalServerError, "127.0.0.0/8"},
+		{"Content type", localURL + "/image.png", http.StatusInternalServerError, "image/png"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/feeds/", strings.NewReader(`{"feedUrl": "`+tc.FeedURL+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := s.app.Test(req)
+			require.Nil(t, err)
+			assert.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.Nil(t, err)
+			if len(tc.ExpectedError) > 0 {
+				assert.Contains(t, string(body), "provided URL can't be fetched")
+				assert.Contains(t, string(body), tc.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestPostFeedsNormalized(t *testing.T) {
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<rss version="2.0"><channel><title>Example</title></channel></rss>`))
+	}))
+	defer feedServer.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+	send := func(method, path, body string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.app.Test(req)
+		require.Nil(t, err)
+		respBody, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+
+	status, _ := send(http.MethodPost, "/feeds/", `{"feedUrl": "`+feedServer.URL+`/feed.xml?utm_source=newsletter"}`)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = send(http.MethodPost, "/feeds/", `{"feedUrl": "`+feedServer.URL+`/other.xml"}`)
+	require.Equal(t, http.StatusOK, status)
+
+	sources, err := store.FetchAllSources(ctx)
+	require.Nil(t, err)
+	require.Len(t, sources, 2)
+	urls := []string{sources[0].FeedURL, sources[1].FeedURL}
+	assert.Contains(t, urls, feedServer.URL+"/feed.xml")
+	other := sources[0]
+	if other.FeedURL != feedServer.URL+"/other.xml" {
+		other = sources[1]
+	}
+
+	testCases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+	}{
+		{"Added again", http.MethodPost, "/feeds/", `{"feedUrl": "` + strings.ToUpper(feedServer.URL) + `/feed.xml/"}`},
+		{"Changed to another", http.MethodPut, "/feeds/" + other.ID, `{"feedURL": "` + feedServer.URL + `/feed.xml#top"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			status, body := send(tc.Method, tc.Path, tc.Body)
+			assert.Equal(t, http.StatusInternalServerError, status)
+			assert.Contains(t, body, "feed has already been added")
+		})
+	}
+
+	sources, err = store.FetchAllSources(ctx)
+	require.Nil(t, err)
+	assert.Len(t, sources, 2)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+// HealthResponse reports the status of a probe and why it failed if it did.
+type HealthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// getHealthz reports the process is up and able to serve requests.
+func (h HTTPFeedServer) getHealthz(c *fiber.Ctx) error {
+	return c.JSON(HealthResponse{Status: "ok"})
+}
+
+// getReadyz reports whether the server should receive traffic. It is not ready
+// once shutdown has started or when any of the stores it depends on fail their
+// health check.
+func (h HTTPFeedServer) getReadyz(c *fiber.Ctx) error {
+	select {
+	case <-h.shuttingDown:
+		return c.Status(fiber.StatusServiceUnavailable).
+			JSON(HealthResponse{Status: "unavailable", Error: "shutting down"})
+	default:
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	checked := make(map[repository.HealthChecker]bool)
+	for _, store := range []interface{}{h.feedRepos, h.itemRepos, h.categoryRepos} {
+		checker, ok := store.(repository.HealthChecker)
+		if !ok || checked[checker] {
+			continue
+		}
+		checked[checker] = true
+		if err := checker.CheckHealth(ctx); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).
+				JSON(HealthResponse{Status: "unavailable", Error: err.Error()})
+		}
+	}
+
+	return c.JSON(HealthResponse{Status: "ready"})
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+// unhealthyStore is a memory store that fails its health check.
+type unhealthyStore struct {
+	*repository.MemoryFeedStore
+}
+
+func (u unhealthyStore) CheckHealth(ctx context.Context) error {
+	return errors.New("database unreachable")
+}
+
+func newTestServer(store repository.Store) *HTTPFeedServer {
+	return NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{CacheTTL: time.Minute})
+}
+
+func TestProbes(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Server         *HTTPFeedServer
+		Path           string
+		ExpectedStatus int
+	}{
+		{
+			"Healthy",
+			newTestServer(repository.NewMemoryStore()),
+			"/healthz",
+			http.StatusOK,
+		},
+		{
+			"Ready",
+			newTestServer(repository.NewMemoryStore()),
+			"/readyz",
+			http.StatusOK,
+		},
+		{
+			"Healthy with unavailable store",
+			newTestServer(unhealthyStore{repository.NewMemoryStore()}),
+			"/healthz",
+			http.StatusOK,
+		},
+		{
+			"Not ready with unavailable store",
+			newTestServer(unhealthyStore{repository.NewMemoryStore()}),
+			"/readyz",
+			http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp, err := tc.Server.app.Test(httptest.NewRequest(http.MethodGet, tc.Path, nil))
+			require.Nil(t, err)
+			assert.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestNotReadyWhenShuttingDown(t *testing.T) {
+	s := newTestServer(repository.NewMemoryStore())
+	// The server isn't listening so shutting down fiber fails but the server
+	// should still report itself as unavailable.
+	_ = s.Shutdown(context.Background())
+
+	resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	fetched := make(chan struct{})
+	release := make(chan struct{})
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fetched)
+		<-release
+		_, _ = w.Write([]byte(`<rss version="2.0"><channel><title>Drained</title></channel></rss>`))
+	}))
+	defer feedServer.Close()
+
+	store := repository.NewMemoryStore()
+	s := newTestServer(store)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go func() { _ = s.app.Listener(ln) }()
+
+	responded := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/feeds/", "application/json",
+			strings.NewReader(`{"feedUrl": "`+feedServer.URL+`/feed.xml"}`))
+		if err != nil {
+			t.Error(err)
+		}
+		responded <- resp
+	}()
+
+	// Shut down while the feed is being added, then let the feed respond.
+	<-fetched
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	resp := <-responded
+	require.NotNil(t, resp)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Nil(t, <-shutdown)
+
+	sources, err := store.FetchAllSources(context.Background())
+	require.Nil(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, "Drained", sources[0].Title)
+}
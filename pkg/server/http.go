@@ -1,9 +1,20 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/icon"
 	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/retention"
+	"github.com/JonPulfer/rss_collector/pkg/secrets"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cache"
@@ -11,46 +22,195 @@ import (
 )
 
 type Config struct {
-	Port uint
+	// Address to listen on as host:port.
+	Address string
+	// TLSCertFile and TLSKeyFile serve HTTPS when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// CacheTTL of cached GET responses. Zero disables the cache.
+	CacheTTL time.Duration
+	// APIKeys, one of which must be sent with every API request, and for the
+	// metrics unless PublicMetrics is set. The API is open when there are
+	// none.
+	APIKeys []string
+	// PublicMetrics serves the metrics without an API key.
+	PublicMetrics bool
+	// RequestTimeout bounds the time spent handling a single request. Zero
+	// means requests are only abandoned when the server shuts down.
+	RequestTimeout time.Duration
+	// ReadTimeout for reading a request, including waiting for the next one
+	// on a keep-alive connection. Idle connections are not closed by Shutdown
+	// until this expires so it should be shorter than the time allowed for
+	// shutting down.
+	ReadTimeout time.Duration
+	// BodyLimit is the size in bytes of the largest request body accepted,
+	// zero is fiber's default of 4MB. The archives loaded by /admin/import
+	// are read as they arrive and may be larger.
+	BodyLimit int
+	// Secrets encrypts the credentials given to feeds. Feeds can't be given
+	// credentials when it is nil.
+	Secrets *secrets.Box
+	// FetchPolicy, when set, turns away feed URLs it doesn't allow before
+	// they are fetched. The collector enforces it when fetching.
+	FetchPolicy *fetchpolicy.Policy
 }
 
 type HTTPFeedServer struct {
 	feedRepos     repository.FeedSourceStore
 	itemRepos     repository.FeedItemStore
 	categoryRepos repository.FeedCategoryStore
+	trashRepos    repository.TrashStore
+	collector     *collector.Collector
+	pruner        *retention.Pruner
+	icons         *icon.Fetcher
 	config        *Config
+	app           *fiber.App
+	shuttingDown  chan struct{}
+	shutdownOnce  *sync.Once
+	// knownRoutes holds the paths of the GET routes, used to label requests
+	// served from the cache.
+	knownRoutes map[string]struct{}
 }
 
 func NewHTTPFeedServer(
 	feedRepos repository.FeedSourceStore,
 	itemRepos repository.FeedItemStore,
 	categoryRepos repository.FeedCategoryStore,
+	trashRepos repository.TrashStore,
+	feedCollector *collector.Collector,
+	pruner *retention.Pruner,
+	icons *icon.Fetcher,
 	config *Config) *HTTPFeedServer {
-	return &HTTPFeedServer{
+	h := &HTTPFeedServer{
 		feedRepos:     feedRepos,
 		itemRepos:     itemRepos,
 		categoryRepos: categoryRepos,
+		trashRepos:    trashRepos,
+		collector:     feedCollector,
+		pruner:        pruner,
+		icons:         icons,
 		config:        config,
+		app: fiber.New(fiber.Config{
+			ReadTimeout: config.ReadTimeout,
+			BodyLimit:   config.BodyLimit,
+		}),
+		shuttingDown: make(chan struct{}),
+		shutdownOnce: &sync.Once{},
+		knownRoutes:  make(map[string]struct{}),
 	}
+	// Request bodies larger than the BodyLimit are streamed to the handler
+	// rather than rejected, so imports needn't fit in memory. limitBody
+	// rejects them for every other route.
+	h.app.Server().StreamRequestBody = true
+	h.routes()
+	return h
 }
 
-func (h HTTPFeedServer) Start() error {
+// requestContext derives the context for the work done handling a request,
+// bounded by the configured RequestTimeout and carrying the span for the
+// request. It isn't derived from the fiber request context, which fasthttp
+// cancels when the server starts shutting down, so that requests in flight
+// are drained rather than abandoned.
+func (h HTTPFeedServer) requestContext(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	return h.withRequestTimeout(traceContext(c))
+}
+
+// withRequestTimeout derives a context from parent bounded by the configured
+// RequestTimeout.
+func (h HTTPFeedServer) withRequestTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if h.config.RequestTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, h.config.RequestTimeout)
+}
+
+// limitBody reads the body of every request other than those to the paths
+// given, responding 413 Request Entity Too Large to those larger than the
+// limit. The server streams bodies larger than the limit instead of
+// rejecting them, which leaves this to reject them for the routes that
+// need their body in memory.
+func limitBody(limit int, streamed ...string) fiber.Handler {
+	if limit <= 0 {
+		limit = fiber.DefaultBodyLimit
+	}
+	return func(c *fiber.Ctx) error {
+		body := c.Context().RequestBodyStream()
+		if body == nil || c.Request().Header.ContentLength() == 0 {
+			return c.Next()
+		}
+		// Whatever is left unread of a body would be taken for the next
+		// request on the connection, so it is closed instead.
+		path := strings.TrimSuffix(c.Path(), "/")
+		for _, p := range streamed {
+			if path == p {
+				c.Context().SetConnectionClose()
+				return c.Next()
+			}
+		}
+
+		read, err := ioutil.ReadAll(io.LimitReader(body, int64(limit)+1))
+		if err != nil {
+			return err
+		}
+		if len(read) > limit {
+			c.Context().SetConnectionClose()
+			return fiber.ErrRequestEntityTooLarge
+		}
+		c.Request().SetBodyRaw(read)
+		return c.Next()
+	}
+}
+
+// isIconPath reports whether the path is that of a feed's icon.
+func isIconPath(path string) bool {
+	return strings.HasPrefix(path, "/feeds/") && strings.HasSuffix(strings.TrimSuffix(path, "/"), "/icon")
+}
+
+func (h HTTPFeedServer) routes() {
+	app := h.app
+
+	// Probes and metrics are registered ahead of the tracing, logging,
+	// metrics and caching middleware so they are neither traced, logged,
+	// counted nor served stale.
+	app.Get("/healthz", h.getHealthz)
+	app.Get("/readyz", h.getReadyz)
+	if len(h.config.APIKeys) > 0 && !h.config.PublicMetrics {
+		app.Get("/metrics", requireAPIKey(h.config.APIKeys), getMetrics)
+	} else {
+		app.Get("/metrics", getMetrics)
+	}
 
-	app := fiber.New()
+	app.Use(limitBody(h.config.BodyLimit, "/admin/import"))
+	app.Use(h.traceRequests)
+	app.Use(h.recordMetrics)
 	app.Use(logger.New())
+	if len(h.config.APIKeys) > 0 {
+		app.Use(requireAPIKey(h.config.APIKeys))
+	}
 
-	// Cache using default expiration of 1 minute but with key generation that
-	// includes the query args.
-	app.Use(cache.New(cache.Config{
-		KeyGenerator: func(c *fiber.Ctx) string {
-			return c.Request().URI().String()
-		},
-	}))
+	// Cache with key generation that includes the query args.
+	if h.config.CacheTTL > 0 {
+		app.Use(cache.New(cache.Config{
+			// Exports must always be of the current data. Icons are left to
+			// HTTP caches with their own headers, which the cache doesn't
+			// keep, and it would replay a 304 to clients without the icon.
+			Next: func(c *fiber.Ctx) bool {
+				return strings.HasPrefix(c.Path(), "/admin/") || isIconPath(c.Path())
+			},
+			Expiration: h.config.CacheTTL,
+			KeyGenerator: func(c *fiber.Ctx) string {
+				return c.Request().URI().String()
+			},
+		}))
+	}
+	app.Use(markCacheMiss)
 
 	// Feeds.
 	app.Get("/feeds/", h.getFeeds)
 	app.Post("/feeds/", h.postFeeds)
+	app.Post("/feeds/preview", h.postFeedPreview)
 	app.Get("/feeds/:id", h.getFeed)
+	app.Get("/feeds/:id/icon", h.getFeedIcon)
 	app.Put("/feeds/:id", h.putFeed)
 	app.Delete("/feeds/:id", h.deleteFeed)
 
@@ -59,6 +219,9 @@ func (h HTTPFeedServer) Start() error {
 	app.Get("/items/:id", h.getItem)
 	app.Put("/items/:id", h.putItem)
 	app.Delete("/items/:id", h.deleteItem)
+	app.Put("/items/:id/star", h.starItem)
+	app.Delete("/items/:id/star", h.unstarItem)
+	app.Post("/items/:id/extract", h.postExtract)
 
 	// Categories.
 	app.Get("/categories/", h.getCategories)
@@ -67,5 +230,53 @@ func (h HTTPFeedServer) Start() error {
 	app.Put("/categories/:id", h.putCategory)
 	app.Delete("/categories/:id", h.deleteCategory)
 
-	return app.Listen(fmt.Sprintf("0.0.0.0:%d", h.config.Port))
+	// Trash.
+	app.Get("/trash/", h.getTrash)
+	app.Post("/trash/:type/:id/restore", h.postRestore)
+
+	// Administration.
+	app.Get("/admin/export", h.getExport)
+	app.Post("/admin/import", h.postImport)
+	if h.pruner != nil {
+		app.Post("/admin/retention/prune", h.postPrune)
+	}
+
+	// The middleware registered with Use appear in the stack as routes for
+	// "/", which has no handler of its own.
+	for _, stack := range app.Stack() {
+		for _, route := range stack {
+			if route.Method == fiber.MethodGet && route.Path != "/" {
+				h.knownRoutes[route.Path] = struct{}{}
+			}
+		}
+	}
+}
+
+// Start listening for requests. This blocks until the server fails or is shut
+// down.
+func (h HTTPFeedServer) Start() error {
+	if len(h.config.TLSCertFile) > 0 {
+		return h.app.ListenTLS(h.config.Address, h.config.TLSCertFile, h.config.TLSKeyFile)
+	}
+	return h.app.Listen(h.config.Address)
+}
+
+// Shutdown stops accepting new connections, reports the server as not ready
+// and waits for in-flight requests to complete or ctx to be done.
+func (h HTTPFeedServer) Shutdown(ctx context.Context) error {
+	h.shutdownOnce.Do(func() {
+		close(h.shuttingDown)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- h.app.Shutdown()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for requests to drain: %w", ctx.Err())
+	}
 }
@@ -9,6 +9,9 @@ import (
 )
 
 func (h HTTPFeedServer) getItems(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	sourceID := c.Query("sourceId")
 	if len(sourceID) > 0 {
 		if err := validateID(sourceID); err != nil {
@@ -25,8 +28,15 @@ func (h HTTPFeedServer) getItems(c *fiber.Ctx) error {
 		}
 		itemOptions.CategoryIDs = []string{categoryID}
 	}
+	enclosureType := c.Query("hasEnclosure")
+	if len(enclosureType) > 0 {
+		if err := validateMediaType(enclosureType); err != nil {
+			return err
+		}
+		itemOptions.EnclosureType = enclosureType
+	}
 
-	items, err := h.itemRepos.FetchAllItems(itemOptions)
+	items, err := h.itemRepos.FetchAllItems(ctx, itemOptions)
 	if err != nil {
 		return err
 	}
@@ -37,12 +47,15 @@ func (h HTTPFeedServer) getItems(c *fiber.Ctx) error {
 }
 
 func (h HTTPFeedServer) getItem(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	itemID := c.Params("id")
 	if err := validateID(itemID); err != nil {
 		return err
 	}
 
-	item, err := h.itemRepos.FetchItemByID(itemID)
+	item, err := h.itemRepos.FetchItemByID(ctx, itemID)
 	if err != nil {
 		return err
 	}
@@ -65,6 +78,9 @@ func (u UpdateItemRequest) Validate() error {
 }
 
 func (h HTTPFeedServer) putItem(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	var updateItemRequest UpdateItemRequest
 	if err := c.BodyParser(&updateItemRequest); err != nil {
 		return err
@@ -77,24 +93,75 @@ func (h HTTPFeedServer) putItem(c *fiber.Ctx) error {
 		return err
 	}
 
-	item, err := h.itemRepos.FetchItemByID(itemID)
+	item, err := h.itemRepos.FetchItemByID(ctx, itemID)
 	if err != nil {
 		return err
 	}
 	item.CategoryIDs = updateItemRequest.CategoryIDs
-	if err := h.itemRepos.StoreItem(item.SourceID, &item); err != nil {
+	if err := h.itemRepos.StoreItem(ctx, item.SourceID, &item); err != nil {
 		return err
 	}
 	return c.JSON(item)
 }
 
 func (h HTTPFeedServer) deleteItem(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
 	itemID := c.Params("id")
 	if err := validateID(itemID); err != nil {
 		return err
 	}
-	if err := h.itemRepos.DeleteItemByID(itemID); err != nil {
+	if err := h.itemRepos.DeleteItemByID(ctx, itemID); err != nil {
 		return err
 	}
 	return c.JSON(itemID)
 }
+
+func (h HTTPFeedServer) starItem(c *fiber.Ctx) error {
+	return h.setStarred(c, true)
+}
+
+func (h HTTPFeedServer) unstarItem(c *fiber.Ctx) error {
+	return h.setStarred(c, false)
+}
+
+// setStarred marks the item as starred, so it is kept regardless of the
+// retention policies, or clears the mark.
+func (h HTTPFeedServer) setStarred(c *fiber.Ctx, starred bool) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	itemID := c.Params("id")
+	if err := validateID(itemID); err != nil {
+		return err
+	}
+
+	item, err := h.itemRepos.FetchItemByID(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	item.Starred = starred
+	if err := h.itemRepos.StoreItem(ctx, item.SourceID, &item); err != nil {
+		return err
+	}
+	return c.JSON(item)
+}
+
+// postExtract replaces the content of the item with the article on the page
+// it links to and responds with the updated item.
+func (h HTTPFeedServer) postExtract(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	itemID := c.Params("id")
+	if err := validateID(itemID); err != nil {
+		return err
+	}
+
+	item, err := h.collector.ExtractContent(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(item)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/extract"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestPostExtract(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><body><nav><a href="/">Home</a></nav><article>` +
+			`<p>The whole of the story, at some length, with the details the feed left out.</p>` +
+			`<p>Another paragraph of the story, so there is enough of it to be an article.</p>` +
+			`</article></body></html>`))
+	}))
+	defer page.Close()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/feed.xml"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	item := rsscollector.FeedItem{GUID: "story", Link: page.URL + "/story.html", Content: "<p>A teaser</p>"}
+	require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+
+	extractor := extract.NewExtractor(store, nil, page.Client(), extract.Config{HostInterval: time.Millisecond})
+	withExtractor := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{Extractor: extractor}), nil, nil, &Config{})
+	withoutExtractor := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+
+	resp, err := withoutExtractor.app.Test(httptest.NewRequest(http.MethodPost, "/items/"+item.ID+"/extract", nil))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp, err = withExtractor.app.Test(httptest.NewRequest(http.MethodPost, "/items/"+item.ID+"/extract", nil))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var extracted rsscollector.FeedItem
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&extracted))
+	assert.True(t, strings.HasPrefix(extracted.Content, "<p>The whole of the story"))
+	assert.False(t, strings.Contains(extracted.Content, "Home"))
+	assert.NotNil(t, extracted.ExtractedAt)
+
+	stored, err := store.FetchItemByID(ctx, item.ID)
+	require.Nil(t, err)
+	assert.Equal(t, extracted.Content, stored.Content)
+
+	resp, err = withExtractor.app.Test(httptest.NewRequest(http.MethodPost, "/items/not-an-id/extract", nil))
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+)
+
+const (
+	// cacheMissKey is set in the request locals once a request has passed
+	// the cache without being served from it.
+	cacheMissKey = "metrics.cacheMiss"
+	// unmatchedRoute labels requests that didn't match any route so that
+	// arbitrary paths can't inflate the cardinality of the HTTP metrics.
+	unmatchedRoute = "unmatched"
+)
+
+// idSegment matches the UUIDs used as object IDs in request paths.
+var idSegment = regexp.MustCompile(`/[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+var metricsHandler = fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+
+// getMetrics serves the Prometheus metrics.
+func getMetrics(c *fiber.Ctx) error {
+	metricsHandler(c.Context())
+	return nil
+}
+
+// recordMetrics counts and times every request passing through it by route,
+// method and status code.
+func (h HTTPFeedServer) recordMetrics(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	status := responseStatus(c, err)
+
+	route := h.routeName(c)
+	cacheMiss := c.Locals(cacheMissKey) != nil
+	switch {
+	case !cacheMiss:
+		metrics.CacheLookups.WithLabelValues("hit").Inc()
+	case c.Method() == fiber.MethodGet:
+		metrics.CacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	// Labels outlive the request, whose buffers fiber reuses, so they must
+	// be copies.
+	route, method := utils.CopyString(route), utils.CopyString(c.Method())
+	metrics.HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// responseStatus provides the status code the response to the request will be
+// sent with, including when err is yet to be handled by fiber.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	if e, ok := err.(*fiber.Error); ok {
+		return e.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// routeName provides the path of the route that handled the request once it
+// has been handled.
+func (h HTTPFeedServer) routeName(c *fiber.Ctx) string {
+	cacheMiss, _ := c.Locals(cacheMissKey).(*fiber.Route)
+	switch {
+	case cacheMiss == nil:
+		// Served from the cache so the route has to be recovered from the
+		// path, which is only used once it matches a registered route.
+		route := idSegment.ReplaceAllString(c.Path(), "/:id")
+		if _, ok := h.knownRoutes[route]; !ok {
+			return unmatchedRoute
+		}
+		return route
+	case cacheMiss == c.Route():
+		// No handler was reached after the cache.
+		return unmatchedRoute
+	default:
+		return c.Route().Path
+	}
+}
+
+// markCacheMiss is placed after the cache so that it only runs for requests
+// the cache didn't serve. It records its own route so that recordMetrics can
+// tell whether a handler was matched after it.
+func markCacheMiss(c *fiber.Ctx) error {
+	c.Locals(cacheMissKey, c.Route())
+	return c.Next()
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/metrics"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestRecordMetrics(t *testing.T) {
+	store := repository.NewMemoryStore()
+	category := rsscollector.FeedCategory{Name: "news"}
+	require.Nil(t, store.StoreCategory(context.Background(), &category))
+	s := newTestServer(repository.NewInstrumentedStore(store, "memory"))
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		ExpectedRoute  string
+		ExpectedStatus string
+		ExpectedCache  string
+	}{
+		{
+			"Miss",
+			"/categories/" + category.ID,
+			"/categories/:id",
+			"200",
+			"miss",
+		},
+		{
+			"Hit",
+			"/categories/" + category.ID,
+			"/categories/:id",
+			"200",
+			"hit",
+		},
+		{
+			"Unmatched",
+			"/nothing/here",
+			unmatchedRoute,
+			"404",
+			"miss",
+		},
+		{
+			"Unmatched hit",
+			"/nothing/here",
+			unmatchedRoute,
+			"404",
+			"hit",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			requests := metrics.HTTPRequests.WithLabelValues(tc.ExpectedRoute, http.MethodGet, tc.ExpectedStatus)
+			lookups := metrics.CacheLookups.WithLabelValues(tc.ExpectedCache)
+			requestsBefore := testutil.ToFloat64(requests)
+			lookupsBefore := testutil.ToFloat64(lookups)
+
+			resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, tc.Path, nil))
+			require.Nil(t, err)
+			assert.Equal(t, tc.ExpectedStatus, resp.Status[:3])
+
+			assert.Equal(t, requestsBefore+1, testutil.ToFloat64(requests))
+			assert.Equal(t, lookupsBefore+1, testutil.ToFloat64(lookups))
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	s := newTestServer(repository.NewInstrumentedStore(repository.NewMemoryStore(), "memory"))
+	_, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/categories/", nil))
+	require.Nil(t, err)
+
+	resp, err := s.app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Contains(t, string(body), `rsscollector_http_requests_total{method="GET",route="/categories/",status="200"}`)
+	assert.Contains(t, string(body), `rsscollector_repository_call_duration_seconds_count{backend="memory",method="FetchAllCategories",outcome="success"}`)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// postPrune applies the retention policies now and responds with the items
+// removed. With dryRun=true nothing is removed and the response previews the
+// items that would be.
+func (h HTTPFeedServer) postPrune(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	dryRun := false
+	if value := c.Query("dryRun"); len(value) > 0 {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return ValidationError{
+				Err: err,
+				Msg: "dryRun must be true or false",
+			}
+		}
+		dryRun = parsed
+	}
+
+	report, err := h.pruner.Prune(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+	return c.JSON(report)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/retention"
+)
+
+func TestStarAndPrune(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/feed.xml"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	items := make([]*rsscollector.FeedItem, 0)
+	for i := 0; i < 3; i++ {
+		published := time.Now().Add(-time.Duration(i) * time.Hour)
+		items = append(items, &rsscollector.FeedItem{GUID: fmt.Sprint(i), Published: &published})
+	}
+	require.Nil(t, store.StoreItems(ctx, source.ID, items))
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}),
+		retention.NewPruner(store, store, store, store,
+			retention.Policies{Default: retention.Policy{MaxItems: 1}}, 0),
+		nil, &Config{})
+
+	do := func(method, path string) *http.Response {
+		resp, err := s.app.Test(httptest.NewRequest(method, path, nil))
+		require.Nil(t, err)
+		return resp
+	}
+
+	// The oldest item is starred so only the middle one is pruned.
+	resp := do(http.MethodPut, "/items/"+items[2].ID+"/star")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var starred rsscollector.FeedItem
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&starred))
+	assert.True(t, starred.Starred)
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		ExpectedStatus int
+		ExpectedItems  int
+	}{
+		{"Invalid dry run", "/admin/retention/prune?dryRun=maybe", http.StatusInternalServerError, 3},
+		{"Dry run", "/admin/retention/prune?dryRun=true", http.StatusOK, 3},
+		{"Prune", "/admin/retention/prune", http.StatusOK, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resp := do(http.MethodPost, tc.Path)
+			require.Equal(t, tc.ExpectedStatus, resp.StatusCode)
+			if resp.StatusCode == http.StatusOK {
+				var report retention.Report
+				require.Nil(t, json.NewDecoder(resp.Body).Decode(&report))
+				assert.Equal(t, 1, report.Removed)
+				require.Len(t, report.Feeds, 1)
+				assert.Equal(t, []string{items[1].ID}, report.Feeds[0].ItemIDs)
+			}
+
+			stored, err := store.FetchAllItems(ctx, rsscollector.ItemOptions{})
+			require.Nil(t, err)
+			assert.Len(t, stored, tc.ExpectedItems)
+		})
+	}
+
+	resp = do(http.MethodDelete, "/items/"+items[2].ID+"/star")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	item, err := store.FetchItemByID(ctx, items[2].ID)
+	require.Nil(t, err)
+	assert.False(t, item.Starred)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/JonPulfer/rss_collector/pkg/tracing"
+)
+
+// traceContextKey is set in the request locals to the context carrying the
+// span for the request.
+const traceContextKey = "tracing.context"
+
+// traceRequests starts a span for every request passing through it,
+// continuing any trace propagated in the request headers. The span is
+// carried in a context of its own, as the fiber request context is
+// cancelled as soon as the server starts shutting down.
+func (h HTTPFeedServer) traceRequests(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier{&c.Request().Header})
+	ctx, span := tracing.Tracer().Start(ctx, "HTTP "+c.Method(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(c.Method()),
+			semconv.HTTPTargetKey.String(string(c.Request().RequestURI())),
+		))
+	defer span.End()
+	c.Locals(traceContextKey, ctx)
+
+	err := c.Next()
+
+	route := h.routeName(c)
+	status := responseStatus(c, err)
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(
+		semconv.HTTPRouteKey.String(route),
+		semconv.HTTPStatusCodeKey.Int(status),
+	)
+	// Client errors are the client's problem so only server errors mark the
+	// span as failed.
+	if status >= fiber.StatusInternalServerError {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.SetStatus(codes.Error, utils.StatusMessage(status))
+	}
+	return err
+}
+
+// traceContext provides the context carrying the span for the request, or
+// the background context if the request isn't traced.
+func traceContext(c *fiber.Ctx) context.Context {
+	if ctx, ok := c.Locals(traceContextKey).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// headerCarrier adapts the fasthttp request headers for extracting the trace
+// context propagated with a request.
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h headerCarrier) Set(key string, value string) {
+	h.header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, h.header.Len())
+	h.header.VisitAll(func(key, value []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>Example</title>
+<item>
+<title>First</title>
+<guid>http://example.com/first</guid>
+</item>
+</channel>
+</rss>`
+
+// recordSpans installs a tracer provider recording every span ended until
+// the test completes.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func TestTraceAddFeed(t *testing.T) {
+	recorder := recordSpans(t)
+
+	feedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testFeed)
+	}))
+	defer feedServer.Close()
+
+	s := newTestServer(repository.NewInstrumentedStore(repository.NewMemoryStore(), "memory"))
+	req := httptest.NewRequest(http.MethodPost, "/feeds/",
+		strings.NewReader(fmt.Sprintf(`{"feedURL": %q}`, feedServer.URL)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	resp, err := s.app.Test(req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String(),
+			"span %s not part of the propagated trace", span.Name())
+		spans[span.Name()] = span
+	}
+
+	testCases := []struct {
+		Name           string
+		ExpectedParent string
+	}{
+		{"POST /feeds/", ""},
+		{"collector.AddSource", "POST /feeds/"},
+		{"feed.Fetch", "collector.AddSource"},
+		{"feed.Parse", "feed.Fetch"},
+		{"repository.StoreSource", "collector.AddSource"},
+		{"repository.StoreItems", "collector.AddSource"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			span, ok := spans[tc.Name]
+			require.True(t, ok)
+			if len(tc.ExpectedParent) == 0 {
+				assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+				assert.True(t, span.Parent().IsRemote())
+				return
+			}
+			parent, ok := spans[tc.ExpectedParent]
+			require.True(t, ok)
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// getTrash responds with the deleted feeds, items and categories that can be
+// restored. Items deleted with their feed are restored with the feed and are
+// not listed on their own.
+func (h HTTPFeedServer) getTrash(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	trash, err := h.trashRepos.FetchTrash(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Sort(trash.Items)
+	for i, feed := range trash.Feeds {
+		trash.Feeds[i] = redactSource(feed)
+	}
+	return c.JSON(trash)
+}
+
+// postRestore moves the feed, item or category out of the trash. Restoring a
+// feed restores the items deleted with it.
+func (h HTTPFeedServer) postRestore(c *fiber.Ctx) error {
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	var restore func(ctx context.Context, id string) error
+	switch objectType := c.Params("type"); objectType {
+	case "feeds":
+		restore = h.trashRepos.RestoreSource
+	case "items":
+		restore = h.trashRepos.RestoreItem
+	case "categories":
+		restore = h.trashRepos.RestoreCategory
+	default:
+		return ValidationError{
+			Err: fmt.Errorf("unknown type %q", objectType),
+			Msg: "type must be feeds, items or categories",
+		}
+	}
+
+	if err := restore(ctx, id); err != nil {
+		return duplicateError(categoryError(err))
+	}
+	return c.JSON(id)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/collector"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	source := rsscollector.FeedSource{
+		FeedSourcePartial: rsscollector.FeedSourcePartial{FeedURL: "http://example.com/feed.xml"},
+	}
+	require.Nil(t, store.StoreSource(ctx, &source))
+	item := rsscollector.FeedItem{GUID: "one"}
+	require.Nil(t, store.StoreItem(ctx, source.ID, &item))
+	category := rsscollector.FeedCategory{Name: "News"}
+	require.Nil(t, store.StoreCategory(ctx, &category))
+
+	s := NewHTTPFeedServer(store, store, store, store,
+		collector.NewCollector(store, store, &collector.Config{}), nil, nil, &Config{})
+
+	do := func(method, path string) *http.Response {
+		resp, err := s.app.Test(httptest.NewRequest(method, path, nil))
+		require.Nil(t, err)
+		return resp
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/items/"+item.ID).StatusCode)
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/categories/"+category.ID).StatusCode)
+	assert.Equal(t, http.StatusInternalServerError, do(http.MethodGet, "/items/"+item.ID).StatusCode)
+
+	resp := do(http.MethodGet, "/trash/")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var trash rsscollector.Trash
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&trash))
+	require.Len(t, trash.Items, 1)
+	assert.Equal(t, item.ID, trash.Items[0].ID)
+	assert.NotNil(t, trash.Items[0].DeletedAt)
+	require.Len(t, trash.Categories, 1)
+	assert.Equal(t, category.ID, trash.Categories[0].ID)
+
+	testCases := []struct {
+		Name           string
+		Path           string
+		ExpectedStatus int
+	}{
+		{"Unknown type", "/trash/widgets/" + item.ID + "/restore", http.StatusInternalServerError},
+		{"Invalid ID", "/trash/items/one/restore", http.StatusInternalServerError},
+		{"Not deleted", "/trash/feeds/" + source.ID + "/restore", http.StatusInternalServerError},
+		{"Item", "/trash/items/" + item.ID + "/restore", http.StatusOK},
+		{"Category", "/trash/categories/" + category.ID + "/restore", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.ExpectedStatus, do(http.MethodPost, tc.Path).StatusCode)
+		})
+	}
+
+	_, err := store.FetchItemByID(ctx, item.ID)
+	assert.Nil(t, err)
+	_, err = store.FetchCategoryByID(ctx, category.ID)
+	assert.Nil(t, err)
+}
@@ -1,11 +1,19 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/google/uuid"
+
+	rsscollector "github.com/JonPulfer/rss_collector/pkg"
+	"github.com/JonPulfer/rss_collector/pkg/feed"
+	"github.com/JonPulfer/rss_collector/pkg/fetchpolicy"
+	"github.com/JonPulfer/rss_collector/pkg/repository"
+	"github.com/JonPulfer/rss_collector/pkg/urlnorm"
 )
 
 type ValidationError struct {
@@ -28,6 +36,105 @@ func validateFeedURL(feedURL string) error {
 			Msg: "provided URL has no scheme",
 		}
 	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ValidationError{
+			Err: nil,
+			Msg: "provided URL must be http or https",
+		}
+	}
+	if len(u.Hostname()) == 0 {
+		return ValidationError{
+			Err: nil,
+			Msg: "provided URL has no host",
+		}
+	}
+	if _, err := urlnorm.Normalize(feedURL); err != nil {
+		return ValidationError{
+			Err: err,
+			Msg: "provided URL is not valid",
+		}
+	}
+	return nil
+}
+
+// duplicateError turns away a feed URL that, however it is written, is the
+// URL of a feed already added, as the collector and the store report it.
+func duplicateError(err error) error {
+	var duplicate repository.DuplicateSourceError
+	if errors.As(err, &duplicate) {
+		return ValidationError{
+			Err: err,
+			Msg: fmt.Sprintf("feed has already been added: %s", duplicate.ID),
+		}
+	}
+	return err
+}
+
+// checkFeedURL against the fetch policy, when the server has one, so URLs
+// that may not be fetched are turned away before they are tried.
+func (h HTTPFeedServer) checkFeedURL(feedURL string) error {
+	if h.config.FetchPolicy == nil {
+		return nil
+	}
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return err
+	}
+	if err := h.config.FetchPolicy.CheckURL(u); err != nil {
+		return ValidationError{
+			Err: err,
+			Msg: "provided URL can't be fetched",
+		}
+	}
+	return nil
+}
+
+// categoryError turns away a category name that another category has.
+func categoryError(err error) error {
+	var duplicate repository.DuplicateCategoryError
+	if errors.As(err, &duplicate) {
+		return ValidationError{
+			Err: err,
+			Msg: fmt.Sprintf("category already exists: %s", duplicate.ID),
+		}
+	}
+	return err
+}
+
+// fetchError reports a feed the fetch policy stopped as a validation error,
+// whether it was stopped by its address, a redirect or its response, as it
+// does a feed already added.
+func fetchError(err error) error {
+	var blocked fetchpolicy.BlockedError
+	var contentType fetchpolicy.ContentTypeError
+	if errors.As(err, &blocked) || errors.As(err, &contentType) || errors.Is(err, fetchpolicy.ErrTooLarge) {
+		return ValidationError{
+			Err: err,
+			Msg: "provided URL can't be fetched",
+		}
+	}
+	return duplicateError(err)
+}
+
+// validateSelectors of a scraped source.
+func validateSelectors(selectors rsscollector.ScrapeSelectors) error {
+	if err := feed.ValidateSelectors(selectors); err != nil {
+		return ValidationError{
+			Err: err,
+			Msg: "provided selectors are not valid",
+		}
+	}
+	return nil
+}
+
+// validateFetchSettings of a source, whose secrets must not be encrypted.
+func validateFetchSettings(settings rsscollector.FetchSettings) error {
+	if err := feed.ValidateFetchSettings(settings); err != nil {
+		return ValidationError{
+			Err: err,
+			Msg: "provided fetch settings are not valid",
+		}
+	}
 	return nil
 }
 
@@ -42,6 +149,22 @@ func validateID(id string) error {
 	return nil
 }
 
+// mediaTypePattern matches a media type, e.g. audio/mpeg, or just its type,
+// e.g. audio.
+var mediaTypePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]*(/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]*)?$`)
+
+// validateMediaType accepts a media type, a type without its subtype or
+// rsscollector.AnyEnclosure.
+func validateMediaType(mediaType string) error {
+	if !mediaTypePattern.MatchString(mediaType) {
+		return ValidationError{
+			Err: nil,
+			Msg: fmt.Sprintf("provided media type is not valid: %s", mediaType),
+		}
+	}
+	return nil
+}
+
 func validateString(s string) error {
 	if len(strings.Replace(s, " ", "", -1)) == 0 {
 		return ValidationError{
@@ -62,6 +62,16 @@ func TestValidateFeedURL(t *testing.T) {
 			" ",
 			true,
 		},
+		{
+			"Not http",
+			"file:///etc/passwd",
+			true,
+		},
+		{
+			"No host",
+			"http:///feed.xml",
+			true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -110,3 +120,30 @@ func TestValidateString(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateMediaType(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		MediaType     string
+		ErrorExpected bool
+	}{
+		{"Type", "audio", false},
+		{"Type and subtype", "audio/mpeg", false},
+		{"Subtype with a suffix", "application/rss+xml", false},
+		{"Any", "any", false},
+		{"Empty subtype", "audio/", true},
+		{"Wildcard", "audio/%", true},
+		{"Several slashes", "audio/mpeg/3", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := validateMediaType(tc.MediaType)
+			if tc.ErrorExpected {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
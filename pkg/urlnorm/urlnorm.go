@@ -0,0 +1,239 @@
+// Package urlnorm puts URLs in a canonical form, so that a feed or link
+// written differently compares equal, and drops the query parameters added
+// to track where a link was followed from.
+//
+// Normalize keeps everything that could change what a URL fetches: the
+// scheme, a trailing slash and the remaining query parameters in their
+// order. Key goes further for telling whether two feeds are the same.
+package urlnorm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTrackingParams are the query parameters stripped unless
+// SetTrackingParams is given others. A name ending in * matches every name
+// it begins.
+var DefaultTrackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"gbraid",
+	"wbraid",
+	"msclkid",
+	"yclid",
+	"twclid",
+	"igshid",
+	"mc_cid",
+	"mc_eid",
+	"_hsenc",
+	"_hsmi",
+	"mkt_tok",
+}
+
+// DefaultRedirectors are hosts whose links only redirect to the one they
+// wrap, such as feedburner's item links and link shorteners.
+var DefaultRedirectors = []string{
+	"feedproxy.google.com",
+	"feeds.feedburner.com",
+	"rss.feedsportal.com",
+	"t.co",
+	"bit.ly",
+	"buff.ly",
+	"dlvr.it",
+	"ow.ly",
+	"trib.al",
+	"lnkd.in",
+}
+
+// ErrNotHTTP is returned normalising a URL that isn't an absolute http or
+// https URL.
+var ErrNotHTTP = errors.New("not an absolute http or https URL")
+
+// defaultPorts are dropped from the URLs of their scheme.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+var (
+	paramsMu       sync.RWMutex
+	trackingParams = DefaultTrackingParams
+)
+
+// SetTrackingParams stripped from every URL normalised from now on, none
+// when patterns is empty.
+func SetTrackingParams(patterns []string) {
+	lowered := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); len(pattern) > 0 {
+			lowered = append(lowered, pattern)
+		}
+	}
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+	trackingParams = lowered
+}
+
+// Normalize the URL, lower casing its scheme and host, dropping a default
+// port, removing dot segments from its path and stripping tracking
+// parameters from its query.
+func Normalize(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 {
+		return "", ErrNotHTTP
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); len(port) > 0 && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if len(u.Path) == 0 {
+		u.Path, u.RawPath = "/", ""
+	} else if hasDotSegment(u.Path) {
+		cleaned := path.Clean(u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		u.Path, u.RawPath = cleaned, ""
+	}
+
+	u.RawQuery = stripTracking(u.RawQuery)
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+// Key the URL compares by to tell whether two feeds are the same. URLs
+// differing only in scheme, a trailing slash or a fragment, as well as in
+// what Normalize changes, have the same key. URLs that can't be normalised
+// are their own key.
+func Key(raw string) string {
+	normalized, err := Normalize(raw)
+	if err != nil {
+		return strings.TrimSpace(raw)
+	}
+	u, _ := url.Parse(normalized)
+	key := u.Host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(u.RawQuery) > 0 {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
+
+// hasDotSegment reports whether the path has a . or .. segment.
+func hasDotSegment(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTracking removes the tracking parameters from the query, leaving the
+// others as they were written.
+func stripTracking(query string) string {
+	if len(query) == 0 {
+		return query
+	}
+	paramsMu.RLock()
+	patterns := trackingParams
+	paramsMu.RUnlock()
+
+	kept := make([]string, 0)
+	for _, param := range strings.Split(query, "&") {
+		if len(param) == 0 {
+			continue
+		}
+		name := strings.SplitN(param, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !isTracking(strings.ToLower(name), patterns) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func isTracking(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTimeout bounds following a single link to where it leads.
+const resolveTimeout = 10 * time.Second
+
+// Resolver follows the links on redirector hosts to where they lead.
+type Resolver struct {
+	client *http.Client
+	hosts  map[string]struct{}
+}
+
+// NewResolver following the links on the hosts with client, the
+// http.DefaultClient when it is nil.
+func NewResolver(client *http.Client, hosts []string) *Resolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &Resolver{client: client, hosts: make(map[string]struct{}, len(hosts))}
+	for _, host := range hosts {
+		r.hosts[strings.ToLower(strings.TrimSpace(host))] = struct{}{}
+	}
+	return r
+}
+
+// Redirects reports whether the link is on a redirector host.
+func (r *Resolver) Redirects(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	_, ok := r.hosts[strings.ToLower(u.Hostname())]
+	return ok
+}
+
+// Resolve the link, when it is on a redirector host, to the normalised URL
+// its redirects end at. Links on other hosts are returned as they are.
+func (r *Resolver) Resolve(ctx context.Context, link string) (string, error) {
+	if !r.Redirects(link) {
+		return link, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	// Only where the redirects ended is wanted, not the page.
+	_, _ = io.CopyN(io.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+	return Normalize(resp.Request.URL.String())
+}
@@ -0,0 +1,106 @@
+package urlnorm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		URL      string
+		Expected string
+	}{
+		{"Canonical", "https://example.com/feed", "https://example.com/feed"},
+		{"Case", "HTTPS://Example.COM/Feed", "https://example.com/Feed"},
+		{"Empty path", "http://example.com", "http://example.com/"},
+		{"Default port", "https://example.com:443/feed", "https://example.com/feed"},
+		{"Other port", "http://example.com:8080/feed", "http://example.com:8080/feed"},
+		{"IPv6", "http://[::1]:8080/feed", "http://[::1]:8080/feed"},
+		{"Trailing dot", "http://example.com./feed", "http://example.com/feed"},
+		{"Dot segments", "http://example.com/a/./b/../feed/", "http://example.com/a/feed/"},
+		{"Trailing slash kept", "http://example.com/feed/", "http://example.com/feed/"},
+		{"Tracking", "https://x/feed?utm_source=y&utm_medium=rss", "https://x/feed"},
+		{"Tracking among others", "https://x/a?id=1&fbclid=abc&page=2&UTM_Campaign=z", "https://x/a?id=1&page=2"},
+		{"Query order kept", "https://x/a?b=2&a=1", "https://x/a?b=2&a=1"},
+		{"Escaping kept", "https://x/a%2Fb?q=a%20b&gclid=1", "https://x/a%2Fb?q=a%20b"},
+		{"Empty query", "https://x/a?", "https://x/a"},
+		{"Fragment kept", "https://x/a?utm_source=y#section", "https://x/a#section"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			normalized, err := Normalize(tc.URL)
+			require.Nil(t, err)
+			assert.Equal(t, tc.Expected, normalized)
+		})
+	}
+
+	for _, invalid := range []string{"ftp://example.com/feed", "/feed.xml", "mailto:jo@example.com", "http:///feed"} {
+		_, err := Normalize(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestKey(t *testing.T) {
+	same := []string{
+		"http://x/feed",
+		"https://x/feed/",
+		"HTTPS://X/feed?utm_source=y",
+		"https://x:443/feed#top",
+	}
+	for _, u := range same {
+		assert.Equal(t, Key(same[0]), Key(u), u)
+	}
+
+	different := []string{
+		"http://x/feed.xml",
+		"http://www.x/feed",
+		"http://x/feed?page=2",
+		"http://x:8080/feed",
+	}
+	for _, u := range different {
+		assert.NotEqual(t, Key(same[0]), Key(u), u)
+	}
+}
+
+func TestSetTrackingParams(t *testing.T) {
+	defer SetTrackingParams(DefaultTrackingParams)
+
+	SetTrackingParams([]string{" Ref ", "source_*"})
+	normalized, err := Normalize("https://x/a?ref=feed&source_id=1&utm_source=y")
+	require.Nil(t, err)
+	assert.Equal(t, "https://x/a?utm_source=y", normalized)
+
+	SetTrackingParams(nil)
+	normalized, err = Normalize("https://x/a?utm_source=y")
+	require.Nil(t, err)
+	assert.Equal(t, "https://x/a?utm_source=y", normalized)
+}
+
+func TestResolve(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/~r/example/1" {
+			http.Redirect(w, r, server.URL+"/story?utm_source=feedburner", http.StatusMovedPermanently)
+			return
+		}
+		_, _ = w.Write([]byte("story"))
+	}))
+	defer server.Close()
+
+	resolver := NewResolver(server.Client(), []string{"127.0.0.1"})
+	resolved, err := resolver.Resolve(context.Background(), server.URL+"/~r/example/1")
+	require.Nil(t, err)
+	assert.Equal(t, server.URL+"/story", resolved)
+
+	other := NewResolver(server.Client(), DefaultRedirectors)
+	resolved, err = other.Resolve(context.Background(), server.URL+"/~r/example/1")
+	require.Nil(t, err)
+	assert.Equal(t, server.URL+"/~r/example/1", resolved)
+}